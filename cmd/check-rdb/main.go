@@ -0,0 +1,228 @@
+// Command check-rdb inspects a snapshot file offline. It validates the
+// header, every record and the checksum, prints key counts per type, a
+// histogram of value sizes and the biggest keys, and can salvage all
+// readable records into a new snapshot.
+//
+//	check-rdb [-top n] [-encryption-key-file keys] [-salvage out.rdb] dump.rdb
+//
+// The exit status is 1 when the file has problems.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/constant"
+	diskstorage "github.com/bhaski-1234/redis-db/storage/diskStorage"
+	"github.com/bhaski-1234/redis-db/storage/encryption"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+	"github.com/bhaski-1234/redis-db/storage/rdb"
+)
+
+var typeNames = map[byte]string{
+	constant.TypeString:    "string",
+	constant.TypeInteger:   "string",
+	constant.TypeList:      "list",
+	constant.TypeHash:      "hash",
+	constant.TypeSet:       "set",
+	constant.TypeSortedSet: "zset",
+	constant.TypeStream:    "stream",
+}
+
+var typeOrder = []string{"string", "list", "hash", "set", "zset", "stream"}
+
+// sizeBuckets are the upper bounds of the value size histogram.
+var sizeBuckets = []int{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+func main() {
+	top := flag.Int("top", 10, "Number of biggest keys to list")
+	keyFile := flag.String("encryption-key-file", "", "Key file for encrypted snapshots")
+	salvage := flag.String("salvage", "", "Write every readable record to this snapshot file")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: check-rdb [flags] dump.rdb\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *keyFile != "" {
+		if err := encryption.LoadKeyFile(*keyFile); err != nil {
+			fatal(err)
+		}
+	}
+
+	path := flag.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("file:     %s (%d bytes)\n", path, len(data))
+
+	if encryption.IsEncrypted(data) {
+		fmt.Println("encrypted: yes")
+		if data, err = encryption.Decrypt(data); err != nil {
+			fatal(err)
+		}
+	}
+
+	if rdb.IsRDB(data) {
+		os.Exit(checkRedisRDB(data))
+	}
+
+	in := diskstorage.Inspect(data, *salvage != "")
+	fmt.Printf("format:   native, version %d\n", in.Version)
+	for _, key := range sortedKeys(in.Aux) {
+		fmt.Printf("aux:      %s=%s\n", key, in.Aux[key])
+	}
+	fmt.Printf("checksum: %s\n", in.Checksum)
+	report(in.Records, *top)
+
+	if len(in.Problems) > 0 {
+		fmt.Printf("\n%d problem(s):\n", len(in.Problems))
+		for _, p := range in.Problems {
+			fmt.Printf("  offset %d: %v\n", p.Offset, p.Err)
+		}
+	}
+
+	if *salvage != "" {
+		var salvaged []string
+		for _, r := range in.Records {
+			if r.Salvaged && r.Type != constant.TypeTTL {
+				salvaged = append(salvaged, r.Key)
+			}
+		}
+		if len(salvaged) > 0 {
+			fmt.Printf("\n%d key(s) found past a problem, check them by hand:\n", len(salvaged))
+			for _, key := range salvaged {
+				fmt.Printf("  %q\n", key)
+			}
+		}
+
+		snap := in.Snapshot()
+		name := strings.TrimSuffix(*salvage, constant.DataFileExtension)
+		if err := diskstorage.NewDiskStorage().SaveSnapshot(name, snap, nil); err != nil {
+			fatal(fmt.Errorf("salvage failed: %w", err))
+		}
+		fmt.Printf("\nsalvaged %d keys to %s%s\n", len(snap.Values), name, constant.DataFileExtension)
+	}
+
+	if len(in.Problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// report prints the key counts, the size histogram and the biggest keys.
+func report(records []diskstorage.RecordInfo, top int) {
+	counts := map[string]int{}
+	bytes := map[string]int{}
+	histogram := make([]int, len(sizeBuckets)+1)
+	var keys []diskstorage.RecordInfo
+	ttls := 0
+	for _, r := range records {
+		if r.Type == constant.TypeTTL {
+			ttls++
+			continue
+		}
+		name := typeNames[r.Type]
+		counts[name]++
+		bytes[name] += r.Size
+		histogram[sort.SearchInts(sizeBuckets, r.Size)]++
+		keys = append(keys, r)
+	}
+
+	fmt.Printf("keys:     %d (%d with TTL)\n\n", len(keys), ttls)
+	fmt.Printf("%-8s %10s %14s\n", "type", "keys", "bytes")
+	for _, name := range typeOrder {
+		if counts[name] > 0 {
+			fmt.Printf("%-8s %10d %14d\n", name, counts[name], bytes[name])
+		}
+	}
+
+	fmt.Printf("\nrecord sizes:\n")
+	for i, n := range histogram {
+		if n == 0 {
+			continue
+		}
+		if i < len(sizeBuckets) {
+			fmt.Printf("  < %-8s %10d\n", formatSize(sizeBuckets[i]), n)
+		} else {
+			fmt.Printf("  >= %-7s %10d\n", formatSize(sizeBuckets[i-1]), n)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Size > keys[j].Size })
+	if len(keys) > top {
+		keys = keys[:top]
+	}
+	if len(keys) > 0 {
+		fmt.Printf("\nbiggest keys:\n")
+		for _, r := range keys {
+			fmt.Printf("  %10d  %-6s  %q\n", r.Size, typeNames[r.Type], r.Key)
+		}
+	}
+}
+
+// checkRedisRDB validates a file written by stock Redis and prints its key
+// counts. It returns the exit status.
+func checkRedisRDB(data []byte) int {
+	fmt.Printf("format:   Redis RDB version %s\n", data[len(rdb.Magic):len(rdb.Magic)+4])
+	snap, err := rdb.Read(data)
+	if err != nil {
+		fmt.Printf("\nproblem: %v\n", err)
+		return 1
+	}
+	counts := map[string]int{}
+	for _, value := range snap.Values {
+		switch value.(type) {
+		case string, int:
+			counts["string"]++
+		case *inMemory.List:
+			counts["list"]++
+		case *inMemory.Hash:
+			counts["hash"]++
+		case *inMemory.Set:
+			counts["set"]++
+		case *inMemory.SortedSet:
+			counts["zset"]++
+		case *inMemory.Stream:
+			counts["stream"]++
+		}
+	}
+	fmt.Printf("keys:     %d (%d with TTL)\n\n", len(snap.Values), len(snap.Expirations))
+	fmt.Printf("%-8s %10s\n", "type", "keys")
+	for _, name := range typeOrder {
+		if counts[name] > 0 {
+			fmt.Printf("%-8s %10d\n", name, counts[name])
+		}
+	}
+	return 0
+}
+
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%dM", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%dK", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "check-rdb: %v\n", err)
+	os.Exit(1)
+}
@@ -1,4 +1,157 @@
 package config
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 var Host string
 var Port int
+
+// Append-only file settings
+var AppendOnly bool
+var AppendFsync string
+var AppendFilename string
+var AutoAOFRewritePercentage int
+var AutoAOFRewriteMinSize int64
+
+// Snapshot file formats: the native format, or the RDB format of stock
+// Redis for handing snapshots to Redis tooling.
+const (
+	SnapshotFormatNative = "native"
+	SnapshotFormatRedis  = "redis"
+)
+
+var SnapshotFormat string
+
+// SnapshotCompressThreshold is the value size in bytes from which native
+// snapshot records are compressed; 0 disables compression.
+var SnapshotCompressThreshold int
+
+// EncryptionKeyFile names the file holding the keys that encrypt snapshots
+// and the AOF; empty disables encryption.
+var EncryptionKeyFile string
+
+// DebugJSON enables DEBUG JSONEXPORT and JSONIMPORT, which read and write
+// files in the data directory.
+var DebugJSON bool
+
+// NotifyKeyspaceEvents holds the enabled keyspace notification classes as
+// parsed by ParseKeyspaceEvents; empty disables notifications.
+var NotifyKeyspaceEvents string
+
+// keyspaceEventClasses are the classes "A" stands for.
+const keyspaceEventClasses = "g$lshzxet"
+
+// ParseKeyspaceEvents parses the class letters of the notify-keyspace-events
+// directive, expanding "A". Without K or E no notification is ever
+// published, so the result is then empty.
+func ParseKeyspaceEvents(s string) (string, error) {
+	var classes []byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == 'A':
+			classes = append(classes, keyspaceEventClasses...)
+		case strings.IndexByte("KE"+keyspaceEventClasses+"mdn", c) >= 0:
+			classes = append(classes, c)
+		default:
+			return "", fmt.Errorf("invalid notify-keyspace-events class %q", c)
+		}
+	}
+	if !strings.ContainsAny(string(classes), "KE") {
+		return "", nil
+	}
+	return string(classes), nil
+}
+
+// ReplicaOf holds "host port" of the primary to replicate at startup;
+// empty starts as a primary.
+var ReplicaOf string
+
+// ReplBacklogSize is the size in bytes of the replication backlog.
+var ReplBacklogSize int
+
+// ReplicaReadOnly makes a replica reject writes from its clients.
+var ReplicaReadOnly bool
+
+// SavePoint triggers a background save once at least Changes writes
+// happened and Seconds elapsed since the last save.
+type SavePoint struct {
+	Seconds int
+	Changes int64
+}
+
+var SavePoints []SavePoint
+
+// ParseSavePoints parses the "seconds changes [seconds changes ...]" format
+// of the save directive. An empty string disables automatic saves.
+func ParseSavePoints(s string) ([]SavePoint, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save parameters %q", s)
+	}
+	points := make([]SavePoint, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save seconds %q", fields[i])
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save changes %q", fields[i+1])
+		}
+		points = append(points, SavePoint{Seconds: seconds, Changes: changes})
+	}
+	return points, nil
+}
+
+// ClusterEnabled runs the server as a node of a cluster, serving only the
+// keys of its hash slots.
+var ClusterEnabled bool
+
+// ClusterConfigFile is where a cluster node keeps its ID, slots and the
+// nodes it knows.
+var ClusterConfigFile string
+
+// Sentinel runs the process as a sentinel watching SentinelMonitors instead
+// of serving a dataset.
+var Sentinel bool
+
+// SentinelMonitor is a primary a sentinel watches, as given by
+// "name host port quorum". Quorum is the number of sentinels that must
+// agree the primary is down before a failover.
+type SentinelMonitor struct {
+	Name   string
+	Host   string
+	Port   int
+	Quorum int
+}
+
+var SentinelMonitors []SentinelMonitor
+
+// SentinelDownAfter is how many milliseconds an instance may go without
+// answering before a sentinel considers it down.
+var SentinelDownAfter int
+
+// SentinelFailoverTimeout is how many milliseconds a failover may take; a
+// failed attempt is retried after twice as long.
+var SentinelFailoverTimeout int
+
+// ParseSentinelMonitor parses the "name host port quorum" of the
+// sentinel-monitor directive.
+func ParseSentinelMonitor(s string) (SentinelMonitor, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return SentinelMonitor{}, fmt.Errorf("invalid sentinel-monitor %q, expected \"name host port quorum\"", s)
+	}
+	port, err := strconv.Atoi(fields[2])
+	if err != nil || port < 1 || port > 65535 {
+		return SentinelMonitor{}, fmt.Errorf("invalid sentinel-monitor port %q", fields[2])
+	}
+	quorum, err := strconv.Atoi(fields[3])
+	if err != nil || quorum < 1 {
+		return SentinelMonitor{}, fmt.Errorf("invalid sentinel-monitor quorum %q", fields[3])
+	}
+	return SentinelMonitor{Name: fields[0], Host: fields[1], Port: port, Quorum: quorum}, nil
+}
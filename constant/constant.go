@@ -3,6 +3,7 @@ package constant
 // Package constant provides constants used in the RESP protocol.
 const (
 	ErrInvalidRESP = "invalid RESP format"
+	ErrWrongType   = "WRONGTYPE Operation against a key holding the wrong kind of value"
 )
 
 const (
@@ -14,4 +15,26 @@ const (
 	TypeInteger       = 0x01
 	TypeList          = 0x02
 	TypeTTL           = 0x03
+	TypeHash          = 0x04
+	TypeSet           = 0x05
+	TypeSortedSet     = 0x06
+	TypeStream        = 0x07
+
+	// TypeCompressed is set on the type byte of a data record whose value
+	// is deflated.
+	TypeCompressed = 0x40
 )
+
+// Snapshot format versioning. Record bytes below OpFirstOptional are data
+// types every reader must understand. Bytes from OpFirstOptional up to
+// OpVersion are length-prefixed optional records, which readers skip when
+// they do not know them, so metadata can be added without a version bump.
+const (
+	FormatVersion   = 3
+	OpFirstOptional = 0x80
+	OpAux           = 0xFA
+	OpVersion       = 0xFE
+)
+
+// ServerVersion is recorded in snapshot metadata.
+const ServerVersion = "0.2.0"
@@ -0,0 +1,282 @@
+// Package cluster implements cluster mode: the keyspace is split into hash
+// slots, each served by one node, and commands for keys of other nodes'
+// slots are redirected to them.
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+const (
+	// pollPeriod is how often a node reads the CLUSTER NODES of the nodes
+	// it knows, to learn their slots and the nodes they know.
+	pollPeriod = time.Second
+	// nodeTimeout is how long a node may be unreachable before it is
+	// flagged as failing.
+	nodeTimeout = 15 * time.Second
+	// forgetPeriod is how long a forgotten node is not learned again from
+	// other nodes, which may still list it.
+	forgetPeriod = time.Minute
+	// busPortOffset is added to a node's port for the cluster bus port
+	// shown in CLUSTER NODES. Nodes here exchange state over their client
+	// port, but clients expect the field.
+	busPortOffset = 10000
+)
+
+var (
+	errDisabled  = errors.New("ERR This instance has cluster support disabled")
+	errCrossSlot = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	errTryAgain  = errors.New("TRYAGAIN Multiple keys request during rehashing of slot")
+	errNotServed = errors.New("CLUSTERDOWN Hash slot not served")
+)
+
+// node is a member of the cluster.
+type node struct {
+	id   string
+	host string
+	port int
+	// epoch is the node's config epoch. When two nodes claim a slot, the
+	// one with the greater epoch owns it.
+	epoch    int64
+	lastPong time.Time
+	linkUp   bool
+}
+
+func (n *node) addr() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+// state is this node's view of the cluster, guarded by mu: commands read
+// it on the event loop while the poller updates it.
+type state struct {
+	mu      sync.Mutex
+	enabled bool
+	file    string
+	self    *node
+	nodes   map[string]*node
+	// currentEpoch is the greatest config epoch known.
+	currentEpoch int64
+	slots        [Slots]*node
+	// migrating maps the slots being moved away to their target, and
+	// importing the slots being moved here to their source.
+	migrating map[int]*node
+	importing map[int]*node
+	// meet holds the addresses given to CLUSTER MEET whose node ID is not
+	// known yet; forgotten the IDs CLUSTER FORGET dropped, until when.
+	meet      map[string]bool
+	forgotten map[string]time.Time
+}
+
+var c = &state{
+	nodes:     make(map[string]*node),
+	migrating: make(map[int]*node),
+	importing: make(map[int]*node),
+	meet:      make(map[string]bool),
+	forgotten: make(map[string]time.Time),
+}
+
+// Enabled reports whether the server runs in cluster mode.
+func Enabled() bool {
+	return c.enabled
+}
+
+// Enable turns on cluster mode for the node listening on host:port. The
+// node's ID, slots and known nodes are kept in file, which is created on
+// the first start.
+func Enable(host string, port int, file string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file = file
+	data, err := os.ReadFile(file)
+	switch {
+	case err == nil:
+		if err := c.load(string(data)); err != nil {
+			return fmt.Errorf("failed to load cluster config %s: %w", file, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		c.self = &node{id: newNodeID()}
+		c.nodes[c.self.id] = c.self
+	default:
+		return err
+	}
+	c.self.host, c.self.port, c.self.linkUp = resolve(host), port, true
+	if err := c.save(); err != nil {
+		return err
+	}
+	c.enabled = true
+	inMemory.GetInMemoryStore().IndexSlots(KeySlot, Slots)
+	fmt.Printf("Cluster node %s\n", c.self.id)
+	go poll()
+	return nil
+}
+
+func newNodeID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// resolve returns the IP address of host, the form nodes are known by.
+func resolve(host string) string {
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return host
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String()
+		}
+	}
+	return ips[0].String()
+}
+
+// Route checks that this node serves the keys of a command. Otherwise it
+// returns the error to answer with: MOVED to the owner of the keys' slot,
+// ASK to the node a migrating slot moves to for keys already gone, or
+// CROSSSLOT for keys of different slots. asking is set after an ASKING,
+// which lets a client use a slot this node is importing.
+func Route(keys []string, asking bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return errCrossSlot
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if asking && c.importing[slot] != nil {
+		return nil
+	}
+	owner := c.slots[slot]
+	if owner == nil {
+		return errNotServed
+	}
+	if owner != c.self {
+		return fmt.Errorf("MOVED %d %s", slot, owner.addr())
+	}
+	if target := c.migrating[slot]; target != nil {
+		store := inMemory.GetInMemoryStore()
+		missing := 0
+		for _, key := range keys {
+			if !store.Exists(key) {
+				missing++
+			}
+		}
+		if missing == len(keys) {
+			return fmt.Errorf("ASK %d %s", slot, target.addr())
+		}
+		if missing > 0 {
+			return errTryAgain
+		}
+	}
+	return nil
+}
+
+// bumpEpoch gives this node a config epoch greater than any other, so its
+// claim wins the slots it takes over.
+func (c *state) bumpEpoch() {
+	c.currentEpoch++
+	c.self.epoch = c.currentEpoch
+}
+
+// setOwner assigns slot to n, ending a migration of the slot.
+func (c *state) setOwner(slot int, n *node) {
+	c.slots[slot] = n
+	delete(c.migrating, slot)
+	delete(c.importing, slot)
+}
+
+// forget removes n and the slots it serves.
+func (c *state) forget(n *node) {
+	for slot, owner := range c.slots {
+		if owner == n {
+			c.setOwner(slot, nil)
+		}
+	}
+	for slot, other := range c.migrating {
+		if other == n {
+			delete(c.migrating, slot)
+		}
+	}
+	for slot, other := range c.importing {
+		if other == n {
+			delete(c.importing, slot)
+		}
+	}
+	delete(c.nodes, n.id)
+}
+
+// save writes the cluster config in the format of CLUSTER NODES.
+func (c *state) save() error {
+	data := c.nodesText() + fmt.Sprintf("vars currentEpoch %d lastVoteEpoch 0\n", c.currentEpoch)
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return fmt.Errorf("failed to save cluster config: %w", err)
+	}
+	if err := os.Rename(tmp, c.file); err != nil {
+		return fmt.Errorf("failed to save cluster config: %w", err)
+	}
+	return nil
+}
+
+// saveOrLog saves the config after a change made outside of a command.
+func (c *state) saveOrLog() {
+	if err := c.save(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// load restores the state saved by save.
+func (c *state) load(text string) error {
+	infos, vars, err := parseNodes(text)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		n := &node{id: info.id, host: info.host, port: info.port, epoch: info.epoch}
+		if info.myself {
+			c.self = n
+		}
+		c.nodes[n.id] = n
+		for _, slot := range info.slots {
+			c.slots[slot] = n
+		}
+	}
+	if c.self == nil {
+		return errors.New("no myself entry")
+	}
+	for _, info := range infos {
+		if !info.myself {
+			continue
+		}
+		for slot, id := range info.migrating {
+			if n, ok := c.nodes[id]; ok {
+				c.migrating[slot] = n
+			}
+		}
+		for slot, id := range info.importing {
+			if n, ok := c.nodes[id]; ok {
+				c.importing[slot] = n
+			}
+		}
+	}
+	c.currentEpoch, _ = strconv.ParseInt(vars["currentEpoch"], 10, 64)
+	for _, n := range c.nodes {
+		c.currentEpoch = max(c.currentEpoch, n.epoch)
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+var (
+	errInvalidSlot = errors.New("ERR Invalid or out of range slot")
+	errSyntax      = errors.New("ERR syntax error")
+)
+
+var clusterHelp = []string{
+	"CLUSTER <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ADDSLOTS <slot> [<slot> ...]",
+	"ADDSLOTSRANGE <start slot> <end slot> [<start slot> <end slot> ...]",
+	"COUNTKEYSINSLOT <slot>",
+	"DELSLOTS <slot> [<slot> ...]",
+	"DELSLOTSRANGE <start slot> <end slot> [<start slot> <end slot> ...]",
+	"FORGET <node-id>",
+	"GETKEYSINSLOT <slot> <count>",
+	"INFO",
+	"KEYSLOT <key>",
+	"MEET <ip> <port>",
+	"MYID",
+	"NODES",
+	"SETSLOT <slot> (IMPORTING <node-id>|MIGRATING <node-id>|STABLE|NODE <node-id>)",
+	"SHARDS",
+	"SLOTS",
+}
+
+// clusterArity maps each subcommand to its number of arguments, counting
+// CLUSTER and the subcommand; a negative arity is a minimum.
+var clusterArity = map[string]int{
+	"ADDSLOTS":        -3,
+	"ADDSLOTSRANGE":   -4,
+	"COUNTKEYSINSLOT": 3,
+	"DELSLOTS":        -3,
+	"DELSLOTSRANGE":   -4,
+	"FORGET":          3,
+	"GETKEYSINSLOT":   4,
+	"HELP":            2,
+	"INFO":            2,
+	"KEYSLOT":         3,
+	"MEET":            4,
+	"MYID":            2,
+	"NODES":           2,
+	"SETSLOT":         -4,
+	"SHARDS":          2,
+	"SLOTS":           2,
+}
+
+// HandleCluster implements the CLUSTER subcommands.
+func HandleCluster(args []string) (interface{}, error) {
+	if !c.enabled {
+		return nil, errDisabled
+	}
+	if len(args) < 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	sub := strings.ToUpper(args[1])
+	arity, ok := clusterArity[sub]
+	if !ok {
+		return nil, errors.New("ERR unknown subcommand '" + args[1] + "'. Try CLUSTER HELP.")
+	}
+	if arity > 0 && len(args) != arity || arity < 0 && len(args) < -arity {
+		return nil, errWrongArgs(args[0] + "|" + strings.ToLower(args[1]))
+	}
+
+	switch sub {
+	case "HELP":
+		reply := make([]interface{}, len(clusterHelp))
+		for i, line := range clusterHelp {
+			reply[i] = line
+		}
+		return reply, nil
+	case "KEYSLOT":
+		return KeySlot(args[2]), nil
+	case "COUNTKEYSINSLOT":
+		slot, err := parseSlotArg(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return len(keysInSlot(slot, -1)), nil
+	case "GETKEYSINSLOT":
+		slot, err := parseSlotArg(args[2])
+		count, countErr := strconv.Atoi(args[3])
+		if err != nil || countErr != nil || count < 0 {
+			return nil, errors.New("ERR Invalid slot or number of keys")
+		}
+		keys := keysInSlot(slot, count)
+		reply := make([]interface{}, len(keys))
+		for i, key := range keys {
+			reply[i] = key
+		}
+		return reply, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch sub {
+	case "INFO":
+		return protocol.BulkString(c.info()), nil
+	case "MYID":
+		return protocol.BulkString(c.self.id), nil
+	case "NODES":
+		return protocol.BulkString(c.nodesText()), nil
+	case "SLOTS":
+		return c.slotsReply(), nil
+	case "SHARDS":
+		return c.shardsReply(), nil
+	case "ADDSLOTS", "DELSLOTS", "ADDSLOTSRANGE", "DELSLOTSRANGE":
+		return c.assignSlots(sub, args[2:])
+	case "SETSLOT":
+		return c.setSlot(args[2:])
+	case "MEET":
+		return c.meetNode(args[2], args[3])
+	case "FORGET":
+		return c.forgetNode(args[2])
+	}
+	return nil, nil
+}
+
+func errWrongArgs(cmd string) error {
+	return errors.New("ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command")
+}
+
+func parseSlotArg(s string) (int, error) {
+	slot, err := parseSlot(s)
+	if err != nil {
+		return 0, errInvalidSlot
+	}
+	return slot, nil
+}
+
+// keysInSlot returns up to limit keys of slot, or all of them if limit is
+// negative.
+func keysInSlot(slot, limit int) []string {
+	return inMemory.GetInMemoryStore().KeysInSlot(slot, limit)
+}
+
+// failing reports whether n has been unreachable for longer than
+// nodeTimeout.
+func (c *state) failing(n *node) bool {
+	return n != c.self && !n.linkUp && time.Since(n.lastPong) > nodeTimeout
+}
+
+func (c *state) info() string {
+	assigned, pfail := 0, 0
+	size := make(map[*node]bool)
+	for _, owner := range c.slots {
+		if owner == nil {
+			continue
+		}
+		assigned++
+		size[owner] = true
+		if c.failing(owner) {
+			pfail++
+		}
+	}
+	clusterState := "ok"
+	if assigned < Slots {
+		clusterState = "fail"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", clusterState)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", assigned-pfail)
+	fmt.Fprintf(&b, "cluster_slots_pfail:%d\r\n", pfail)
+	fmt.Fprintf(&b, "cluster_slots_fail:0\r\n")
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(c.nodes))
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", len(size))
+	fmt.Fprintf(&b, "cluster_current_epoch:%d\r\n", c.currentEpoch)
+	fmt.Fprintf(&b, "cluster_my_epoch:%d\r\n", c.self.epoch)
+	return b.String()
+}
+
+// Info renders the cluster section of INFO.
+func Info(b *strings.Builder) {
+	enabled := 0
+	if c.enabled {
+		enabled = 1
+	}
+	fmt.Fprintf(b, "cluster_enabled:%d\r\n", enabled)
+}
+
+// sortedNodes returns the known nodes ordered by ID.
+func (c *state) sortedNodes() []*node {
+	nodes := make([]*node, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+// slotsReply lists every range of assigned slots with the node serving it.
+func (c *state) slotsReply() []interface{} {
+	var reply []interface{}
+	for _, n := range c.sortedNodes() {
+		for _, r := range c.ranges(n) {
+			reply = append(reply, []interface{}{r[0], r[1], []interface{}{n.host, n.port, n.id}})
+		}
+	}
+	sort.Slice(reply, func(i, j int) bool {
+		return reply[i].([]interface{})[0].(int) < reply[j].([]interface{})[0].(int)
+	})
+	return reply
+}
+
+// shardsReply describes every node as a shard of its own, since nodes have
+// no replicas.
+func (c *state) shardsReply() []interface{} {
+	var reply []interface{}
+	for _, n := range c.sortedNodes() {
+		slots := []interface{}{}
+		for _, r := range c.ranges(n) {
+			slots = append(slots, r[0], r[1])
+		}
+		health := "online"
+		if c.failing(n) {
+			health = "failed"
+		}
+		desc := []interface{}{
+			"id", n.id,
+			"port", n.port,
+			"ip", n.host,
+			"endpoint", n.host,
+			"role", "master",
+			"replication-offset", 0,
+			"health", health,
+		}
+		reply = append(reply, []interface{}{"slots", slots, "nodes", []interface{}{desc}})
+	}
+	return reply
+}
+
+// assignSlots implements ADDSLOTS, DELSLOTS and their RANGE forms. No slot
+// changes unless all of them can.
+func (c *state) assignSlots(sub string, args []string) (interface{}, error) {
+	var slots []int
+	if strings.HasSuffix(sub, "RANGE") {
+		if len(args)%2 != 0 {
+			return nil, errWrongArgs("cluster|" + strings.ToLower(sub))
+		}
+		for i := 0; i < len(args); i += 2 {
+			start, err := parseSlotArg(args[i])
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseSlotArg(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if start > end {
+				return nil, fmt.Errorf("ERR start slot number %d is greater than end slot number %d", start, end)
+			}
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+	} else {
+		for _, arg := range args {
+			slot, err := parseSlotArg(arg)
+			if err != nil {
+				return nil, err
+			}
+			slots = append(slots, slot)
+		}
+	}
+
+	add := strings.HasPrefix(sub, "ADD")
+	seen := make(map[int]bool)
+	for _, slot := range slots {
+		if seen[slot] {
+			return nil, fmt.Errorf("ERR Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+		if add && c.slots[slot] != nil {
+			return nil, fmt.Errorf("ERR Slot %d is already busy", slot)
+		}
+		if !add && c.slots[slot] == nil {
+			return nil, fmt.Errorf("ERR Slot %d is already unassigned", slot)
+		}
+	}
+	for _, slot := range slots {
+		if add {
+			c.setOwner(slot, c.self)
+		} else {
+			c.setOwner(slot, nil)
+		}
+	}
+	return c.saveReply()
+}
+
+// setSlot implements CLUSTER SETSLOT, which drives the migration of a slot
+// from one node to another:
+//
+//	SETSLOT slot IMPORTING source  on the target
+//	SETSLOT slot MIGRATING target  on the source
+//	SETSLOT slot NODE target       on both once the keys are moved
+//	SETSLOT slot STABLE            to cancel a migration
+func (c *state) setSlot(args []string) (interface{}, error) {
+	slot, err := parseSlotArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	action := strings.ToUpper(args[1])
+	if action == "STABLE" {
+		if len(args) != 2 {
+			return nil, errSyntax
+		}
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+		return c.saveReply()
+	}
+	if len(args) != 3 || action != "IMPORTING" && action != "MIGRATING" && action != "NODE" {
+		return nil, errSyntax
+	}
+	n, ok := c.nodes[args[2]]
+	if !ok {
+		return nil, errors.New("ERR I don't know about node " + args[2])
+	}
+
+	switch action {
+	case "MIGRATING":
+		if c.slots[slot] != c.self {
+			return nil, fmt.Errorf("ERR I'm not the owner of hash slot %d", slot)
+		}
+		if n == c.self {
+			return nil, errors.New("ERR I can't migrate a slot to myself")
+		}
+		c.migrating[slot] = n
+	case "IMPORTING":
+		if c.slots[slot] == c.self {
+			return nil, fmt.Errorf("ERR I'm already the owner of hash slot %d", slot)
+		}
+		if n == c.self {
+			return nil, errors.New("ERR I can't import a slot from myself")
+		}
+		c.importing[slot] = n
+	case "NODE":
+		if c.slots[slot] == c.self && n != c.self && len(keysInSlot(slot, 1)) > 0 {
+			return nil, fmt.Errorf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+		}
+		// Taking over an imported slot needs an epoch greater than the
+		// source's, so that the other nodes prefer this node's claim.
+		if n == c.self && c.slots[slot] != c.self {
+			c.bumpEpoch()
+			fmt.Printf("Slot %d taken over with config epoch %d\n", slot, c.self.epoch)
+		}
+		c.setOwner(slot, n)
+	}
+	return c.saveReply()
+}
+
+func (c *state) meetNode(host, port string) (interface{}, error) {
+	p, err := strconv.Atoi(port)
+	if net.ParseIP(host) == nil || err != nil || p <= 0 || p > 65535 {
+		return nil, errors.New("ERR Invalid node address specified: " + host + ":" + port)
+	}
+	addr := net.JoinHostPort(host, port)
+	for _, n := range c.nodes {
+		if n.addr() == addr {
+			return "OK", nil
+		}
+	}
+	c.meet[addr] = true
+	return "OK", nil
+}
+
+func (c *state) forgetNode(id string) (interface{}, error) {
+	n, ok := c.nodes[id]
+	if !ok {
+		return nil, errors.New("ERR Unknown node " + id)
+	}
+	if n == c.self {
+		return nil, errors.New("ERR I tried hard but I can't forget myself...")
+	}
+	c.forget(n)
+	c.forgotten[id] = time.Now().Add(forgetPeriod)
+	return c.saveReply()
+}
+
+// saveReply saves the config after a command changed it.
+func (c *state) saveReply() (interface{}, error) {
+	if err := c.save(); err != nil {
+		return nil, errors.New("ERR " + err.Error())
+	}
+	return "OK", nil
+}
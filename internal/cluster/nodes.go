@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nodeInfo is a node as described by a line of CLUSTER NODES:
+//
+//	<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv>
+//	<config-epoch> <link-state> <slot> ... <slot>
+//
+// The slots of the myself line may include migrations, written as
+// [slot->-target] and [slot-<-source].
+type nodeInfo struct {
+	id        string
+	host      string
+	port      int
+	myself    bool
+	epoch     int64
+	slots     []int
+	migrating map[int]string
+	importing map[int]string
+}
+
+// nodesText renders the state in the format of CLUSTER NODES.
+func (c *state) nodesText() string {
+	var b strings.Builder
+	for _, n := range c.sortedNodes() {
+		flags, pong, link := "master", n.lastPong.UnixMilli(), "connected"
+		if n == c.self {
+			flags, pong = "myself,master", 0
+		} else if !n.linkUp {
+			link = "disconnected"
+			if c.failing(n) {
+				flags += ",fail?"
+			}
+			if n.lastPong.IsZero() {
+				pong = 0
+			}
+		}
+		fmt.Fprintf(&b, "%s %s:%d@%d %s - 0 %d %d %s", n.id, n.host, n.port, n.port+busPortOffset, flags, pong, n.epoch, link)
+		for _, r := range c.ranges(n) {
+			if r[0] == r[1] {
+				fmt.Fprintf(&b, " %d", r[0])
+			} else {
+				fmt.Fprintf(&b, " %d-%d", r[0], r[1])
+			}
+		}
+		if n == c.self {
+			for _, slot := range sortedSlots(c.migrating) {
+				fmt.Fprintf(&b, " [%d->-%s]", slot, c.migrating[slot].id)
+			}
+			for _, slot := range sortedSlots(c.importing) {
+				fmt.Fprintf(&b, " [%d-<-%s]", slot, c.importing[slot].id)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// ranges returns the slots served by n as inclusive [start, end] ranges.
+func (c *state) ranges(n *node) [][2]int {
+	var ranges [][2]int
+	for slot := 0; slot < Slots; slot++ {
+		if c.slots[slot] != n {
+			continue
+		}
+		if len(ranges) > 0 && ranges[len(ranges)-1][1] == slot-1 {
+			ranges[len(ranges)-1][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+func sortedSlots(m map[int]*node) []int {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// parseNodes parses CLUSTER NODES output, or a saved config, which adds a
+// line of "vars name value ...".
+func parseNodes(text string) ([]nodeInfo, map[string]string, error) {
+	var infos []nodeInfo
+	vars := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				vars[fields[i]] = fields[i+1]
+			}
+			continue
+		}
+		info, err := parseNodeLine(fields)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid node line %q: %w", line, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, vars, nil
+}
+
+func parseNodeLine(fields []string) (nodeInfo, error) {
+	if len(fields) < 8 {
+		return nodeInfo{}, fmt.Errorf("expected at least 8 fields")
+	}
+	info := nodeInfo{
+		id:        fields[0],
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+	}
+	addr, _, _ := strings.Cut(fields[1], "@")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nodeInfo{}, err
+	}
+	info.host = host
+	if info.port, err = strconv.Atoi(port); err != nil {
+		return nodeInfo{}, err
+	}
+	for _, flag := range strings.Split(fields[2], ",") {
+		info.myself = info.myself || flag == "myself"
+	}
+	if info.epoch, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+		return nodeInfo{}, err
+	}
+
+	for _, field := range fields[8:] {
+		if strings.HasPrefix(field, "[") {
+			spec := strings.Trim(field, "[]")
+			if s, id, ok := strings.Cut(spec, "->-"); ok {
+				slot, err := parseSlot(s)
+				if err != nil {
+					return nodeInfo{}, err
+				}
+				info.migrating[slot] = id
+			} else if s, id, ok := strings.Cut(spec, "-<-"); ok {
+				slot, err := parseSlot(s)
+				if err != nil {
+					return nodeInfo{}, err
+				}
+				info.importing[slot] = id
+			}
+			continue
+		}
+		first, last, isRange := strings.Cut(field, "-")
+		start, err := parseSlot(first)
+		if err != nil {
+			return nodeInfo{}, err
+		}
+		end := start
+		if isRange {
+			if end, err = parseSlot(last); err != nil {
+				return nodeInfo{}, err
+			}
+		}
+		for slot := start; slot <= end; slot++ {
+			info.slots = append(info.slots, slot)
+		}
+	}
+	return info, nil
+}
+
+// parseSlot parses a slot number.
+func parseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= Slots {
+		return 0, fmt.Errorf("invalid slot %q", s)
+	}
+	return slot, nil
+}
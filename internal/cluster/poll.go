@@ -0,0 +1,262 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+)
+
+// pollTimeout bounds a node's answer to CLUSTER NODES, so one that hangs
+// can not hold up the others.
+const pollTimeout = time.Second
+
+// poll keeps the state in sync with the other nodes. Rather than a cluster
+// bus, every node periodically reads the CLUSTER NODES of the nodes it
+// knows: a node's myself line is authoritative for its ID, config epoch
+// and slots, and its other lines introduce the nodes it knows. Each read
+// is preceded by a CLUSTER MEET of this node, so that a node met by
+// another learns about it in turn.
+func poll() {
+	links := make(map[string]*link)
+	for range time.Tick(pollPeriod) {
+		c.mu.Lock()
+		self := c.self
+		targets := make(map[string]*node)
+		for _, n := range c.nodes {
+			if n != c.self {
+				targets[n.addr()] = n
+			}
+		}
+		for addr := range c.meet {
+			if _, ok := targets[addr]; !ok {
+				targets[addr] = nil
+			}
+		}
+		c.mu.Unlock()
+
+		for addr, l := range links {
+			if _, ok := targets[addr]; !ok {
+				l.close()
+				delete(links, addr)
+			}
+		}
+		for addr := range targets {
+			if links[addr] == nil {
+				links[addr] = &link{addr: addr}
+			}
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		texts := make(map[string]string)
+		for addr, l := range links {
+			wg.Add(1)
+			go func(addr string, l *link) {
+				defer wg.Done()
+				text, err := l.fetchNodes(self.host, strconv.Itoa(self.port))
+				if err == nil {
+					mu.Lock()
+					texts[addr] = text
+					mu.Unlock()
+				}
+			}(addr, l)
+		}
+		wg.Wait()
+
+		c.mu.Lock()
+		changed := false
+		now := time.Now()
+		for addr, n := range targets {
+			text, ok := texts[addr]
+			if !ok {
+				if n != nil {
+					n.linkUp = false
+				}
+				continue
+			}
+			changed = c.update(addr, n, text, now) || changed
+		}
+		for id, until := range c.forgotten {
+			if now.After(until) {
+				delete(c.forgotten, id)
+			}
+		}
+		if changed {
+			c.saveOrLog()
+		}
+		c.mu.Unlock()
+	}
+}
+
+// update applies the CLUSTER NODES text of the node reached at addr, known
+// as n if it is not only an address given to CLUSTER MEET. It reports
+// whether the config changed.
+func (c *state) update(addr string, n *node, text string, now time.Time) bool {
+	infos, _, err := parseNodes(text)
+	if err != nil {
+		return false
+	}
+	var me *nodeInfo
+	for i := range infos {
+		if infos[i].myself {
+			me = &infos[i]
+		}
+	}
+	if me == nil {
+		return false
+	}
+	delete(c.meet, addr)
+	if me.id == c.self.id {
+		return false
+	}
+
+	changed := false
+	if n == nil || n.id != me.id {
+		if _, ok := c.forgotten[me.id]; ok {
+			return false
+		}
+		// A node restarted with a new config replaces the old one at its
+		// address
+		if n != nil {
+			fmt.Printf("Cluster node %s at %s replaced by %s\n", n.id, addr, me.id)
+			c.forget(n)
+		}
+		if n = c.nodes[me.id]; n == nil {
+			n = &node{id: me.id}
+			c.nodes[me.id] = n
+			fmt.Printf("Cluster node %s at %s joined\n", me.id, addr)
+		}
+		changed = true
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	if p, _ := strconv.Atoi(port); host != n.host || p != n.port {
+		n.host, n.port = host, p
+		changed = true
+	}
+	n.linkUp, n.lastPong = true, now
+	if me.epoch != n.epoch {
+		n.epoch = me.epoch
+		changed = true
+	}
+	if n.epoch > c.currentEpoch {
+		c.currentEpoch = n.epoch
+		changed = true
+	}
+
+	// Slots the node no longer claims are unassigned until another node
+	// claims them.
+	var claimed [Slots]bool
+	for _, slot := range me.slots {
+		claimed[slot] = true
+	}
+	for slot, owner := range c.slots {
+		if owner == n && !claimed[slot] {
+			c.slots[slot] = nil
+			changed = true
+		}
+	}
+	// A claim wins over the current owner's if the node's config epoch is
+	// greater, with ties broken by the lesser node ID.
+	for _, slot := range me.slots {
+		owner := c.slots[slot]
+		if owner == n {
+			continue
+		}
+		if owner != nil && (owner.epoch > n.epoch || owner.epoch == n.epoch && owner.id < n.id) {
+			continue
+		}
+		if owner == c.self {
+			fmt.Printf("Slot %d taken over by cluster node %s\n", slot, n.id)
+			delete(c.migrating, slot)
+		}
+		c.slots[slot] = n
+		changed = true
+	}
+
+	for _, info := range infos {
+		if info.myself || info.id == c.self.id {
+			continue
+		}
+		if _, ok := c.nodes[info.id]; ok {
+			continue
+		}
+		if _, ok := c.forgotten[info.id]; ok {
+			continue
+		}
+		c.meet[net.JoinHostPort(info.host, strconv.Itoa(info.port))] = true
+	}
+	return changed
+}
+
+// link is a connection to another node, dialed on first use and redialed
+// after a failure.
+type link struct {
+	addr    string
+	conn    net.Conn
+	decoder *protocol.Decoder
+}
+
+// fetchNodes meets the node with this node, at host:port, and returns its
+// CLUSTER NODES.
+func (l *link) fetchNodes(host, port string) (string, error) {
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", l.addr, pollTimeout)
+		if err != nil {
+			return "", err
+		}
+		l.conn, l.decoder = conn, protocol.NewDecoder()
+	}
+	text, err := l.roundTrip(host, port)
+	if err != nil {
+		l.close()
+	}
+	return text, err
+}
+
+func (l *link) roundTrip(host, port string) (string, error) {
+	l.conn.SetDeadline(time.Now().Add(pollTimeout))
+	var request []byte
+	request = append(request, protocol.EncodeArray([]interface{}{"CLUSTER", "MEET", host, port})...)
+	request = append(request, protocol.EncodeArray([]interface{}{"CLUSTER", "NODES"})...)
+	if _, err := l.conn.Write(request); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 4096)
+	// Only the reply to NODES matters
+	for replies := 0; ; {
+		frame, err := l.decoder.Next()
+		if err == nil {
+			if replies++; replies == 1 {
+				continue
+			}
+			text, ok := frame.(string)
+			if !ok || l.decoder.Raw()[0] != '$' {
+				return "", fmt.Errorf("unexpected reply from %s: %v", l.addr, frame)
+			}
+			return text, nil
+		}
+		if !errors.Is(err, protocol.ErrIncomplete) {
+			return "", err
+		}
+		n, err := l.conn.Read(buf)
+		if n > 0 {
+			l.decoder.Feed(buf[:n])
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (l *link) close() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
@@ -0,0 +1,51 @@
+package cluster
+
+// Slots is the number of hash slots the keyspace is divided into.
+const Slots = 16384
+
+// crc16Table is the table of CRC16/XMODEM, the checksum Redis Cluster maps
+// keys to slots with.
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// KeySlot returns the hash slot of key. When the key contains a hash tag,
+// a non-empty substring between the first "{" and the next "}", only the
+// tag is hashed, so that related keys can be placed in the same slot.
+func KeySlot(key string) int {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j > i+1 {
+					key = key[i+1 : j]
+				}
+				return int(crc16(key) & (Slots - 1))
+			}
+		}
+		break
+	}
+	return int(crc16(key) & (Slots - 1))
+}
@@ -0,0 +1,41 @@
+package cluster
+
+import "testing"
+
+func TestCRC16(t *testing.T) {
+	// The check value of CRC16/XMODEM
+	if got := crc16("123456789"); got != 0x31C3 {
+		t.Errorf("crc16(123456789) = %#x, want 0x31c3", got)
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	// Slots as computed by Redis' CLUSTER KEYSLOT
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"hello", 866},
+		{"", 0},
+		{"{user1000}.following", 3443},
+		{"{user1000}.followers", 3443},
+		{"user1000", 3443},
+		{"foo{}{bar}", 8363},
+		{"foo{{bar}}zap", 4015},
+		{"foo{bar}{zap}", 5061},
+	}
+	for _, tt := range tests {
+		if got := KeySlot(tt.key); got != tt.slot {
+			t.Errorf("KeySlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+}
+
+func TestKeySlotUnclosedTag(t *testing.T) {
+	// Without a closing brace the whole key is hashed
+	if got, want := KeySlot("{foo"), int(crc16("{foo")&(Slots-1)); got != want {
+		t.Errorf("KeySlot({foo) = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+package command
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+	"github.com/bhaski-1234/redis-db/utils"
+)
+
+var (
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errSyntax     = errors.New("ERR syntax error")
+	errNoSuchKey  = errors.New("ERR no such key")
+	errNotFloat   = errors.New("ERR value is not a valid float")
+
+	errIndexOutOfRange = errors.New("ERR index out of range")
+)
+
+// errWrongArgs builds the standard arity error for a command.
+func errWrongArgs(cmd string) error {
+	return errors.New("ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command")
+}
+
+func parseInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	return n, nil
+}
+
+// stringsToReply converts a slice of strings into an array reply.
+func stringsToReply(values []string) []interface{} {
+	reply := make([]interface{}, len(values))
+	for i, v := range values {
+		reply[i] = v
+	}
+	return reply
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// deleteIfEmpty removes an aggregate key once its last element is gone, as
+// Redis never keeps empty lists, hashes or sets around.
+func deleteIfEmpty(inmemory *inMemory.InMemoryStore, key string, value interface{ Len() int }) {
+	if value.Len() == 0 {
+		inmemory.Delete(key)
+	}
+}
+
+// scanOptions holds the parsed arguments of the *SCAN family.
+type scanOptions struct {
+	cursor   int
+	pattern  string
+	count    int
+	noValues bool
+}
+
+// parseScanArgs parses "cursor [MATCH pattern] [COUNT count] [NOVALUES]".
+func parseScanArgs(args []string, allowNoValues bool) (*scanOptions, error) {
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		return nil, errors.New("ERR invalid cursor")
+	}
+	opts := &scanOptions{cursor: cursor, count: 10}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			opts.pattern = args[i+1]
+			i++
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			count, err := parseInt(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if count < 1 {
+				return nil, errSyntax
+			}
+			opts.count = count
+			i++
+		case "NOVALUES":
+			if !allowNoValues {
+				return nil, errSyntax
+			}
+			opts.noValues = true
+		default:
+			return nil, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// page returns the items visited by one scan step over a stably ordered
+// slice, filtered by MATCH, together with the cursor for the next call.
+func (o *scanOptions) page(items []string) ([]string, int) {
+	if o.cursor >= len(items) {
+		return nil, 0
+	}
+	end := o.cursor + o.count
+	next := end
+	if end >= len(items) {
+		end = len(items)
+		next = 0
+	}
+	result := make([]string, 0, end-o.cursor)
+	for _, item := range items[o.cursor:end] {
+		if o.pattern == "" || utils.MatchPattern(o.pattern, item) {
+			result = append(result, item)
+		}
+	}
+	return result, next
+}
@@ -0,0 +1,117 @@
+package command
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/storage/aof"
+	diskstorage "github.com/bhaski-1234/redis-db/storage/diskStorage"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// lastImport holds the dataset loaded by the last DEBUG JSONIMPORT until
+// RewriteDebug turns it into commands. Commands run on a single goroutine,
+// so the rewrite always sees the import it belongs to.
+var lastImport *inMemory.Snapshot
+
+// HandleDebug implements the DEBUG subcommands:
+//
+//	DEBUG JSONEXPORT path [MATCH pattern]
+//	DEBUG JSONIMPORT path [MATCH pattern]
+//
+// JSONEXPORT writes the matching keys to path as JSON Lines; JSONIMPORT
+// loads them back, replacing keys of the same name. Both reply with the
+// number of keys. They are disabled unless config.DebugJSON is set, and
+// path must name a file inside the data directory, the working directory
+// of the server.
+func HandleDebug(args []string) (interface{}, error) {
+	lastImport = nil
+	if len(args) < 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	switch sub := strings.ToUpper(args[1]); sub {
+	case "JSONEXPORT", "JSONIMPORT":
+		if !config.DebugJSON {
+			return nil, errors.New("ERR DEBUG " + sub + " is disabled, start the server with -enable-debug-json to allow it")
+		}
+		path, pattern, err := parseJSONArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		if sub == "JSONEXPORT" {
+			return jsonExport(path, pattern)
+		}
+		return jsonImport(path, pattern)
+	}
+	return nil, errors.New("ERR unknown subcommand '" + args[1] + "'. Try DEBUG JSONEXPORT or DEBUG JSONIMPORT.")
+}
+
+func parseJSONArgs(args []string) (string, string, error) {
+	switch {
+	case len(args) == 3:
+		return args[2], "", nil
+	case len(args) == 5 && strings.ToUpper(args[3]) == "MATCH":
+		return args[2], args[4], nil
+	case len(args) < 3:
+		return "", "", errWrongArgs(args[0] + "|" + strings.ToLower(args[1]))
+	}
+	return "", "", errSyntax
+}
+
+func jsonExport(path, pattern string) (interface{}, error) {
+	root, err := os.OpenRoot(".")
+	if err != nil {
+		return nil, errors.New("ERR " + err.Error())
+	}
+	defer root.Close()
+	file, err := root.Create(path)
+	if err != nil {
+		return nil, errors.New("ERR " + err.Error())
+	}
+	n, err := diskstorage.ExportJSON(file, inMemory.GetInMemoryStore().Snapshot(), pattern)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, errors.New("ERR " + err.Error())
+	}
+	return n, nil
+}
+
+func jsonImport(path, pattern string) (interface{}, error) {
+	root, err := os.OpenRoot(".")
+	if err != nil {
+		return nil, errors.New("ERR " + err.Error())
+	}
+	defer root.Close()
+	file, err := root.Open(path)
+	if err != nil {
+		return nil, errors.New("ERR " + err.Error())
+	}
+	defer file.Close()
+	snap, err := diskstorage.ParseJSON(file, pattern)
+	if err != nil {
+		return nil, errors.New("ERR " + err.Error())
+	}
+	diskstorage.NewDiskStorage().Restore(snap)
+	lastImport = snap
+	return len(snap.Values), nil
+}
+
+// RewriteDebug propagates a JSON import as the commands that recreate the
+// imported keys, each preceded by a DEL since the import replaced them. The
+// other subcommands do not change the dataset.
+func RewriteDebug(args []string, result interface{}) [][]string {
+	if lastImport == nil {
+		return nil
+	}
+	var rewritten [][]string
+	for key := range lastImport.Values {
+		rewritten = append(rewritten, []string{"DEL", key})
+	}
+	rewritten = append(rewritten, aof.Commands(lastImport)...)
+	lastImport = nil
+	return rewritten
+}
@@ -0,0 +1,450 @@
+package command
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+var (
+	errHashNotInteger = errors.New("ERR hash value is not an integer")
+	errHashNotFloat   = errors.New("ERR hash value is not a float")
+	errFieldsArgument = errors.New("ERR Parameter `numFields` should be greater than 0 and match the number of arguments")
+)
+
+func HandleHSet(args []string) (interface{}, error) {
+	if len(args) < 4 || len(args)%2 != 0 {
+		return nil, errWrongArgs(args[0])
+	}
+	hash, err := inMemory.GetInMemoryStore().GetOrCreateHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+	added := 0
+	for i := 2; i < len(args); i += 2 {
+		if hash.Set(args[i], args[i+1]) {
+			added++
+		}
+	}
+	if strings.ToUpper(args[0]) == "HMSET" {
+		return "OK", nil
+	}
+	return added, nil
+}
+
+func HandleHSetNX(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	hash, err := inMemory.GetInMemoryStore().GetOrCreateHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := hash.Get(args[2]); exists {
+		return 0, nil
+	}
+	hash.Set(args[2], args[3])
+	return 1, nil
+}
+
+func HandleHGet(args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	hash, err := inMemory.GetInMemoryStore().GetHash(args[1])
+	if err != nil || hash == nil {
+		return nil, err
+	}
+	value, ok := hash.Get(args[2])
+	if !ok {
+		return nil, nil
+	}
+	return protocol.BulkString(value), nil
+}
+
+func HandleHMGet(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	hash, err := inMemory.GetInMemoryStore().GetHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]interface{}, len(args)-2)
+	for i, field := range args[2:] {
+		if hash == nil {
+			continue
+		}
+		if value, ok := hash.Get(field); ok {
+			reply[i] = value
+		}
+	}
+	return reply, nil
+}
+
+func HandleHDel(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	inmemory := inMemory.GetInMemoryStore()
+	hash, err := inmemory.GetHash(args[1])
+	if err != nil || hash == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range args[2:] {
+		if hash.Delete(field) {
+			removed++
+		}
+	}
+	deleteIfEmpty(inmemory, args[1], hash)
+	return removed, nil
+}
+
+func HandleHLen(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	hash, err := inMemory.GetInMemoryStore().GetHash(args[1])
+	if err != nil || hash == nil {
+		return 0, err
+	}
+	return hash.Len(), nil
+}
+
+func HandleHExists(args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	hash, err := inMemory.GetInMemoryStore().GetHash(args[1])
+	if err != nil || hash == nil {
+		return 0, err
+	}
+	if _, ok := hash.Get(args[2]); ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func HandleHGetAll(args []string) (interface{}, error) {
+	return hashContents(args, true, true)
+}
+
+func HandleHKeys(args []string) (interface{}, error) {
+	return hashContents(args, true, false)
+}
+
+func HandleHVals(args []string) (interface{}, error) {
+	return hashContents(args, false, true)
+}
+
+func hashContents(args []string, withFields, withValues bool) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	hash, err := inMemory.GetInMemoryStore().GetHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+	reply := []interface{}{}
+	if hash == nil {
+		return reply, nil
+	}
+	for _, field := range hash.Fields() {
+		value, _ := hash.Get(field)
+		if withFields {
+			reply = append(reply, field)
+		}
+		if withValues {
+			reply = append(reply, value)
+		}
+	}
+	return reply, nil
+}
+
+func HandleHIncrBy(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	increment, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	hash, err := inMemory.GetInMemoryStore().GetOrCreateHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var current int64
+	if value, ok := hash.Get(args[2]); ok {
+		current, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errHashNotInteger
+		}
+	}
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		return nil, errors.New("ERR increment or decrement would overflow")
+	}
+	current += increment
+	setKeepingExpiration(hash, args[2], strconv.FormatInt(current, 10))
+	return current, nil
+}
+
+func HandleHIncrByFloat(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	increment, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || math.IsNaN(increment) || math.IsInf(increment, 0) {
+		return nil, errNotFloat
+	}
+	hash, err := inMemory.GetInMemoryStore().GetOrCreateHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var current float64
+	if value, ok := hash.Get(args[2]); ok {
+		current, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errHashNotFloat
+		}
+	}
+	current += increment
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return nil, errors.New("ERR increment would produce NaN or Infinity")
+	}
+	result := strconv.FormatFloat(current, 'f', -1, 64)
+	setKeepingExpiration(hash, args[2], result)
+	return protocol.BulkString(result), nil
+}
+
+// setKeepingExpiration updates a field in place without clearing its TTL,
+// matching how Redis treats HINCRBY on a volatile field.
+func setKeepingExpiration(hash *inMemory.Hash, field, value string) {
+	expTime, hasExpiration := hash.GetExpiration(field)
+	hash.Set(field, value)
+	if hasExpiration {
+		hash.SetExpiration(field, expTime)
+	}
+}
+
+func HandleHScan(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	opts, err := parseScanArgs(args[2:], true)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := inMemory.GetInMemoryStore().GetHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		return []interface{}{"0", []interface{}{}}, nil
+	}
+
+	fields, next := opts.page(hash.Fields())
+	items := []interface{}{}
+	for _, field := range fields {
+		items = append(items, field)
+		if !opts.noValues {
+			value, _ := hash.Get(field)
+			items = append(items, value)
+		}
+	}
+	return []interface{}{strconv.Itoa(next), items}, nil
+}
+
+// Field expiration commands. Replies use the per-field status codes of
+// Redis 7.4: -2 missing field, 0 condition not met, 1 updated, 2 deleted.
+
+func HandleHExpire(args []string) (interface{}, error) {
+	return hashExpire(args, time.Second, false)
+}
+
+func HandleHPExpire(args []string) (interface{}, error) {
+	return hashExpire(args, time.Millisecond, false)
+}
+
+func HandleHExpireAt(args []string) (interface{}, error) {
+	return hashExpire(args, time.Second, true)
+}
+
+func HandleHPExpireAt(args []string) (interface{}, error) {
+	return hashExpire(args, time.Millisecond, true)
+}
+
+// hashExpire sets field TTLs. With absolute set the amount is a unix
+// timestamp instead of a relative TTL.
+func hashExpire(args []string, unit time.Duration, absolute bool) (interface{}, error) {
+	if len(args) < 6 {
+		return nil, errWrongArgs(args[0])
+	}
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+
+	condition := ""
+	rest := args[3:]
+	switch strings.ToUpper(rest[0]) {
+	case "NX", "XX", "GT", "LT":
+		condition = strings.ToUpper(rest[0])
+		rest = rest[1:]
+	}
+	fields, err := parseFieldsArgument(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	inmemory := inMemory.GetInMemoryStore()
+	hash, err := inmemory.GetHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]interface{}, len(fields))
+	if hash == nil {
+		for i := range reply {
+			reply[i] = -2
+		}
+		return reply, nil
+	}
+
+	expTime := time.Now().Add(time.Duration(amount) * unit)
+	if absolute {
+		expTime = time.Unix(0, 0).Add(time.Duration(amount) * unit)
+	}
+	for i, field := range fields {
+		if _, ok := hash.Get(field); !ok {
+			reply[i] = -2
+			continue
+		}
+		current, hasExpiration := hash.GetExpiration(field)
+		switch condition {
+		case "NX":
+			if hasExpiration {
+				reply[i] = 0
+				continue
+			}
+		case "XX":
+			if !hasExpiration {
+				reply[i] = 0
+				continue
+			}
+		case "GT":
+			// A field without TTL counts as an infinite TTL
+			if !hasExpiration || !expTime.After(current) {
+				reply[i] = 0
+				continue
+			}
+		case "LT":
+			if hasExpiration && !expTime.Before(current) {
+				reply[i] = 0
+				continue
+			}
+		}
+		if !expTime.After(time.Now()) {
+			hash.Delete(field)
+			reply[i] = 2
+			continue
+		}
+		hash.SetExpiration(field, expTime)
+		reply[i] = 1
+	}
+	deleteIfEmpty(inmemory, args[1], hash)
+	return reply, nil
+}
+
+func HandleHTTL(args []string) (interface{}, error) {
+	return hashTTL(args, time.Second)
+}
+
+func HandleHPTTL(args []string) (interface{}, error) {
+	return hashTTL(args, time.Millisecond)
+}
+
+func hashTTL(args []string, unit time.Duration) (interface{}, error) {
+	if len(args) < 5 {
+		return nil, errWrongArgs(args[0])
+	}
+	fields, err := parseFieldsArgument(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	hash, err := inMemory.GetInMemoryStore().GetHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if hash == nil {
+			reply[i] = -2
+			continue
+		}
+		if _, ok := hash.Get(field); !ok {
+			reply[i] = -2
+			continue
+		}
+		expTime, ok := hash.GetExpiration(field)
+		if !ok {
+			reply[i] = -1
+			continue
+		}
+		// Round to the nearest unit like TTL does in Redis
+		reply[i] = int64((time.Until(expTime) + unit/2) / unit)
+	}
+	return reply, nil
+}
+
+func HandleHPersist(args []string) (interface{}, error) {
+	if len(args) < 5 {
+		return nil, errWrongArgs(args[0])
+	}
+	fields, err := parseFieldsArgument(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	hash, err := inMemory.GetInMemoryStore().GetHash(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if hash == nil {
+			reply[i] = -2
+			continue
+		}
+		if _, ok := hash.Get(field); !ok {
+			reply[i] = -2
+			continue
+		}
+		if hash.Persist(field) {
+			reply[i] = 1
+		} else {
+			reply[i] = -1
+		}
+	}
+	return reply, nil
+}
+
+// parseFieldsArgument parses the "FIELDS numfields field [field ...]" tail
+// shared by the field expiration commands.
+func parseFieldsArgument(args []string) ([]string, error) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errSyntax
+	}
+	n, err := parseInt(args[1])
+	if err != nil || n <= 0 || n != len(args)-2 {
+		return nil, errFieldsArgument
+	}
+	return args[2:], nil
+}
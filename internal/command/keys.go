@@ -0,0 +1,116 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func HandleExpire(args []string) (interface{}, error) {
+	return expire(args, time.Second, false)
+}
+
+func HandlePExpire(args []string) (interface{}, error) {
+	return expire(args, time.Millisecond, false)
+}
+
+func HandleExpireAt(args []string) (interface{}, error) {
+	return expire(args, time.Second, true)
+}
+
+func HandlePExpireAt(args []string) (interface{}, error) {
+	return expire(args, time.Millisecond, true)
+}
+
+// expire implements the EXPIRE family: key amount [NX|XX|GT|LT]. With
+// absolute set the amount is a unix timestamp instead of a relative TTL.
+func expire(args []string, unit time.Duration, absolute bool) (interface{}, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	condition := ""
+	if len(args) == 4 {
+		condition = strings.ToUpper(args[3])
+		switch condition {
+		case "NX", "XX", "GT", "LT":
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	var expTime time.Time
+	if absolute {
+		expTime = time.Unix(0, 0).Add(time.Duration(amount) * unit)
+	} else {
+		expTime = time.Now().Add(time.Duration(amount) * unit)
+	}
+
+	key := args[1]
+	inmemory := inMemory.GetInMemoryStore()
+	if !inmemory.Exists(key) {
+		return 0, nil
+	}
+	current, hasExpiration := inmemory.GetExpiration(key)
+	switch condition {
+	case "NX":
+		if hasExpiration {
+			return 0, nil
+		}
+	case "XX":
+		if !hasExpiration {
+			return 0, nil
+		}
+	case "GT":
+		// A key without TTL counts as an infinite TTL
+		if !hasExpiration || !expTime.After(current) {
+			return 0, nil
+		}
+	case "LT":
+		if hasExpiration && !expTime.Before(current) {
+			return 0, nil
+		}
+	}
+
+	if !expTime.After(time.Now()) {
+		inmemory.Delete(key)
+		return 1, nil
+	}
+	inmemory.SetExpiration(key, expTime)
+	return 1, nil
+}
+
+func HandlePersist(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	inmemory := inMemory.GetInMemoryStore()
+	if !inmemory.Exists(args[1]) {
+		return 0, nil
+	}
+	if _, ok := inmemory.GetExpiration(args[1]); !ok {
+		return 0, nil
+	}
+	inmemory.DeleteExpiration(args[1])
+	return 1, nil
+}
+
+func HandlePTTL(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	inmemory := inMemory.GetInMemoryStore()
+	if !inmemory.Exists(args[1]) {
+		return -2, nil
+	}
+	expTime, ok := inmemory.GetExpiration(args[1])
+	if !ok {
+		return -1, nil
+	}
+	return time.Until(expTime).Milliseconds(), nil
+}
@@ -0,0 +1,328 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func HandleLPush(args []string) (interface{}, error) {
+	return push(args, true, false)
+}
+
+func HandleRPush(args []string) (interface{}, error) {
+	return push(args, false, false)
+}
+
+func HandleLPushX(args []string) (interface{}, error) {
+	return push(args, true, true)
+}
+
+func HandleRPushX(args []string) (interface{}, error) {
+	return push(args, false, true)
+}
+
+// push implements the LPUSH/RPUSH family. When onlyExisting is set the
+// elements are only added if the list already exists.
+func push(args []string, front, onlyExisting bool) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	key := args[1]
+	inmemory := inMemory.GetInMemoryStore()
+
+	var list *inMemory.List
+	var err error
+	if onlyExisting {
+		list, err = inmemory.GetList(key)
+		if err != nil || list == nil {
+			return 0, err
+		}
+	} else {
+		list, err = inmemory.GetOrCreateList(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, value := range args[2:] {
+		if front {
+			list.PushFront(value)
+		} else {
+			list.PushBack(value)
+		}
+	}
+	return list.Len(), nil
+}
+
+func HandleLPop(args []string) (interface{}, error) {
+	return pop(args, true)
+}
+
+func HandleRPop(args []string) (interface{}, error) {
+	return pop(args, false)
+}
+
+func pop(args []string, front bool) (interface{}, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	key := args[1]
+	inmemory := inMemory.GetInMemoryStore()
+
+	count := -1
+	if len(args) == 3 {
+		n, err := parseInt(args[2])
+		if err != nil || n < 0 {
+			return nil, errNotInteger
+		}
+		count = n
+	}
+
+	list, err := inmemory.GetList(key)
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		// A missing key gives a null array when a count was asked for
+		if count >= 0 {
+			return protocol.NullArray{}, nil
+		}
+		return nil, nil
+	}
+
+	popOne := func() (string, bool) {
+		if front {
+			return list.PopFront()
+		}
+		return list.PopBack()
+	}
+
+	var reply interface{}
+	if count < 0 {
+		value, _ := popOne()
+		reply = protocol.BulkString(value)
+	} else {
+		values := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			value, ok := popOne()
+			if !ok {
+				break
+			}
+			values = append(values, value)
+		}
+		reply = values
+	}
+
+	deleteIfEmpty(inmemory, key, list)
+	return reply, nil
+}
+
+func HandleLLen(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	list, err := inMemory.GetInMemoryStore().GetList(args[1])
+	if err != nil || list == nil {
+		return 0, err
+	}
+	return list.Len(), nil
+}
+
+func HandleLRange(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	start, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := inMemory.GetInMemoryStore().GetList(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		return []interface{}{}, nil
+	}
+	return stringsToReply(list.Range(start, stop)), nil
+}
+
+func HandleLIndex(args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	index, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := inMemory.GetInMemoryStore().GetList(args[1])
+	if err != nil || list == nil {
+		return nil, err
+	}
+	value, ok := list.Index(index)
+	if !ok {
+		return nil, nil
+	}
+	return protocol.BulkString(value), nil
+}
+
+func HandleLSet(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	index, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := inMemory.GetInMemoryStore().GetList(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		return nil, errNoSuchKey
+	}
+	if !list.Set(index, args[3]) {
+		return nil, errIndexOutOfRange
+	}
+	return "OK", nil
+}
+
+func HandleLRem(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	count, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	inmemory := inMemory.GetInMemoryStore()
+	list, err := inmemory.GetList(args[1])
+	if err != nil || list == nil {
+		return 0, err
+	}
+	removed := list.Remove(count, args[3])
+	deleteIfEmpty(inmemory, args[1], list)
+	return removed, nil
+}
+
+func HandleLTrim(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	start, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	inmemory := inMemory.GetInMemoryStore()
+	list, err := inmemory.GetList(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if list != nil {
+		list.Trim(start, stop)
+		deleteIfEmpty(inmemory, args[1], list)
+	}
+	return "OK", nil
+}
+
+func HandleLInsert(args []string) (interface{}, error) {
+	if len(args) != 5 {
+		return nil, errWrongArgs(args[0])
+	}
+	var before bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		return nil, errSyntax
+	}
+
+	list, err := inMemory.GetInMemoryStore().GetList(args[1])
+	if err != nil || list == nil {
+		return 0, err
+	}
+	return list.Insert(before, args[3], args[4]), nil
+}
+
+func HandleLMove(args []string) (interface{}, error) {
+	if len(args) != 5 {
+		return nil, errWrongArgs(args[0])
+	}
+	fromLeft, err := parseListSide(args[3])
+	if err != nil {
+		return nil, err
+	}
+	toLeft, err := parseListSide(args[4])
+	if err != nil {
+		return nil, err
+	}
+	return move(args[1], args[2], fromLeft, toLeft)
+}
+
+func HandleRPopLPush(args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	return move(args[1], args[2], false, true)
+}
+
+func parseListSide(side string) (bool, error) {
+	switch strings.ToUpper(side) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	default:
+		return false, errSyntax
+	}
+}
+
+// move pops an element from one end of source and pushes it onto one end
+// of destination, which may be the same list.
+func move(source, destination string, fromLeft, toLeft bool) (interface{}, error) {
+	inmemory := inMemory.GetInMemoryStore()
+	src, err := inmemory.GetList(source)
+	if err != nil || src == nil {
+		return nil, err
+	}
+	// Check the destination type before popping so a WRONGTYPE error
+	// leaves the source untouched
+	if _, err := inmemory.GetList(destination); err != nil {
+		return nil, err
+	}
+
+	var value string
+	if fromLeft {
+		value, _ = src.PopFront()
+	} else {
+		value, _ = src.PopBack()
+	}
+	deleteIfEmpty(inmemory, source, src)
+
+	dst, err := inmemory.GetOrCreateList(destination)
+	if err != nil {
+		return nil, err
+	}
+	if toLeft {
+		dst.PushFront(value)
+	} else {
+		dst.PushBack(value)
+	}
+	return protocol.BulkString(value), nil
+}
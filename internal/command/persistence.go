@@ -0,0 +1,130 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/aof"
+	diskstorage "github.com/bhaski-1234/redis-db/storage/diskStorage"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func HandleBgSave(args []string) (interface{}, error) {
+	if len(args) > 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	if err := diskstorage.NewDiskStorage().BackgroundSave("dump"); err != nil {
+		return nil, err
+	}
+	return "Background saving started", nil
+}
+
+func HandleLastSave(args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errWrongArgs(args[0])
+	}
+	return diskstorage.Status().LastSaveTime.Unix(), nil
+}
+
+func HandleBgRewriteAof(args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errWrongArgs(args[0])
+	}
+	log := aof.Active()
+	if log == nil {
+		return nil, errors.New("ERR AOF is not enabled")
+	}
+	if err := log.StartRewrite(); err != nil {
+		return nil, err
+	}
+	return "Background append only file rewriting started", nil
+}
+
+type infoSection struct {
+	name   string
+	render func(b *strings.Builder)
+}
+
+// infoSections lists the INFO sections in the order they are reported.
+var infoSections = []infoSection{
+	{"persistence", infoPersistence},
+}
+
+// AddInfoSection adds a section to INFO that is rendered outside this
+// package, e.g. by the server.
+func AddInfoSection(name string, render func(b *strings.Builder)) {
+	infoSections = append(infoSections, infoSection{name, render})
+}
+
+func boolFlag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func statusText(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "err"
+}
+
+func infoPersistence(b *strings.Builder) {
+	status := diskstorage.Status()
+	lastBgsave := int64(-1)
+	if status.LastBgsaveTime >= 0 {
+		lastBgsave = int64(status.LastBgsaveTime / time.Second)
+	}
+	currentBgsave := int64(-1)
+	if status.InProgress {
+		currentBgsave = int64(time.Since(status.CurrentStartTime) / time.Second)
+	}
+	fmt.Fprintf(b, "loading:0\r\n")
+	fmt.Fprintf(b, "rdb_changes_since_last_save:%d\r\n", inMemory.GetInMemoryStore().Dirty())
+	fmt.Fprintf(b, "rdb_bgsave_in_progress:%d\r\n", boolFlag(status.InProgress))
+	fmt.Fprintf(b, "rdb_last_save_time:%d\r\n", status.LastSaveTime.Unix())
+	fmt.Fprintf(b, "rdb_last_bgsave_status:%s\r\n", statusText(status.LastBgsaveOK))
+	fmt.Fprintf(b, "rdb_last_bgsave_time_sec:%d\r\n", lastBgsave)
+	fmt.Fprintf(b, "rdb_current_bgsave_time_sec:%d\r\n", currentBgsave)
+	fmt.Fprintf(b, "current_save_keys_processed:%d\r\n", status.KeysProcessed)
+	fmt.Fprintf(b, "current_save_keys_total:%d\r\n", status.KeysTotal)
+
+	log := aof.Active()
+	fmt.Fprintf(b, "aof_enabled:%d\r\n", boolFlag(config.AppendOnly))
+	if log == nil {
+		fmt.Fprintf(b, "aof_rewrite_in_progress:0\r\n")
+		return
+	}
+	current, base := log.Sizes()
+	fmt.Fprintf(b, "aof_rewrite_in_progress:%d\r\n", boolFlag(log.Rewriting()))
+	fmt.Fprintf(b, "aof_current_size:%d\r\n", current)
+	fmt.Fprintf(b, "aof_base_size:%d\r\n", base)
+}
+
+// HandleInfo implements INFO [section ...]. Without arguments, or with
+// "all" or "everything", every section is reported.
+func HandleInfo(args []string) (interface{}, error) {
+	wanted := map[string]bool{}
+	for _, arg := range args[1:] {
+		wanted[strings.ToLower(arg)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["everything"] || wanted["default"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s%s\r\n", strings.ToUpper(section.name[:1]), section.name[1:])
+		section.render(&b)
+	}
+	return protocol.BulkString(b.String()), nil
+}
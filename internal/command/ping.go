@@ -1,8 +1,10 @@
 package command
 
+import "github.com/bhaski-1234/redis-db/protocol"
+
 func HandlePing(args []string) (interface{}, error) {
 	if len(args) == 1 {
 		return "PONG", nil
 	}
-	return args[1], nil
+	return protocol.BulkString(args[1]), nil
 }
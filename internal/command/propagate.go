@@ -0,0 +1,198 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// Rewrites turn a successfully executed write command into the commands
+// that reproduce its effect deterministically. Relative TTLs become absolute
+// timestamps and generated values such as stream IDs or popped members are
+// spelled out, so that replaying the log later yields the same dataset.
+
+func unixMilli(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// RewriteIfChanged propagates a command unchanged unless its reply shows
+// that it changed nothing: a count of zero, or -1 for a LINSERT pivot that
+// was not found, a nil reply or an empty array.
+func RewriteIfChanged(args []string, result interface{}) [][]string {
+	switch r := result.(type) {
+	case nil, protocol.NullArray:
+		return nil
+	case int:
+		if r <= 0 {
+			return nil
+		}
+	case []interface{}:
+		if len(r) == 0 {
+			return nil
+		}
+	}
+	return [][]string{args}
+}
+
+func RewriteSet(args []string, result interface{}) [][]string {
+	if len(args) < 3 {
+		return nil
+	}
+	set := []string{"SET", args[1], args[2]}
+	expTime, ok := inMemory.GetInMemoryStore().GetExpiration(args[1])
+	if len(args) <= 4 || args[3] != "EX" || !ok {
+		return [][]string{set}
+	}
+	return [][]string{set, {"PEXPIREAT", args[1], unixMilli(expTime)}}
+}
+
+// RewriteExpire covers EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT.
+func RewriteExpire(args []string, result interface{}) [][]string {
+	if result == 0 {
+		return nil
+	}
+	expTime, ok := inMemory.GetInMemoryStore().GetExpiration(args[1])
+	if !ok {
+		// A timestamp in the past deleted the key
+		return [][]string{{"DEL", args[1]}}
+	}
+	return [][]string{{"PEXPIREAT", args[1], unixMilli(expTime)}}
+}
+
+// RewriteHExpire covers the HEXPIRE family, splitting the fields into those
+// that got a new TTL and those that were deleted.
+func RewriteHExpire(args []string, result interface{}) [][]string {
+	codes, ok := result.([]interface{})
+	if !ok {
+		return nil
+	}
+	fields, err := parseFieldsArgument(args[len(args)-len(codes)-2:])
+	if err != nil {
+		return nil
+	}
+
+	var updated, deleted []string
+	for i, code := range codes {
+		switch code {
+		case 1:
+			updated = append(updated, fields[i])
+		case 2:
+			deleted = append(deleted, fields[i])
+		}
+	}
+
+	var rewritten [][]string
+	if len(updated) > 0 {
+		hash, _ := inMemory.GetInMemoryStore().GetHash(args[1])
+		if hash != nil {
+			if expTime, ok := hash.GetExpiration(updated[0]); ok {
+				cmd := []string{"HPEXPIREAT", args[1], unixMilli(expTime), "FIELDS", strconv.Itoa(len(updated))}
+				rewritten = append(rewritten, append(cmd, updated...))
+			}
+		}
+	}
+	if len(deleted) > 0 {
+		rewritten = append(rewritten, append([]string{"HDEL", args[1]}, deleted...))
+	}
+	return rewritten
+}
+
+// RewriteSPop replaces the random choice with the members actually removed.
+func RewriteSPop(args []string, result interface{}) [][]string {
+	var members []string
+	switch popped := result.(type) {
+	case protocol.BulkString:
+		members = []string{string(popped)}
+	case []interface{}:
+		for _, m := range popped {
+			members = append(members, m.(string))
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return [][]string{append([]string{"SREM", args[1]}, members...)}
+}
+
+// RewriteXAdd replaces an auto-generated ID with the one that was assigned.
+func RewriteXAdd(args []string, result interface{}) [][]string {
+	id, ok := result.(protocol.BulkString)
+	if !ok {
+		return nil
+	}
+	i := 2
+	for i < len(args) {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			i++
+			continue
+		case "MAXLEN", "MINID":
+			_, n, err := parseTrimArgs(args[i:])
+			if err != nil {
+				return nil
+			}
+			i += n
+			continue
+		}
+		break
+	}
+	rewritten := append([]string(nil), args...)
+	rewritten[i] = string(id)
+	return [][]string{rewritten}
+}
+
+// claimedIDs extracts the IDs from an XCLAIM style reply, which holds
+// either bare IDs or [id, fields] entries.
+func claimedIDs(reply []interface{}) []string {
+	ids := make([]string, 0, len(reply))
+	for _, item := range reply {
+		switch v := item.(type) {
+		case string:
+			ids = append(ids, v)
+		case []interface{}:
+			ids = append(ids, v[0].(string))
+		}
+	}
+	return ids
+}
+
+// RewriteXClaim drops the idle time condition and the IDs that were not
+// claimed, since idle times are not reproducible on replay.
+func RewriteXClaim(args []string, result interface{}) [][]string {
+	claimed := claimedIDs(result.([]interface{}))
+	if len(claimed) == 0 {
+		return nil
+	}
+	i := 5
+	for i < len(args) {
+		if _, err := inMemory.ParseStreamID(args[i], 0); err != nil {
+			break
+		}
+		i++
+	}
+	rewritten := append([]string{"XCLAIM", args[1], args[2], args[3], "0"}, claimed...)
+	return [][]string{append(rewritten, args[i:]...)}
+}
+
+// RewriteXAutoClaim turns XAUTOCLAIM into an XCLAIM of the entries it
+// transferred and an XACK of the deleted entries it removed from the PEL.
+func RewriteXAutoClaim(args []string, result interface{}) [][]string {
+	reply := result.([]interface{})
+	var rewritten [][]string
+	if claimed := claimedIDs(reply[1].([]interface{})); len(claimed) > 0 {
+		cmd := append([]string{"XCLAIM", args[1], args[2], args[3], "0"}, claimed...)
+		for _, arg := range args[5:] {
+			if strings.ToUpper(arg) == "JUSTID" {
+				cmd = append(cmd, "JUSTID")
+			}
+		}
+		rewritten = append(rewritten, cmd)
+	}
+	if deleted := claimedIDs(reply[2].([]interface{})); len(deleted) > 0 {
+		rewritten = append(rewritten, append([]string{"XACK", args[1], args[2]}, deleted...))
+	}
+	return rewritten
+}
@@ -0,0 +1,397 @@
+package command
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func HandleSAdd(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	set, err := inMemory.GetInMemoryStore().GetOrCreateSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	added := 0
+	for _, member := range args[2:] {
+		if set.Add(member) {
+			added++
+		}
+	}
+	return added, nil
+}
+
+func HandleSRem(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	inmemory := inMemory.GetInMemoryStore()
+	set, err := inmemory.GetSet(args[1])
+	if err != nil || set == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range args[2:] {
+		if set.Remove(member) {
+			removed++
+		}
+	}
+	deleteIfEmpty(inmemory, args[1], set)
+	return removed, nil
+}
+
+func HandleSMembers(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	set, err := inMemory.GetInMemoryStore().GetSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		return []interface{}{}, nil
+	}
+	return stringsToReply(set.Members()), nil
+}
+
+func HandleSIsMember(args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	set, err := inMemory.GetInMemoryStore().GetSet(args[1])
+	if err != nil || set == nil {
+		return 0, err
+	}
+	if set.Contains(args[2]) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func HandleSMIsMember(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	set, err := inMemory.GetInMemoryStore().GetSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]interface{}, len(args)-2)
+	for i, member := range args[2:] {
+		reply[i] = 0
+		if set != nil && set.Contains(member) {
+			reply[i] = 1
+		}
+	}
+	return reply, nil
+}
+
+func HandleSCard(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	set, err := inMemory.GetInMemoryStore().GetSet(args[1])
+	if err != nil || set == nil {
+		return 0, err
+	}
+	return set.Len(), nil
+}
+
+func HandleSMove(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	inmemory := inMemory.GetInMemoryStore()
+	src, err := inmemory.GetSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := inmemory.GetSet(args[2]); err != nil {
+		return nil, err
+	}
+	if src == nil || !src.Remove(args[3]) {
+		return 0, nil
+	}
+	deleteIfEmpty(inmemory, args[1], src)
+
+	dst, err := inmemory.GetOrCreateSet(args[2])
+	if err != nil {
+		return nil, err
+	}
+	dst.Add(args[3])
+	return 1, nil
+}
+
+func HandleSPop(args []string) (interface{}, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	count := -1
+	if len(args) == 3 {
+		n, err := parseInt(args[2])
+		if err != nil || n < 0 {
+			return nil, errors.New("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	inmemory := inMemory.GetInMemoryStore()
+	set, err := inmemory.GetSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		if count < 0 {
+			return nil, nil
+		}
+		return []interface{}{}, nil
+	}
+
+	var reply interface{}
+	if count < 0 {
+		reply = protocol.BulkString(set.Pop(1)[0])
+	} else {
+		reply = stringsToReply(set.Pop(count))
+	}
+	deleteIfEmpty(inmemory, args[1], set)
+	return reply, nil
+}
+
+// maxRandomCount bounds the reply of SRANDMEMBER with a negative count,
+// which does not depend on the size of the set.
+const maxRandomCount = 1 << 20
+
+func HandleSRandMember(args []string) (interface{}, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	set, err := inMemory.GetInMemoryStore().GetSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 2 {
+		if set == nil {
+			return nil, nil
+		}
+		return protocol.BulkString(set.RandomMembers(1, true)[0]), nil
+	}
+
+	count, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	if set == nil || count == 0 {
+		return []interface{}{}, nil
+	}
+	// A negative count allows the same member to be returned several times
+	if count < 0 {
+		if count < -maxRandomCount {
+			return nil, errors.New("ERR value is out of range")
+		}
+		return stringsToReply(set.RandomMembers(-count, false)), nil
+	}
+	return stringsToReply(set.RandomMembers(count, true)), nil
+}
+
+func HandleSScan(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	opts, err := parseScanArgs(args[2:], false)
+	if err != nil {
+		return nil, err
+	}
+	set, err := inMemory.GetInMemoryStore().GetSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		return []interface{}{"0", []interface{}{}}, nil
+	}
+	members, next := opts.page(set.Members())
+	return []interface{}{strconv.Itoa(next), stringsToReply(members)}, nil
+}
+
+// Set algebra. Missing keys behave as empty sets.
+
+type setOperation int
+
+const (
+	setInter setOperation = iota
+	setUnion
+	setDiff
+)
+
+// loadSets fetches the sets stored at keys, failing if any key holds another
+// type. Missing keys are returned as nil entries.
+func loadSets(keys []string) ([]*inMemory.Set, error) {
+	inmemory := inMemory.GetInMemoryStore()
+	sets := make([]*inMemory.Set, len(keys))
+	for i, key := range keys {
+		set, err := inmemory.GetSet(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
+// combineSets applies op to the sets stored at keys and returns the
+// resulting members in a fresh set.
+func combineSets(op setOperation, keys []string) (*inMemory.Set, error) {
+	sets, err := loadSets(keys)
+	if err != nil {
+		return nil, err
+	}
+	result := inMemory.NewSet()
+
+	switch op {
+	case setUnion:
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			for _, member := range set.Members() {
+				result.Add(member)
+			}
+		}
+	case setInter:
+		for _, set := range sets {
+			if set == nil {
+				return result, nil
+			}
+		}
+		// Iterate the smallest set and probe the others
+		smallest := sets[0]
+		for _, set := range sets[1:] {
+			if set.Len() < smallest.Len() {
+				smallest = set
+			}
+		}
+		for _, member := range smallest.Members() {
+			inAll := true
+			for _, set := range sets {
+				if !set.Contains(member) {
+					inAll = false
+					break
+				}
+			}
+			if inAll {
+				result.Add(member)
+			}
+		}
+	case setDiff:
+		if sets[0] == nil {
+			return result, nil
+		}
+		for _, member := range sets[0].Members() {
+			found := false
+			for _, set := range sets[1:] {
+				if set != nil && set.Contains(member) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				result.Add(member)
+			}
+		}
+	}
+	return result, nil
+}
+
+func setAlgebra(args []string, op setOperation) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	result, err := combineSets(op, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return stringsToReply(result.Members()), nil
+}
+
+// setAlgebraStore stores the result at the destination key, replacing any
+// previous value of any type, and replies with its cardinality.
+func setAlgebraStore(args []string, op setOperation) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	result, err := combineSets(op, args[2:])
+	if err != nil {
+		return nil, err
+	}
+	inmemory := inMemory.GetInMemoryStore()
+	inmemory.Delete(args[1])
+	if result.Len() > 0 {
+		inmemory.SetValue(args[1], result)
+	}
+	return result.Len(), nil
+}
+
+func HandleSInter(args []string) (interface{}, error) {
+	return setAlgebra(args, setInter)
+}
+
+func HandleSUnion(args []string) (interface{}, error) {
+	return setAlgebra(args, setUnion)
+}
+
+func HandleSDiff(args []string) (interface{}, error) {
+	return setAlgebra(args, setDiff)
+}
+
+func HandleSInterStore(args []string) (interface{}, error) {
+	return setAlgebraStore(args, setInter)
+}
+
+func HandleSUnionStore(args []string) (interface{}, error) {
+	return setAlgebraStore(args, setUnion)
+}
+
+func HandleSDiffStore(args []string) (interface{}, error) {
+	return setAlgebraStore(args, setDiff)
+}
+
+// HandleSInterCard implements SINTERCARD numkeys key [key ...] [LIMIT limit].
+func HandleSInterCard(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	numKeys, err := parseInt(args[1])
+	if err != nil || numKeys <= 0 {
+		return nil, errors.New("ERR numkeys should be greater than 0")
+	}
+	if len(args) < 2+numKeys {
+		return nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	keys := args[2 : 2+numKeys]
+	rest := args[2+numKeys:]
+
+	limit := 0
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(rest[0]) != "LIMIT" {
+			return nil, errSyntax
+		}
+		limit, err = parseInt(rest[1])
+		if err != nil || limit < 0 {
+			return nil, errors.New("ERR LIMIT can't be negative")
+		}
+	}
+
+	result, err := combineSets(setInter, keys)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && result.Len() > limit {
+		return limit, nil
+	}
+	return result.Len(), nil
+}
@@ -0,0 +1,1063 @@
+package command
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+var (
+	errStreamIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDZero     = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	errStreamExhausted  = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+	errBusyGroup        = errors.New("BUSYGROUP Consumer Group name already exists")
+	errUnbalancedXRead  = errors.New("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	errXGroupNoKey      = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+)
+
+func errNoGroup(key, group string) error {
+	return errors.New("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+// entryReply renders an entry as [id, [field, value, ...]].
+func entryReply(entry inMemory.StreamEntry) []interface{} {
+	return []interface{}{entry.ID.String(), stringsToReply(entry.Fields)}
+}
+
+func entriesReply(entries []inMemory.StreamEntry) []interface{} {
+	reply := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		reply[i] = entryReply(entry)
+	}
+	return reply
+}
+
+// trimSpec holds the parsed MAXLEN/MINID arguments shared by XADD and XTRIM.
+type trimSpec struct {
+	strategy string
+	approx   bool
+	maxLen   int
+	minID    inMemory.StreamID
+	limit    int
+}
+
+// parseTrimArgs parses "MAXLEN|MINID [=|~] threshold [LIMIT count]" at the
+// start of args and returns the number of arguments consumed.
+func parseTrimArgs(args []string) (*trimSpec, int, error) {
+	spec := &trimSpec{strategy: strings.ToUpper(args[0])}
+	i := 1
+	if i < len(args) && (args[i] == "~" || args[i] == "=") {
+		spec.approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, errSyntax
+	}
+	switch spec.strategy {
+	case "MAXLEN":
+		n, err := parseInt(args[i])
+		if err != nil || n < 0 {
+			return nil, 0, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		spec.maxLen = n
+	case "MINID":
+		id, err := inMemory.ParseStreamID(args[i], 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		spec.minID = id
+	}
+	i++
+	if i+1 < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		if !spec.approx {
+			return nil, 0, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		n, err := parseInt(args[i+1])
+		if err != nil || n < 0 {
+			return nil, 0, errNotInteger
+		}
+		spec.limit = n
+		i += 2
+	}
+	return spec, i, nil
+}
+
+func (spec *trimSpec) apply(stream *inMemory.Stream) int {
+	if spec.strategy == "MAXLEN" {
+		return stream.TrimMaxLen(spec.maxLen, spec.approx, spec.limit)
+	}
+	return stream.TrimMinID(spec.minID, spec.approx, spec.limit)
+}
+
+// nextStreamID resolves the ID argument of XADD ("*", "ms-*" or explicit)
+// against the last ID of the stream.
+func nextStreamID(arg string, last inMemory.StreamID) (inMemory.StreamID, error) {
+	if arg == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > last.Ms {
+			return inMemory.StreamID{Ms: ms}, nil
+		}
+		next, ok := last.Next()
+		if !ok {
+			return next, errStreamExhausted
+		}
+		return next, nil
+	}
+
+	if msPart, found := strings.CutSuffix(arg, "-*"); found {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return inMemory.StreamID{}, inMemory.ErrInvalidStreamID
+		}
+		switch {
+		case ms > last.Ms:
+			if ms == 0 {
+				return inMemory.StreamID{Ms: 0, Seq: 1}, nil
+			}
+			return inMemory.StreamID{Ms: ms}, nil
+		case ms == last.Ms:
+			if last.Seq == math.MaxUint64 {
+				return inMemory.StreamID{}, errStreamIDTooSmall
+			}
+			return inMemory.StreamID{Ms: ms, Seq: last.Seq + 1}, nil
+		default:
+			return inMemory.StreamID{}, errStreamIDTooSmall
+		}
+	}
+
+	id, err := inMemory.ParseStreamID(arg, 0)
+	if err != nil {
+		return id, err
+	}
+	if id.IsZero() {
+		return id, errStreamIDZero
+	}
+	if id.Compare(last) <= 0 {
+		return id, errStreamIDTooSmall
+	}
+	return id, nil
+}
+
+// HandleXAdd implements XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold
+// [LIMIT count]] *|id field value [field value ...].
+func HandleXAdd(args []string) (interface{}, error) {
+	if len(args) < 5 {
+		return nil, errWrongArgs(args[0])
+	}
+	key := args[1]
+	noMkStream := false
+	var trim *trimSpec
+
+	i := 2
+	for i < len(args) {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			i++
+			continue
+		case "MAXLEN", "MINID":
+			spec, n, err := parseTrimArgs(args[i:])
+			if err != nil {
+				return nil, err
+			}
+			trim = spec
+			i += n
+			continue
+		}
+		break
+	}
+
+	if i >= len(args) {
+		return nil, errWrongArgs(args[0])
+	}
+	idArg := args[i]
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return nil, errWrongArgs(args[0])
+	}
+
+	inmemory := inMemory.GetInMemoryStore()
+	stream, err := inmemory.GetStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil && noMkStream {
+		return nil, nil
+	}
+
+	last := inMemory.StreamID{}
+	if stream != nil {
+		last = stream.LastID
+	}
+	id, err := nextStreamID(idArg, last)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream == nil {
+		stream = inMemory.NewStream()
+		inmemory.SetValue(key, stream)
+	}
+	stream.Append(id, append([]string(nil), fields...))
+	if trim != nil {
+		trim.apply(stream)
+	}
+	return protocol.BulkString(id.String()), nil
+}
+
+func HandleXLen(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	stream, err := inMemory.GetInMemoryStore().GetStream(args[1])
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	return stream.Len(), nil
+}
+
+// parseRangeID parses an XRANGE bound: "-", "+", an exclusive "(id" or a
+// possibly incomplete ID.
+func parseRangeID(arg string, isStart bool) (inMemory.StreamID, bool, error) {
+	switch arg {
+	case "-":
+		return inMemory.StreamID{}, true, nil
+	case "+":
+		return inMemory.MaxStreamID, true, nil
+	}
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	defaultSeq := uint64(0)
+	if !isStart {
+		defaultSeq = math.MaxUint64
+	}
+	id, err := inMemory.ParseStreamID(arg, defaultSeq)
+	if err != nil {
+		return id, false, err
+	}
+	if !exclusive {
+		return id, true, nil
+	}
+	// An exclusive bound is turned into the adjacent inclusive one
+	var ok bool
+	if isStart {
+		id, ok = id.Next()
+	} else {
+		id, ok = id.Prev()
+	}
+	if !ok {
+		return id, false, errors.New("ERR invalid start ID for the interval")
+	}
+	return id, true, nil
+}
+
+func HandleXRange(args []string) (interface{}, error) {
+	return xrange(args, false)
+}
+
+func HandleXRevRange(args []string) (interface{}, error) {
+	return xrange(args, true)
+}
+
+func xrange(args []string, reverse bool) (interface{}, error) {
+	if len(args) != 4 && len(args) != 6 {
+		return nil, errWrongArgs(args[0])
+	}
+	startArg, endArg := args[2], args[3]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, _, err := parseRangeID(startArg, true)
+	if err != nil {
+		return nil, err
+	}
+	end, _, err := parseRangeID(endArg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	count := -1
+	if len(args) == 6 {
+		if strings.ToUpper(args[4]) != "COUNT" {
+			return nil, errSyntax
+		}
+		count, err = parseInt(args[5])
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			count = 0
+		}
+	}
+
+	stream, err := inMemory.GetInMemoryStore().GetStream(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil {
+		return []interface{}{}, nil
+	}
+	return entriesReply(stream.Range(start, end, count, reverse)), nil
+}
+
+func HandleXDel(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	ids := make([]inMemory.StreamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, err := inMemory.ParseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	stream, err := inMemory.GetInMemoryStore().GetStream(args[1])
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, id := range ids {
+		if stream.Delete(id) {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func HandleXTrim(args []string) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	switch strings.ToUpper(args[2]) {
+	case "MAXLEN", "MINID":
+	default:
+		return nil, errSyntax
+	}
+	spec, n, err := parseTrimArgs(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	if 2+n != len(args) {
+		return nil, errSyntax
+	}
+	stream, err := inMemory.GetInMemoryStore().GetStream(args[1])
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	return spec.apply(stream), nil
+}
+
+// readOptions holds the arguments shared by XREAD and XREADGROUP.
+type readOptions struct {
+	count int
+	noAck bool
+	keys  []string
+	ids   []string
+}
+
+// parseReadArgs parses "[COUNT n] [BLOCK ms] [NOACK] STREAMS key ... id ...".
+// BLOCK is accepted for compatibility but the read never blocks: the event
+// loop has no way to park a client, so an empty result is returned at once.
+func parseReadArgs(args []string, allowNoAck bool) (*readOptions, error) {
+	opts := &readOptions{count: -1}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				opts.count = n
+			}
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			if _, err := parseInt(args[i+1]); err != nil {
+				return nil, errors.New("ERR timeout is not an integer or out of range")
+			}
+			i++
+		case "NOACK":
+			if !allowNoAck {
+				return nil, errSyntax
+			}
+			opts.noAck = true
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, errUnbalancedXRead
+			}
+			opts.keys = rest[:len(rest)/2]
+			opts.ids = rest[len(rest)/2:]
+			return opts, nil
+		default:
+			return nil, errSyntax
+		}
+	}
+	return nil, errSyntax
+}
+
+// HandleXRead implements XREAD [COUNT n] [BLOCK ms] STREAMS key ... id ...
+func HandleXRead(args []string) (interface{}, error) {
+	opts, err := parseReadArgs(args[1:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	inmemory := inMemory.GetInMemoryStore()
+	reply := []interface{}{}
+	for i, key := range opts.keys {
+		stream, err := inmemory.GetStream(key)
+		if err != nil {
+			return nil, err
+		}
+
+		var after inMemory.StreamID
+		if opts.ids[i] == "$" {
+			if stream == nil {
+				continue
+			}
+			after = stream.LastID
+		} else if after, err = inMemory.ParseStreamID(opts.ids[i], 0); err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			continue
+		}
+
+		start, ok := after.Next()
+		if !ok {
+			continue
+		}
+		entries := stream.Range(start, inMemory.MaxStreamID, opts.count, false)
+		if len(entries) > 0 {
+			reply = append(reply, []interface{}{key, entriesReply(entries)})
+		}
+	}
+	if len(reply) == 0 {
+		return protocol.NullArray{}, nil
+	}
+	return reply, nil
+}
+
+// lookupGroup returns the stream and consumer group, or a NOGROUP error.
+func lookupGroup(key, group string) (*inMemory.Stream, *inMemory.ConsumerGroup, error) {
+	stream, err := inMemory.GetInMemoryStore().GetStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream == nil {
+		return nil, nil, errNoGroup(key, group)
+	}
+	g, ok := stream.Groups[group]
+	if !ok {
+		return nil, nil, errNoGroup(key, group)
+	}
+	return stream, g, nil
+}
+
+// HandleXSetID implements XSETID key last-id [ENTRIESADDED n]
+// [MAXDELETEDID id], which sets the stream's bookkeeping directly.
+func HandleXSetID(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	lastID, err := inMemory.ParseStreamID(args[2], 0)
+	if err != nil {
+		return nil, err
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID *inMemory.StreamID
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			id, err := inMemory.ParseStreamID(args[i+1], 0)
+			if err != nil {
+				return nil, err
+			}
+			maxDeletedID = &id
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	stream, err := inMemory.GetInMemoryStore().GetStream(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil {
+		return nil, errNoSuchKey
+	}
+	if last, ok := stream.Last(); ok && lastID.Compare(last.ID) < 0 {
+		return nil, errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	if entriesAdded >= 0 && uint64(entriesAdded) < uint64(stream.Len()) {
+		return nil, errors.New("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if maxDeletedID != nil {
+		if lastID.Compare(*maxDeletedID) < 0 {
+			return nil, errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+		}
+		stream.MaxDeletedID = *maxDeletedID
+	}
+	if entriesAdded >= 0 {
+		stream.EntriesAdded = uint64(entriesAdded)
+	}
+	stream.LastID = lastID
+	return "OK", nil
+}
+
+// parseGroupID resolves the ID given to XGROUP CREATE/SETID, where "$"
+// stands for the last ID in the stream.
+func parseGroupID(arg string, stream *inMemory.Stream) (inMemory.StreamID, error) {
+	if arg == "$" {
+		return stream.LastID, nil
+	}
+	return inMemory.ParseStreamID(arg, 0)
+}
+
+// HandleXGroup implements the XGROUP CREATE, SETID, DESTROY,
+// CREATECONSUMER and DELCONSUMER subcommands.
+func HandleXGroup(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	sub := strings.ToUpper(args[1])
+	inmemory := inMemory.GetInMemoryStore()
+
+	switch sub {
+	case "CREATE":
+		if len(args) < 5 {
+			return nil, errWrongArgs("xgroup|create")
+		}
+		mkStream := false
+		entriesRead := int64(-1)
+		for i := 5; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "MKSTREAM":
+				mkStream = true
+			case "ENTRIESREAD":
+				if i+1 >= len(args) {
+					return nil, errSyntax
+				}
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil {
+					return nil, errNotInteger
+				}
+				entriesRead = n
+				i++
+			default:
+				return nil, errSyntax
+			}
+		}
+
+		stream, err := inmemory.GetStream(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			if !mkStream {
+				return nil, errXGroupNoKey
+			}
+			stream = inMemory.NewStream()
+			inmemory.SetValue(args[2], stream)
+		}
+		id, err := parseGroupID(args[4], stream)
+		if err != nil {
+			return nil, err
+		}
+		if !stream.CreateGroup(args[3], id, entriesRead) {
+			return nil, errBusyGroup
+		}
+		return "OK", nil
+
+	case "SETID":
+		if len(args) != 5 && len(args) != 7 {
+			return nil, errWrongArgs("xgroup|setid")
+		}
+		stream, g, err := lookupGroup(args[2], args[3])
+		if err != nil {
+			return nil, err
+		}
+		id, err := parseGroupID(args[4], stream)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 7 {
+			if strings.ToUpper(args[5]) != "ENTRIESREAD" {
+				return nil, errSyntax
+			}
+			n, err := strconv.ParseInt(args[6], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			g.EntriesRead = n
+		}
+		g.LastDeliveredID = id
+		return "OK", nil
+
+	case "DESTROY":
+		if len(args) != 4 {
+			return nil, errWrongArgs("xgroup|destroy")
+		}
+		stream, err := inmemory.GetStream(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			return nil, errXGroupNoKey
+		}
+		if _, ok := stream.Groups[args[3]]; !ok {
+			return 0, nil
+		}
+		delete(stream.Groups, args[3])
+		return 1, nil
+
+	case "CREATECONSUMER":
+		if len(args) != 5 {
+			return nil, errWrongArgs("xgroup|createconsumer")
+		}
+		_, g, err := lookupGroup(args[2], args[3])
+		if err != nil {
+			return nil, err
+		}
+		if _, created := g.Consumer(args[4], true); created {
+			return 1, nil
+		}
+		return 0, nil
+
+	case "DELCONSUMER":
+		if len(args) != 5 {
+			return nil, errWrongArgs("xgroup|delconsumer")
+		}
+		_, g, err := lookupGroup(args[2], args[3])
+		if err != nil {
+			return nil, err
+		}
+		return g.DeleteConsumer(args[4]), nil
+	}
+	return nil, errors.New("ERR unknown subcommand '" + args[1] + "'. Try XGROUP HELP.")
+}
+
+// HandleXReadGroup implements XREADGROUP GROUP group consumer [COUNT n]
+// [BLOCK ms] [NOACK] STREAMS key ... id ...
+func HandleXReadGroup(args []string) (interface{}, error) {
+	if len(args) < 7 || strings.ToUpper(args[1]) != "GROUP" {
+		return nil, errSyntax
+	}
+	groupName, consumerName := args[2], args[3]
+	opts, err := parseReadArgs(args[4:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve every group first so a NOGROUP error has no side effects
+	streams := make([]*inMemory.Stream, len(opts.keys))
+	groups := make([]*inMemory.ConsumerGroup, len(opts.keys))
+	for i, key := range opts.keys {
+		streams[i], groups[i], err = lookupGroup(key, groupName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	reply := []interface{}{}
+	for i, key := range opts.keys {
+		stream, g := streams[i], groups[i]
+		consumer, _ := g.Consumer(consumerName, true)
+		consumer.SeenTime = now
+
+		if opts.ids[i] == ">" {
+			start, ok := g.LastDeliveredID.Next()
+			if !ok {
+				continue
+			}
+			entries := stream.Range(start, inMemory.MaxStreamID, opts.count, false)
+			if len(entries) == 0 {
+				continue
+			}
+			consumer.ActiveTime = now
+			for _, entry := range entries {
+				g.LastDeliveredID = entry.ID
+				if g.EntriesRead >= 0 {
+					g.EntriesRead++
+				}
+				if !opts.noAck {
+					g.Deliver(consumer, entry.ID, now)
+				}
+			}
+			reply = append(reply, []interface{}{key, entriesReply(entries)})
+			continue
+		}
+
+		// Any other ID replays this consumer's pending history after it
+		after, err := inMemory.ParseStreamID(opts.ids[i], 0)
+		if err != nil {
+			return nil, err
+		}
+		start, ok := after.Next()
+		history := []interface{}{}
+		if ok {
+			for _, pending := range g.PendingRange(start, inMemory.MaxStreamID, consumerName) {
+				if opts.count > 0 && len(history) == opts.count {
+					break
+				}
+				entry, exists := stream.Get(pending.ID)
+				if !exists {
+					// Deleted entries are reported with a nil body
+					history = append(history, []interface{}{pending.ID.String(), nil})
+					continue
+				}
+				history = append(history, entryReply(*entry))
+			}
+		}
+		reply = append(reply, []interface{}{key, history})
+	}
+	if len(reply) == 0 {
+		return protocol.NullArray{}, nil
+	}
+	return reply, nil
+}
+
+func HandleXAck(args []string) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	ids := make([]inMemory.StreamID, len(args)-3)
+	for i, arg := range args[3:] {
+		id, err := inMemory.ParseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	stream, err := inMemory.GetInMemoryStore().GetStream(args[1])
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	g, ok := stream.Groups[args[2]]
+	if !ok {
+		return 0, nil
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.Ack(id) {
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// HandleXPending implements both the summary form XPENDING key group and the
+// extended form XPENDING key group [IDLE min-idle] start end count [consumer].
+func HandleXPending(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	_, g, err := lookupGroup(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 3 {
+		pending := g.PendingRange(inMemory.StreamID{}, inMemory.MaxStreamID, "")
+		if len(pending) == 0 {
+			return []interface{}{0, nil, nil, nil}, nil
+		}
+		perConsumer := map[string]int{}
+		for _, p := range pending {
+			perConsumer[p.Consumer]++
+		}
+		consumers := []interface{}{}
+		for _, name := range sortedKeys(perConsumer) {
+			consumers = append(consumers, []interface{}{name, strconv.Itoa(perConsumer[name])})
+		}
+		return []interface{}{
+			len(pending),
+			pending[0].ID.String(),
+			pending[len(pending)-1].ID.String(),
+			consumers,
+		}, nil
+	}
+
+	rest := args[3:]
+	var minIdle time.Duration
+	if strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			return nil, errSyntax
+		}
+		ms, err := parseInt(rest[1])
+		if err != nil {
+			return nil, err
+		}
+		minIdle = time.Duration(ms) * time.Millisecond
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return nil, errSyntax
+	}
+	start, _, err := parseRangeID(rest[0], true)
+	if err != nil {
+		return nil, err
+	}
+	end, _, err := parseRangeID(rest[1], false)
+	if err != nil {
+		return nil, err
+	}
+	count, err := parseInt(rest[2])
+	if err != nil {
+		return nil, err
+	}
+	consumer := ""
+	if len(rest) == 4 {
+		consumer = rest[3]
+	}
+
+	now := time.Now()
+	reply := []interface{}{}
+	for _, p := range g.PendingRange(start, end, consumer) {
+		if len(reply) >= count {
+			break
+		}
+		idle := now.Sub(p.DeliveryTime)
+		if idle < minIdle {
+			continue
+		}
+		reply = append(reply, []interface{}{p.ID.String(), p.Consumer, int(idle.Milliseconds()), p.DeliveryCount})
+	}
+	return reply, nil
+}
+
+// claimOptions holds the optional arguments of XCLAIM.
+type claimOptions struct {
+	deliveryTime  *time.Time
+	retryCount    int
+	hasRetryCount bool
+	force         bool
+	justID        bool
+}
+
+// claim transfers ownership of a pending entry to consumer. It returns the
+// entry and whether it was claimed. Entries that no longer exist in the
+// stream are dropped from the PEL and reported through deleted.
+func claim(stream *inMemory.Stream, g *inMemory.ConsumerGroup, consumer *inMemory.Consumer, id inMemory.StreamID,
+	minIdle time.Duration, opts *claimOptions, now time.Time) (entry *inMemory.StreamEntry, claimed bool, deleted bool) {
+
+	pending, isPending := g.Pending[id]
+	entry, exists := stream.Get(id)
+	if !exists {
+		if isPending {
+			g.Ack(id)
+			return nil, false, true
+		}
+		return nil, false, false
+	}
+	if !isPending {
+		if !opts.force {
+			return nil, false, false
+		}
+		g.Deliver(consumer, id, now)
+		pending = g.Pending[id]
+	} else {
+		if now.Sub(pending.DeliveryTime) < minIdle {
+			return nil, false, false
+		}
+		count := pending.DeliveryCount
+		g.Deliver(consumer, id, now)
+		// JUSTID claims do not count as a delivery attempt
+		if opts.justID {
+			pending.DeliveryCount = count
+		}
+	}
+	if opts.deliveryTime != nil {
+		pending.DeliveryTime = *opts.deliveryTime
+	}
+	if opts.hasRetryCount {
+		pending.DeliveryCount = opts.retryCount
+	}
+	return entry, true, false
+}
+
+// HandleXClaim implements XCLAIM key group consumer min-idle-time id ...
+// [IDLE ms] [TIME unix-ms] [RETRYCOUNT n] [FORCE] [JUSTID] [LASTID id].
+func HandleXClaim(args []string) (interface{}, error) {
+	if len(args) < 6 {
+		return nil, errWrongArgs(args[0])
+	}
+	minIdleMs, err := parseInt(args[4])
+	if err != nil {
+		return nil, errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+
+	var ids []inMemory.StreamID
+	i := 5
+	for ; i < len(args); i++ {
+		id, err := inMemory.ParseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := time.Now()
+	opts := &claimOptions{}
+	var lastID *inMemory.StreamID
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			option, value := strings.ToUpper(args[i]), args[i+1]
+			i++
+			if option == "LASTID" {
+				id, err := inMemory.ParseStreamID(value, 0)
+				if err != nil {
+					return nil, err
+				}
+				lastID = &id
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			switch option {
+			case "IDLE":
+				t := now.Add(-time.Duration(n) * time.Millisecond)
+				opts.deliveryTime = &t
+			case "TIME":
+				t := time.UnixMilli(n)
+				opts.deliveryTime = &t
+			case "RETRYCOUNT":
+				opts.retryCount = int(n)
+				opts.hasRetryCount = true
+			}
+		case "FORCE":
+			opts.force = true
+		case "JUSTID":
+			opts.justID = true
+		default:
+			return nil, errors.New("ERR Unrecognized XCLAIM option '" + args[i] + "'")
+		}
+	}
+
+	stream, g, err := lookupGroup(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if lastID != nil && lastID.Compare(g.LastDeliveredID) > 0 {
+		g.LastDeliveredID = *lastID
+	}
+	consumer, _ := g.Consumer(args[3], true)
+	consumer.SeenTime = now
+
+	minIdle := time.Duration(minIdleMs) * time.Millisecond
+	reply := []interface{}{}
+	for _, id := range ids {
+		entry, claimed, _ := claim(stream, g, consumer, id, minIdle, opts, now)
+		if !claimed {
+			continue
+		}
+		consumer.ActiveTime = now
+		if opts.justID {
+			reply = append(reply, id.String())
+		} else {
+			reply = append(reply, entryReply(*entry))
+		}
+	}
+	return reply, nil
+}
+
+// HandleXAutoClaim implements XAUTOCLAIM key group consumer min-idle-time
+// start [COUNT count] [JUSTID].
+func HandleXAutoClaim(args []string) (interface{}, error) {
+	if len(args) < 6 {
+		return nil, errWrongArgs(args[0])
+	}
+	minIdleMs, err := parseInt(args[4])
+	if err != nil {
+		return nil, errors.New("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, _, err := parseRangeID(args[5], true)
+	if err != nil {
+		return nil, err
+	}
+	count := 100
+	opts := &claimOptions{}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			count, err = parseInt(args[i+1])
+			if err != nil || count < 1 {
+				return nil, errors.New("ERR COUNT must be > 0")
+			}
+			i++
+		case "JUSTID":
+			opts.justID = true
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	stream, g, err := lookupGroup(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	consumer, _ := g.Consumer(args[3], true)
+	consumer.SeenTime = now
+
+	minIdle := time.Duration(minIdleMs) * time.Millisecond
+	pending := g.PendingRange(start, inMemory.MaxStreamID, "")
+	claimedReply := []interface{}{}
+	deletedReply := []interface{}{}
+	next := "0-0"
+	scanned := 0
+	for _, p := range pending {
+		if scanned == count {
+			next = p.ID.String()
+			break
+		}
+		scanned++
+		entry, claimed, deleted := claim(stream, g, consumer, p.ID, minIdle, opts, now)
+		switch {
+		case deleted:
+			deletedReply = append(deletedReply, p.ID.String())
+		case claimed && opts.justID:
+			claimedReply = append(claimedReply, p.ID.String())
+		case claimed:
+			claimedReply = append(claimedReply, entryReply(*entry))
+		}
+	}
+	if len(claimedReply) > 0 {
+		consumer.ActiveTime = now
+	}
+	return []interface{}{next, claimedReply, deletedReply}, nil
+}
@@ -0,0 +1,102 @@
+package command
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func TestNextStreamID(t *testing.T) {
+	last := inMemory.StreamID{Ms: 100, Seq: 5}
+	tests := []struct {
+		arg     string
+		last    inMemory.StreamID
+		want    inMemory.StreamID
+		wantErr error
+	}{
+		{"100-*", last, inMemory.StreamID{Ms: 100, Seq: 6}, nil},
+		{"200-*", last, inMemory.StreamID{Ms: 200}, nil},
+		{"99-*", last, inMemory.StreamID{}, errStreamIDTooSmall},
+		{"0-*", inMemory.StreamID{}, inMemory.StreamID{Ms: 0, Seq: 1}, nil},
+		{"100-*", inMemory.StreamID{Ms: 100, Seq: math.MaxUint64}, inMemory.StreamID{}, errStreamIDTooSmall},
+		{"100-6", last, inMemory.StreamID{Ms: 100, Seq: 6}, nil},
+		{"100-5", last, inMemory.StreamID{Ms: 100, Seq: 5}, errStreamIDTooSmall},
+		{"0-0", inMemory.StreamID{}, inMemory.StreamID{}, errStreamIDZero},
+		{"x-*", last, inMemory.StreamID{}, inMemory.ErrInvalidStreamID},
+		{"*", inMemory.MaxStreamID, inMemory.MaxStreamID, errStreamExhausted},
+	}
+	for _, tt := range tests {
+		got, err := nextStreamID(tt.arg, tt.last)
+		if err != tt.wantErr || (err == nil && got != tt.want) {
+			t.Errorf("TestNextStreamID failed: nextStreamID(%q, %v) = %v, %v, want %v, %v", tt.arg, tt.last, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// "*" uses the clock, or the next sequence when the clock is behind
+	before := uint64(time.Now().UnixMilli())
+	if got, err := nextStreamID("*", last); err != nil || got.Ms < before || got.Seq != 0 {
+		t.Errorf("TestNextStreamID failed: * after an old ID gave %v, %v", got, err)
+	}
+	future := inMemory.StreamID{Ms: before + 60000, Seq: 7}
+	if got, err := nextStreamID("*", future); err != nil || got != (inMemory.StreamID{Ms: future.Ms, Seq: 8}) {
+		t.Errorf("TestNextStreamID failed: * after a future ID gave %v, %v", got, err)
+	}
+}
+
+func TestParseTrimArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		want     trimSpec
+		consumed int
+		wantErr  bool
+	}{
+		{[]string{"MAXLEN", "10", "*"}, trimSpec{strategy: "MAXLEN", maxLen: 10}, 2, false},
+		{[]string{"maxlen", "=", "10"}, trimSpec{strategy: "MAXLEN", maxLen: 10}, 3, false},
+		{[]string{"MAXLEN", "~", "10", "LIMIT", "5", "*"}, trimSpec{strategy: "MAXLEN", approx: true, maxLen: 10, limit: 5}, 5, false},
+		{[]string{"MINID", "~", "7-1"}, trimSpec{strategy: "MINID", approx: true, minID: inMemory.StreamID{Ms: 7, Seq: 1}}, 3, false},
+		{[]string{"MAXLEN", "10", "LIMIT", "5"}, trimSpec{}, 0, true},
+		{[]string{"MAXLEN", "-1"}, trimSpec{}, 0, true},
+		{[]string{"MAXLEN", "~"}, trimSpec{}, 0, true},
+		{[]string{"MINID", "bad"}, trimSpec{}, 0, true},
+	}
+	for _, tt := range tests {
+		spec, n, err := parseTrimArgs(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("TestParseTrimArgs failed: %v was accepted", tt.args)
+			}
+			continue
+		}
+		if err != nil || *spec != tt.want || n != tt.consumed {
+			t.Errorf("TestParseTrimArgs failed: %v gave %+v, %d, %v", tt.args, spec, n, err)
+		}
+	}
+}
+
+func TestXReadNothing(t *testing.T) {
+	inMemory.GetInMemoryStore().Clear()
+	defer inMemory.GetInMemoryStore().Clear()
+	HandleXAdd([]string{"XADD", "st", "1-1", "f", "v"})
+	HandleXGroup([]string{"XGROUP", "CREATE", "st", "g", "$"})
+
+	// Reads that find no entries reply with a null array
+	for _, args := range [][]string{
+		{"XREAD", "STREAMS", "missing", "0"},
+		{"XREAD", "STREAMS", "st", "1-1"},
+		{"XREADGROUP", "GROUP", "g", "c", "STREAMS", "st", ">"},
+	} {
+		read := HandleXRead
+		if args[0] == "XREADGROUP" {
+			read = HandleXReadGroup
+		}
+		if reply, err := read(args); err != nil || reply != (protocol.NullArray{}) {
+			t.Errorf("TestXReadNothing failed: %v replied %#v, %v", args, reply, err)
+		}
+	}
+	if reply, _ := HandleXRead([]string{"XREAD", "STREAMS", "st", "0"}); reply == (protocol.NullArray{}) {
+		t.Errorf("TestXReadNothing failed: XREAD of an entry replied a null array")
+	}
+}
@@ -1,24 +1,37 @@
 package command
 
 import (
+	"strconv"
+
+	"github.com/bhaski-1234/redis-db/protocol"
 	diskstorage "github.com/bhaski-1234/redis-db/storage/diskStorage"
 	"github.com/bhaski-1234/redis-db/storage/inMemory"
 	"github.com/bhaski-1234/redis-db/utils"
 )
 
 func HandleGet(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
 	inmemory := inMemory.GetInMemoryStore()
 	key := args[1]
-	value, exists := inmemory.Get(key)
+	value, exists := inmemory.GetValue(key)
 	if !exists {
 		return nil, nil // Key does not exist
 	}
-	return value, nil
+	switch v := value.(type) {
+	case string:
+		return protocol.BulkString(v), nil
+	case int:
+		return protocol.BulkString(strconv.Itoa(v)), nil
+	default:
+		return nil, inMemory.ErrWrongType
+	}
 }
 
 func HandleSet(args []string) (interface{}, error) {
-	if len(args) < 2 {
-		return nil, nil // Not enough arguments
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
 	}
 	key := args[1]
 	value := args[2]
@@ -37,18 +50,22 @@ func HandleSet(args []string) (interface{}, error) {
 
 func HandleDel(args []string) (interface{}, error) {
 	if len(args) < 2 {
-		return nil, nil
+		return nil, errWrongArgs(args[0])
 	}
-	key := args[1]
 	inmemory := inMemory.GetInMemoryStore()
-	inmemory.Delete(key)
-	inmemory.DeleteExpiration(key)
-	return 1, nil
+	deleted := 0
+	for _, key := range args[1:] {
+		if inmemory.Exists(key) {
+			inmemory.Delete(key)
+			deleted++
+		}
+	}
+	return deleted, nil
 }
 
 func HandleExists(args []string) (interface{}, error) {
 	if len(args) < 2 {
-		return nil, nil
+		return nil, errWrongArgs(args[0])
 	}
 	key := args[1]
 	inmemory := inMemory.GetInMemoryStore()
@@ -60,8 +77,8 @@ func HandleExists(args []string) (interface{}, error) {
 }
 
 func HandleTTL(args []string) (interface{}, error) {
-	if len(args) < 2 {
-		return nil, nil // Not enough arguments
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
 	}
 	key := args[1]
 	inmemory := inMemory.GetInMemoryStore()
@@ -73,9 +90,20 @@ func HandleTTL(args []string) (interface{}, error) {
 }
 
 func HandleSave(args []string) (interface{}, error) {
+	if diskstorage.SaveInProgress() {
+		return nil, diskstorage.ErrSaveInProgress
+	}
 	disk := diskstorage.NewDiskStorage()
 	if err := disk.Save("dump"); err != nil {
 		return nil, err // Handle save error
 	}
+	diskstorage.RecordSave()
 	return "OK", nil
 }
+
+func HandleType(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	return inMemory.GetInMemoryStore().Type(args[1]), nil
+}
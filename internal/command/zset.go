@@ -0,0 +1,795 @@
+package command
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+var (
+	errMinMaxNotFloat = errors.New("ERR min or max is not a float")
+	errMinMaxLex      = errors.New("ERR min or max not valid string range item")
+	errZAddNXXX       = errors.New("ERR XX and NX options at the same time are not compatible")
+	errZAddGTLTNX     = errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	errZAddIncr       = errors.New("ERR INCR option supports a single increment-element pair")
+	errScoreNaN       = errors.New("ERR resulting score is not a number (NaN)")
+)
+
+// parseScore parses a score argument, accepting "inf", "+inf" and "-inf".
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+	return score, nil
+}
+
+// formatScore renders a score the way Redis does in replies.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// parseScoreBound parses a range bound like "1", "(1" or "-inf".
+func parseScoreBound(s string) (inMemory.ScoreBound, error) {
+	bound := inMemory.ScoreBound{}
+	if strings.HasPrefix(s, "(") {
+		bound.Exclusive = true
+		s = s[1:]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return bound, errMinMaxNotFloat
+	}
+	bound.Value = value
+	return bound, nil
+}
+
+// parseLexBound parses a lexicographical bound like "[a", "(a", "-" or "+".
+func parseLexBound(s string) (inMemory.LexBound, error) {
+	switch {
+	case s == "-":
+		return inMemory.LexBound{Inf: -1}, nil
+	case s == "+":
+		return inMemory.LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "["):
+		return inMemory.LexBound{Value: s[1:]}, nil
+	case strings.HasPrefix(s, "("):
+		return inMemory.LexBound{Value: s[1:], Exclusive: true}, nil
+	default:
+		return inMemory.LexBound{}, errMinMaxLex
+	}
+}
+
+// scoredReply flattens members into an array reply, interleaving scores
+// when withScores is set.
+func scoredReply(members []inMemory.ScoredMember, withScores bool) []interface{} {
+	reply := make([]interface{}, 0, len(members)*2)
+	for _, m := range members {
+		reply = append(reply, m.Member)
+		if withScores {
+			reply = append(reply, formatScore(m.Score))
+		}
+	}
+	return reply
+}
+
+func HandleZAdd(args []string) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	var nx, xx, gt, lt, ch, incr bool
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, errSyntax
+	}
+	if nx && xx {
+		return nil, errZAddNXXX
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return nil, errZAddGTLTNX
+	}
+	if incr && len(pairs) != 2 {
+		return nil, errZAddIncr
+	}
+
+	// Validate every score before touching the key
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, err := parseScore(pairs[j*2])
+		if err != nil {
+			return nil, err
+		}
+		scores[j] = score
+	}
+
+	inmemory := inMemory.GetInMemoryStore()
+	zset, err := inmemory.GetSortedSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if zset == nil {
+		if xx {
+			if incr {
+				return nil, nil
+			}
+			return 0, nil
+		}
+		zset, _ = inmemory.GetOrCreateSortedSet(args[1])
+	}
+
+	added, changed := 0, 0
+	for j, score := range scores {
+		member := pairs[j*2+1]
+		current, exists := zset.Score(member)
+		if (nx && exists) || (xx && !exists) {
+			if incr {
+				return nil, nil
+			}
+			continue
+		}
+		if incr {
+			score += current
+			if math.IsNaN(score) {
+				return nil, errScoreNaN
+			}
+		}
+		if exists && ((gt && score <= current) || (lt && score >= current)) {
+			if incr {
+				return nil, nil
+			}
+			continue
+		}
+		if !exists {
+			added++
+		} else if score != current {
+			changed++
+		}
+		zset.Add(member, score)
+		if incr {
+			return protocol.BulkString(formatScore(score)), nil
+		}
+	}
+	deleteIfEmpty(inmemory, args[1], zset)
+
+	if ch {
+		return added + changed, nil
+	}
+	return added, nil
+}
+
+func HandleZIncrBy(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	return HandleZAdd([]string{"ZADD", args[1], "INCR", args[2], args[3]})
+}
+
+func HandleZRem(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	inmemory := inMemory.GetInMemoryStore()
+	zset, err := inmemory.GetSortedSet(args[1])
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range args[2:] {
+		if zset.Remove(member) {
+			removed++
+		}
+	}
+	deleteIfEmpty(inmemory, args[1], zset)
+	return removed, nil
+}
+
+func HandleZCard(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	zset, err := inMemory.GetInMemoryStore().GetSortedSet(args[1])
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.Len(), nil
+}
+
+func HandleZScore(args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	zset, err := inMemory.GetInMemoryStore().GetSortedSet(args[1])
+	if err != nil || zset == nil {
+		return nil, err
+	}
+	score, ok := zset.Score(args[2])
+	if !ok {
+		return nil, nil
+	}
+	return protocol.BulkString(formatScore(score)), nil
+}
+
+func HandleZMScore(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	zset, err := inMemory.GetInMemoryStore().GetSortedSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]interface{}, len(args)-2)
+	for i, member := range args[2:] {
+		if zset == nil {
+			continue
+		}
+		if score, ok := zset.Score(member); ok {
+			reply[i] = formatScore(score)
+		}
+	}
+	return reply, nil
+}
+
+func HandleZRank(args []string) (interface{}, error) {
+	return zrank(args, false)
+}
+
+func HandleZRevRank(args []string) (interface{}, error) {
+	return zrank(args, true)
+}
+
+func zrank(args []string, reverse bool) (interface{}, error) {
+	if len(args) < 3 || len(args) > 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	withScore := false
+	if len(args) == 4 {
+		if strings.ToUpper(args[3]) != "WITHSCORE" {
+			return nil, errSyntax
+		}
+		withScore = true
+	}
+	zset, err := inMemory.GetInMemoryStore().GetSortedSet(args[1])
+	if err != nil || zset == nil {
+		return nil, err
+	}
+	rank, ok := zset.Rank(args[2], reverse)
+	if !ok {
+		return nil, nil
+	}
+	if withScore {
+		score, _ := zset.Score(args[2])
+		return []interface{}{rank, formatScore(score)}, nil
+	}
+	return rank, nil
+}
+
+func HandleZCount(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	min, err := parseScoreBound(args[2])
+	if err != nil {
+		return nil, err
+	}
+	max, err := parseScoreBound(args[3])
+	if err != nil {
+		return nil, err
+	}
+	zset, err := inMemory.GetInMemoryStore().GetSortedSet(args[1])
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.CountByScore(min, max), nil
+}
+
+func HandleZLexCount(args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	min, err := parseLexBound(args[2])
+	if err != nil {
+		return nil, err
+	}
+	max, err := parseLexBound(args[3])
+	if err != nil {
+		return nil, err
+	}
+	zset, err := inMemory.GetInMemoryStore().GetSortedSet(args[1])
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.CountByLex(min, max), nil
+}
+
+// Range queries. Every ZRANGE flavour is parsed into a rangeSpec and run by
+// rangeSortedSet.
+
+type rangeKind int
+
+const (
+	rangeByRank rangeKind = iota
+	rangeByScore
+	rangeByLex
+)
+
+type rangeSpec struct {
+	kind       rangeKind
+	start      string
+	stop       string
+	reverse    bool
+	withScores bool
+	offset     int
+	count      int
+}
+
+// parseRangeOptions parses the optional arguments following start and stop.
+func parseRangeOptions(spec *rangeSpec, opts []string, allowKind bool) error {
+	limit := false
+	for i := 0; i < len(opts); i++ {
+		switch strings.ToUpper(opts[i]) {
+		case "BYSCORE":
+			if !allowKind {
+				return errSyntax
+			}
+			spec.kind = rangeByScore
+		case "BYLEX":
+			if !allowKind {
+				return errSyntax
+			}
+			spec.kind = rangeByLex
+		case "REV":
+			if !allowKind {
+				return errSyntax
+			}
+			spec.reverse = true
+		case "WITHSCORES":
+			spec.withScores = true
+		case "LIMIT":
+			if i+2 >= len(opts) {
+				return errSyntax
+			}
+			offset, err := parseInt(opts[i+1])
+			if err != nil {
+				return err
+			}
+			count, err := parseInt(opts[i+2])
+			if err != nil {
+				return err
+			}
+			spec.offset, spec.count = offset, count
+			limit = true
+			i += 2
+		default:
+			return errSyntax
+		}
+	}
+	if limit && spec.kind == rangeByRank {
+		return errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.kind == rangeByLex {
+		return errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// rangeSortedSet evaluates spec against zset. For reverse score and lex
+// ranges the start argument is the upper bound, as in Redis.
+func rangeSortedSet(zset *inMemory.SortedSet, spec *rangeSpec) ([]inMemory.ScoredMember, error) {
+	switch spec.kind {
+	case rangeByScore:
+		lo, hi := spec.start, spec.stop
+		if spec.reverse {
+			lo, hi = hi, lo
+		}
+		min, err := parseScoreBound(lo)
+		if err != nil {
+			return nil, err
+		}
+		max, err := parseScoreBound(hi)
+		if err != nil {
+			return nil, err
+		}
+		if zset == nil || spec.offset < 0 {
+			return nil, nil
+		}
+		return zset.RangeByScore(min, max, spec.reverse, spec.offset, spec.count), nil
+	case rangeByLex:
+		lo, hi := spec.start, spec.stop
+		if spec.reverse {
+			lo, hi = hi, lo
+		}
+		min, err := parseLexBound(lo)
+		if err != nil {
+			return nil, err
+		}
+		max, err := parseLexBound(hi)
+		if err != nil {
+			return nil, err
+		}
+		if zset == nil || spec.offset < 0 {
+			return nil, nil
+		}
+		return zset.RangeByLex(min, max, spec.reverse, spec.offset, spec.count), nil
+	default:
+		start, err := parseInt(spec.start)
+		if err != nil {
+			return nil, err
+		}
+		stop, err := parseInt(spec.stop)
+		if err != nil {
+			return nil, err
+		}
+		if zset == nil {
+			return nil, nil
+		}
+		return zset.RangeByRank(start, stop, spec.reverse), nil
+	}
+}
+
+func zrange(args []string, spec *rangeSpec) (interface{}, error) {
+	zset, err := inMemory.GetInMemoryStore().GetSortedSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	members, err := rangeSortedSet(zset, spec)
+	if err != nil {
+		return nil, err
+	}
+	return scoredReply(members, spec.withScores), nil
+}
+
+// HandleZRange implements the unified ZRANGE key start stop [BYSCORE|BYLEX]
+// [REV] [LIMIT offset count] [WITHSCORES].
+func HandleZRange(args []string) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	spec := &rangeSpec{start: args[2], stop: args[3], count: -1}
+	if err := parseRangeOptions(spec, args[4:], true); err != nil {
+		return nil, err
+	}
+	return zrange(args, spec)
+}
+
+func HandleZRevRange(args []string) (interface{}, error) {
+	return legacyRange(args, rangeByRank, true)
+}
+
+func HandleZRangeByScore(args []string) (interface{}, error) {
+	return legacyRange(args, rangeByScore, false)
+}
+
+func HandleZRevRangeByScore(args []string) (interface{}, error) {
+	return legacyRange(args, rangeByScore, true)
+}
+
+func HandleZRangeByLex(args []string) (interface{}, error) {
+	return legacyRange(args, rangeByLex, false)
+}
+
+func HandleZRevRangeByLex(args []string) (interface{}, error) {
+	return legacyRange(args, rangeByLex, true)
+}
+
+// legacyRange serves the pre-6.2 range commands through the unified path.
+func legacyRange(args []string, kind rangeKind, reverse bool) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	spec := &rangeSpec{kind: kind, start: args[2], stop: args[3], reverse: reverse, count: -1}
+	if err := parseRangeOptions(spec, args[4:], false); err != nil {
+		return nil, err
+	}
+	return zrange(args, spec)
+}
+
+// HandleZRangeStore implements ZRANGESTORE dst src start stop [options].
+func HandleZRangeStore(args []string) (interface{}, error) {
+	if len(args) < 5 {
+		return nil, errWrongArgs(args[0])
+	}
+	spec := &rangeSpec{start: args[3], stop: args[4], count: -1}
+	if err := parseRangeOptions(spec, args[5:], true); err != nil {
+		return nil, err
+	}
+	if spec.withScores {
+		return nil, errSyntax
+	}
+
+	inmemory := inMemory.GetInMemoryStore()
+	src, err := inmemory.GetSortedSet(args[2])
+	if err != nil {
+		return nil, err
+	}
+	members, err := rangeSortedSet(src, spec)
+	if err != nil {
+		return nil, err
+	}
+	return storeSortedSet(args[1], members), nil
+}
+
+// storeSortedSet replaces the destination key with the given members and
+// returns the resulting cardinality.
+func storeSortedSet(key string, members []inMemory.ScoredMember) int {
+	inmemory := inMemory.GetInMemoryStore()
+	inmemory.Delete(key)
+	if len(members) == 0 {
+		return 0
+	}
+	zset := inMemory.NewSortedSet()
+	for _, m := range members {
+		zset.Add(m.Member, m.Score)
+	}
+	inmemory.SetValue(key, zset)
+	return zset.Len()
+}
+
+func HandleZPopMin(args []string) (interface{}, error) {
+	return zpop(args, false)
+}
+
+func HandleZPopMax(args []string) (interface{}, error) {
+	return zpop(args, true)
+}
+
+func zpop(args []string, max bool) (interface{}, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := parseInt(args[2])
+		if err != nil || n < 0 {
+			return nil, errors.New("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	inmemory := inMemory.GetInMemoryStore()
+	zset, err := inmemory.GetSortedSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if zset == nil || count == 0 {
+		return []interface{}{}, nil
+	}
+	popped := zset.Pop(count, max)
+	deleteIfEmpty(inmemory, args[1], zset)
+	return scoredReply(popped, true), nil
+}
+
+func HandleZScan(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	opts, err := parseScanArgs(args[2:], false)
+	if err != nil {
+		return nil, err
+	}
+	zset, err := inMemory.GetInMemoryStore().GetSortedSet(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if zset == nil {
+		return []interface{}{"0", []interface{}{}}, nil
+	}
+
+	all := zset.Members()
+	members := make([]string, len(all))
+	for i, m := range all {
+		members[i] = m.Member
+	}
+	page, next := opts.page(members)
+	items := []interface{}{}
+	for _, member := range page {
+		score, _ := zset.Score(member)
+		items = append(items, member, formatScore(score))
+	}
+	return []interface{}{strconv.Itoa(next), items}, nil
+}
+
+// Union and intersection. Plain sets are accepted as inputs with every
+// member scored 1, matching Redis.
+
+type aggregateFunc func(a, b float64) float64
+
+func aggregateSum(a, b float64) float64 {
+	sum := a + b
+	// inf + -inf yields NaN; Redis treats it as 0
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+// loadScoredInput returns the members of a sorted set or set at key.
+func loadScoredInput(key string) ([]inMemory.ScoredMember, error) {
+	inmemory := inMemory.GetInMemoryStore()
+	val, ok := inmemory.GetValue(key)
+	if !ok {
+		return nil, nil
+	}
+	switch v := val.(type) {
+	case *inMemory.SortedSet:
+		return v.Members(), nil
+	case *inMemory.Set:
+		members := v.Members()
+		result := make([]inMemory.ScoredMember, len(members))
+		for i, member := range members {
+			result[i] = inMemory.ScoredMember{Member: member, Score: 1}
+		}
+		return result, nil
+	default:
+		return nil, inMemory.ErrWrongType
+	}
+}
+
+// combineSortedSets parses "numkeys key [key ...] [WEIGHTS w ...]
+// [AGGREGATE SUM|MIN|MAX] [WITHSCORES]" and computes the union or
+// intersection. It reports whether WITHSCORES was given.
+func combineSortedSets(args []string, union, allowWithScores bool) ([]inMemory.ScoredMember, bool, error) {
+	numKeys, err := parseInt(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, false, errors.New("ERR at least 1 input key is needed for this command")
+	}
+	if len(args) < 1+numKeys {
+		return nil, false, errSyntax
+	}
+	keys := args[1 : 1+numKeys]
+	opts := args[1+numKeys:]
+
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := aggregateFunc(aggregateSum)
+	withScores := false
+
+	for i := 0; i < len(opts); i++ {
+		switch strings.ToUpper(opts[i]) {
+		case "WEIGHTS":
+			if i+numKeys >= len(opts) {
+				return nil, false, errSyntax
+			}
+			for j := 0; j < numKeys; j++ {
+				w, err := strconv.ParseFloat(opts[i+1+j], 64)
+				if err != nil || math.IsNaN(w) {
+					return nil, false, errors.New("ERR weight value is not a float")
+				}
+				weights[j] = w
+			}
+			i += numKeys
+		case "AGGREGATE":
+			if i+1 >= len(opts) {
+				return nil, false, errSyntax
+			}
+			switch strings.ToUpper(opts[i+1]) {
+			case "SUM":
+				aggregate = aggregateSum
+			case "MIN":
+				aggregate = math.Min
+			case "MAX":
+				aggregate = math.Max
+			default:
+				return nil, false, errSyntax
+			}
+			i++
+		case "WITHSCORES":
+			if !allowWithScores {
+				return nil, false, errSyntax
+			}
+			withScores = true
+		default:
+			return nil, false, errSyntax
+		}
+	}
+
+	inputs := make([][]inMemory.ScoredMember, numKeys)
+	for i, key := range keys {
+		members, err := loadScoredInput(key)
+		if err != nil {
+			return nil, false, err
+		}
+		inputs[i] = members
+	}
+
+	scores := make(map[string]float64)
+	seen := make(map[string]int)
+	for i, members := range inputs {
+		for _, m := range members {
+			weighted := m.Score * weights[i]
+			if math.IsNaN(weighted) {
+				weighted = 0
+			}
+			if current, ok := scores[m.Member]; ok {
+				scores[m.Member] = aggregate(current, weighted)
+			} else {
+				scores[m.Member] = weighted
+			}
+			seen[m.Member]++
+		}
+	}
+
+	result := make([]inMemory.ScoredMember, 0, len(scores))
+	for member, score := range scores {
+		if !union && seen[member] != numKeys {
+			continue
+		}
+		result = append(result, inMemory.ScoredMember{Member: member, Score: score})
+	}
+
+	// Order the reply by score through a temporary sorted set
+	ordered := inMemory.NewSortedSet()
+	for _, m := range result {
+		ordered.Add(m.Member, m.Score)
+	}
+	return ordered.Members(), withScores, nil
+}
+
+func HandleZUnionStore(args []string) (interface{}, error) {
+	return zcombineStore(args, true)
+}
+
+func HandleZInterStore(args []string) (interface{}, error) {
+	return zcombineStore(args, false)
+}
+
+func zcombineStore(args []string, union bool) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, errWrongArgs(args[0])
+	}
+	members, _, err := combineSortedSets(args[2:], union, false)
+	if err != nil {
+		return nil, err
+	}
+	return storeSortedSet(args[1], members), nil
+}
+
+func HandleZUnion(args []string) (interface{}, error) {
+	return zcombine(args, true)
+}
+
+func HandleZInter(args []string) (interface{}, error) {
+	return zcombine(args, false)
+}
+
+func zcombine(args []string, union bool) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	members, withScores, err := combineSortedSets(args[1:], union, true)
+	if err != nil {
+		return nil, err
+	}
+	return scoredReply(members, withScores), nil
+}
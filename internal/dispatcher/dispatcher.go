@@ -4,28 +4,164 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/bhaski-1234/redis-db/internal/cluster"
 	"github.com/bhaski-1234/redis-db/internal/command"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
 )
 
 type HandlerFunc func(args []string) (interface{}, error)
 
+// RewriteFunc converts an executed write command and its result into the
+// commands to propagate. A nil rewrite propagates the command unchanged; a
+// rewrite returning no commands marks a call that changed nothing.
+type RewriteFunc func(args []string, result interface{}) [][]string
+
+// PropagateFunc receives every write command after it succeeded, e.g. to
+// append it to the AOF.
+type PropagateFunc func(args []string)
+
+// TouchFunc receives every key modified by a write command, e.g. to abort
+// transactions watching it.
+type TouchFunc func(key string)
+
 type Dispatcher struct {
-	handlers map[string]HandlerFunc
+	handlers    map[string]HandlerFunc
+	writes      map[string]RewriteFunc
+	propagators []PropagateFunc
+	touchers    []TouchFunc
+	notifiers   []NotifyFunc
 }
 
 func NewDispatcher() *Dispatcher {
 	d := &Dispatcher{
 		handlers: make(map[string]HandlerFunc),
+		writes:   make(map[string]RewriteFunc),
 	}
 
 	// Register commands
 	d.Register("PING", command.HandlePing)
 	d.Register("GET", command.HandleGet)
-	d.Register("SET", command.HandleSet)
-	d.Register("DEL", command.HandleDel)
+	d.RegisterWrite("SET", command.HandleSet, command.RewriteSet)
+	d.RegisterWrite("DEL", command.HandleDel, command.RewriteIfChanged)
 	d.Register("EXISTS", command.HandleExists)
 	d.Register("TTL", command.HandleTTL)
+	d.Register("TYPE", command.HandleType)
 	d.Register("SAVE", command.HandleSave)
+	d.Register("BGSAVE", command.HandleBgSave)
+	d.Register("LASTSAVE", command.HandleLastSave)
+	d.Register("BGREWRITEAOF", command.HandleBgRewriteAof)
+	d.Register("INFO", command.HandleInfo)
+	d.RegisterWrite("DEBUG", command.HandleDebug, command.RewriteDebug)
+	d.Register("CLUSTER", cluster.HandleCluster)
+
+	// Key expiration commands
+	d.RegisterWrite("EXPIRE", command.HandleExpire, command.RewriteExpire)
+	d.RegisterWrite("PEXPIRE", command.HandlePExpire, command.RewriteExpire)
+	d.RegisterWrite("EXPIREAT", command.HandleExpireAt, command.RewriteExpire)
+	d.RegisterWrite("PEXPIREAT", command.HandlePExpireAt, command.RewriteExpire)
+	d.RegisterWrite("PERSIST", command.HandlePersist, command.RewriteIfChanged)
+	d.Register("PTTL", command.HandlePTTL)
+
+	// List commands
+	d.RegisterWrite("LPUSH", command.HandleLPush, nil)
+	d.RegisterWrite("RPUSH", command.HandleRPush, nil)
+	d.RegisterWrite("LPUSHX", command.HandleLPushX, command.RewriteIfChanged)
+	d.RegisterWrite("RPUSHX", command.HandleRPushX, command.RewriteIfChanged)
+	d.RegisterWrite("LPOP", command.HandleLPop, command.RewriteIfChanged)
+	d.RegisterWrite("RPOP", command.HandleRPop, command.RewriteIfChanged)
+	d.Register("LLEN", command.HandleLLen)
+	d.Register("LRANGE", command.HandleLRange)
+	d.Register("LINDEX", command.HandleLIndex)
+	d.RegisterWrite("LSET", command.HandleLSet, nil)
+	d.RegisterWrite("LREM", command.HandleLRem, command.RewriteIfChanged)
+	d.RegisterWrite("LTRIM", command.HandleLTrim, nil)
+	d.RegisterWrite("LINSERT", command.HandleLInsert, command.RewriteIfChanged)
+	d.RegisterWrite("LMOVE", command.HandleLMove, command.RewriteIfChanged)
+	d.RegisterWrite("RPOPLPUSH", command.HandleRPopLPush, command.RewriteIfChanged)
+
+	// Hash commands
+	d.RegisterWrite("HSET", command.HandleHSet, nil)
+	d.RegisterWrite("HMSET", command.HandleHSet, nil)
+	d.RegisterWrite("HSETNX", command.HandleHSetNX, command.RewriteIfChanged)
+	d.Register("HGET", command.HandleHGet)
+	d.Register("HMGET", command.HandleHMGet)
+	d.RegisterWrite("HDEL", command.HandleHDel, command.RewriteIfChanged)
+	d.Register("HLEN", command.HandleHLen)
+	d.Register("HEXISTS", command.HandleHExists)
+	d.Register("HGETALL", command.HandleHGetAll)
+	d.Register("HKEYS", command.HandleHKeys)
+	d.Register("HVALS", command.HandleHVals)
+	d.RegisterWrite("HINCRBY", command.HandleHIncrBy, nil)
+	d.RegisterWrite("HINCRBYFLOAT", command.HandleHIncrByFloat, nil)
+	d.Register("HSCAN", command.HandleHScan)
+	d.RegisterWrite("HEXPIRE", command.HandleHExpire, command.RewriteHExpire)
+	d.RegisterWrite("HPEXPIRE", command.HandleHPExpire, command.RewriteHExpire)
+	d.RegisterWrite("HEXPIREAT", command.HandleHExpireAt, command.RewriteHExpire)
+	d.RegisterWrite("HPEXPIREAT", command.HandleHPExpireAt, command.RewriteHExpire)
+	d.Register("HTTL", command.HandleHTTL)
+	d.Register("HPTTL", command.HandleHPTTL)
+	d.RegisterWrite("HPERSIST", command.HandleHPersist, nil)
+
+	// Set commands
+	d.RegisterWrite("SADD", command.HandleSAdd, command.RewriteIfChanged)
+	d.RegisterWrite("SREM", command.HandleSRem, command.RewriteIfChanged)
+	d.Register("SMEMBERS", command.HandleSMembers)
+	d.Register("SISMEMBER", command.HandleSIsMember)
+	d.Register("SMISMEMBER", command.HandleSMIsMember)
+	d.Register("SCARD", command.HandleSCard)
+	d.RegisterWrite("SMOVE", command.HandleSMove, command.RewriteIfChanged)
+	d.RegisterWrite("SPOP", command.HandleSPop, command.RewriteSPop)
+	d.Register("SRANDMEMBER", command.HandleSRandMember)
+	d.Register("SSCAN", command.HandleSScan)
+	d.Register("SINTER", command.HandleSInter)
+	d.Register("SUNION", command.HandleSUnion)
+	d.Register("SDIFF", command.HandleSDiff)
+	d.RegisterWrite("SINTERSTORE", command.HandleSInterStore, nil)
+	d.RegisterWrite("SUNIONSTORE", command.HandleSUnionStore, nil)
+	d.RegisterWrite("SDIFFSTORE", command.HandleSDiffStore, nil)
+	d.Register("SINTERCARD", command.HandleSInterCard)
+
+	// Sorted set commands
+	d.RegisterWrite("ZADD", command.HandleZAdd, nil)
+	d.RegisterWrite("ZINCRBY", command.HandleZIncrBy, nil)
+	d.RegisterWrite("ZREM", command.HandleZRem, command.RewriteIfChanged)
+	d.Register("ZCARD", command.HandleZCard)
+	d.Register("ZSCORE", command.HandleZScore)
+	d.Register("ZMSCORE", command.HandleZMScore)
+	d.Register("ZRANK", command.HandleZRank)
+	d.Register("ZREVRANK", command.HandleZRevRank)
+	d.Register("ZCOUNT", command.HandleZCount)
+	d.Register("ZLEXCOUNT", command.HandleZLexCount)
+	d.Register("ZRANGE", command.HandleZRange)
+	d.Register("ZREVRANGE", command.HandleZRevRange)
+	d.Register("ZRANGEBYSCORE", command.HandleZRangeByScore)
+	d.Register("ZREVRANGEBYSCORE", command.HandleZRevRangeByScore)
+	d.Register("ZRANGEBYLEX", command.HandleZRangeByLex)
+	d.Register("ZREVRANGEBYLEX", command.HandleZRevRangeByLex)
+	d.RegisterWrite("ZRANGESTORE", command.HandleZRangeStore, nil)
+	d.RegisterWrite("ZPOPMIN", command.HandleZPopMin, command.RewriteIfChanged)
+	d.RegisterWrite("ZPOPMAX", command.HandleZPopMax, command.RewriteIfChanged)
+	d.Register("ZSCAN", command.HandleZScan)
+	d.RegisterWrite("ZUNIONSTORE", command.HandleZUnionStore, nil)
+	d.RegisterWrite("ZINTERSTORE", command.HandleZInterStore, nil)
+	d.Register("ZUNION", command.HandleZUnion)
+	d.Register("ZINTER", command.HandleZInter)
+
+	// Stream commands
+	d.RegisterWrite("XADD", command.HandleXAdd, command.RewriteXAdd)
+	d.Register("XLEN", command.HandleXLen)
+	d.Register("XRANGE", command.HandleXRange)
+	d.Register("XREVRANGE", command.HandleXRevRange)
+	d.RegisterWrite("XDEL", command.HandleXDel, command.RewriteIfChanged)
+	d.RegisterWrite("XTRIM", command.HandleXTrim, command.RewriteIfChanged)
+	d.RegisterWrite("XSETID", command.HandleXSetID, nil)
+	d.Register("XREAD", command.HandleXRead)
+	d.RegisterWrite("XGROUP", command.HandleXGroup, nil)
+	d.RegisterWrite("XREADGROUP", command.HandleXReadGroup, nil)
+	d.RegisterWrite("XACK", command.HandleXAck, command.RewriteIfChanged)
+	d.Register("XPENDING", command.HandleXPending)
+	d.RegisterWrite("XCLAIM", command.HandleXClaim, command.RewriteXClaim)
+	d.RegisterWrite("XAUTOCLAIM", command.HandleXAutoClaim, command.RewriteXAutoClaim)
 
 	return d
 }
@@ -34,11 +170,97 @@ func (d *Dispatcher) Register(cmd string, handler HandlerFunc) {
 	d.handlers[strings.ToUpper(cmd)] = handler
 }
 
+// RegisterWrite registers a command that modifies the dataset, so that it
+// is handed to the propagators once it succeeds.
+func (d *Dispatcher) RegisterWrite(cmd string, handler HandlerFunc, rewrite RewriteFunc) {
+	d.Register(cmd, handler)
+	d.writes[strings.ToUpper(cmd)] = rewrite
+}
+
+func (d *Dispatcher) AddPropagator(fn PropagateFunc) {
+	d.propagators = append(d.propagators, fn)
+}
+
+func (d *Dispatcher) AddToucher(fn TouchFunc) {
+	d.touchers = append(d.touchers, fn)
+}
+
+func (d *Dispatcher) AddNotifier(fn NotifyFunc) {
+	d.notifiers = append(d.notifiers, fn)
+}
+
+// Exists reports whether cmd is a known command.
+func (d *Dispatcher) Exists(cmd string) bool {
+	_, ok := d.handlers[strings.ToUpper(cmd)]
+	return ok
+}
+
+// IsWrite reports whether cmd modifies the dataset.
+func (d *Dispatcher) IsWrite(cmd string) bool {
+	_, ok := d.writes[strings.ToUpper(cmd)]
+	return ok
+}
+
+// Route checks, in cluster mode, that this node serves the keys of a
+// command, returning the MOVED, ASK or CROSSSLOT error to reply with
+// otherwise. asking is set when the client sent ASKING before the command.
+func (d *Dispatcher) Route(args []string, asking bool) error {
+	if !cluster.Enabled() || !d.Exists(args[0]) {
+		return nil
+	}
+	return cluster.Route(accessedKeys(args), asking)
+}
+
 func (d *Dispatcher) Execute(cmd string, args []string) (interface{}, error) {
-	handler, exists := d.handlers[strings.ToUpper(cmd)]
+	name := strings.ToUpper(cmd)
+	handler, exists := d.handlers[name]
 	if !exists {
 		return nil, errors.New("ERR unknown command '" + cmd + "'")
 	}
 
-	return handler(args)
+	rewrite, isWrite := d.writes[name]
+	var existed map[string]bool
+	if isWrite && len(d.notifiers) > 0 {
+		existed = existing(commandEvents(args, nil))
+	}
+	if isWrite {
+		// Snapshots being written keep the values the command changes in
+		// place
+		store := inMemory.GetInMemoryStore()
+		for _, key := range modifiedKeys(args) {
+			store.WillModify(key)
+		}
+	}
+
+	result, err := handler(args)
+	if err != nil {
+		return result, err
+	}
+	if isWrite {
+		commands := [][]string{args}
+		if rewrite != nil {
+			commands = rewrite(args, result)
+		}
+		if len(commands) > 0 {
+			inMemory.GetInMemoryStore().IncrDirty()
+			d.propagate(commands)
+			if len(d.notifiers) > 0 {
+				d.notify(args, commands, existed)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (d *Dispatcher) propagate(commands [][]string) {
+	for _, c := range commands {
+		for _, key := range modifiedKeys(c) {
+			for _, fn := range d.touchers {
+				fn(key)
+			}
+		}
+		for _, fn := range d.propagators {
+			fn(c)
+		}
+	}
 }
@@ -0,0 +1,238 @@
+package dispatcher
+
+import (
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// Keyspace notification classes, as in notify-keyspace-events.
+const (
+	ClassGeneric = 'g'
+	ClassString  = '$'
+	ClassList    = 'l'
+	ClassSet     = 's'
+	ClassHash    = 'h'
+	ClassZSet    = 'z'
+	ClassExpired = 'x'
+	ClassStream  = 't'
+	ClassNew     = 'n'
+)
+
+// NotifyFunc receives the keyspace events of executed write commands.
+type NotifyFunc func(class byte, event, key string)
+
+type keyEvent struct {
+	class byte
+	event string
+	key   string
+}
+
+// eventsFunc returns the events a write command raises. commands are the
+// commands it propagates as, nil until it has run.
+type eventsFunc func(args []string, commands [][]string) []keyEvent
+
+// writeEvents lists the events of each write command. Commands missing here
+// raise the events of the commands they propagate as, so a DEBUG JSONIMPORT
+// notifies the DEL and SET of every imported key.
+var writeEvents = map[string]eventsFunc{
+	"SET":       setEvents,
+	"DEL":       each(ClassGeneric, "del"),
+	"EXPIRE":    on(ClassGeneric, "expire"),
+	"PEXPIRE":   on(ClassGeneric, "expire"),
+	"EXPIREAT":  on(ClassGeneric, "expire"),
+	"PEXPIREAT": on(ClassGeneric, "expire"),
+	"PERSIST":   on(ClassGeneric, "persist"),
+
+	"LPUSH":     on(ClassList, "lpush"),
+	"LPUSHX":    on(ClassList, "lpush"),
+	"RPUSH":     on(ClassList, "rpush"),
+	"RPUSHX":    on(ClassList, "rpush"),
+	"LPOP":      on(ClassList, "lpop"),
+	"RPOP":      on(ClassList, "rpop"),
+	"LSET":      on(ClassList, "lset"),
+	"LREM":      on(ClassList, "lrem"),
+	"LTRIM":     on(ClassList, "ltrim"),
+	"LINSERT":   on(ClassList, "linsert"),
+	"LMOVE":     lmoveEvents,
+	"RPOPLPUSH": lmoveEvents,
+
+	"HSET":         on(ClassHash, "hset"),
+	"HMSET":        on(ClassHash, "hset"),
+	"HSETNX":       on(ClassHash, "hset"),
+	"HDEL":         on(ClassHash, "hdel"),
+	"HINCRBY":      on(ClassHash, "hincrby"),
+	"HINCRBYFLOAT": on(ClassHash, "hincrbyfloat"),
+	"HEXPIRE":      on(ClassHash, "hexpire"),
+	"HPEXPIRE":     on(ClassHash, "hexpire"),
+	"HEXPIREAT":    on(ClassHash, "hexpire"),
+	"HPEXPIREAT":   on(ClassHash, "hexpire"),
+	"HPERSIST":     on(ClassHash, "hpersist"),
+
+	"SADD":        on(ClassSet, "sadd"),
+	"SREM":        on(ClassSet, "srem"),
+	"SMOVE":       smoveEvents,
+	"SPOP":        on(ClassSet, "spop"),
+	"SINTERSTORE": on(ClassSet, "sinterstore"),
+	"SUNIONSTORE": on(ClassSet, "sunionstore"),
+	"SDIFFSTORE":  on(ClassSet, "sdiffstore"),
+
+	"ZADD":        on(ClassZSet, "zadd"),
+	"ZINCRBY":     on(ClassZSet, "zincr"),
+	"ZREM":        on(ClassZSet, "zrem"),
+	"ZRANGESTORE": on(ClassZSet, "zrangestore"),
+	"ZPOPMIN":     on(ClassZSet, "zpopmin"),
+	"ZPOPMAX":     on(ClassZSet, "zpopmax"),
+	"ZUNIONSTORE": on(ClassZSet, "zunionstore"),
+	"ZINTERSTORE": on(ClassZSet, "zinterstore"),
+
+	"XADD":       on(ClassStream, "xadd"),
+	"XDEL":       on(ClassStream, "xdel"),
+	"XTRIM":      on(ClassStream, "xtrim"),
+	"XSETID":     on(ClassStream, "xsetid"),
+	"XGROUP":     xgroupEvents,
+	"XREADGROUP": none,
+	"XACK":       none,
+	"XCLAIM":     none,
+	"XAUTOCLAIM": none,
+}
+
+// on returns the events of a command acting on its first key.
+func on(class byte, event string) eventsFunc {
+	return func(args []string, _ [][]string) []keyEvent {
+		if len(args) < 2 {
+			return nil
+		}
+		return []keyEvent{{class, event, args[1]}}
+	}
+}
+
+// each returns the events of a command acting on all its arguments.
+func each(class byte, event string) eventsFunc {
+	return func(args []string, _ [][]string) []keyEvent {
+		events := make([]keyEvent, 0, len(args)-1)
+		for _, key := range args[1:] {
+			events = append(events, keyEvent{class, event, key})
+		}
+		return events
+	}
+}
+
+func none(args []string, _ [][]string) []keyEvent {
+	return []keyEvent{}
+}
+
+// setEvents adds an expire event to the set of a SET that gave the key an
+// expiration, which it propagates as a PEXPIREAT whatever option set it.
+func setEvents(args []string, commands [][]string) []keyEvent {
+	events := on(ClassString, "set")(args, nil)
+	for _, c := range commands {
+		if strings.EqualFold(c[0], "PEXPIREAT") {
+			events = append(events, keyEvent{ClassGeneric, "expire", args[1]})
+		}
+	}
+	return events
+}
+
+// lmoveEvents covers LMOVE src dst LEFT|RIGHT LEFT|RIGHT and
+// RPOPLPUSH src dst.
+func lmoveEvents(args []string, _ [][]string) []keyEvent {
+	if len(args) < 3 {
+		return nil
+	}
+	from, to := "RIGHT", "LEFT"
+	if len(args) >= 5 {
+		from, to = strings.ToUpper(args[3]), strings.ToUpper(args[4])
+	}
+	pop, push := "rpop", "lpush"
+	if from == "LEFT" {
+		pop = "lpop"
+	}
+	if to == "RIGHT" {
+		push = "rpush"
+	}
+	return []keyEvent{{ClassList, pop, args[1]}, {ClassList, push, args[2]}}
+}
+
+func smoveEvents(args []string, _ [][]string) []keyEvent {
+	if len(args) < 3 {
+		return nil
+	}
+	return []keyEvent{{ClassSet, "srem", args[1]}, {ClassSet, "sadd", args[2]}}
+}
+
+// xgroupEvents names the event after the subcommand, e.g. xgroup-create.
+func xgroupEvents(args []string, _ [][]string) []keyEvent {
+	if len(args) < 3 {
+		return nil
+	}
+	return []keyEvent{{ClassStream, "xgroup-" + strings.ToLower(args[1]), args[2]}}
+}
+
+// commandEvents returns the events of a write command propagated as
+// commands, or nil when it has none of its own.
+func commandEvents(args []string, commands [][]string) []keyEvent {
+	if fn, ok := writeEvents[strings.ToUpper(args[0])]; ok {
+		return fn(args, commands)
+	}
+	return nil
+}
+
+// existing records which keys of events exist before the command runs, so
+// that notify can tell created and deleted keys.
+func existing(events []keyEvent) map[string]bool {
+	store := inMemory.GetInMemoryStore()
+	existed := make(map[string]bool, len(events))
+	for _, ev := range events {
+		if _, ok := existed[ev.key]; !ok {
+			existed[ev.key] = store.Exists(ev.key)
+		}
+	}
+	return existed
+}
+
+// notify hands the events of an executed write to the notifiers. A key the
+// command created is announced as new before its event, and one it left
+// empty gets a del after all events, as Redis does. DEL raises del only for
+// the keys it actually removed.
+func (d *Dispatcher) notify(args []string, commands [][]string, existed map[string]bool) {
+	events := commandEvents(args, commands)
+	if events == nil {
+		for _, c := range commands {
+			events = append(events, commandEvents(c, nil)...)
+		}
+	}
+	store := inMemory.GetInMemoryStore()
+	isDel := strings.ToUpper(args[0]) == "DEL"
+	announced := make(map[string]bool)
+	var emptied []string
+	for _, ev := range events {
+		before, known := existed[ev.key]
+		if isDel {
+			if before && !store.Exists(ev.key) {
+				d.emit(ev)
+			}
+			continue
+		}
+		if known && !announced[ev.key] {
+			announced[ev.key] = true
+			after := store.Exists(ev.key)
+			switch {
+			case !before && after:
+				d.emit(keyEvent{ClassNew, "new", ev.key})
+			case before && !after:
+				emptied = append(emptied, ev.key)
+			}
+		}
+		d.emit(ev)
+	}
+	for _, key := range emptied {
+		d.emit(keyEvent{ClassGeneric, "del", key})
+	}
+}
+
+func (d *Dispatcher) emit(ev keyEvent) {
+	for _, fn := range d.notifiers {
+		fn(ev.class, ev.event, ev.key)
+	}
+}
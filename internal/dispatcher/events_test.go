@@ -0,0 +1,50 @@
+package dispatcher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func TestNotify(t *testing.T) {
+	store := inMemory.GetInMemoryStore()
+	store.Clear()
+	defer store.Clear()
+	d := NewDispatcher()
+	var got []keyEvent
+	d.AddNotifier(func(class byte, event, key string) {
+		got = append(got, keyEvent{class, event, key})
+	})
+
+	// Each command runs on the dataset the previous ones left
+	tests := []struct {
+		args []string
+		want []keyEvent
+	}{
+		{[]string{"RPUSH", "l", "a", "b"}, []keyEvent{{ClassNew, "new", "l"}, {ClassList, "rpush", "l"}}},
+		{[]string{"RPUSH", "l", "c"}, []keyEvent{{ClassList, "rpush", "l"}}},
+		// A key left empty gets its del after the command's own event
+		{[]string{"LPOP", "l", "3"}, []keyEvent{{ClassList, "lpop", "l"}, {ClassGeneric, "del", "l"}}},
+		{[]string{"LPOP", "l"}, nil},
+		// SET with an expiration propagates a PEXPIREAT, which raises expire
+		{[]string{"SET", "s", "v", "EX", "100"}, []keyEvent{{ClassNew, "new", "s"}, {ClassString, "set", "s"}, {ClassGeneric, "expire", "s"}}},
+		{[]string{"SET", "s", "v2"}, []keyEvent{{ClassString, "set", "s"}}},
+		{[]string{"RPUSH", "src", "x"}, []keyEvent{{ClassNew, "new", "src"}, {ClassList, "rpush", "src"}}},
+		{[]string{"LMOVE", "src", "dst", "LEFT", "RIGHT"}, []keyEvent{{ClassList, "lpop", "src"}, {ClassNew, "new", "dst"}, {ClassList, "rpush", "dst"}, {ClassGeneric, "del", "src"}}},
+		{[]string{"SADD", "set", "m"}, []keyEvent{{ClassNew, "new", "set"}, {ClassSet, "sadd", "set"}}},
+		{[]string{"SADD", "set", "m"}, nil},
+		// DEL raises del only for the keys it removed, and nothing else
+		{[]string{"DEL", "s", "missing", "dst"}, []keyEvent{{ClassGeneric, "del", "s"}, {ClassGeneric, "del", "dst"}}},
+		{[]string{"DEL", "missing"}, nil},
+	}
+	for _, tt := range tests {
+		got = nil
+		if _, err := d.Execute(tt.args[0], tt.args); err != nil {
+			t.Fatalf("TestNotify failed: %v returned %v", tt.args, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TestNotify failed: %v raised %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
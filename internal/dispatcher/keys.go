@@ -0,0 +1,125 @@
+package dispatcher
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keysFunc returns the keys a command modifies.
+type keysFunc func(args []string) []string
+
+// writeKeys lists the write commands whose modified keys are not simply
+// their first argument.
+var writeKeys = map[string]keysFunc{
+	"DEL":        func(args []string) []string { return args[1:] },
+	"LMOVE":      keyRange(1, 3),
+	"RPOPLPUSH":  keyRange(1, 3),
+	"SMOVE":      keyRange(1, 3),
+	"XGROUP":     keyRange(2, 3),
+	"XREADGROUP": streamsKeys,
+}
+
+// commandKeys lists the commands whose keys are not simply their first
+// argument, for routing in cluster mode.
+var commandKeys = map[string]keysFunc{
+	"PING":         noKeys,
+	"SAVE":         noKeys,
+	"BGSAVE":       noKeys,
+	"LASTSAVE":     noKeys,
+	"BGREWRITEAOF": noKeys,
+	"INFO":         noKeys,
+	"DEBUG":        noKeys,
+	"CLUSTER":      noKeys,
+	"DEL":          allKeys,
+	"SINTER":       allKeys,
+	"SUNION":       allKeys,
+	"SDIFF":        allKeys,
+	"SINTERSTORE":  allKeys,
+	"SUNIONSTORE":  allKeys,
+	"SDIFFSTORE":   allKeys,
+	"LMOVE":        keyRange(1, 3),
+	"RPOPLPUSH":    keyRange(1, 3),
+	"SMOVE":        keyRange(1, 3),
+	"ZRANGESTORE":  keyRange(1, 3),
+	"SINTERCARD":   numKeys(1),
+	"ZUNION":       numKeys(1),
+	"ZINTER":       numKeys(1),
+	"ZUNIONSTORE":  destNumKeys,
+	"ZINTERSTORE":  destNumKeys,
+	"XGROUP":       keyRange(2, 3),
+	"XREAD":        streamsKeys,
+	"XREADGROUP":   streamsKeys,
+}
+
+func noKeys(args []string) []string {
+	return nil
+}
+
+func allKeys(args []string) []string {
+	return args[1:]
+}
+
+func keyRange(first, end int) keysFunc {
+	return func(args []string) []string {
+		if len(args) < end {
+			return nil
+		}
+		return args[first:end]
+	}
+}
+
+// streamsKeys returns the keys of "... STREAMS key ... id ...".
+func streamsKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.ToUpper(arg) == "STREAMS" {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+// numKeys returns the keys of "... numkeys key ..." with numkeys at index
+// i. A malformed numkeys yields no keys; the command itself rejects it.
+func numKeys(i int) keysFunc {
+	return func(args []string) []string {
+		if len(args) <= i {
+			return nil
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n <= 0 || len(args) < i+1+n {
+			return nil
+		}
+		return args[i+1 : i+1+n]
+	}
+}
+
+// destNumKeys returns the keys of "CMD destination numkeys key ...".
+func destNumKeys(args []string) []string {
+	if len(args) < 2 {
+		return nil
+	}
+	return append([]string{args[1]}, numKeys(2)(args)...)
+}
+
+// accessedKeys returns the keys a command reads or writes.
+func accessedKeys(args []string) []string {
+	if fn, ok := commandKeys[strings.ToUpper(args[0])]; ok {
+		return fn(args)
+	}
+	if len(args) < 2 {
+		return nil
+	}
+	return args[1:2]
+}
+
+// modifiedKeys returns the keys modified by an executed write command.
+func modifiedKeys(args []string) []string {
+	if fn, ok := writeKeys[strings.ToUpper(args[0])]; ok {
+		return fn(args)
+	}
+	if len(args) < 2 {
+		return nil
+	}
+	return args[1:2]
+}
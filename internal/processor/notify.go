@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/internal/dispatcher"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// EnableKeyspaceEvents starts publishing the keyspace notifications of the
+// classes in config.NotifyKeyspaceEvents.
+func EnableKeyspaceEvents() {
+	d.AddNotifier(notify)
+	inMemory.GetInMemoryStore().RecordExpired()
+}
+
+// notify publishes event on key to __keyspace@0__:<key> and the key to
+// __keyevent@0__:<event>, as far as the configuration asks for them.
+func notify(class byte, event, key string) {
+	classes := config.NotifyKeyspaceEvents
+	if strings.IndexByte(classes, class) < 0 {
+		return
+	}
+	if strings.IndexByte(classes, 'K') >= 0 {
+		Publish("__keyspace@0__:"+key, event)
+	}
+	if strings.IndexByte(classes, 'E') >= 0 {
+		Publish("__keyevent@0__:"+event, key)
+	}
+}
+
+// NotifyExpired publishes the expired events of the keys deleted on expiry
+// since the last call, whether lazily by a command or by the background
+// cleanup.
+func NotifyExpired() {
+	for _, key := range inMemory.GetInMemoryStore().TakeExpired() {
+		notify(dispatcher.ClassExpired, "expired", key)
+	}
+}
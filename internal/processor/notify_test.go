@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/internal/dispatcher"
+)
+
+func TestNotifyClasses(t *testing.T) {
+	defer func(saved string) { config.NotifyKeyspaceEvents = saved }(config.NotifyKeyspaceEvents)
+	s, pushed := newSubscriber()
+	defer s.Close()
+	run(s, "PSUBSCRIBE", "__key*")
+
+	tests := []struct {
+		classes string
+		class   byte
+		want    []string
+	}{
+		{"Kl", dispatcher.ClassList, []string{message("pmessage", "__key*", "__keyspace@0__:k", "lpush")}},
+		{"El", dispatcher.ClassList, []string{message("pmessage", "__key*", "__keyevent@0__:lpush", "k")}},
+		{"KEl", dispatcher.ClassList, []string{
+			message("pmessage", "__key*", "__keyspace@0__:k", "lpush"),
+			message("pmessage", "__key*", "__keyevent@0__:lpush", "k"),
+		}},
+		// Events of classes not enabled, or with neither K nor E, are dropped
+		{"K$", dispatcher.ClassList, nil},
+		{"l", dispatcher.ClassList, nil},
+	}
+	for _, tt := range tests {
+		config.NotifyKeyspaceEvents = tt.classes
+		*pushed = nil
+		notify(tt.class, "lpush", "k")
+		if !reflect.DeepEqual(*pushed, tt.want) {
+			t.Errorf("TestNotifyClasses failed: with %q published %q, want %q", tt.classes, *pushed, tt.want)
+		}
+	}
+}
@@ -4,15 +4,69 @@ import (
 	"fmt"
 
 	"github.com/bhaski-1234/redis-db/internal/dispatcher"
-	"github.com/bhaski-1234/redis-db/protocol"
 )
 
-func Process(data []byte) (interface{}, error) {
-	decoded, _, err := protocol.DecodeRESP(data)
+var d = dispatcher.NewDispatcher()
+
+var (
+	propagators []dispatcher.PropagateFunc
+	// inExec is set while EXEC runs its queue; multiSent once MULTI has
+	// been propagated for it.
+	inExec    bool
+	multiSent bool
+)
+
+func init() {
+	d.AddPropagator(propagate)
+	d.AddToucher(touch)
+}
+
+// AddPropagator registers fn to receive every successful write command.
+// The writes of a transaction are wrapped in MULTI and EXEC so they are
+// replayed atomically.
+func AddPropagator(fn dispatcher.PropagateFunc) {
+	propagators = append(propagators, fn)
+}
+
+func propagate(args []string) {
+	if inExec && !multiSent {
+		multiSent = true
+		emit([]string{"MULTI"})
+	}
+	emit(args)
+}
+
+func endExec() {
+	if multiSent {
+		emit([]string{"EXEC"})
+	}
+	inExec, multiSent = false, false
+}
+
+func emit(args []string) {
+	for _, fn := range propagators {
+		fn(args)
+	}
+}
+
+// Process executes a single decoded RESP frame as a command of session s.
+func Process(s *Session, decoded interface{}) (interface{}, error) {
+	args, err := Args(decoded)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode RESP: %w", err)
+		return nil, err
 	}
+	return Execute(s, args)
+}
 
+// Execute runs the command args of session s.
+func Execute(s *Session, args []string) (interface{}, error) {
+	result, err := s.execute(args)
+	NotifyExpired()
+	return result, err
+}
+
+// Args converts a decoded RESP frame to the arguments of a command.
+func Args(decoded interface{}) ([]string, error) {
 	// Check if decoded is a slice of interfaces
 	decodedData, ok := decoded.([]interface{})
 	if !ok {
@@ -23,12 +77,10 @@ func Process(data []byte) (interface{}, error) {
 		return nil, fmt.Errorf("empty command")
 	}
 
-	cmd, ok := decodedData[0].(string)
-	if !ok {
+	if _, ok := decodedData[0].(string); !ok {
 		return nil, fmt.Errorf("invalid command type")
 	}
 
-	d := dispatcher.NewDispatcher()
 	args := make([]string, len(decodedData))
 
 	for i, arg := range decodedData {
@@ -40,6 +92,5 @@ func Process(data []byte) (interface{}, error) {
 		}
 	}
 
-	result, err := d.Execute(cmd, args)
-	return result, err
+	return args, nil
 }
@@ -0,0 +1,170 @@
+package processor
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/utils"
+)
+
+// channels and patterns map each subscription to its subscribers.
+var (
+	channels = map[string]map[*Session]struct{}{}
+	patterns = map[string]map[*Session]struct{}{}
+)
+
+// subscribedCommands are the only commands a session with subscriptions
+// accepts.
+var subscribedCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+}
+
+// subscriptions returns the number of channels and patterns s is
+// subscribed to.
+func (s *Session) subscriptions() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// Publish sends message to every subscriber of channel and returns the
+// number of sessions that received it.
+func Publish(channel, message string) int {
+	receivers := 0
+	for sub := range channels[channel] {
+		sub.send(protocol.EncodeArray([]interface{}{"message", channel, message}))
+		receivers++
+	}
+	for pattern, subs := range patterns {
+		if !utils.MatchPattern(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			sub.send(protocol.EncodeArray([]interface{}{"pmessage", pattern, channel, message}))
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// send pushes data to the connection of s outside of any reply.
+func (s *Session) send(data []byte) {
+	if s.push != nil {
+		s.push(data)
+	}
+}
+
+// subscribe implements SUBSCRIBE and PSUBSCRIBE. Each name gets its own
+// confirmation, so the reply is several arrays.
+func (s *Session) subscribe(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	kind, registry, own := s.registry(args[0])
+	if *own == nil {
+		*own = make(map[string]struct{})
+	}
+	var reply []byte
+	for _, name := range args[1:] {
+		if _, ok := (*own)[name]; !ok {
+			(*own)[name] = struct{}{}
+			if registry[name] == nil {
+				registry[name] = make(map[*Session]struct{})
+			}
+			registry[name][s] = struct{}{}
+		}
+		reply = append(reply, protocol.EncodeArray([]interface{}{kind, name, s.subscriptions()})...)
+	}
+	return protocol.Encoded(reply), nil
+}
+
+// unsubscribe implements UNSUBSCRIBE and PUNSUBSCRIBE; without names it
+// drops every subscription of that kind.
+func (s *Session) unsubscribe(args []string) (interface{}, error) {
+	kind, registry, own := s.registry(args[0])
+	kind = "un" + kind
+	names := args[1:]
+	if len(names) == 0 {
+		for name := range *own {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		return protocol.Encoded(protocol.EncodeArray([]interface{}{kind, nil, s.subscriptions()})), nil
+	}
+	var reply []byte
+	for _, name := range names {
+		leave(registry, *own, name, s)
+		reply = append(reply, protocol.EncodeArray([]interface{}{kind, name, s.subscriptions()})...)
+	}
+	return protocol.Encoded(reply), nil
+}
+
+// registry returns the reply kind, the global registry and the session's
+// own set for a (un)subscribe command.
+func (s *Session) registry(cmd string) (string, map[string]map[*Session]struct{}, *map[string]struct{}) {
+	if strings.HasPrefix(strings.ToUpper(cmd), "P") {
+		return "psubscribe", patterns, &s.patterns
+	}
+	return "subscribe", channels, &s.channels
+}
+
+func leave(registry map[string]map[*Session]struct{}, own map[string]struct{}, name string, s *Session) {
+	delete(own, name)
+	delete(registry[name], s)
+	if len(registry[name]) == 0 {
+		delete(registry, name)
+	}
+}
+
+// unsubscribeAll drops every subscription of a closed session.
+func (s *Session) unsubscribeAll() {
+	for name := range s.channels {
+		leave(channels, s.channels, name, s)
+	}
+	for name := range s.patterns {
+		leave(patterns, s.patterns, name, s)
+	}
+}
+
+// pubsub implements the PUBSUB introspection subcommands:
+//
+//	PUBSUB CHANNELS [pattern]
+//	PUBSUB NUMSUB [channel ...]
+//	PUBSUB NUMPAT
+func pubsub(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errWrongArgs(args[0])
+	}
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "CHANNELS" && len(args) <= 3:
+		names := []string{}
+		for name := range channels {
+			if len(args) == 2 || utils.MatchPattern(args[2], name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		reply := make([]interface{}, len(names))
+		for i, name := range names {
+			reply[i] = name
+		}
+		return reply, nil
+	case sub == "NUMSUB":
+		reply := make([]interface{}, 0, 2*(len(args)-2))
+		for _, name := range args[2:] {
+			reply = append(reply, name, len(channels[name]))
+		}
+		return reply, nil
+	case sub == "NUMPAT" && len(args) == 2:
+		return len(patterns), nil
+	case sub == "CHANNELS" || sub == "NUMPAT":
+		return nil, errWrongArgs(args[0] + "|" + strings.ToLower(args[1]))
+	}
+	return nil, errors.New("ERR unknown subcommand '" + args[1] + "'. Try PUBSUB CHANNELS, PUBSUB NUMSUB or PUBSUB NUMPAT.")
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+)
+
+// newSubscriber returns a session and the messages pushed to it.
+func newSubscriber() (*Session, *[]string) {
+	var pushed []string
+	s := NewSession(func(data []byte) { pushed = append(pushed, string(data)) })
+	return s, &pushed
+}
+
+func message(parts ...interface{}) string {
+	return string(protocol.EncodeArray(parts))
+}
+
+func TestPublish(t *testing.T) {
+	alice, toAlice := newSubscriber()
+	bob, toBob := newSubscriber()
+	defer alice.Close()
+	defer bob.Close()
+	publisher := NewSession(nil)
+
+	reply, err := run(alice, "SUBSCRIBE", "news", "sport")
+	if want := message("subscribe", "news", 1) + message("subscribe", "sport", 2); err != nil || string(reply.(protocol.Encoded)) != want {
+		t.Errorf("TestPublish failed: SUBSCRIBE replied %q, %v", reply, err)
+	}
+	run(bob, "PSUBSCRIBE", "new?", "n*")
+
+	// A channel subscriber and every matching pattern get the message
+	if n, _ := run(publisher, "PUBLISH", "news", "hi"); n != 3 {
+		t.Errorf("TestPublish failed: PUBLISH reached %v subscribers, want 3", n)
+	}
+	if want := []string{message("message", "news", "hi")}; !reflect.DeepEqual(*toAlice, want) {
+		t.Errorf("TestPublish failed: alice got %q", *toAlice)
+	}
+	if len(*toBob) != 2 || !strings.Contains(strings.Join(*toBob, ""), message("pmessage", "new?", "news", "hi")) {
+		t.Errorf("TestPublish failed: bob got %q", *toBob)
+	}
+
+	*toAlice, *toBob = nil, nil
+	if n, _ := run(publisher, "PUBLISH", "nothing", "x"); n != 1 || len(*toAlice) != 0 || len(*toBob) != 1 {
+		t.Errorf("TestPublish failed: PUBLISH to a channel matching n* reached %v", n)
+	}
+	if n, _ := run(publisher, "PUBLISH", "other", "x"); n != 0 {
+		t.Errorf("TestPublish failed: PUBLISH to an unwatched channel reached %v", n)
+	}
+
+	// Unsubscribing from everything ends the delivery
+	run(alice, "UNSUBSCRIBE")
+	run(bob, "PUNSUBSCRIBE", "new?")
+	if n, _ := run(publisher, "PUBLISH", "news", "bye"); n != 1 {
+		t.Errorf("TestPublish failed: PUBLISH after unsubscribing reached %v", n)
+	}
+}
+
+func TestSubscribedMode(t *testing.T) {
+	s, pushed := newSubscriber()
+	defer s.Close()
+	run(s, "SUBSCRIBE", "news")
+
+	for _, args := range [][]string{{"GET", "k"}, {"PUBLISH", "news", "x"}, {"MULTI"}} {
+		if _, err := run(s, args...); err == nil || !strings.Contains(err.Error(), "only (P)SUBSCRIBE") {
+			t.Errorf("TestSubscribedMode failed: %v in subscribed mode gave %v", args, err)
+		}
+	}
+	// PING is answered as a message
+	if reply, err := run(s, "PING", "hi"); err != nil || !reflect.DeepEqual(reply, []interface{}{"pong", "hi"}) {
+		t.Errorf("TestSubscribedMode failed: PING replied %q, %v", reply, err)
+	}
+	if len(*pushed) != 0 {
+		t.Errorf("TestSubscribedMode failed: rejected commands pushed %q", *pushed)
+	}
+
+	// Leaving the last subscription allows other commands again
+	if reply, _ := run(s, "UNSUBSCRIBE", "news"); string(reply.(protocol.Encoded)) != message("unsubscribe", "news", 0) {
+		t.Errorf("TestSubscribedMode failed: UNSUBSCRIBE replied %q", reply)
+	}
+	if _, err := run(s, "GET", "k"); err != nil {
+		t.Errorf("TestSubscribedMode failed: GET after unsubscribing gave %v", err)
+	}
+}
+
+func TestPubsubIntrospection(t *testing.T) {
+	a, _ := newSubscriber()
+	b, _ := newSubscriber()
+	defer a.Close()
+	defer b.Close()
+	run(a, "SUBSCRIBE", "news", "sport")
+	run(b, "SUBSCRIBE", "news")
+	run(b, "PSUBSCRIBE", "n*", "s*")
+	s := NewSession(nil)
+
+	tests := []struct {
+		args []string
+		want interface{}
+	}{
+		{[]string{"PUBSUB", "CHANNELS"}, []interface{}{"news", "sport"}},
+		{[]string{"PUBSUB", "channels", "s*"}, []interface{}{"sport"}},
+		{[]string{"PUBSUB", "CHANNELS", "x*"}, []interface{}{}},
+		{[]string{"PUBSUB", "NUMSUB", "news", "sport", "none"}, []interface{}{"news", 2, "sport", 1, "none", 0}},
+		{[]string{"PUBSUB", "NUMSUB"}, []interface{}{}},
+		{[]string{"PUBSUB", "NUMPAT"}, 2},
+	}
+	for _, tt := range tests {
+		if got, err := run(s, tt.args...); err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TestPubsubIntrospection failed: %v replied %v, %v, want %v", tt.args, got, err, tt.want)
+		}
+	}
+	for _, args := range [][]string{{"PUBSUB"}, {"PUBSUB", "NUMPAT", "x"}, {"PUBSUB", "CHANNELS", "a", "b"}, {"PUBSUB", "OTHER"}} {
+		if _, err := run(s, args...); err == nil {
+			t.Errorf("TestPubsubIntrospection failed: %v was accepted", args)
+		}
+	}
+
+	// Closed sessions leave their channels and patterns
+	b.Close()
+	if got, _ := run(s, "PUBSUB", "NUMSUB", "news"); !reflect.DeepEqual(got, []interface{}{"news", 1}) {
+		t.Errorf("TestPubsubIntrospection failed: NUMSUB after close replied %v", got)
+	}
+	if got, _ := run(s, "PUBSUB", "NUMPAT"); got != 0 {
+		t.Errorf("TestPubsubIntrospection failed: NUMPAT after close replied %v", got)
+	}
+}
@@ -0,0 +1,335 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/internal/cluster"
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+var errExecAbort = errors.New("EXECABORT Transaction discarded because of previous errors.")
+
+var errReadOnly = errors.New("READONLY You can't write against a read only replica.")
+
+var errClusterDisabled = errors.New("ERR This instance has cluster support disabled")
+
+// readOnly rejects writes from every session but the primary's.
+var readOnly bool
+
+// SetReadOnly makes the server reject writes from clients, as a replica
+// does.
+func SetReadOnly(on bool) {
+	readOnly = on
+}
+
+// sessionCommands are the commands that need the session and are run by it
+// instead of the dispatcher.
+var sessionCommands = map[string]bool{
+	"UNWATCH":      true,
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"PUBLISH":      true,
+	"PUBSUB":       true,
+}
+
+var errNotInMulti = errors.New("ERR Command not allowed inside a transaction")
+
+// connCommands are the commands run by the handler of the connection, such
+// as the replication commands of the server. Each maps to whether it may
+// be queued in a transaction.
+var connCommands = map[string]bool{}
+
+// RegisterConnCommand makes sessions run cmd with the handler set by
+// SetConnHandler. Unless queueable, cmd is refused inside MULTI.
+func RegisterConnCommand(cmd string, queueable bool) {
+	connCommands[strings.ToUpper(cmd)] = queueable
+}
+
+// Session is the state a connection keeps between commands: the queue of
+// an open MULTI, the keys it watches and its subscriptions.
+type Session struct {
+	multi  bool
+	queued [][]string
+	// failed is set when a command could not be queued; EXEC then
+	// discards the transaction.
+	failed bool
+
+	watched map[string]time.Time // key -> expiry when watched, zero if none
+	// dirty is set when a watched key is modified, so EXEC aborts.
+	dirty bool
+
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	// push writes a message to the connection outside of any reply.
+	push func([]byte)
+	// master is set on the link a replica receives its primary's writes on.
+	master bool
+	// asking is set by ASKING, for the next command only.
+	asking bool
+	// conn runs the connection commands, if the session has a connection.
+	conn func(args []string) (interface{}, error)
+}
+
+// watchers maps each watched key to the sessions watching it.
+var watchers = map[string]map[*Session]struct{}{}
+
+// NewSession returns the session of a connection. push delivers pub/sub
+// messages to it and may be nil for sessions that never subscribe, such as
+// the one replaying the AOF.
+func NewSession(push func([]byte)) *Session {
+	return &Session{push: push}
+}
+
+// SetMaster marks s as the link to the primary, whose writes are applied
+// even on a read-only replica.
+func (s *Session) SetMaster() {
+	s.master = true
+}
+
+// SetConnHandler sets the handler that runs the commands registered with
+// RegisterConnCommand for s.
+func (s *Session) SetConnHandler(fn func(args []string) (interface{}, error)) {
+	s.conn = fn
+}
+
+// InExec reports whether EXEC is running the commands of a transaction, in
+// which no command can block.
+func InExec() bool {
+	return inExec
+}
+
+// Close releases the watches and subscriptions of a closed connection.
+func (s *Session) Close() {
+	s.unwatch()
+	s.unsubscribeAll()
+}
+
+// touch marks every session watching key as dirty.
+func touch(key string) {
+	for s := range watchers[key] {
+		s.dirty = true
+	}
+}
+
+// execute runs a command in the context of the session.
+func (s *Session) execute(args []string) (interface{}, error) {
+	name := strings.ToUpper(args[0])
+	if s.subscriptions() > 0 && !subscribedCommands[name] {
+		return nil, fmt.Errorf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context", strings.ToLower(args[0]))
+	}
+	asking := s.asking
+	s.asking = false
+	if name == "ASKING" {
+		if len(args) != 1 {
+			return nil, errWrongArgs(args[0])
+		}
+		if !cluster.Enabled() {
+			return nil, errClusterDisabled
+		}
+		s.asking = true
+		return "OK", nil
+	}
+	if readOnly && !s.master && d.IsWrite(name) {
+		if s.multi {
+			s.failed = true
+		}
+		return nil, errReadOnly
+	}
+	if !s.master {
+		if err := d.Route(args, asking); err != nil {
+			if s.multi {
+				s.failed = true
+			}
+			return nil, err
+		}
+	}
+	if s.multi {
+		switch name {
+		case "MULTI", "EXEC", "DISCARD", "WATCH":
+		default:
+			if queueable, ok := s.connCommand(name); ok && !queueable {
+				s.failed = true
+				return nil, errNotInMulti
+			} else if !ok && !d.Exists(name) && !sessionCommands[name] {
+				s.failed = true
+				return nil, errors.New("ERR unknown command '" + args[0] + "'")
+			}
+			s.queued = append(s.queued, args)
+			return "QUEUED", nil
+		}
+	}
+
+	switch name {
+	case "MULTI":
+		if len(args) != 1 {
+			return nil, errWrongArgs(args[0])
+		}
+		if s.multi {
+			return nil, errors.New("ERR MULTI calls can not be nested")
+		}
+		s.multi = true
+		return "OK", nil
+	case "EXEC":
+		if len(args) != 1 {
+			return nil, errWrongArgs(args[0])
+		}
+		return s.exec()
+	case "DISCARD":
+		if len(args) != 1 {
+			return nil, errWrongArgs(args[0])
+		}
+		if !s.multi {
+			return nil, errors.New("ERR DISCARD without MULTI")
+		}
+		s.discard()
+		s.unwatch()
+		return "OK", nil
+	case "WATCH":
+		if len(args) < 2 {
+			return nil, errWrongArgs(args[0])
+		}
+		if s.multi {
+			return nil, errors.New("ERR WATCH inside MULTI is not allowed")
+		}
+		for _, key := range args[1:] {
+			s.watch(key)
+		}
+		return "OK", nil
+	}
+	return s.dispatch(args)
+}
+
+// connCommand reports whether name is a connection command s can run and
+// whether it may be queued.
+func (s *Session) connCommand(name string) (queueable bool, ok bool) {
+	if s.conn == nil {
+		return false, false
+	}
+	queueable, ok = connCommands[name]
+	return queueable, ok
+}
+
+// dispatch runs a command that may also be queued in a transaction.
+func (s *Session) dispatch(args []string) (interface{}, error) {
+	name := strings.ToUpper(args[0])
+	if _, ok := s.connCommand(name); ok {
+		return s.conn(args)
+	}
+	switch name {
+	case "UNWATCH":
+		if len(args) != 1 {
+			return nil, errWrongArgs(args[0])
+		}
+		s.unwatch()
+		return "OK", nil
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		return s.subscribe(args)
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return s.unsubscribe(args)
+	case "PUBLISH":
+		if len(args) != 3 {
+			return nil, errWrongArgs(args[0])
+		}
+		return Publish(args[1], args[2]), nil
+	case "PUBSUB":
+		return pubsub(args)
+	case "PING":
+		// A subscribed connection gets its pong as a message
+		if s.subscriptions() > 0 && len(args) <= 2 {
+			message := ""
+			if len(args) == 2 {
+				message = args[1]
+			}
+			return []interface{}{"pong", message}, nil
+		}
+	}
+	return d.Execute(args[0], args)
+}
+
+// exec runs the queued commands one after another. Commands run on a single
+// goroutine, so no other client's command can interleave with them. A
+// command that fails does not stop the others; its error is part of the
+// reply.
+func (s *Session) exec() (interface{}, error) {
+	if !s.multi {
+		return nil, errors.New("ERR EXEC without MULTI")
+	}
+	queued, failed := s.queued, s.failed
+	s.discard()
+	aborted := s.dirty || s.watchExpired()
+	s.unwatch()
+	if failed {
+		return nil, errExecAbort
+	}
+	if aborted {
+		return protocol.NullArray{}, nil
+	}
+
+	inExec = true
+	defer endExec()
+	replies := make([]interface{}, len(queued))
+	for i, args := range queued {
+		result, err := s.dispatch(args)
+		if err != nil {
+			replies[i] = protocol.Encoded(protocol.EncodeResponse(err))
+		} else {
+			replies[i] = protocol.Encoded(protocol.EncodeResponse(result))
+		}
+	}
+	return replies, nil
+}
+
+func (s *Session) discard() {
+	s.multi, s.queued, s.failed = false, nil, false
+}
+
+func (s *Session) watch(key string) {
+	if s.watched == nil {
+		s.watched = make(map[string]time.Time)
+	}
+	if _, ok := s.watched[key]; ok {
+		return
+	}
+	var expires time.Time
+	store := inMemory.GetInMemoryStore()
+	if store.Exists(key) {
+		expires, _ = store.GetExpiration(key)
+	}
+	s.watched[key] = expires
+	if watchers[key] == nil {
+		watchers[key] = make(map[*Session]struct{})
+	}
+	watchers[key][s] = struct{}{}
+}
+
+// watchExpired reports whether a watched key has expired since WATCH; an
+// expiry is a deletion even though no command touched the key.
+func (s *Session) watchExpired() bool {
+	now := time.Now()
+	for _, expires := range s.watched {
+		if !expires.IsZero() && now.After(expires) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Session) unwatch() {
+	for key := range s.watched {
+		delete(watchers[key], s)
+		if len(watchers[key]) == 0 {
+			delete(watchers, key)
+		}
+	}
+	s.watched, s.dirty = nil, false
+}
+
+func errWrongArgs(cmd string) error {
+	return errors.New("ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command")
+}
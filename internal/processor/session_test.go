@@ -0,0 +1,209 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/internal/dispatcher"
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func run(s *Session, args ...string) (interface{}, error) {
+	return Execute(s, args)
+}
+
+// execReplies runs EXEC and decodes the reply of every queued command.
+func execReplies(t *testing.T, s *Session) []string {
+	t.Helper()
+	reply, err := run(s, "EXEC")
+	if err != nil {
+		t.Fatalf("%s failed: EXEC returned %v", t.Name(), err)
+	}
+	replies, ok := reply.([]interface{})
+	if !ok {
+		t.Fatalf("%s failed: EXEC returned %#v, want an array", t.Name(), reply)
+	}
+	got := make([]string, len(replies))
+	for i, r := range replies {
+		got[i] = string(r.(protocol.Encoded))
+	}
+	return got
+}
+
+func TestMultiExec(t *testing.T) {
+	inMemory.GetInMemoryStore().Clear()
+	s := NewSession(nil)
+
+	if reply, _ := run(s, "MULTI"); reply != "OK" {
+		t.Fatalf("TestMultiExec failed: MULTI replied %v", reply)
+	}
+	if _, err := run(s, "MULTI"); err == nil {
+		t.Errorf("TestMultiExec failed: nested MULTI was accepted")
+	}
+	for _, args := range [][]string{{"SET", "tx:a", "1"}, {"RPUSH", "tx:list", "x"}, {"LPUSH", "tx:a", "x"}, {"GET", "tx:a"}} {
+		if reply, err := run(s, args...); reply != "QUEUED" || err != nil {
+			t.Fatalf("TestMultiExec failed: %v replied %v, %v", args, reply, err)
+		}
+	}
+	if _, ok := inMemory.GetInMemoryStore().Get("tx:a"); ok {
+		t.Errorf("TestMultiExec failed: queued SET ran before EXEC")
+	}
+
+	// A command failing at run time does not stop the others
+	want := []string{"+OK\r\n", ":1\r\n", "-WRONGTYPE", "$1\r\n1\r\n"}
+	got := execReplies(t, s)
+	if len(got) != len(want) {
+		t.Fatalf("TestMultiExec failed: got %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("TestMultiExec failed: reply %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+	if _, err := run(s, "EXEC"); err == nil {
+		t.Errorf("TestMultiExec failed: EXEC without MULTI was accepted")
+	}
+}
+
+func TestExecAbort(t *testing.T) {
+	inMemory.GetInMemoryStore().Clear()
+	s := NewSession(nil)
+
+	run(s, "MULTI")
+	run(s, "SET", "tx:b", "1")
+	if _, err := run(s, "NOSUCHCOMMAND"); err == nil {
+		t.Fatalf("TestExecAbort failed: unknown command was queued")
+	}
+	run(s, "SET", "tx:c", "1")
+	if _, err := run(s, "EXEC"); err != errExecAbort {
+		t.Errorf("TestExecAbort failed: EXEC returned %v, want EXECABORT", err)
+	}
+	store := inMemory.GetInMemoryStore()
+	if store.Exists("tx:b") || store.Exists("tx:c") {
+		t.Errorf("TestExecAbort failed: an aborted transaction changed the dataset")
+	}
+
+	// The session is usable again afterwards
+	run(s, "MULTI")
+	run(s, "SET", "tx:b", "2")
+	if got := execReplies(t, s); !reflect.DeepEqual(got, []string{"+OK\r\n"}) {
+		t.Errorf("TestExecAbort failed: transaction after an abort replied %q", got)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	inMemory.GetInMemoryStore().Clear()
+	s := NewSession(nil)
+
+	if _, err := run(s, "DISCARD"); err == nil {
+		t.Errorf("TestDiscard failed: DISCARD without MULTI was accepted")
+	}
+	run(s, "WATCH", "tx:d")
+	run(s, "MULTI")
+	run(s, "SET", "tx:d", "1")
+	if reply, _ := run(s, "DISCARD"); reply != "OK" {
+		t.Fatalf("TestDiscard failed: DISCARD replied %v", reply)
+	}
+	if inMemory.GetInMemoryStore().Exists("tx:d") {
+		t.Errorf("TestDiscard failed: a discarded command ran")
+	}
+	if len(s.watched) != 0 || len(watchers["tx:d"]) != 0 {
+		t.Errorf("TestDiscard failed: DISCARD kept the watched keys")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	store := inMemory.GetInMemoryStore()
+	store.Clear()
+	store.Set("tx:deleted", "v")
+	store.Set("tx:expiring", "v")
+	store.SetExpiration("tx:expiring", time.Now().Add(20*time.Millisecond))
+
+	tests := []struct {
+		name   string
+		key    string
+		change func(other *Session)
+	}{
+		{"modified", "tx:modified", func(other *Session) { run(other, "SET", "tx:modified", "x") }},
+		{"deleted", "tx:deleted", func(other *Session) { run(other, "DEL", "tx:deleted") }},
+		{"expired", "tx:expiring", func(*Session) { time.Sleep(30 * time.Millisecond) }},
+	}
+	for _, tt := range tests {
+		s, other := NewSession(nil), NewSession(nil)
+		if reply, _ := run(s, "WATCH", tt.key); reply != "OK" {
+			t.Fatalf("TestWatch failed: WATCH replied %v", reply)
+		}
+		tt.change(other)
+		run(s, "MULTI")
+		if _, err := run(s, "WATCH", tt.key); err == nil {
+			t.Errorf("TestWatch failed: WATCH inside MULTI was accepted")
+		}
+		run(s, "SET", "tx:result", tt.name)
+		if reply, err := run(s, "EXEC"); reply != (protocol.NullArray{}) || err != nil {
+			t.Errorf("TestWatch failed: EXEC after the key was %s replied %v, %v", tt.name, reply, err)
+		}
+		if store.Exists("tx:result") {
+			t.Errorf("TestWatch failed: transaction ran after the key was %s", tt.name)
+		}
+	}
+
+	// A watched key left alone lets the transaction run
+	s := NewSession(nil)
+	run(s, "WATCH", "tx:untouched")
+	run(s, "MULTI")
+	run(s, "SET", "tx:untouched", "1")
+	if got := execReplies(t, s); !reflect.DeepEqual(got, []string{"+OK\r\n"}) {
+		t.Errorf("TestWatch failed: transaction on an untouched key replied %q", got)
+	}
+	if len(watchers) != 0 {
+		t.Errorf("TestWatch failed: EXEC left %d keys watched", len(watchers))
+	}
+}
+
+func TestUnwatch(t *testing.T) {
+	inMemory.GetInMemoryStore().Clear()
+	s, other := NewSession(nil), NewSession(nil)
+
+	run(s, "WATCH", "tx:e", "tx:f")
+	if reply, _ := run(s, "UNWATCH"); reply != "OK" {
+		t.Fatalf("TestUnwatch failed: UNWATCH replied %v", reply)
+	}
+	run(other, "SET", "tx:e", "x")
+	run(s, "MULTI")
+	run(s, "GET", "tx:e")
+	if got := execReplies(t, s); !reflect.DeepEqual(got, []string{"$1\r\nx\r\n"}) {
+		t.Errorf("TestUnwatch failed: EXEC after UNWATCH replied %q", got)
+	}
+	if len(watchers) != 0 {
+		t.Errorf("TestUnwatch failed: UNWATCH left %d keys watched", len(watchers))
+	}
+}
+
+func TestPropagateExec(t *testing.T) {
+	inMemory.GetInMemoryStore().Clear()
+	defer func(saved []dispatcher.PropagateFunc) { propagators = saved }(propagators)
+	var got [][]string
+	AddPropagator(func(args []string) { got = append(got, args) })
+	s := NewSession(nil)
+
+	// The writes of a transaction are wrapped in MULTI and EXEC
+	for _, args := range [][]string{{"MULTI"}, {"SET", "tx:a", "1"}, {"GET", "tx:a"}, {"RPUSH", "tx:list", "x"}, {"EXEC"}} {
+		run(s, args...)
+	}
+	want := [][]string{{"MULTI"}, {"SET", "tx:a", "1"}, {"RPUSH", "tx:list", "x"}, {"EXEC"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TestPropagateExec failed: propagated %q, want %q", got, want)
+	}
+
+	// Transactions without writes and writes outside one are not wrapped
+	got = nil
+	for _, args := range [][]string{{"MULTI"}, {"GET", "tx:a"}, {"EXEC"}, {"SET", "tx:b", "2"}} {
+		run(s, args...)
+	}
+	if want := [][]string{{"SET", "tx:b", "2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TestPropagateExec failed: propagated %q, want %q", got, want)
+	}
+}
@@ -3,23 +3,109 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/sentinel"
 	"github.com/bhaski-1234/redis-db/server"
 )
 
 func initFlags() {
 	flag.StringVar(&config.Host, "host", "localhost", "Redis server host")
 	flag.IntVar(&config.Port, "port", 6379, "Redis server port")
+	flag.BoolVar(&config.AppendOnly, "appendonly", false, "Log every write command to the append-only file")
+	flag.StringVar(&config.AppendFsync, "appendfsync", "everysec", "AOF fsync policy: always, everysec or no")
+	flag.StringVar(&config.AppendFilename, "appendfilename", "appendonly.aof", "Name of the append-only file")
+	flag.IntVar(&config.AutoAOFRewritePercentage, "auto-aof-rewrite-percentage", 100, "Rewrite the AOF once it grew by this percentage (0 disables)")
+	flag.Int64Var(&config.AutoAOFRewriteMinSize, "auto-aof-rewrite-min-size", 64<<20, "Minimum AOF size in bytes for an automatic rewrite")
+	saveRules := flag.String("save", "3600 1 300 100 60 10000", "Snapshot save points as \"seconds changes\" pairs, empty to disable")
+	flag.StringVar(&config.SnapshotFormat, "snapshot-format", config.SnapshotFormatNative, "Format of saved snapshots: native or redis (RDB files of stock Redis are always readable)")
+	flag.IntVar(&config.SnapshotCompressThreshold, "snapshot-compress-threshold", 1024, "Compress snapshot values of at least this many bytes, 0 to disable")
+	flag.StringVar(&config.EncryptionKeyFile, "encryption-key-file", "", "File with hex AES-256 keys for encrypting snapshots and the AOF, current key first")
+	flag.StringVar(&config.ReplicaOf, "replicaof", "", "Replicate the primary at \"host port\"")
+	flag.BoolVar(&config.ReplicaReadOnly, "replica-read-only", true, "Reject writes from clients while replicating")
+	flag.IntVar(&config.ReplBacklogSize, "repl-backlog-size", 1<<20, "Bytes of replication stream kept for replicas to continue after a broken link")
+	flag.BoolVar(&config.DebugJSON, "enable-debug-json", false, "Allow DEBUG JSONEXPORT and JSONIMPORT to write and read files in the data directory")
+	keyspaceEvents := flag.String("notify-keyspace-events", "", "Keyspace notification classes, e.g. \"KEA\" or \"Ex\" (empty disables)")
+	flag.BoolVar(&config.ClusterEnabled, "cluster-enabled", false, "Run as a cluster node serving the keys of its hash slots")
+	flag.StringVar(&config.ClusterConfigFile, "cluster-config-file", "nodes.conf", "File a cluster node keeps its ID, slots and known nodes in")
+	flag.BoolVar(&config.Sentinel, "sentinel", false, "Run as a sentinel that monitors primaries and fails over to their replicas")
+	flag.Func("sentinel-monitor", "Primary to monitor as \"name host port quorum\", may be repeated", func(s string) error {
+		monitor, err := config.ParseSentinelMonitor(s)
+		if err != nil {
+			return err
+		}
+		config.SentinelMonitors = append(config.SentinelMonitors, monitor)
+		return nil
+	})
+	flag.IntVar(&config.SentinelDownAfter, "sentinel-down-after-milliseconds", 30000, "Time without a reply after which a sentinel considers an instance down")
+	flag.IntVar(&config.SentinelFailoverTimeout, "sentinel-failover-timeout", 180000, "Milliseconds a failover may take before it is retried")
+	flag.Parse()
+
+	// A sentinel listens on its own port unless told otherwise
+	if config.Sentinel {
+		portSet := false
+		flag.Visit(func(f *flag.Flag) { portSet = portSet || f.Name == "port" })
+		if !portSet {
+			config.Port = 26379
+		}
+	}
+	if config.SentinelDownAfter < 1 || config.SentinelFailoverTimeout < 1 {
+		fmt.Println("sentinel timeouts must be positive")
+		os.Exit(1)
+	}
+
+	if config.ClusterEnabled && config.ReplicaOf != "" {
+		fmt.Println("replicaof is not allowed in cluster mode")
+		os.Exit(1)
+	}
+
+	if config.SnapshotFormat != config.SnapshotFormatNative && config.SnapshotFormat != config.SnapshotFormatRedis {
+		fmt.Printf("invalid snapshot format %q\n", config.SnapshotFormat)
+		os.Exit(1)
+	}
+
+	if config.ReplBacklogSize < 1 {
+		fmt.Printf("invalid repl-backlog-size %d\n", config.ReplBacklogSize)
+		os.Exit(1)
+	}
+
+	savePoints, err := config.ParseSavePoints(*saveRules)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	config.SavePoints = savePoints
+
+	config.NotifyKeyspaceEvents, err = config.ParseKeyspaceEvents(*keyspaceEvents)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 	initFlags()
+	if config.Sentinel {
+		if err := sentinel.New().Start(); err != nil {
+			fmt.Printf("Error starting sentinel: %v\n", err)
+		}
+		return
+	}
 	server := server.NewServer()
 	if err := server.Start(); err != nil {
 		fmt.Printf("Error starting server: %v\n", err)
 		return
 	}
 	defer server.Close()
-	fmt.Printf("Server started on %s:%d\n", config.Host, config.Port)
+	fmt.Printf("Server on %s:%d stopped\n", config.Host, config.Port)
 }
@@ -2,106 +2,168 @@ package protocol
 
 import (
 	"errors"
-	"strings"
 
 	"github.com/bhaski-1234/redis-db/constant"
 )
 
+// ErrIncomplete is returned when the buffer ends before a full RESP frame
+// has been received. Callers should wait for more data and retry.
+var ErrIncomplete = errors.New("incomplete RESP frame")
+
+// maxBulkLength caps the size of a single bulk string, matching Redis'
+// default proto-max-bulk-len.
+const maxBulkLength = 512 * 1024 * 1024
+
+// maxPrealloc caps the elements allocated for an array before they arrive,
+// so a huge length prefix alone can not exhaust memory.
+const maxPrealloc = 1024
+
+// readLine returns the bytes between start and the next CRLF together with
+// the index just past the CRLF.
+func readLine(data []byte, start int) ([]byte, int, error) {
+	for i := start; i+1 < len(data); i++ {
+		if data[i] == '\r' {
+			if data[i+1] != '\n' {
+				return nil, 0, errors.New(constant.ErrInvalidRESP)
+			}
+			return data[start:i], i + 2, nil
+		}
+	}
+	return nil, 0, ErrIncomplete
+}
+
+// parseLength parses the signed length prefix used by bulk strings and arrays.
+func parseLength(line []byte) (int, error) {
+	if len(line) == 0 {
+		return 0, errors.New(constant.ErrInvalidRESP)
+	}
+	if len(line) == 2 && line[0] == '-' && line[1] == '1' {
+		return -1, nil
+	}
+	var length int
+	for _, c := range line {
+		if c < '0' || c > '9' {
+			return 0, errors.New(constant.ErrInvalidRESP)
+		}
+		length = length*10 + int(c-'0')
+		if length > maxBulkLength {
+			return 0, errors.New(constant.ErrInvalidRESP)
+		}
+	}
+	return length, nil
+}
+
 func DecodeInteger(data []byte) (int, int, error) {
-	if len(data) < 2 || data[0] != ':' {
+	if len(data) < 1 || data[0] != ':' {
 		return 0, 0, errors.New(constant.ErrInvalidRESP)
 	}
+	line, next, err := readLine(data, 1)
+	if err != nil {
+		return 0, 0, err
+	}
 	var value int
-	i := 1
+	i := 0
 	sign := 1
-	if i < len(data) && data[i] == '-' {
+	if i < len(line) && line[i] == '-' {
 		sign = -1
 		i++
 	}
-	for i < len(data) && data[i] != '\r' {
-		if data[i] < '0' || data[i] > '9' {
+	if i == len(line) {
+		return 0, 0, errors.New(constant.ErrInvalidRESP)
+	}
+	for ; i < len(line); i++ {
+		if line[i] < '0' || line[i] > '9' {
 			return 0, 0, errors.New(constant.ErrInvalidRESP)
 		}
-		value = value*10 + int(data[i]-'0')
-		i++
+		value = value*10 + int(line[i]-'0')
 	}
-	return sign * value, i + 2, nil
+	return sign * value, next, nil
 }
 
-func DecodeBulkString(data []byte) (string, int, error) {
-	if len(data) < 2 || data[0] != '$' {
+// DecodeBulkString decodes a bulk string. A null bulk string ($-1) decodes
+// to nil.
+func DecodeBulkString(data []byte) (interface{}, int, error) {
+	if len(data) < 1 || data[0] != '$' {
 		return "", 0, errors.New(constant.ErrInvalidRESP)
 	}
-
-	var length int
-	i := 1
-	for i < len(data) && data[i] != '\r' {
-		if data[i] < '0' || data[i] > '9' {
-			return "", 0, errors.New(constant.ErrInvalidRESP)
-		}
-		length = length*10 + int(data[i]-'0')
-		i++
+	line, i, err := readLine(data, 1)
+	if err != nil {
+		return "", 0, err
+	}
+	length, err := parseLength(line)
+	if err != nil {
+		return "", 0, err
+	}
+	if length < 0 {
+		return nil, i, nil
+	}
+	if len(data) < i+length+2 {
+		return "", 0, ErrIncomplete
+	}
+	if data[i+length] != '\r' || data[i+length+1] != '\n' {
+		return "", 0, errors.New(constant.ErrInvalidRESP)
 	}
-
-	i += 2 // skip \r\n
 	return string(data[i : i+length]), i + length + 2, nil
 }
 
 func DecodeSimpleString(data []byte) (string, int, error) {
-	if len(data) < 2 || data[0] != '+' {
+	if len(data) < 1 || data[0] != '+' {
 		return "", 0, errors.New(constant.ErrInvalidRESP)
 	}
-	i := 1
-	var builder strings.Builder
-	for i < len(data) && data[i] != '\r' {
-		builder.WriteByte(data[i])
-		i++
+	line, next, err := readLine(data, 1)
+	if err != nil {
+		return "", 0, err
 	}
-	return builder.String(), i + 2, nil
+	return string(line), next, nil
 }
 
+// DecodeArray decodes an array. A null array (*-1) decodes to a nil slice.
 func DecodeArray(data []byte) ([]interface{}, int, error) {
-	if len(data) < 2 || data[0] != '*' {
+	if len(data) < 1 || data[0] != '*' {
 		return nil, 0, errors.New(constant.ErrInvalidRESP)
 	}
-
-	var length int
-	i := 1
-	for i < len(data) && data[i] != '\r' {
-		if data[i] < '0' || data[i] > '9' {
-			return nil, 0, errors.New(constant.ErrInvalidRESP)
-		}
-		length = length*10 + int(data[i]-'0')
-		i++
+	line, i, err := readLine(data, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	length, err := parseLength(line)
+	if err != nil {
+		return nil, 0, err
+	}
+	if length < 0 {
+		return nil, i, nil
 	}
 
-	i += 2 // skip \r\n
-	result := make([]interface{}, length)
+	result := make([]interface{}, 0, min(length, maxPrealloc))
 	for j := 0; j < length; j++ {
+		if i >= len(data) {
+			return nil, 0, ErrIncomplete
+		}
 		item, nextIndex, err := DecodeRESP(data[i:])
 		if err != nil {
 			return nil, 0, err
 		}
-		result[j] = item
+		result = append(result, item)
 		i += nextIndex
 	}
 	return result, i, nil
 }
 
 func DecodeError(data []byte) (string, int, error) {
-	if len(data) < 2 || data[0] != '-' {
+	if len(data) < 1 || data[0] != '-' {
 		return "", 0, errors.New(constant.ErrInvalidRESP)
 	}
-	i := 1
-	var builder strings.Builder
-	for i < len(data) && data[i] != '\r' {
-		builder.WriteByte(data[i])
-		i++
+	line, next, err := readLine(data, 1)
+	if err != nil {
+		return "", 0, err
 	}
-	return builder.String(), i + 2, nil
+	return string(line), next, nil
 }
 
 func DecodeRESP(data []byte) (interface{}, int, error) {
+	if len(data) == 0 {
+		return nil, 0, ErrIncomplete
+	}
 	switch data[0] {
 	case ':':
 		return DecodeInteger(data)
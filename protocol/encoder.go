@@ -6,6 +6,18 @@ import (
 	"strconv"
 )
 
+// Encoded is a reply that is already in RESP form, e.g. one element of an
+// array of replies to different commands.
+type Encoded []byte
+
+// NullArray is the reply of a command that returns no array at all, such as
+// an aborted EXEC.
+type NullArray struct{}
+
+// BulkString is a reply that must be sent as a bulk string, e.g. because it
+// may contain CRLF. Plain strings are sent as simple strings.
+type BulkString string
+
 func EncodeInteger(value int) []byte {
 	sign := ""
 	if value < 0 {
@@ -19,6 +31,11 @@ func EncodeBulkString(value string) []byte {
 	return []byte("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
 }
 
+// EncodeNull encodes a null bulk string, the RESP2 reply for a missing value.
+func EncodeNull() []byte {
+	return []byte("$-1\r\n")
+}
+
 func EncodeSimpleString(value string) []byte {
 	return []byte("+" + value + "\r\n")
 }
@@ -38,8 +55,18 @@ func EncodeArray(values []interface{}) []byte {
 		switch v := value.(type) {
 		case int:
 			result = append(result, EncodeInteger(v)...)
+		case int64:
+			result = append(result, EncodeInteger(int(v))...)
 		case string:
 			result = append(result, EncodeBulkString(v)...)
+		case BulkString:
+			result = append(result, EncodeBulkString(string(v))...)
+		case []interface{}:
+			result = append(result, EncodeArray(v)...)
+		case nil:
+			result = append(result, EncodeNull()...)
+		case Encoded:
+			result = append(result, v...)
 		default:
 			log.Printf("Warning: Unsupported type %T encountered in encodeArray", value)
 		}
@@ -51,8 +78,18 @@ func EncodeResponse(data interface{}) []byte {
 	switch v := data.(type) {
 	case string:
 		return EncodeSimpleString(v)
+	case BulkString:
+		return EncodeBulkString(string(v))
 	case int:
 		return EncodeInteger(v)
+	case int64:
+		return EncodeInteger(int(v))
+	case nil:
+		return EncodeNull()
+	case NullArray:
+		return []byte("*-1\r\n")
+	case Encoded:
+		return v
 	case []interface{}:
 		return EncodeArray(v)
 	case error:
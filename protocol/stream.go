@@ -0,0 +1,120 @@
+package protocol
+
+// Decoder incrementally decodes RESP frames from a byte stream. Data read
+// from a connection is appended with Feed, and every complete frame can be
+// pulled out with Next. Bytes belonging to a partial frame stay buffered
+// until the rest of the frame arrives; the elements of a partial array that
+// were already decoded are kept, so a large command arriving in many reads
+// is scanned only once.
+type Decoder struct {
+	buf   []byte
+	pos   int
+	start int // start of the frame being decoded
+	last  int // start of the frame last returned by Next
+	// arrays holds the arrays of the frame being decoded that still miss
+	// elements, innermost last.
+	arrays []partialArray
+}
+
+type partialArray struct {
+	items []interface{}
+	want  int
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// Feed appends freshly read bytes to the decoder buffer.
+func (d *Decoder) Feed(data []byte) {
+	keep := d.pos
+	if len(d.arrays) > 0 {
+		keep = d.start
+	}
+	if keep > 0 && keep == len(d.buf) {
+		// Everything buffered has been consumed, start over
+		d.buf = d.buf[:0]
+		d.pos, d.start, d.last = 0, 0, 0
+	} else if keep > len(d.buf)/2 {
+		// Compact to avoid growing the buffer forever on pipelined input
+		n := copy(d.buf, d.buf[keep:])
+		d.buf = d.buf[:n]
+		d.pos -= keep
+		d.start -= keep
+		d.last = 0
+	}
+	d.buf = append(d.buf, data...)
+}
+
+// Next returns the next complete frame. It returns ErrIncomplete when the
+// buffer does not yet hold a whole frame; any other error means the stream
+// is malformed and the connection should be dropped.
+func (d *Decoder) Next() (interface{}, error) {
+	if len(d.arrays) == 0 {
+		d.start = d.pos
+	}
+	for {
+		if d.pos >= len(d.buf) {
+			return nil, ErrIncomplete
+		}
+		var value interface{}
+		if d.buf[d.pos] == '*' {
+			line, next, err := readLine(d.buf, d.pos+1)
+			if err != nil {
+				return nil, err
+			}
+			length, err := parseLength(line)
+			if err != nil {
+				return nil, err
+			}
+			d.pos = next
+			if length > 0 {
+				d.arrays = append(d.arrays, partialArray{items: make([]interface{}, 0, min(length, maxPrealloc)), want: length})
+				continue
+			}
+			if length == 0 {
+				value = []interface{}{}
+			} else {
+				value = []interface{}(nil)
+			}
+		} else {
+			v, n, err := DecodeRESP(d.buf[d.pos:])
+			if err != nil {
+				return nil, err
+			}
+			d.pos += n
+			value = v
+		}
+
+		// Hand the value to the array it belongs to, completing every
+		// array that it fills
+		for len(d.arrays) > 0 {
+			top := &d.arrays[len(d.arrays)-1]
+			top.items = append(top.items, value)
+			if len(top.items) < top.want {
+				break
+			}
+			value = top.items
+			d.arrays = d.arrays[:len(d.arrays)-1]
+		}
+		if len(d.arrays) == 0 {
+			d.last = d.start
+			return value, nil
+		}
+	}
+}
+
+// Raw returns the encoded bytes of the frame last returned by Next. They
+// are only valid until the next call to Feed.
+func (d *Decoder) Raw() []byte {
+	return d.buf[d.last:d.pos]
+}
+
+// Buffered returns the number of bytes received but not yet returned as
+// part of a frame.
+func (d *Decoder) Buffered() int {
+	if len(d.arrays) > 0 {
+		return len(d.buf) - d.start
+	}
+	return len(d.buf) - d.pos
+}
@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecoderPipelined(t *testing.T) {
+	input := []byte("*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n:7\r\n")
+
+	output := []interface{}{
+		[]interface{}{"PING"},
+		[]interface{}{"GET", "foo"},
+		7,
+	}
+
+	d := NewDecoder()
+	d.Feed(input)
+	for i, expected := range output {
+		result, err := d.Next()
+		if err != nil {
+			t.Fatalf("TestDecoderPipelined failed at frame %d: unexpected error %v", i, err)
+		}
+		if string(EncodeResponse(result)) != string(EncodeResponse(expected)) {
+			t.Errorf("TestDecoderPipelined failed at frame %d: expected %v, got %v", i, expected, result)
+		}
+	}
+	if _, err := d.Next(); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("TestDecoderPipelined expected ErrIncomplete after last frame, got %v", err)
+	}
+}
+
+func TestDecoderPartialFrames(t *testing.T) {
+	value := strings.Repeat("v", 10000)
+	frame := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$" + "10000\r\n" + value + "\r\n"
+
+	// Feed the frame in small chunks, including splits inside the CRLF
+	d := NewDecoder()
+	for i := 0; i < len(frame); i += 7 {
+		end := i + 7
+		if end > len(frame) {
+			end = len(frame)
+		}
+		d.Feed([]byte(frame[i:end]))
+		result, err := d.Next()
+		if end < len(frame) {
+			if !errors.Is(err, ErrIncomplete) {
+				t.Fatalf("TestDecoderPartialFrames expected ErrIncomplete at offset %d, got %v", end, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestDecoderPartialFrames failed: unexpected error %v", err)
+		}
+		args, _ := result.([]interface{})
+		if len(args) != 3 || args[2] != value {
+			t.Errorf("TestDecoderPartialFrames failed: got %d args", len(args))
+		}
+	}
+	if d.Buffered() != 0 {
+		t.Errorf("TestDecoderPartialFrames expected empty buffer, got %d bytes", d.Buffered())
+	}
+}
+
+func TestDecoderNullValues(t *testing.T) {
+	input := [][]byte{
+		[]byte("$-1\r\n"),
+		[]byte("*-1\r\n"),
+	}
+
+	for _, data := range input {
+		d := NewDecoder()
+		d.Feed(data)
+		result, err := d.Next()
+		if err != nil {
+			t.Errorf("TestDecoderNullValues failed for input %q: unexpected error %v", data, err)
+		}
+		if s, ok := result.([]interface{}); ok && s != nil {
+			t.Errorf("TestDecoderNullValues failed for input %q: expected nil, got %v", data, result)
+		}
+		if s, ok := result.(string); ok {
+			t.Errorf("TestDecoderNullValues failed for input %q: expected nil, got %q", data, s)
+		}
+	}
+}
+
+func TestDecoderProtocolError(t *testing.T) {
+	d := NewDecoder()
+	d.Feed([]byte("?garbage\r\n"))
+	if _, err := d.Next(); err == nil || errors.Is(err, ErrIncomplete) {
+		t.Errorf("TestDecoderProtocolError expected protocol error, got %v", err)
+	}
+}
+
+func TestDecoderRaw(t *testing.T) {
+	frames := []string{"*1\r\n$4\r\nPING\r\n", "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"}
+
+	d := NewDecoder()
+	d.Feed([]byte(strings.Join(frames, "")))
+	for i, expected := range frames {
+		if _, err := d.Next(); err != nil {
+			t.Fatalf("TestDecoderRaw failed at frame %d: unexpected error %v", i, err)
+		}
+		if string(d.Raw()) != expected {
+			t.Errorf("TestDecoderRaw failed at frame %d: expected %q, got %q", i, expected, d.Raw())
+		}
+	}
+}
+
+func TestDecoderHugeArrayHeader(t *testing.T) {
+	d := NewDecoder()
+	d.Feed([]byte("*536870911\r\n$3\r\nSET\r\n"))
+	if _, err := d.Next(); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("TestDecoderHugeArrayHeader expected ErrIncomplete, got %v", err)
+	}
+	if c := cap(d.arrays[0].items); c > maxPrealloc {
+		t.Errorf("TestDecoderHugeArrayHeader preallocated %d elements", c)
+	}
+}
+
+func TestDecoderResumesArrays(t *testing.T) {
+	d := NewDecoder()
+	d.Feed([]byte("*2\r\n*2\r\n:1\r\n"))
+	if _, err := d.Next(); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("TestDecoderResumesArrays expected ErrIncomplete, got %v", err)
+	}
+	// The elements decoded so far are kept instead of being scanned again
+	if len(d.arrays) != 2 || len(d.arrays[1].items) != 1 {
+		t.Fatalf("TestDecoderResumesArrays failed: partial state %+v", d.arrays)
+	}
+	if d.Buffered() != 12 {
+		t.Errorf("TestDecoderResumesArrays expected 12 buffered bytes, got %d", d.Buffered())
+	}
+
+	d.Feed([]byte(":2\r\n$1\r\na\r\n*0\r\n"))
+	result, err := d.Next()
+	if err != nil {
+		t.Fatalf("TestDecoderResumesArrays failed: unexpected error %v", err)
+	}
+	expected := []interface{}{[]interface{}{1, 2}, "a"}
+	if string(EncodeResponse(result)) != string(EncodeResponse(expected)) {
+		t.Errorf("TestDecoderResumesArrays failed: expected %v, got %v", expected, result)
+	}
+	if string(d.Raw()) != "*2\r\n*2\r\n:1\r\n:2\r\n$1\r\na\r\n" {
+		t.Errorf("TestDecoderResumesArrays failed: raw frame %q", d.Raw())
+	}
+	if result, err := d.Next(); err != nil || len(result.([]interface{})) != 0 {
+		t.Errorf("TestDecoderResumesArrays expected an empty array, got %v, %v", result, err)
+	}
+}
@@ -0,0 +1,229 @@
+package sentinel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/internal/processor"
+	"github.com/bhaski-1234/redis-db/protocol"
+)
+
+// serve answers the commands of a client until it disconnects.
+func (s *Sentinel) serve(conn net.Conn) {
+	defer conn.Close()
+	d := protocol.NewDecoder()
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			d.Feed(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+		var out []byte
+		for {
+			frame, err := d.Next()
+			if errors.Is(err, protocol.ErrIncomplete) {
+				break
+			}
+			if err != nil {
+				conn.Write(protocol.EncodeError("ERR Protocol error: " + err.Error()))
+				return
+			}
+			reply, err := s.execute(frame)
+			if err != nil {
+				out = append(out, protocol.EncodeError(err.Error())...)
+			} else {
+				out = append(out, protocol.EncodeResponse(reply)...)
+			}
+		}
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Sentinel) execute(frame interface{}) (interface{}, error) {
+	args, err := processor.Args(frame)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "PONG", nil
+	case "SENTINEL":
+		if len(args) < 2 {
+			return nil, errWrongArgs(args[0])
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.sentinel(args)
+	}
+	return nil, fmt.Errorf("ERR unknown command '%s'", args[0])
+}
+
+// sentinel implements the SENTINEL subcommands.
+func (s *Sentinel) sentinel(args []string) (interface{}, error) {
+	sub := strings.ToUpper(args[1])
+	switch sub {
+	case "MYID":
+		return protocol.BulkString(s.runID), nil
+	case "MASTERS":
+		masters := make([]interface{}, 0, len(s.masters))
+		for _, m := range s.masters {
+			masters = append(masters, s.masterFields(m))
+		}
+		return masters, nil
+	case "IS-MASTER-DOWN-BY-ADDR":
+		return s.isMasterDownByAddr(args)
+	}
+
+	if len(args) != 3 {
+		return nil, errWrongArgs("sentinel|" + strings.ToLower(sub))
+	}
+	m, ok := s.masters[args[2]]
+	if !ok {
+		if sub == "GET-MASTER-ADDR-BY-NAME" {
+			return protocol.NullArray{}, nil
+		}
+		return nil, errors.New("ERR No such master with that name")
+	}
+	switch sub {
+	case "GET-MASTER-ADDR-BY-NAME":
+		return []interface{}{m.host, strconv.Itoa(m.port)}, nil
+	case "MASTER":
+		return s.masterFields(m), nil
+	case "REPLICAS", "SLAVES":
+		replicas := make([]interface{}, 0, len(m.replicas))
+		for _, r := range m.replicas {
+			replicas = append(replicas, replicaFields(r))
+		}
+		return replicas, nil
+	case "SENTINELS":
+		sentinels := make([]interface{}, 0, len(m.sentinels))
+		for _, p := range m.sentinels {
+			sentinels = append(sentinels, []interface{}{
+				"name", p.runID,
+				"ip", p.host,
+				"port", strconv.Itoa(p.port),
+				"runid", p.runID,
+				"flags", "sentinel",
+				"last-hello-message", millisSince(p.lastHello),
+				"voted-leader", orStar(p.leader),
+				"voted-leader-epoch", strconv.FormatInt(p.leaderEpoch, 10),
+			})
+		}
+		return sentinels, nil
+	case "FAILOVER":
+		if m.failover != failoverNone {
+			return nil, errors.New("INPROG Failover already in progress")
+		}
+		if m.selectReplica(time.Now()) == nil {
+			return nil, errors.New("NOGOODSLAVE No suitable replica to promote")
+		}
+		m.force = true
+		m.tryAt = time.Time{}
+		return "OK", nil
+	}
+	return nil, fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+}
+
+// isMasterDownByAddr implements SENTINEL is-master-down-by-addr ip port
+// epoch runid, which another sentinel sends to learn whether this one
+// considers the primary at ip:port down. With a run ID instead of "*" it
+// also asks for this sentinel's vote in epoch.
+func (s *Sentinel) isMasterDownByAddr(args []string) (interface{}, error) {
+	if len(args) != 6 {
+		return nil, errWrongArgs("sentinel|is-master-down-by-addr")
+	}
+	port, err1 := strconv.Atoi(args[3])
+	epoch, err2 := strconv.ParseInt(args[4], 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, errors.New("ERR value is not an integer or out of range")
+	}
+	host, runID := resolve(args[2]), args[5]
+	down, leader, leaderEpoch := 0, "*", int64(0)
+	for _, m := range s.masters {
+		if m.host != host || m.port != port {
+			continue
+		}
+		if m.sdown {
+			down = 1
+		}
+		if runID != "*" {
+			leader, leaderEpoch = s.vote(m, epoch, runID)
+		}
+		break
+	}
+	return []interface{}{down, orStar(leader), int(leaderEpoch)}, nil
+}
+
+func (s *Sentinel) masterFields(m *master) []interface{} {
+	flags := "master"
+	if m.sdown {
+		flags += ",s_down"
+	}
+	if m.odown {
+		flags += ",o_down"
+	}
+	if m.failover != failoverNone {
+		flags += ",failover_in_progress"
+	}
+	return []interface{}{
+		"name", m.name,
+		"ip", m.host,
+		"port", strconv.Itoa(m.port),
+		"flags", flags,
+		"last-ok-ping-reply", millisSince(m.lastOK),
+		"role-reported", m.role,
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"down-after-milliseconds", strconv.FormatInt(s.downAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(s.failoverTimeout.Milliseconds(), 10),
+	}
+}
+
+func replicaFields(r *instance) []interface{} {
+	flags := "slave"
+	if r.sdown {
+		flags += ",s_down"
+	}
+	link := "down"
+	if r.masterLinkUp {
+		link = "up"
+	}
+	return []interface{}{
+		"name", r.addr(),
+		"ip", r.host,
+		"port", strconv.Itoa(r.port),
+		"flags", flags,
+		"last-ok-ping-reply", millisSince(r.lastOK),
+		"role-reported", r.role,
+		"master-host", r.masterHost,
+		"master-port", strconv.Itoa(r.masterPort),
+		"master-link-status", link,
+		"slave-repl-offset", strconv.FormatInt(r.offset, 10),
+	}
+}
+
+func millisSince(t time.Time) string {
+	return strconv.FormatInt(time.Since(t).Milliseconds(), 10)
+}
+
+func orStar(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
+func errWrongArgs(cmd string) error {
+	return errors.New("ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command")
+}
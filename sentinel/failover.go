@@ -0,0 +1,284 @@
+package sentinel
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type failoverState int
+
+const (
+	failoverNone failoverState = iota
+	// failoverElecting waits for the votes of the other sentinels.
+	failoverElecting
+	// failoverPromoting runs while the chosen replica is promoted.
+	failoverPromoting
+)
+
+// checkSubjectiveDown marks i down when it did not answer a ping for
+// down-after, and up again when it does.
+func (s *Sentinel) checkSubjectiveDown(m *master, i *instance, now time.Time) {
+	down := now.Sub(i.lastOK) > s.downAfter
+	if down == i.sdown {
+		return
+	}
+	i.sdown = down
+	if down {
+		s.event("+sdown", m, i, "")
+	} else {
+		s.event("-sdown", m, i, "")
+	}
+}
+
+// askSentinels asks every other sentinel whether it considers m down. While
+// this sentinel runs for leader, the question also asks for the vote.
+func (s *Sentinel) askSentinels(m *master, now time.Time) {
+	runID, epoch := "*", s.epoch
+	if m.failover == failoverElecting {
+		runID, epoch = s.runID, m.failoverEpoch
+	}
+	args := []string{"SENTINEL", "is-master-down-by-addr", m.host, strconv.Itoa(m.port), strconv.FormatInt(epoch, 10), runID}
+	for _, p := range m.sentinels {
+		if p.asking || now.Sub(p.lastAsk) < askPeriod {
+			continue
+		}
+		p.asking, p.lastAsk = true, now
+		go s.ask(p, args)
+	}
+}
+
+// ask sends an is-master-down-by-addr question to p. The reply is
+// [down, leader run ID or "*", leader epoch].
+func (s *Sentinel) ask(p *peer, args []string) {
+	reply, err := p.link.do(args...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.asking = false
+	r, ok := reply.([]interface{})
+	if err != nil || !ok || len(r) != 3 {
+		return
+	}
+	down, _ := r[0].(int)
+	leader, _ := r[1].(string)
+	leaderEpoch, _ := r[2].(int)
+	p.masterDown = down == 1
+	p.replyTime = time.Now()
+	if leader != "*" {
+		p.leader, p.leaderEpoch = leader, int64(leaderEpoch)
+	}
+}
+
+// checkObjectiveDown marks m down once a quorum of sentinels, this one
+// included, considers it down.
+func (s *Sentinel) checkObjectiveDown(m *master, now time.Time) {
+	agreed := 0
+	if m.sdown {
+		agreed++
+		for _, p := range m.sentinels {
+			if p.masterDown && now.Sub(p.replyTime) < askValidity {
+				agreed++
+			}
+		}
+	}
+	down := agreed >= m.quorum
+	if down == m.odown {
+		return
+	}
+	m.odown = down
+	if down {
+		s.event("+odown", m, m.instance, "#quorum %d/%d", agreed, m.quorum)
+		m.tryAt = maxTime(m.tryAt, now.Add(time.Duration(rand.Int63n(int64(maxDesync)))))
+	} else {
+		s.event("-odown", m, m.instance, "")
+	}
+}
+
+// vote gives this sentinel's vote in epoch to runID, unless it already
+// voted in that epoch. It returns the sentinel voted for.
+func (s *Sentinel) vote(m *master, epoch int64, runID string) (string, int64) {
+	if epoch > s.epoch {
+		s.epoch = epoch
+		fmt.Printf("+new-epoch %d\n", epoch)
+	}
+	if m.leaderEpoch < epoch && s.epoch <= epoch {
+		m.leader, m.leaderEpoch = runID, s.epoch
+		fmt.Printf("+vote-for-leader %s %d\n", runID, s.epoch)
+		// Leave the failover to the sentinel voted for
+		if runID != s.runID {
+			m.tryAt = time.Now().Add(2*s.failoverTimeout + time.Duration(rand.Int63n(int64(maxDesync))))
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// advanceFailover starts a failover of a primary that is objectively down
+// and, once this sentinel is elected leader, promotes a replica.
+func (s *Sentinel) advanceFailover(m *master, now time.Time) {
+	switch m.failover {
+	case failoverNone:
+		if !(m.odown || m.force) || now.Before(m.tryAt) {
+			return
+		}
+		s.epoch++
+		m.failover, m.failoverEpoch, m.failoverStart = failoverElecting, s.epoch, now
+		m.tryAt = now.Add(2*s.failoverTimeout + time.Duration(rand.Int63n(int64(maxDesync))))
+		s.event("+try-failover", m, m.instance, "epoch %d", s.epoch)
+		s.vote(m, s.epoch, s.runID)
+		for _, p := range m.sentinels {
+			p.lastAsk = time.Time{}
+		}
+
+	case failoverElecting:
+		if !m.odown && !m.force {
+			s.event("-failover-abort-master-back", m, m.instance, "")
+			m.failover = failoverNone
+			return
+		}
+		votes, needed := s.votes(m), max(m.quorum, (len(m.sentinels)+1)/2+1)
+		if !m.force && votes < needed {
+			if now.Sub(m.failoverStart) > min(s.failoverTimeout, 10*time.Second) {
+				s.event("-failover-abort-not-elected", m, m.instance, "%d/%d votes", votes, needed)
+				m.failover = failoverNone
+			}
+			return
+		}
+		r := m.selectReplica(now)
+		if r == nil {
+			s.event("-failover-abort-no-good-slave", m, m.instance, "")
+			m.failover, m.force = failoverNone, false
+			return
+		}
+		s.event("+elected-leader", m, m.instance, "epoch %d", m.failoverEpoch)
+		s.event("+selected-slave", m, r, "@ %s %s %d", m.name, m.host, m.port)
+		m.failover = failoverPromoting
+		var others []string
+		for _, o := range m.replicas {
+			if o != r && !o.sdown {
+				others = append(others, o.addr())
+			}
+		}
+		go s.promote(m, r, others, m.failoverEpoch)
+	}
+}
+
+// votes counts the votes for this sentinel in the current failover.
+func (s *Sentinel) votes(m *master) int {
+	votes := 0
+	if m.leader == s.runID && m.leaderEpoch == m.failoverEpoch {
+		votes++
+	}
+	for _, p := range m.sentinels {
+		if p.leader == s.runID && p.leaderEpoch == m.failoverEpoch {
+			votes++
+		}
+	}
+	return votes
+}
+
+// selectReplica picks the replica to promote: among those that answer and
+// report recently, the one that received most of the primary's stream.
+func (m *master) selectReplica(now time.Time) *instance {
+	var candidates []*instance
+	for _, r := range m.replicas {
+		if r.sdown || r.role != "slave" || now.Sub(r.infoTime) > 5*probePeriod {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		if candidates[a].offset != candidates[b].offset {
+			return candidates[a].offset > candidates[b].offset
+		}
+		return candidates[a].addr() < candidates[b].addr()
+	})
+	return candidates[0]
+}
+
+// promote turns r into the primary and repoints the other replicas to it.
+// It runs outside the lock, as it waits on the network.
+func (s *Sentinel) promote(m *master, r *instance, others []string, epoch int64) {
+	_, err := command(r.addr(), "REPLICAOF", "NO", "ONE")
+	if err == nil {
+		for _, addr := range others {
+			if _, err := command(addr, "REPLICAOF", r.host, strconv.Itoa(r.port)); err != nil {
+				fmt.Printf("Error reconfiguring replica %s: %v\n", addr, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.failover, m.force = failoverNone, false
+	if err != nil {
+		s.event("-failover-abort-slave-timeout", m, r, "%v", err)
+		return
+	}
+	// A hello may have announced a newer failover meanwhile
+	if epoch <= m.configEpoch {
+		return
+	}
+	s.event("+promoted-slave", m, r, "@ %s %s %d", m.name, m.host, m.port)
+	m.configEpoch = epoch
+	s.switchMaster(m, r.host, r.port)
+}
+
+// switchMaster makes host:port the primary of m. The old primary is kept
+// as a replica, so it is reconfigured when it comes back.
+func (s *Sentinel) switchMaster(m *master, host string, port int) {
+	old := m.instance
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	fmt.Printf("+switch-master %s %s %d %s %d\n", m.name, old.host, old.port, host, port)
+	next, ok := m.replicas[addr]
+	if !ok {
+		next = newInstance(host, port)
+	}
+	delete(m.replicas, addr)
+	m.replicas[old.addr()] = old
+	m.instance = next
+	m.odown = false
+	if m.failover == failoverElecting {
+		m.failover = failoverNone
+	}
+	for _, p := range m.sentinels {
+		p.masterDown = false
+	}
+}
+
+// reconfigureReplicas points the replicas of m that follow another server,
+// or act as primaries themselves, at m. It waits until m itself looks
+// healthy, and until the instance reported the same role and primary for
+// reconfigureDelay, so that a failover still in progress is not undone.
+func (s *Sentinel) reconfigureReplicas(m *master, now time.Time) {
+	if m.sdown || m.role != "master" || m.failover != failoverNone || now.Sub(m.infoTime) > 3*probePeriod {
+		return
+	}
+	for _, r := range m.replicas {
+		if r.sdown || now.Sub(r.infoTime) > 3*probePeriod || now.Sub(r.reportedSince) < reconfigureDelay || now.Sub(r.lastReconfig) < reconfigureDelay {
+			continue
+		}
+		if r.role == "slave" && r.masterHost == m.host && r.masterPort == m.port {
+			continue
+		}
+		r.lastReconfig = now
+		s.event("+fix-slave-config", m, r, "@ %s %s %d", m.name, m.host, m.port)
+		go func(addr, host string, port int) {
+			if _, err := command(addr, "REPLICAOF", host, strconv.Itoa(port)); err != nil {
+				fmt.Printf("Error reconfiguring replica %s: %v\n", addr, err)
+			}
+		}(r.addr(), m.host, m.port)
+	}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
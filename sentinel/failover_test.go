@@ -0,0 +1,96 @@
+package sentinel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectReplica(t *testing.T) {
+	now := time.Now()
+	replica := func(host string, offset int64) *instance {
+		r := newInstance(host, 6379)
+		r.role, r.offset, r.infoTime = "slave", offset, now
+		return r
+	}
+	m := &master{instance: &instance{}, replicas: map[string]*instance{}}
+	add := func(r *instance) *instance {
+		m.replicas[r.addr()] = r
+		return r
+	}
+
+	if m.selectReplica(now) != nil {
+		t.Errorf("TestSelectReplica failed: selected a replica among none")
+	}
+
+	behind := add(replica("10.0.0.1", 100))
+	down := add(replica("10.0.0.2", 900))
+	down.sdown = true
+	stale := add(replica("10.0.0.3", 900))
+	stale.infoTime = now.Add(-10 * probePeriod)
+	promoted := add(replica("10.0.0.4", 900))
+	promoted.role = "master"
+	if got := m.selectReplica(now); got != behind {
+		t.Fatalf("TestSelectReplica failed: selected %v, want the only healthy replica", got)
+	}
+
+	// The replica with the greatest offset wins, ties go to the lower address
+	ahead := add(replica("10.0.0.6", 500))
+	tied := add(replica("10.0.0.5", 500))
+	if got := m.selectReplica(now); got != tied {
+		t.Errorf("TestSelectReplica failed: selected %s, want %s", got.addr(), tied.addr())
+	}
+	ahead.offset = 501
+	if got := m.selectReplica(now); got != ahead {
+		t.Errorf("TestSelectReplica failed: selected %s, want %s", got.addr(), ahead.addr())
+	}
+}
+
+func TestVote(t *testing.T) {
+	s := &Sentinel{runID: "self", epoch: 5, failoverTimeout: time.Minute}
+	m := &master{instance: &instance{}}
+
+	tests := []struct {
+		epoch      int64
+		runID      string
+		wantLeader string
+		wantEpoch  int64
+	}{
+		{6, "self", "self", 6},
+		// One vote per epoch
+		{6, "other", "self", 6},
+		// Requests for an older epoch get the current vote
+		{4, "other", "self", 6},
+		{8, "other", "other", 8},
+	}
+	for _, tt := range tests {
+		leader, epoch := s.vote(m, tt.epoch, tt.runID)
+		if leader != tt.wantLeader || epoch != tt.wantEpoch {
+			t.Errorf("TestVote failed: vote(%d, %s) = %s, %d, want %s, %d", tt.epoch, tt.runID, leader, epoch, tt.wantLeader, tt.wantEpoch)
+		}
+	}
+	if s.epoch != 8 {
+		t.Errorf("TestVote failed: current epoch is %d, want 8", s.epoch)
+	}
+	// Voting for another sentinel holds back this one's own failover
+	if !m.tryAt.After(time.Now().Add(time.Minute)) {
+		t.Errorf("TestVote failed: failover attempt not delayed after voting for another sentinel")
+	}
+}
+
+func TestVotes(t *testing.T) {
+	s := &Sentinel{runID: "self"}
+	m := &master{
+		instance:      &instance{},
+		failoverEpoch: 3,
+		leader:        "self",
+		leaderEpoch:   3,
+		sentinels: map[string]*peer{
+			"a": {leader: "self", leaderEpoch: 3},
+			"b": {leader: "self", leaderEpoch: 2},
+			"c": {leader: "a", leaderEpoch: 3},
+		},
+	}
+	if n := s.votes(m); n != 2 {
+		t.Errorf("TestVotes failed: counted %d votes, want 2", n)
+	}
+}
@@ -0,0 +1,131 @@
+package sentinel
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+)
+
+// linkTimeout bounds every request to a monitored server or another
+// sentinel, so one that hangs can not hold up the others.
+const linkTimeout = time.Second
+
+// replyError is an error reply; the link stays usable after one.
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+// link is a connection to a server or sentinel, dialed on first use and
+// redialed after a failure. Only one goroutine uses a link at a time.
+type link struct {
+	addr    string
+	conn    net.Conn
+	decoder *protocol.Decoder
+}
+
+// do sends a command and returns its reply.
+func (l *link) do(args ...string) (interface{}, error) {
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", l.addr, linkTimeout)
+		if err != nil {
+			return nil, err
+		}
+		l.conn, l.decoder = conn, protocol.NewDecoder()
+	}
+	l.conn.SetDeadline(time.Now().Add(linkTimeout))
+	reply, err := roundTrip(l.conn, l.decoder, args)
+	var replyErr replyError
+	if err != nil && !errors.As(err, &replyErr) {
+		l.close()
+	}
+	return reply, err
+}
+
+func (l *link) close() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+// command sends a single command over a connection of its own, for the
+// commands of a failover that must not wait behind a link's pings.
+func command(addr string, args ...string) (interface{}, error) {
+	l := link{addr: addr}
+	defer l.close()
+	return l.do(args...)
+}
+
+func roundTrip(conn net.Conn, d *protocol.Decoder, args []string) (interface{}, error) {
+	if _, err := conn.Write(encodeCommand(args)); err != nil {
+		return nil, err
+	}
+	return readFrame(conn, d)
+}
+
+func encodeCommand(args []string) []byte {
+	parts := make([]interface{}, len(args))
+	for i, arg := range args {
+		parts[i] = arg
+	}
+	return protocol.EncodeArray(parts)
+}
+
+// readFrame reads the next frame from conn. An error reply is returned as
+// a replyError.
+func readFrame(conn net.Conn, d *protocol.Decoder) (interface{}, error) {
+	buf := make([]byte, 4096)
+	for {
+		frame, err := d.Next()
+		if err == nil {
+			if d.Raw()[0] == '-' {
+				return nil, replyError(frame.(string))
+			}
+			return frame, nil
+		}
+		if !errors.Is(err, protocol.ErrIncomplete) {
+			return nil, err
+		}
+		n, err := conn.Read(buf)
+		if n > 0 {
+			d.Feed(buf[:n])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// subscribe passes the hello messages published on the server at addr to
+// receive until the connection fails. Sentinels publish hellos every
+// helloPeriod, so a connection that stays silent much longer is dead.
+func subscribe(addr string, receive func(payload string)) error {
+	conn, err := net.DialTimeout("tcp", addr, linkTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	d := protocol.NewDecoder()
+	conn.SetDeadline(time.Now().Add(linkTimeout))
+	if _, err := roundTrip(conn, d, []string{"SUBSCRIBE", helloChannel}); err != nil {
+		return err
+	}
+	for {
+		conn.SetDeadline(time.Now().Add(5 * helloPeriod))
+		frame, err := readFrame(conn, d)
+		if err != nil {
+			return err
+		}
+		msg, ok := frame.([]interface{})
+		if !ok || len(msg) != 3 || msg[0] != "message" {
+			continue
+		}
+		if payload, ok := msg[2].(string); ok {
+			receive(payload)
+		}
+	}
+}
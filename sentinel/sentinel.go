@@ -0,0 +1,429 @@
+// Package sentinel implements sentinel mode: the process watches primaries
+// and their replicas, agrees with other sentinels when a primary is down
+// and promotes one of its replicas in its place.
+package sentinel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/config"
+)
+
+const (
+	// tickPeriod is how often the state of every primary is evaluated.
+	tickPeriod = 100 * time.Millisecond
+	// probePeriod is how often instances are pinged and their INFO read.
+	probePeriod = time.Second
+	// helloPeriod is how often a sentinel announces itself and its view
+	// of a primary on every instance.
+	helloPeriod = 2 * time.Second
+	// askPeriod is how often other sentinels are asked whether a primary
+	// they all consider down is down, and for their vote.
+	askPeriod = time.Second
+	// askValidity is how long the answer of another sentinel counts.
+	askValidity = 5 * time.Second
+	// maxDesync spreads the failover attempts of sentinels that see a
+	// primary fail at the same time, so that one of them wins the vote.
+	maxDesync = time.Second
+	// reconfigureDelay is how long an instance must report a role that
+	// contradicts the configuration before it is corrected, to give hellos
+	// about a failover time to arrive.
+	reconfigureDelay = 4 * helloPeriod
+)
+
+// helloChannel is the channel sentinels announce themselves on.
+const helloChannel = "__sentinel__:hello"
+
+// Sentinel is the state of a sentinel. It is guarded by mu; the network
+// I/O runs in goroutines that take mu to apply their results.
+type Sentinel struct {
+	mu    sync.Mutex
+	runID string
+	host  string
+	port  int
+	// epoch is the current epoch; every failover attempt starts a new one.
+	epoch   int64
+	masters map[string]*master
+
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+}
+
+// master is a monitored primary with its replicas and the other sentinels
+// watching it.
+type master struct {
+	name   string
+	quorum int
+	*instance
+	// configEpoch is the epoch of the failover that made the instance the
+	// primary; a hello with a newer one announces a failover.
+	configEpoch int64
+	replicas    map[string]*instance
+	sentinels   map[string]*peer
+	odown       bool
+	// leader is the sentinel this one voted for in leaderEpoch.
+	leader      string
+	leaderEpoch int64
+
+	failover      failoverState
+	force         bool // failover asked for by SENTINEL FAILOVER
+	failoverEpoch int64
+	failoverStart time.Time
+	// tryAt is the earliest time for the next failover attempt.
+	tryAt time.Time
+}
+
+// instance is a monitored server, a primary or a replica.
+type instance struct {
+	host string
+	port int
+	link link
+	// probing and subscribed are set while a goroutine uses link, or
+	// receives hellos from the instance.
+	probing    bool
+	subscribed bool
+	lastProbe  time.Time
+	lastHello  time.Time
+	lastOK     time.Time
+	sdown      bool
+
+	// What the instance last reported in INFO
+	infoTime     time.Time
+	role         string
+	masterHost   string
+	masterPort   int
+	masterLinkUp bool
+	offset       int64
+	// reportedSince is when the role or the primary reported last changed.
+	reportedSince time.Time
+	lastReconfig  time.Time
+}
+
+// peer is another sentinel watching the same primary.
+type peer struct {
+	runID     string
+	host      string
+	port      int
+	link      link
+	asking    bool
+	lastAsk   time.Time
+	lastHello time.Time
+	// The answer to the last is-master-down-by-addr
+	masterDown  bool
+	replyTime   time.Time
+	leader      string
+	leaderEpoch int64
+}
+
+// New returns a sentinel monitoring the configured primaries.
+func New() *Sentinel {
+	s := &Sentinel{
+		runID:           newRunID(),
+		host:            resolve(config.Host),
+		port:            config.Port,
+		masters:         make(map[string]*master),
+		downAfter:       time.Duration(config.SentinelDownAfter) * time.Millisecond,
+		failoverTimeout: time.Duration(config.SentinelFailoverTimeout) * time.Millisecond,
+	}
+	for _, m := range config.SentinelMonitors {
+		s.masters[m.Name] = &master{
+			name:      m.Name,
+			quorum:    m.Quorum,
+			instance:  newInstance(resolve(m.Host), m.Port),
+			replicas:  make(map[string]*instance),
+			sentinels: make(map[string]*peer),
+		}
+	}
+	return s
+}
+
+func newRunID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// resolve returns the IP address of host, so that sentinels configured
+// with different names for an instance agree on its address.
+func resolve(host string) string {
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return host
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String()
+		}
+	}
+	return ips[0].String()
+}
+
+func newInstance(host string, port int) *instance {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	// A new instance gets a full down-after period to answer
+	return &instance{host: host, port: port, link: link{addr: addr}, lastOK: time.Now()}
+}
+
+func (i *instance) addr() string {
+	return i.link.addr
+}
+
+// Start serves clients and monitors the primaries until the process exits.
+func (s *Sentinel) Start() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", config.Host, config.Port))
+	if err != nil {
+		return fmt.Errorf("failed to start listener: %w", err)
+	}
+	fmt.Printf("Sentinel %s is running on %s:%d\n", s.runID, config.Host, config.Port)
+	for _, m := range s.masters {
+		s.event("+monitor", m, m.instance, "quorum %d", m.quorum)
+	}
+
+	go func() {
+		for now := range time.Tick(tickPeriod) {
+			s.mu.Lock()
+			s.tick(now)
+			s.mu.Unlock()
+		}
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// event logs a change of state, in the "+sdown master mymaster 127.0.0.1
+// 6379" form of Redis sentinel events.
+func (s *Sentinel) event(name string, m *master, i *instance, format string, args ...interface{}) {
+	kind := "slave"
+	if i == m.instance {
+		kind = "master"
+	}
+	msg := fmt.Sprintf("%s %s %s %s %d", name, kind, m.name, i.host, i.port)
+	if format != "" {
+		msg += " " + fmt.Sprintf(format, args...)
+	}
+	fmt.Println(msg)
+}
+
+// tick starts the periodic I/O of every primary and advances its failure
+// detection and failover.
+func (s *Sentinel) tick(now time.Time) {
+	for _, m := range s.masters {
+		for _, i := range m.instances() {
+			if !i.probing && now.Sub(i.lastProbe) >= probePeriod {
+				i.probing, i.lastProbe = true, now
+				hello := ""
+				if now.Sub(i.lastHello) >= helloPeriod {
+					i.lastHello = now
+					hello = s.hello(m)
+				}
+				go s.probe(m, i, hello)
+			}
+			if !i.subscribed {
+				i.subscribed = true
+				go s.subscribe(i)
+			}
+			s.checkSubjectiveDown(m, i, now)
+		}
+		if m.sdown || m.failover != failoverNone {
+			s.askSentinels(m, now)
+		}
+		s.checkObjectiveDown(m, now)
+		s.advanceFailover(m, now)
+		s.reconfigureReplicas(m, now)
+	}
+}
+
+// instances returns the primary followed by its replicas.
+func (m *master) instances() []*instance {
+	instances := []*instance{m.instance}
+	for _, r := range m.replicas {
+		instances = append(instances, r)
+	}
+	return instances
+}
+
+// info is what probe learns about an instance.
+type info struct {
+	fields   map[string]string
+	replicas []string // host:port of the replicas a primary lists
+}
+
+// probe pings i, reads its INFO and publishes hello on it if set.
+func (s *Sentinel) probe(m *master, i *instance, hello string) {
+	reply, err := i.link.do("PING")
+	// A busy or loading server is still alive
+	ok := reply == "PONG" || (err != nil && (strings.HasPrefix(err.Error(), "LOADING") || strings.HasPrefix(err.Error(), "MASTERDOWN")))
+	var in *info
+	if reply == "PONG" {
+		if reply, err := i.link.do("INFO", "replication"); err == nil {
+			if text, isText := reply.(string); isText {
+				in = parseInfo(text)
+			}
+		}
+		if hello != "" {
+			i.link.do("PUBLISH", helloChannel, hello)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i.probing = false
+	now := time.Now()
+	if ok {
+		i.lastOK = now
+	}
+	if in != nil {
+		s.applyInfo(m, i, in, now)
+	}
+}
+
+// parseInfo parses the replication section of INFO. Replicas are listed
+// as "slave0:ip=127.0.0.1,port=6380,...".
+func parseInfo(text string) *info {
+	in := &info{fields: make(map[string]string)}
+	for _, line := range strings.Split(text, "\r\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(key, "slave") && strings.Contains(value, "ip=") {
+			var host, port string
+			for _, field := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(field, "=")
+				switch k {
+				case "ip":
+					host = v
+				case "port":
+					port = v
+				}
+			}
+			if host != "" && port != "" && port != "0" {
+				in.replicas = append(in.replicas, net.JoinHostPort(host, port))
+			}
+			continue
+		}
+		in.fields[key] = value
+	}
+	// Compare the primary of a replica by address, whatever name it was
+	// given
+	if host := in.fields["master_host"]; host != "" {
+		in.fields["master_host"] = resolve(host)
+	}
+	return in
+}
+
+func (s *Sentinel) applyInfo(m *master, i *instance, in *info, now time.Time) {
+	i.infoTime = now
+	role := in.fields["role"]
+	if role != i.role && i.role != "" {
+		s.event("+role-change", m, i, "new reported role is %s", role)
+	}
+	masterHost, masterPort := "", 0
+	if role == "slave" {
+		masterHost = in.fields["master_host"]
+		masterPort, _ = strconv.Atoi(in.fields["master_port"])
+		i.masterLinkUp = in.fields["master_link_status"] == "up"
+		i.offset, _ = strconv.ParseInt(in.fields["slave_repl_offset"], 10, 64)
+	} else {
+		i.offset, _ = strconv.ParseInt(in.fields["master_repl_offset"], 10, 64)
+	}
+	if role != i.role || masterHost != i.masterHost || masterPort != i.masterPort {
+		i.role, i.masterHost, i.masterPort = role, masterHost, masterPort
+		i.reportedSince = now
+	}
+
+	// The primary lists its replicas
+	if i == m.instance && role == "master" {
+		for _, addr := range in.replicas {
+			if _, ok := m.replicas[addr]; ok || addr == m.addr() {
+				continue
+			}
+			host, port, _ := net.SplitHostPort(addr)
+			p, _ := strconv.Atoi(port)
+			r := newInstance(host, p)
+			m.replicas[addr] = r
+			s.event("+slave", m, r, "@ %s %s %d", m.name, m.host, m.port)
+		}
+	}
+}
+
+// subscribe receives hellos from i for as long as it is monitored.
+func (s *Sentinel) subscribe(i *instance) {
+	err := subscribe(i.addr(), s.receiveHello)
+	if err != nil {
+		// Wait before redialing an instance that is down
+		time.Sleep(probePeriod)
+	}
+	s.mu.Lock()
+	i.subscribed = false
+	s.mu.Unlock()
+}
+
+// hello announces this sentinel and its view of m as
+// "ip,port,runid,epoch,name,master-ip,master-port,config-epoch".
+func (s *Sentinel) hello(m *master) string {
+	return fmt.Sprintf("%s,%d,%s,%d,%s,%s,%d,%d", s.host, s.port, s.runID, s.epoch, m.name, m.host, m.port, m.configEpoch)
+}
+
+// receiveHello records the sentinel a hello announces, and adopts the
+// primary it names if it comes from a newer failover.
+func (s *Sentinel) receiveHello(payload string) {
+	fields := strings.Split(payload, ",")
+	if len(fields) != 8 || fields[2] == s.runID {
+		return
+	}
+	port, err1 := strconv.Atoi(fields[1])
+	epoch, err2 := strconv.ParseInt(fields[3], 10, 64)
+	masterPort, err3 := strconv.Atoi(fields[6])
+	configEpoch, err4 := strconv.ParseInt(fields[7], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.masters[fields[4]]
+	if !ok {
+		return
+	}
+	if epoch > s.epoch {
+		s.epoch = epoch
+	}
+	host, runID := fields[0], fields[2]
+	p, ok := m.sentinels[runID]
+	if !ok {
+		// A sentinel that restarted comes back with a new run ID
+		for id, old := range m.sentinels {
+			if old.host == host && old.port == port {
+				if !old.asking {
+					old.link.close()
+				}
+				delete(m.sentinels, id)
+			}
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		p = &peer{runID: runID, host: host, port: port, link: link{addr: addr}}
+		m.sentinels[runID] = p
+		fmt.Printf("+sentinel sentinel %s %s %d @ %s %s %d\n", runID, host, port, m.name, m.host, m.port)
+	}
+	p.lastHello = time.Now()
+
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if fields[5] != m.host || masterPort != m.port {
+			s.switchMaster(m, fields[5], masterPort)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+// backlog keeps the latest bytes of the replication stream in a ring, so a
+// replica whose link broke can continue from its offset instead of loading
+// a new snapshot.
+type backlog struct {
+	buf []byte
+	// start and end are the stream offsets of the first byte held and of
+	// the byte after the last.
+	start int64
+	end   int64
+}
+
+// newBacklog returns an empty backlog of size bytes that continues the
+// stream at offset.
+func newBacklog(size int, offset int64) *backlog {
+	return &backlog{buf: make([]byte, size), start: offset, end: offset}
+}
+
+func (b *backlog) write(data []byte) {
+	size := int64(len(b.buf))
+	if int64(len(data)) > size {
+		b.end += int64(len(data)) - size
+		data = data[int64(len(data))-size:]
+	}
+	for len(data) > 0 {
+		n := copy(b.buf[b.end%size:], data)
+		data = data[n:]
+		b.end += int64(n)
+	}
+	if b.end-b.start > size {
+		b.start = b.end - size
+	}
+}
+
+// since returns the bytes from offset to the end of the stream, and false
+// when the backlog no longer holds them.
+func (b *backlog) since(offset int64) ([]byte, bool) {
+	if offset < b.start || offset > b.end {
+		return nil, false
+	}
+	size := int64(len(b.buf))
+	data := make([]byte, 0, b.end-offset)
+	for offset < b.end {
+		i := offset % size
+		n := min(size-i, b.end-offset)
+		data = append(data, b.buf[i:i+n]...)
+		offset += n
+	}
+	return data, true
+}
@@ -0,0 +1,77 @@
+package server
+
+import "testing"
+
+func TestBacklogWraparound(t *testing.T) {
+	b := newBacklog(8, 100)
+	if data, ok := b.since(100); !ok || len(data) != 0 {
+		t.Errorf("TestBacklogWraparound failed: empty backlog gave %q, %v", data, ok)
+	}
+
+	b.write([]byte("abcde"))
+	if data, ok := b.since(100); !ok || string(data) != "abcde" {
+		t.Errorf("TestBacklogWraparound failed: got %q, %v, want abcde", data, ok)
+	}
+
+	// The second write wraps around the end of the ring
+	b.write([]byte("fghij"))
+	if b.start != 102 || b.end != 110 {
+		t.Errorf("TestBacklogWraparound failed: holds offsets %d to %d, want 102 to 110", b.start, b.end)
+	}
+	tests := []struct {
+		offset int64
+		want   string
+		ok     bool
+	}{
+		{101, "", false},
+		{102, "cdefghij", true},
+		{107, "hij", true},
+		{110, "", true},
+		{111, "", false},
+	}
+	for _, tt := range tests {
+		data, ok := b.since(tt.offset)
+		if ok != tt.ok || string(data) != tt.want {
+			t.Errorf("TestBacklogWraparound failed: since(%d) = %q, %v, want %q, %v", tt.offset, data, ok, tt.want, tt.ok)
+		}
+	}
+
+	// A write larger than the ring keeps its tail
+	b.write([]byte("0123456789ABCDEFGHIJ"))
+	if data, ok := b.since(122); !ok || string(data) != "CDEFGHIJ" {
+		t.Errorf("TestBacklogWraparound failed: after a large write got %q, %v", data, ok)
+	}
+	if _, ok := b.since(121); ok {
+		t.Errorf("TestBacklogWraparound failed: overwritten offset 121 still served")
+	}
+}
+
+func TestContinueStream(t *testing.T) {
+	s := &Server{}
+	s.repl.id, s.repl.id2, s.repl.id2End = "current", "previous", 104
+	s.repl.backlog = newBacklog(16, 100)
+	s.repl.backlog.write([]byte("0123456789"))
+
+	// Offsets in PSYNC count from 1, those of the backlog from 0
+	tests := []struct {
+		id, offset string
+		want       string
+		ok         bool
+	}{
+		{"current", "101", "0123456789", true},
+		{"current", "106", "56789", true},
+		{"current", "111", "", true},
+		{"current", "112", "", false},
+		{"current", "100", "", false},
+		{"previous", "105", "456789", true},
+		{"previous", "106", "", false},
+		{"other", "101", "", false},
+		{"current", "x", "", false},
+	}
+	for _, tt := range tests {
+		data, ok := s.continueStream(tt.id, tt.offset)
+		if ok != tt.ok || string(data) != tt.want {
+			t.Errorf("TestContinueStream failed: continueStream(%s, %s) = %q, %v, want %q, %v", tt.id, tt.offset, data, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/internal/processor"
+	"github.com/bhaski-1234/redis-db/protocol"
+)
+
+// client holds the per-connection state kept by the event loop.
+type client struct {
+	fd      int
+	conn    net.Conn
+	file    *os.File // duplicate descriptor registered with epoll
+	decoder *protocol.Decoder
+	session *processor.Session
+
+	// out holds output the socket did not accept yet; writable is set
+	// while epoll watches the socket for room to write it.
+	out      []byte
+	writable bool
+	closed   bool
+	// processing is set while the client's commands run; their replies
+	// and what is pushed meanwhile collect in out, in order, until the
+	// batch is done.
+	processing bool
+
+	// master marks the link to the primary on a replica; replica marks a
+	// replica connected to this server, listening on replicaPort.
+	master      bool
+	replica     bool
+	replicaPort int
+	// ackOffset is the offset a replica last acknowledged, at ackTime.
+	ackOffset int64
+	ackTime   time.Time
+
+	// woff is the replication offset after the client's last command, the
+	// offset WAIT waits for; blocked is set while it waits.
+	woff    int64
+	blocked bool
+}
+
+func newClient(fd int, conn net.Conn, file *os.File) *client {
+	return &client{
+		fd:      fd,
+		conn:    conn,
+		file:    file,
+		decoder: protocol.NewDecoder(),
+	}
+}
+
+func (c *client) close() {
+	c.session.Close()
+	c.file.Close()
+	c.conn.Close()
+}
@@ -0,0 +1,619 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/internal/cluster"
+	"github.com/bhaski-1234/redis-db/internal/processor"
+	"github.com/bhaski-1234/redis-db/protocol"
+	diskstorage "github.com/bhaski-1234/redis-db/storage/diskStorage"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+const (
+	// replPingPeriod is how often a primary pings its replicas, so they
+	// can tell an idle link from a dead one.
+	replPingPeriod = 10 * time.Second
+	// replTimeout is how long a replica waits for its primary, both while
+	// syncing and on an established link, before giving up on it.
+	replTimeout = 60 * time.Second
+	// replRetryDelay is the pause between attempts to reach the primary.
+	replRetryDelay = time.Second
+)
+
+// replState is the replication state of the server. It is only used on the
+// event loop; the goroutine syncing with a primary hands its result over
+// through syncs.
+type replState struct {
+	id string
+	// offset counts the bytes of the replication stream produced by a
+	// primary, or applied by a replica.
+	offset int64
+	// id2 is the ID this server had before it was promoted; replicas of its
+	// old primary may continue under it up to offset id2End.
+	id2      string
+	id2End   int64
+	backlog  *backlog
+	replicas map[*client]struct{}
+	lastPing time.Time
+	// waiting holds the clients blocked in WAIT and unblocked those
+	// answered since, whose buffered commands are still to run. getAck is
+	// set when the replicas must be asked for their offsets.
+	waiting   []*waiter
+	unblocked []*client
+	getAck    bool
+
+	// masterHost and masterPort are set on a replica.
+	masterHost string
+	masterPort int
+	// master is the link to the primary once the full sync is loaded.
+	master  *client
+	lastIO  time.Time
+	lastAck time.Time
+	// syncing is set while a sync goroutine runs; gen is bumped by every
+	// REPLICAOF so a sync for an earlier primary is dropped.
+	syncing     bool
+	gen         int
+	lastAttempt time.Time
+	syncs       chan *masterSync
+}
+
+// masterSync is the outcome of a sync with a primary. A partial sync
+// continues the stream at the replica's offset and has no payload.
+type masterSync struct {
+	gen     int
+	conn    net.Conn
+	id      string
+	offset  int64
+	partial bool
+	payload []byte
+	// rest holds stream bytes read past the snapshot.
+	rest []byte
+	err  error
+}
+
+func newReplState() replState {
+	return replState{
+		id:       newReplID(),
+		replicas: make(map[*client]struct{}),
+		syncs:    make(chan *masterSync, 1),
+	}
+}
+
+// newReplID returns a random 40 character replication ID.
+func newReplID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// serverCommands act on the connection or on replication, so the server
+// runs them for the sessions of its clients. Those that turn a connection
+// into a replication link can not be queued in a transaction.
+var serverCommands = map[string]struct {
+	run       func(s *Server, c *client, args []string) (interface{}, error)
+	queueable bool
+}{
+	"REPLICAOF": {(*Server).replicaOf, true},
+	"SLAVEOF":   {(*Server).replicaOf, true},
+	"REPLCONF":  {(*Server).replConf, false},
+	"PSYNC":     {(*Server).sync, false},
+	"SYNC":      {(*Server).sync, false},
+	"WAIT":      {(*Server).wait, true},
+}
+
+func init() {
+	for name, cmd := range serverCommands {
+		processor.RegisterConnCommand(name, cmd.queueable)
+	}
+}
+
+// runServerCommand runs a command of serverCommands for c.
+func (s *Server) runServerCommand(c *client, args []string) (interface{}, error) {
+	return serverCommands[strings.ToUpper(args[0])].run(s, c, args)
+}
+
+// parseReplicaOf parses the "host port" of the replicaof setting.
+func parseReplicaOf(s string) (string, int, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 2 {
+		if port, err := strconv.Atoi(fields[1]); err == nil && port > 0 && port <= 65535 {
+			return fields[0], port, nil
+		}
+	}
+	return "", 0, fmt.Errorf("invalid replicaof %q, expected \"host port\"", s)
+}
+
+// replicaOf implements REPLICAOF host port and REPLICAOF NO ONE.
+func (s *Server) replicaOf(c *client, args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	if cluster.Enabled() {
+		return nil, errors.New("ERR REPLICAOF not allowed in cluster mode.")
+	}
+	if strings.EqualFold(args[1], "no") && strings.EqualFold(args[2], "one") {
+		if s.repl.masterHost != "" {
+			s.becomePrimary()
+		}
+		return "OK", nil
+	}
+	port, err := strconv.Atoi(args[2])
+	if err != nil || port < 1 || port > 65535 {
+		return nil, errors.New("ERR Invalid master port")
+	}
+	if args[1] == s.repl.masterHost && port == s.repl.masterPort {
+		return "OK Already connected to specified master", nil
+	}
+	s.follow(args[1], port)
+	return "OK", nil
+}
+
+// follow makes the server a replica of host:port. Its own replicas are
+// disconnected, since their data no longer matches.
+func (s *Server) follow(host string, port int) {
+	s.dropMaster()
+	s.repl.masterHost, s.repl.masterPort = host, port
+	s.repl.lastAttempt = time.Time{}
+	processor.SetReadOnly(config.ReplicaReadOnly)
+	for r := range s.repl.replicas {
+		s.removeConnection(r)
+	}
+	fmt.Printf("Replicating %s:%d\n", host, port)
+}
+
+// becomePrimary stops replicating and keeps the data. A new replication ID
+// tells replicas of this server that its stream diverges from here on; the
+// old one stays valid up to the current offset, so replicas of the same
+// primary can continue with this server without a full sync.
+func (s *Server) becomePrimary() {
+	s.dropMaster()
+	s.repl.masterHost, s.repl.masterPort = "", 0
+	s.repl.id2, s.repl.id2End = s.repl.id, s.repl.offset
+	s.repl.id = newReplID()
+	processor.SetReadOnly(false)
+	// Replicas of this server learn the new ID when they reconnect
+	for r := range s.repl.replicas {
+		s.removeConnection(r)
+	}
+	fmt.Println("Replication stopped, now a primary")
+}
+
+// dropMaster closes the link to the primary and abandons a running sync.
+func (s *Server) dropMaster() {
+	s.repl.gen++
+	if s.repl.master != nil {
+		s.removeConnection(s.repl.master)
+	}
+}
+
+// replConf implements the REPLCONF options a replica sends during the
+// handshake, and the offset acknowledgements exchanged afterwards: a
+// replica reports its offset with REPLCONF ACK, which is not answered, and
+// sends it on a primary's REPLCONF GETACK.
+func (s *Server) replConf(c *client, args []string) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return nil, errors.New("ERR syntax error")
+	}
+	if len(args) == 3 {
+		switch strings.ToLower(args[1]) {
+		case "ack":
+			if offset, err := strconv.ParseInt(args[2], 10, 64); err == nil && c.replica {
+				s.ack(c, offset)
+			}
+			return noReply, nil
+		case "getack":
+			if c.master {
+				s.sendAck()
+			}
+			return noReply, nil
+		}
+	}
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			port, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, errors.New("ERR value is not an integer or out of range")
+			}
+			c.replicaPort = port
+		case "capa", "ip-address":
+		default:
+			return nil, fmt.Errorf("ERR Unrecognized REPLCONF option: %s", args[i])
+		}
+	}
+	return "OK", nil
+}
+
+// sync implements PSYNC and SYNC. PSYNC id offset continues the stream
+// from the backlog when it still holds offset of the stream id; otherwise
+// c gets a snapshot of the dataset. Either way c then receives every write
+// as it is propagated. The snapshot is encoded on the event loop, so the
+// writes that follow it in the output buffer are exactly those made after
+// it.
+func (s *Server) sync(c *client, args []string) (interface{}, error) {
+	psync := strings.EqualFold(args[0], "PSYNC")
+	if psync && len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	if c.replica {
+		return nil, errors.New("ERR already a replica")
+	}
+	if c.master {
+		return nil, errors.New("ERR the primary can not sync from its replica")
+	}
+	if s.repl.masterHost != "" && s.repl.master == nil {
+		return nil, errors.New("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	if s.repl.backlog == nil {
+		s.repl.backlog = newBacklog(config.ReplBacklogSize, s.repl.offset)
+	}
+
+	if psync {
+		if stream, ok := s.continueStream(args[1], args[2]); ok {
+			c.replica, c.ackOffset, c.ackTime = true, s.repl.offset, time.Now()
+			s.repl.replicas[c] = struct{}{}
+			fmt.Printf("Replica %v continues with %d bytes of backlog\n", c.conn.RemoteAddr(), len(stream))
+			reply := fmt.Appendf(nil, "+CONTINUE %s\r\n", s.repl.id)
+			return protocol.Encoded(append(reply, stream...)), nil
+		}
+	}
+
+	var payload bytes.Buffer
+	if err := diskstorage.WriteSnapshot(&payload, inMemory.GetInMemoryStore().Snapshot()); err != nil {
+		return nil, fmt.Errorf("ERR failed to create snapshot: %v", err)
+	}
+
+	var reply []byte
+	if strings.EqualFold(args[0], "PSYNC") {
+		reply = fmt.Appendf(reply, "+FULLRESYNC %s %d\r\n", s.repl.id, s.repl.offset)
+	}
+	reply = fmt.Appendf(reply, "$%d\r\n", payload.Len())
+	reply = append(reply, payload.Bytes()...)
+
+	c.replica, c.ackTime = true, time.Now()
+	s.repl.replicas[c] = struct{}{}
+	fmt.Printf("Replica %v synced with %d bytes\n", c.conn.RemoteAddr(), payload.Len())
+	return protocol.Encoded(reply), nil
+}
+
+// continueStream returns the stream from the offset a replica asked for,
+// if it has the ID of this server's stream and the backlog still holds it.
+// Offsets in PSYNC count from 1.
+func (s *Server) continueStream(id, offset string) ([]byte, bool) {
+	from, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	from--
+	if id != s.repl.id && (id != s.repl.id2 || from > s.repl.id2End) {
+		return nil, false
+	}
+	return s.repl.backlog.since(from)
+}
+
+// replicate sends a write command to every replica. A replica forwards its
+// primary's stream as it is instead, see processInput.
+func (s *Server) replicate(args []string) {
+	if s.repl.masterHost != "" {
+		return
+	}
+	parts := make([]interface{}, len(args))
+	for i, arg := range args {
+		parts[i] = arg
+	}
+	s.feedReplicas(protocol.EncodeArray(parts))
+}
+
+// feedReplicas appends data to the replication stream: the backlog and the
+// output of every replica.
+func (s *Server) feedReplicas(data []byte) {
+	s.repl.offset += int64(len(data))
+	if s.repl.backlog != nil {
+		s.repl.backlog.write(data)
+	}
+	for r := range s.repl.replicas {
+		s.send(r, data)
+	}
+}
+
+// replicationCron connects to the primary when the link is down, reports
+// the offset to it, pings the replicas and times out WAITs.
+func (s *Server) replicationCron(now time.Time) {
+	if s.repl.masterHost != "" {
+		if s.repl.master != nil && now.Sub(s.repl.lastIO) > replTimeout {
+			fmt.Println("Primary timed out")
+			s.removeConnection(s.repl.master)
+		}
+		if s.repl.master != nil && now.Sub(s.repl.lastAck) >= replAckPeriod {
+			s.sendAck()
+		}
+		if s.repl.master == nil && !s.repl.syncing && now.Sub(s.repl.lastAttempt) >= replRetryDelay {
+			s.startSync()
+		}
+	}
+	if s.repl.masterHost == "" && len(s.repl.replicas) > 0 && now.Sub(s.repl.lastPing) >= replPingPeriod {
+		s.repl.lastPing = now
+		s.replicate([]string{"PING"})
+	}
+	s.checkWaiting(now)
+}
+
+// startSync syncs with the primary in the background. The event loop
+// picks up the result in finishSync. A server that has a backlog asks to
+// continue its stream from where it is.
+func (s *Server) startSync() {
+	s.repl.syncing = true
+	s.repl.lastAttempt = time.Now()
+	addr := net.JoinHostPort(s.repl.masterHost, strconv.Itoa(s.repl.masterPort))
+	psync := []string{"PSYNC", "?", "-1"}
+	if s.repl.backlog != nil {
+		psync = []string{"PSYNC", s.repl.id, strconv.FormatInt(s.repl.offset+1, 10)}
+	}
+	gen := s.repl.gen
+	go func() {
+		sync := syncWith(addr, psync)
+		sync.gen = gen
+		s.repl.syncs <- sync
+	}()
+}
+
+// syncWith performs the replication handshake with the primary at addr and
+// reads the snapshot it sends, if any.
+func syncWith(addr string, psync []string) *masterSync {
+	conn, err := net.DialTimeout("tcp", addr, replTimeout)
+	if err != nil {
+		return &masterSync{err: err}
+	}
+	sync, err := handshake(conn, psync)
+	if err != nil {
+		conn.Close()
+		return &masterSync{err: err}
+	}
+	sync.conn = conn
+	return sync
+}
+
+func handshake(conn net.Conn, psync []string) (*masterSync, error) {
+	conn.SetDeadline(time.Now().Add(replTimeout))
+	r := bufio.NewReader(conn)
+	steps := [][]string{
+		{"PING"},
+		{"REPLCONF", "listening-port", strconv.Itoa(config.Port)},
+		{"REPLCONF", "capa", "psync2"},
+	}
+	for _, step := range steps {
+		if _, err := readReply(conn, r, step); err != nil {
+			return nil, err
+		}
+	}
+
+	line, err := readReply(conn, r, psync)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 2 && fields[0] == "CONTINUE" {
+		sync := &masterSync{id: fields[1], partial: true}
+		sync.rest, _ = r.Peek(r.Buffered())
+		conn.SetDeadline(time.Time{})
+		return sync, nil
+	}
+	if len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		return nil, fmt.Errorf("unexpected PSYNC reply %q", line)
+	}
+	sync := &masterSync{id: fields[1]}
+	if sync.offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return nil, fmt.Errorf("unexpected PSYNC reply %q", line)
+	}
+
+	// The primary may send newlines to keep the link alive while it
+	// prepares the snapshot
+	var header string
+	for header == "" {
+		if header, err = readLine(r); err != nil {
+			return nil, err
+		}
+	}
+	size, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+	if header[0] != '$' || err != nil || size < 0 {
+		return nil, fmt.Errorf("unexpected snapshot header %q", header)
+	}
+	sync.payload = make([]byte, size)
+	if _, err := io.ReadFull(r, sync.payload); err != nil {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	sync.rest, _ = r.Peek(r.Buffered())
+	conn.SetDeadline(time.Time{})
+	return sync, nil
+}
+
+// readReply sends a command and returns its status reply without the
+// leading "+".
+func readReply(conn net.Conn, r *bufio.Reader, args []string) (string, error) {
+	parts := make([]interface{}, len(args))
+	for i, arg := range args {
+		parts[i] = arg
+	}
+	if _, err := conn.Write(protocol.EncodeArray(parts)); err != nil {
+		return "", err
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "+") {
+		return "", fmt.Errorf("%s: %s", args[0], strings.TrimPrefix(line, "-"))
+	}
+	return line[1:], nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// finishSync loads the snapshot of a completed sync and turns its
+// connection into the link the primary's writes arrive on.
+func (s *Server) finishSync(sync *masterSync) {
+	s.repl.syncing = false
+	if sync.gen != s.repl.gen {
+		if sync.conn != nil {
+			sync.conn.Close()
+		}
+		return
+	}
+	addr := net.JoinHostPort(s.repl.masterHost, strconv.Itoa(s.repl.masterPort))
+	if sync.err != nil {
+		fmt.Printf("Error syncing with primary %s: %v\n", addr, sync.err)
+		return
+	}
+	if sync.partial {
+		s.continueSync(addr, sync)
+		return
+	}
+	if err := s.diskstorage.LoadData("from primary "+addr, sync.payload); err != nil {
+		fmt.Printf("Error loading snapshot of primary %s: %v\n", addr, err)
+		sync.conn.Close()
+		return
+	}
+	c, err := s.addConnection(sync.conn)
+	if err != nil {
+		fmt.Printf("Error adding primary link: %v\n", err)
+		return
+	}
+	s.setMaster(c)
+	s.repl.id, s.repl.offset = sync.id, sync.offset
+	s.repl.id2, s.repl.id2End = "", 0
+	s.repl.backlog = newBacklog(config.ReplBacklogSize, sync.offset)
+	fmt.Printf("Synced with primary %s: %d bytes\n", addr, len(sync.payload))
+
+	// Replicas of this server continue a stream that no longer matches
+	for r := range s.repl.replicas {
+		s.removeConnection(r)
+	}
+
+	// The AOF must describe the new dataset
+	if s.aof != nil {
+		if err := s.aof.StartRewrite(); err != nil {
+			fmt.Printf("Error starting AOF rewrite after sync: %v\n", err)
+		}
+	}
+	if len(sync.rest) > 0 {
+		c.decoder.Feed(sync.rest)
+		s.processInput(c)
+	}
+}
+
+// continueSync resumes applying the primary's stream at the offset this
+// server reached. A primary that was promoted since continues under its new
+// ID, which this server adopts for its own replicas.
+func (s *Server) continueSync(addr string, sync *masterSync) {
+	c, err := s.addConnection(sync.conn)
+	if err != nil {
+		fmt.Printf("Error adding primary link: %v\n", err)
+		return
+	}
+	s.setMaster(c)
+	if sync.id != s.repl.id {
+		s.repl.id2, s.repl.id2End = s.repl.id, s.repl.offset
+		s.repl.id = sync.id
+	}
+	fmt.Printf("Continuing replication of %s at offset %d\n", addr, s.repl.offset)
+	if len(sync.rest) > 0 {
+		c.decoder.Feed(sync.rest)
+		s.processInput(c)
+	}
+}
+
+func (s *Server) setMaster(c *client) {
+	c.master = true
+	c.session.SetMaster()
+	s.repl.master = c
+	s.repl.lastIO = time.Now()
+}
+
+// forgetReplication is called when a connection closes.
+func (s *Server) forgetReplication(c *client) {
+	if c.blocked {
+		s.forgetWaiter(c)
+	}
+	if c.replica {
+		delete(s.repl.replicas, c)
+		fmt.Printf("Replica %v disconnected\n", c.conn.RemoteAddr())
+	}
+	if c == s.repl.master {
+		s.repl.master = nil
+		fmt.Println("Connection with primary lost")
+	}
+}
+
+func (s *Server) infoReplication(b *strings.Builder) {
+	if s.repl.masterHost == "" {
+		fmt.Fprintf(b, "role:master\r\n")
+	} else {
+		link := "down"
+		lastIO := -1
+		if s.repl.master != nil {
+			link = "up"
+			lastIO = int(time.Since(s.repl.lastIO) / time.Second)
+		}
+		fmt.Fprintf(b, "role:slave\r\n")
+		fmt.Fprintf(b, "master_host:%s\r\n", s.repl.masterHost)
+		fmt.Fprintf(b, "master_port:%d\r\n", s.repl.masterPort)
+		fmt.Fprintf(b, "master_link_status:%s\r\n", link)
+		fmt.Fprintf(b, "master_last_io_seconds_ago:%d\r\n", lastIO)
+		fmt.Fprintf(b, "master_sync_in_progress:%d\r\n", boolFlag(s.repl.syncing))
+		fmt.Fprintf(b, "slave_repl_offset:%d\r\n", s.repl.offset)
+		fmt.Fprintf(b, "slave_read_only:%d\r\n", boolFlag(config.ReplicaReadOnly))
+	}
+	fmt.Fprintf(b, "connected_slaves:%d\r\n", len(s.repl.replicas))
+	i := 0
+	for r := range s.repl.replicas {
+		host, _, _ := net.SplitHostPort(r.conn.RemoteAddr().String())
+		lag := int(time.Since(r.ackTime) / time.Second)
+		fmt.Fprintf(b, "slave%d:ip=%s,port=%d,state=online,offset=%d,lag=%d\r\n", i, host, r.replicaPort, r.ackOffset, lag)
+		i++
+	}
+	id2, id2End := s.repl.id2, s.repl.id2End+1
+	if id2 == "" {
+		id2, id2End = strings.Repeat("0", 40), -1
+	}
+	fmt.Fprintf(b, "master_replid:%s\r\n", s.repl.id)
+	fmt.Fprintf(b, "master_replid2:%s\r\n", id2)
+	fmt.Fprintf(b, "master_repl_offset:%d\r\n", s.repl.offset)
+	fmt.Fprintf(b, "second_repl_offset:%d\r\n", id2End)
+	if s.repl.backlog == nil {
+		fmt.Fprintf(b, "repl_backlog_active:0\r\n")
+		return
+	}
+	fmt.Fprintf(b, "repl_backlog_active:1\r\n")
+	fmt.Fprintf(b, "repl_backlog_size:%d\r\n", len(s.repl.backlog.buf))
+	fmt.Fprintf(b, "repl_backlog_first_byte_offset:%d\r\n", s.repl.backlog.start+1)
+	fmt.Fprintf(b, "repl_backlog_histlen:%d\r\n", s.repl.backlog.end-s.repl.backlog.start)
+}
+
+func boolFlag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func errWrongArgs(cmd string) error {
+	return errors.New("ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command")
+}
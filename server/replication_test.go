@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/internal/processor"
+	"github.com/bhaski-1234/redis-db/protocol"
+	diskstorage "github.com/bhaski-1234/redis-db/storage/diskStorage"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+	"golang.org/x/sys/unix"
+)
+
+func encodeCommand(args ...string) []byte {
+	parts := make([]interface{}, len(args))
+	for i, arg := range args {
+		parts[i] = arg
+	}
+	return protocol.EncodeArray(parts)
+}
+
+// fakePrimary accepts one replica on l, answers its handshake with a full
+// resync of payload followed by stream, and reports the PSYNC it got. The
+// link is handed over on conns for the test to write more of the stream.
+func fakePrimary(l net.Listener, payload, stream []byte, psync chan<- []string, conns chan<- net.Conn) {
+	conn, err := l.Accept()
+	if err != nil {
+		close(psync)
+		return
+	}
+	decoder := protocol.NewDecoder()
+	buf := make([]byte, 4096)
+	replies := []string{"+PONG\r\n", "+OK\r\n", "+OK\r\n"}
+	for {
+		frame, err := decoder.Next()
+		if err != nil {
+			n, err := conn.Read(buf)
+			if err != nil {
+				close(psync)
+				return
+			}
+			decoder.Feed(buf[:n])
+			continue
+		}
+		args, _ := processor.Args(frame)
+		if len(replies) > 0 {
+			conn.Write([]byte(replies[0]))
+			replies = replies[1:]
+			continue
+		}
+		psync <- args
+		reply := []byte("+FULLRESYNC " + strings.Repeat("a", 40) + " 100\r\n")
+		reply = append(reply, "$"+strconv.Itoa(len(payload))+"\r\n"...)
+		reply = append(reply, payload...)
+		conn.Write(append(reply, stream...))
+		conns <- conn
+		return
+	}
+}
+
+func TestReplicaSync(t *testing.T) {
+	store := inMemory.GetInMemoryStore()
+	store.Clear()
+	store.Set("synced", "1")
+	var payload bytes.Buffer
+	if err := diskstorage.WriteSnapshot(&payload, store.Snapshot()); err != nil {
+		t.Fatalf("TestReplicaSync failed: WriteSnapshot returned %v", err)
+	}
+	store.Clear()
+	store.Set("stale", "1")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestReplicaSync failed: %v", err)
+	}
+	defer l.Close()
+	streamed := encodeCommand("SET", "streamed", "1")
+	psync := make(chan []string, 1)
+	conns := make(chan net.Conn, 1)
+	go fakePrimary(l, payload.Bytes(), streamed, psync, conns)
+
+	s := NewServer()
+	if s.epollFd, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
+		t.Fatalf("TestReplicaSync failed: %v", err)
+	}
+	readOnly, backlogSize := config.ReplicaReadOnly, config.ReplBacklogSize
+	config.ReplicaReadOnly, config.ReplBacklogSize = true, 1024
+	defer func() {
+		config.ReplicaReadOnly, config.ReplBacklogSize = readOnly, backlogSize
+		processor.SetReadOnly(false)
+		store.Clear()
+		s.Close()
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	if reply, err := s.replicaOf(&client{}, []string{"REPLICAOF", addr.IP.String(), strconv.Itoa(addr.Port)}); reply != "OK" || err != nil {
+		t.Fatalf("TestReplicaSync failed: REPLICAOF replied %v, %v", reply, err)
+	}
+	sync := syncWith(addr.String(), []string{"PSYNC", "?", "-1"})
+	if sync.err != nil {
+		t.Fatalf("TestReplicaSync failed: sync returned %v", sync.err)
+	}
+	if args := <-psync; strings.Join(args, " ") != "PSYNC ? -1" {
+		t.Errorf("TestReplicaSync failed: primary got %v, want PSYNC ? -1", args)
+	}
+	sync.gen = s.repl.gen
+	s.finishSync(sync)
+
+	// The snapshot replaces the dataset, and the stream read with it runs
+	if s.repl.master == nil {
+		t.Fatalf("TestReplicaSync failed: no link to the primary after the sync")
+	}
+	if store.Exists("stale") || !store.Exists("synced") || !store.Exists("streamed") {
+		t.Errorf("TestReplicaSync failed: after the sync stale=%v synced=%v streamed=%v", store.Exists("stale"), store.Exists("synced"), store.Exists("streamed"))
+	}
+	if s.repl.id != strings.Repeat("a", 40) || s.repl.offset != 100+int64(len(streamed)) {
+		t.Errorf("TestReplicaSync failed: replicating %s at offset %d", s.repl.id, s.repl.offset)
+	}
+
+	// Writes of the primary keep arriving on the link
+	primary := <-conns
+	defer primary.Close()
+	later := encodeCommand("SET", "later", "2")
+	primary.Write(later)
+	for deadline := time.Now().Add(time.Second); !store.Exists("later") && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		s.handleClientData(s.repl.master)
+	}
+	if v, _ := store.GetValue("later"); v != "2" {
+		t.Errorf("TestReplicaSync failed: streamed write gave %v", v)
+	}
+	if data, ok := s.repl.backlog.since(100); !ok || !bytes.Equal(data, append(streamed, later...)) {
+		t.Errorf("TestReplicaSync failed: backlog holds %q", data)
+	}
+
+	// Clients of the replica may read but not write
+	session := processor.NewSession(nil)
+	defer session.Close()
+	if _, err := processor.Execute(session, []string{"SET", "k", "v"}); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Errorf("TestReplicaSync failed: a client write on the replica gave %v", err)
+	}
+	if v, err := processor.Execute(session, []string{"GET", "synced"}); err != nil || v != protocol.BulkString("1") {
+		t.Errorf("TestReplicaSync failed: a client read on the replica gave %v, %v", v, err)
+	}
+}
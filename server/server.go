@@ -5,29 +5,58 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/internal/cluster"
+	"github.com/bhaski-1234/redis-db/internal/command"
 	"github.com/bhaski-1234/redis-db/internal/processor"
 	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/aof"
 	diskstorage "github.com/bhaski-1234/redis-db/storage/diskStorage"
+	"github.com/bhaski-1234/redis-db/storage/encryption"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
 	"golang.org/x/sys/unix"
 )
 
 type Server struct {
-	epollFd     int
-	listener    net.Listener
-	connections map[int]net.Conn
-	mu          sync.RWMutex
-	diskstorage *diskstorage.DiskStorage
+	epollFd      int
+	listener     net.Listener
+	listenerFile *os.File
+	connections  map[int]*client
+	mu           sync.RWMutex
+	diskstorage  *diskstorage.DiskStorage
+	aof          *aof.AOF
+	signals      chan os.Signal
+	lastCron     time.Time
+	repl         replState
 }
 
+// cronInterval is how often periodic tasks run, and thus the longest the
+// event loop sleeps waiting for events.
+const cronInterval = 100 * time.Millisecond
+
+// saveRetryDelay is how long to wait before retrying a failed automatic
+// background save.
+const saveRetryDelay = 5 * time.Second
+
+// pushOutputLimit is how much pushed output, such as pub/sub messages, a
+// client may leave unread before it is disconnected.
+const pushOutputLimit = 32 << 20
+
 func NewServer() *Server {
-	return &Server{
-		connections: make(map[int]net.Conn),
+	s := &Server{
+		connections: make(map[int]*client),
 		diskstorage: diskstorage.NewDiskStorage(),
+		signals:     make(chan os.Signal, 1),
+		repl:        newReplState(),
 	}
+	command.AddInfoSection("replication", s.infoReplication)
+	command.AddInfoSection("cluster", cluster.Info)
+	return s
 }
 
 func (s *Server) Start() error {
@@ -41,9 +70,16 @@ func (s *Server) Start() error {
 	fmt.Printf("Server is running on %s:%d\n", config.Host, config.Port)
 
 	// Load data from disk
-	err = s.diskstorage.Load("dump")
-	if errors.Is(err, os.ErrNotExist) {
-		// Handle the "file not found" case specifically
+	if err := s.loadData(); err != nil {
+		s.listener.Close()
+		return err
+	}
+
+	if config.ClusterEnabled {
+		if err := cluster.Enable(config.Host, config.Port, config.ClusterConfigFile); err != nil {
+			s.listener.Close()
+			return err
+		}
 	}
 
 	// Create epoll instance
@@ -59,15 +95,83 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	processor.AddPropagator(s.replicate)
+	if config.NotifyKeyspaceEvents != "" {
+		processor.EnableKeyspaceEvents()
+	}
+	if config.ReplicaOf != "" {
+		host, port, err := parseReplicaOf(config.ReplicaOf)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.follow(host, port)
+	}
+
+	signal.Notify(s.signals, syscall.SIGINT, syscall.SIGTERM)
+
 	return s.eventLoop()
 }
 
+// loadData restores the dataset before any client is accepted. With AOF
+// enabled the log is the authoritative copy, otherwise the snapshot is used.
+func (s *Server) loadData() error {
+	if config.EncryptionKeyFile != "" {
+		if err := encryption.LoadKeyFile(config.EncryptionKeyFile); err != nil {
+			return fmt.Errorf("failed to load encryption keys: %w", err)
+		}
+	}
+	if config.AppendOnly {
+		if err := aof.ParsePolicy(config.AppendFsync); err != nil {
+			return err
+		}
+		if _, err := os.Stat(config.AppendFilename); err == nil {
+			// A transaction cut off by a crash is left queued in the
+			// replay session and never executed.
+			replay := processor.NewSession(nil)
+			if err := aof.Load(config.AppendFilename, func(frame interface{}) error {
+				_, err := processor.Process(replay, frame)
+				return err
+			}); err != nil {
+				return fmt.Errorf("failed to load AOF: %w", err)
+			}
+		} else if err := s.loadSnapshot(); err != nil {
+			return err
+		}
+
+		var err error
+		s.aof, err = aof.Open(config.AppendFilename, config.AppendFsync)
+		if err != nil {
+			return fmt.Errorf("failed to open AOF: %w", err)
+		}
+		processor.AddPropagator(s.aof.Append)
+	} else if err := s.loadSnapshot(); err != nil {
+		return err
+	}
+
+	// Loading is not a change that needs saving
+	store := inMemory.GetInMemoryStore()
+	store.ResetDirty(store.Dirty())
+	return nil
+}
+
+// loadSnapshot loads the snapshot if there is one. A corrupt snapshot stops
+// the server, since serving an empty dataset would soon overwrite it.
+func (s *Server) loadSnapshot() error {
+	err := s.diskstorage.Load("dump")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to load snapshot: %w", err)
+	}
+	return nil
+}
+
 func (s *Server) addListenerToEpoll() error {
 	file, err := s.listener.(*net.TCPListener).File()
 	if err != nil {
 		return fmt.Errorf("failed to get listener file: %w", err)
 	}
-	defer file.Close()
+	// Keep the duplicate open so its fd number is not reused by a client
+	s.listenerFile = file
 
 	fd := int(file.Fd())
 	event := unix.EpollEvent{
@@ -91,7 +195,7 @@ func (s *Server) eventLoop() error {
 	events := make([]unix.EpollEvent, 100)
 
 	for {
-		n, err := unix.EpollWait(s.epollFd, events, -1)
+		n, err := unix.EpollWait(s.epollFd, events, int(cronInterval/time.Millisecond))
 		if err != nil {
 			if err == unix.EINTR {
 				continue
@@ -103,18 +207,96 @@ func (s *Server) eventLoop() error {
 			fd := int(events[i].Fd)
 
 			s.mu.RLock()
-			conn := s.connections[fd]
+			c, ok := s.connections[fd]
 			s.mu.RUnlock()
 
-			if conn == nil {
+			if !ok {
+				// Closed earlier in this batch, e.g. by a failed push
+				continue
+			}
+			if c == nil {
 				// This is the listener
 				s.handleNewConnection()
-			} else {
-				// This is a client connection
-				s.handleClientData(fd, conn)
+				continue
+			}
+			// This is a client connection
+			if events[i].Events&unix.EPOLLOUT != 0 {
+				s.flush(c)
+			}
+			if events[i].Events&^unix.EPOLLOUT != 0 && !c.closed {
+				s.handleClientData(c)
 			}
 		}
+
+		s.resumeUnblocked()
+		if s.repl.getAck {
+			s.requestAcks()
+		}
+
+		select {
+		case sig := <-s.signals:
+			fmt.Printf("Received %v, shutting down\n", sig)
+			s.prepareShutdown()
+			return nil
+		case sync := <-s.repl.syncs:
+			s.finishSync(sync)
+		default:
+		}
+		s.cron()
+	}
+}
+
+// cron runs periodic tasks. It runs on the event loop goroutine so that it
+// can take consistent snapshots of the dataset.
+func (s *Server) cron() {
+	now := time.Now()
+	if now.Sub(s.lastCron) < cronInterval {
+		return
+	}
+	s.lastCron = now
+	processor.NotifyExpired()
+	s.replicationCron(now)
+	s.checkSavePoints(now)
+}
+
+// checkSavePoints starts a background save when one of the configured save
+// points is reached.
+func (s *Server) checkSavePoints(now time.Time) {
+	if diskstorage.SaveInProgress() {
+		return
+	}
+	status := diskstorage.Status()
+	if !status.LastBgsaveOK && now.Sub(status.CurrentStartTime) < saveRetryDelay {
+		return
+	}
+	dirty := inMemory.GetInMemoryStore().Dirty()
+	for _, point := range config.SavePoints {
+		if dirty < point.Changes || now.Sub(status.LastSaveTime) < time.Duration(point.Seconds)*time.Second {
+			continue
+		}
+		fmt.Printf("%d changes in %d seconds. Saving...\n", point.Changes, point.Seconds)
+		if err := s.diskstorage.BackgroundSave("dump"); err != nil {
+			fmt.Printf("Error starting background save: %v\n", err)
+		}
+		return
+	}
+}
+
+// prepareShutdown writes a final snapshot when save points are configured.
+// The AOF is flushed and synced by Close.
+func (s *Server) prepareShutdown() {
+	for diskstorage.SaveInProgress() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(config.SavePoints) == 0 {
+		return
+	}
+	if err := s.diskstorage.Save("dump"); err != nil {
+		fmt.Printf("Error saving the final snapshot: %v\n", err)
+		return
 	}
+	diskstorage.RecordSave()
+	fmt.Println("DB saved on disk")
 }
 
 func (s *Server) handleNewConnection() {
@@ -123,24 +305,32 @@ func (s *Server) handleNewConnection() {
 		fmt.Printf("Error accepting connection: %v\n", err)
 		return
 	}
+	if _, err := s.addConnection(conn); err != nil {
+		fmt.Printf("Error adding connection: %v\n", err)
+		return
+	}
+	fmt.Printf("New connection accepted: %v\n", conn.RemoteAddr())
+}
 
+// addConnection registers conn with the event loop as a client. conn is
+// closed if that fails.
+func (s *Server) addConnection(conn net.Conn) (*client, error) {
 	tcpConn := conn.(*net.TCPConn)
 	file, err := tcpConn.File()
 	if err != nil {
-		fmt.Printf("Error getting connection file: %v\n", err)
 		conn.Close()
-		return
+		return nil, fmt.Errorf("failed to get connection file: %w", err)
 	}
 
+	// The duplicate descriptor stays open for the lifetime of the client so
+	// that its fd number remains a unique key in the epoll set
 	fd := int(file.Fd())
-	fmt.Println(fd)
-	defer file.Close() // Close the duplicate file descriptor
 
 	// Set non-blocking mode
 	if err := syscall.SetNonblock(fd, true); err != nil {
-		fmt.Printf("Error setting non-blocking: %v\n", err)
+		file.Close()
 		conn.Close()
-		return
+		return nil, fmt.Errorf("failed to set non-blocking: %w", err)
 	}
 
 	// Add to epoll
@@ -150,50 +340,202 @@ func (s *Server) handleNewConnection() {
 	}
 
 	if err := unix.EpollCtl(s.epollFd, unix.EPOLL_CTL_ADD, fd, &event); err != nil {
-		fmt.Printf("Error adding connection to epoll: %v\n", err)
+		file.Close()
 		conn.Close()
-		return
+		return nil, fmt.Errorf("failed to add connection to epoll: %w", err)
 	}
 
 	// Store connection
+	c := newClient(fd, conn, file)
+	c.session = processor.NewSession(func(data []byte) { s.push(c, data) })
+	c.session.SetConnHandler(func(args []string) (interface{}, error) { return s.runServerCommand(c, args) })
 	s.mu.Lock()
-	s.connections[fd] = conn
+	s.connections[fd] = c
 	s.mu.Unlock()
-
-	fmt.Printf("New connection accepted: %v\n", conn.RemoteAddr())
+	return c, nil
 }
 
-func (s *Server) handleClientData(fd int, conn net.Conn) {
+// handleClientData drains the socket, decodes every complete command that
+// has been buffered so far and writes the replies back in order. Partial
+// frames stay in the client's decoder until the next read completes them.
+func (s *Server) handleClientData(c *client) {
 	buf := make([]byte, 4096)
 
+	// The socket is edge-triggered, so read until the kernel buffer is empty
 	for {
-		n, _ := conn.Read(buf)
+		n, err := unix.Read(c.fd, buf)
+		if n > 0 {
+			c.decoder.Feed(buf[:n])
+		}
+		if err == unix.EINTR {
+			continue
+		}
+		if err == unix.EAGAIN {
+			break
+		}
+		if err != nil || n == 0 {
+			s.removeConnection(c)
+			return
+		}
+	}
+
+	if c.master {
+		s.repl.lastIO = time.Now()
+	}
+	s.processInput(c)
+}
+
+// processInput executes every complete command buffered for c and writes
+// the replies back in order. A client blocked in WAIT keeps its further
+// commands buffered until it is answered.
+func (s *Server) processInput(c *client) {
+	processed := false
+	c.processing = true
+	for !c.blocked && !c.closed {
+		frame, err := c.decoder.Next()
+		if errors.Is(err, protocol.ErrIncomplete) {
+			break
+		}
+		if err != nil {
+			// The stream can not be resynchronised after a protocol error
+			c.processing = false
+			c.out = append(c.out, protocol.EncodeError("ERR Protocol error: "+err.Error())...)
+			s.flush(c)
+			s.removeConnection(c)
+			return
+		}
+		processed = true
+
+		resp, err := s.execute(c, frame)
+		c.woff = s.repl.offset
+		if c.master {
+			// The primary does not read replies. Its stream is passed on
+			// as it is, so replicas of this server see the same offsets.
+			s.feedReplicas(c.decoder.Raw())
+			continue
+		}
+		if err != nil {
+			c.out = append(c.out, protocol.EncodeError(err.Error())...)
+		} else {
+			c.out = append(c.out, protocol.EncodeResponse(resp)...)
+		}
+	}
+	c.processing = false
+
+	if !processed || c.closed {
+		return
+	}
+	// Writes must reach the AOF before they are acknowledged
+	if s.aof != nil {
+		if err := s.aof.Flush(); err != nil {
+			fmt.Printf("Error writing AOF: %v\n", err)
+		}
+		if s.aof.NeedsRewrite(config.AutoAOFRewritePercentage, config.AutoAOFRewriteMinSize) {
+			if err := s.aof.StartRewrite(); err != nil {
+				fmt.Printf("Error starting automatic AOF rewrite: %v\n", err)
+			}
+		}
+	}
+	if len(c.out) > 0 {
+		s.flush(c)
+	}
+}
+
+// execute runs a command through the session of c, which hands the
+// server's own commands back to runServerCommand.
+func (s *Server) execute(c *client, frame interface{}) (interface{}, error) {
+	args, err := processor.Args(frame)
+	if err != nil {
+		return nil, err
+	}
+	return processor.Execute(c.session, args)
+}
+
+// send writes data to c after any output still pending. What the socket
+// does not accept now is written when epoll reports it writable. Sent to
+// the client whose commands are running, it follows the replies before it
+// once the batch is written.
+func (s *Server) send(c *client, data []byte) {
+	c.out = append(c.out, data...)
+	if !c.processing {
+		s.flush(c)
+	}
+}
 
-		// Process data
-		resp, _ := processor.Process(buf[:n])
-		respEncoded := protocol.EncodeResponse(resp)
+// push sends output the client did not ask for, such as a pub/sub message.
+// A client that does not read it is disconnected rather than buffered
+// without bound.
+func (s *Server) push(c *client, data []byte) {
+	if c.closed {
+		return
+	}
+	if len(c.out)+len(data) > pushOutputLimit {
+		fmt.Printf("Closing %v: pending output over %d bytes\n", c.conn.RemoteAddr(), pushOutputLimit)
+		s.removeConnection(c)
+		return
+	}
+	s.send(c, data)
+}
 
-		// Echo back for now
-		if _, err := conn.Write([]byte(respEncoded)); err != nil {
-			s.removeConnection(fd, conn)
+func (s *Server) flush(c *client) {
+	for len(c.out) > 0 {
+		n, err := unix.Write(c.fd, c.out)
+		if n > 0 {
+			c.out = c.out[n:]
+		}
+		if err == unix.EINTR {
+			continue
+		}
+		if err == unix.EAGAIN {
+			break
+		}
+		if err != nil {
+			s.removeConnection(c)
 			return
 		}
 	}
+	if len(c.out) == 0 {
+		c.out = nil
+	}
+	s.watchWritable(c, len(c.out) > 0)
+}
+
+// watchWritable adds or removes EPOLLOUT for c.
+func (s *Server) watchWritable(c *client, on bool) {
+	if c.writable == on {
+		return
+	}
+	events := uint32(unix.EPOLLIN | unix.EPOLLET)
+	if on {
+		events |= unix.EPOLLOUT
+	}
+	event := unix.EpollEvent{Events: events, Fd: int32(c.fd)}
+	if err := unix.EpollCtl(s.epollFd, unix.EPOLL_CTL_MOD, c.fd, &event); err != nil {
+		fmt.Printf("Error updating epoll events: %v\n", err)
+		return
+	}
+	c.writable = on
 }
 
-func (s *Server) removeConnection(fd int, conn net.Conn) {
+func (s *Server) removeConnection(c *client) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	s.forgetReplication(c)
+
 	// Remove from epoll
-	unix.EpollCtl(s.epollFd, unix.EPOLL_CTL_DEL, fd, nil)
+	unix.EpollCtl(s.epollFd, unix.EPOLL_CTL_DEL, c.fd, nil)
 
 	// Remove from map
 	s.mu.Lock()
-	delete(s.connections, fd)
+	delete(s.connections, c.fd)
 	s.mu.Unlock()
 
 	// Close connection
-	conn.Close()
+	c.close()
 
-	fmt.Printf("Connection closed: %v\n", conn.RemoteAddr())
+	fmt.Printf("Connection closed: %v\n", c.conn.RemoteAddr())
 }
 
 func (s *Server) Close() {
@@ -205,10 +547,18 @@ func (s *Server) Close() {
 		s.listener.Close()
 	}
 
+	if s.listenerFile != nil {
+		s.listenerFile.Close()
+	}
+
+	if s.aof != nil {
+		s.aof.Close()
+	}
+
 	s.mu.Lock()
-	for _, conn := range s.connections {
-		if conn != nil {
-			conn.Close()
+	for _, c := range s.connections {
+		if c != nil {
+			c.close()
 		}
 	}
 	s.mu.Unlock()
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/internal/processor"
+	"github.com/bhaski-1234/redis-db/protocol"
+)
+
+// replAckPeriod is how often a replica reports its offset to the primary.
+const replAckPeriod = time.Second
+
+// noReply is returned by commands that must not be answered, such as the
+// acknowledgements replicas send on their replication link.
+var noReply = protocol.Encoded(nil)
+
+// waiter is a client blocked in WAIT until numReplicas replicas have
+// acknowledged offset, or until deadline if it is set.
+type waiter struct {
+	c           *client
+	offset      int64
+	numReplicas int
+	deadline    time.Time
+}
+
+// wait implements WAIT numreplicas timeout. It replies with the number of
+// replicas that acknowledged the client's last write, blocking the client
+// until enough have or timeout milliseconds passed. A timeout of 0 blocks
+// until enough replicas acknowledge.
+func (s *Server) wait(c *client, args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errWrongArgs(args[0])
+	}
+	if s.repl.masterHost != "" {
+		return nil, errors.New("ERR WAIT cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+	}
+	numReplicas, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errors.New("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return nil, errors.New("ERR timeout is negative")
+	}
+
+	if processor.InExec() {
+		// A transaction can not block, so WAIT answers right away for the
+		// writes made so far, as in Redis
+		return s.ackedReplicas(s.repl.offset), nil
+	}
+	acked := s.ackedReplicas(c.woff)
+	if acked >= numReplicas {
+		return acked, nil
+	}
+	w := &waiter{c: c, offset: c.woff, numReplicas: numReplicas}
+	if timeout > 0 {
+		w.deadline = time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	}
+	c.blocked = true
+	s.repl.waiting = append(s.repl.waiting, w)
+	s.repl.getAck = true
+	return noReply, nil
+}
+
+// ackedReplicas counts the replicas that acknowledged offset.
+func (s *Server) ackedReplicas(offset int64) int {
+	n := 0
+	for r := range s.repl.replicas {
+		if r.ackOffset >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// requestAcks asks every replica for its offset. It is called once per
+// event loop iteration, so the WAITs of a batch share one request.
+func (s *Server) requestAcks() {
+	s.repl.getAck = false
+	s.replicate([]string{"REPLCONF", "GETACK", "*"})
+}
+
+// sendAck reports the offset this replica applied to its primary.
+func (s *Server) sendAck() {
+	s.repl.lastAck = time.Now()
+	offset := strconv.FormatInt(s.repl.offset, 10)
+	s.send(s.repl.master, protocol.EncodeArray([]interface{}{"REPLCONF", "ACK", offset}))
+}
+
+// ack records the offset a replica acknowledged and unblocks the WAITs it
+// satisfies.
+func (s *Server) ack(c *client, offset int64) {
+	if offset > c.ackOffset {
+		c.ackOffset = offset
+	}
+	c.ackTime = time.Now()
+	s.checkWaiting(c.ackTime)
+}
+
+// checkWaiting replies to the waiters that have enough acknowledgements or
+// whose timeout passed.
+func (s *Server) checkWaiting(now time.Time) {
+	var done []*waiter
+	waiting := s.repl.waiting[:0]
+	for _, w := range s.repl.waiting {
+		if s.ackedReplicas(w.offset) >= w.numReplicas || (!w.deadline.IsZero() && now.After(w.deadline)) {
+			done = append(done, w)
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+	s.repl.waiting = waiting
+	for _, w := range done {
+		s.unblock(w.c, s.ackedReplicas(w.offset))
+	}
+}
+
+// unblock answers a client blocked in WAIT. The commands it sent in the
+// meantime are run by resumeUnblocked.
+func (s *Server) unblock(c *client, reply interface{}) {
+	c.blocked = false
+	s.send(c, protocol.EncodeResponse(reply))
+	s.repl.unblocked = append(s.repl.unblocked, c)
+}
+
+// resumeUnblocked runs the commands buffered by clients that were blocked.
+func (s *Server) resumeUnblocked() {
+	for len(s.repl.unblocked) > 0 {
+		c := s.repl.unblocked[0]
+		s.repl.unblocked = s.repl.unblocked[1:]
+		if !c.closed && !c.blocked {
+			s.processInput(c)
+		}
+	}
+	s.repl.unblocked = nil
+}
+
+// forgetWaiter drops the WAIT of a closed client.
+func (s *Server) forgetWaiter(c *client) {
+	for i, w := range s.repl.waiting {
+		if w.c == c {
+			s.repl.waiting = append(s.repl.waiting[:i], s.repl.waiting[i+1:]...)
+			return
+		}
+	}
+}
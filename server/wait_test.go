@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// newWaitServer returns a primary with replicas that acknowledged offsets.
+// Its clients are marked processing, so replies collect in their output
+// instead of being written to a socket.
+func newWaitServer(offsets ...int64) (*Server, []*client) {
+	s := &Server{}
+	s.repl.replicas = map[*client]struct{}{}
+	var replicas []*client
+	for _, offset := range offsets {
+		r := &client{replica: true, ackOffset: offset, processing: true}
+		s.repl.replicas[r] = struct{}{}
+		replicas = append(replicas, r)
+	}
+	return s, replicas
+}
+
+func TestWait(t *testing.T) {
+	s, replicas := newWaitServer(60, 40)
+	c := &client{woff: 50, processing: true}
+
+	if reply, err := s.wait(c, []string{"WAIT", "1", "0"}); reply != 1 || err != nil {
+		t.Errorf("TestWait failed: WAIT 1 replied %v, %v, want 1", reply, err)
+	}
+
+	// Not enough replicas: the client blocks until one more acknowledges
+	if reply, _ := s.wait(c, []string{"WAIT", "2", "0"}); !c.blocked || !s.repl.getAck {
+		t.Fatalf("TestWait failed: WAIT 2 replied %v without blocking", reply)
+	}
+	s.ack(replicas[1], 45)
+	if !c.blocked {
+		t.Errorf("TestWait failed: an acknowledgement short of the offset unblocked WAIT")
+	}
+	s.ack(replicas[1], 50)
+	if c.blocked || string(c.out) != ":2\r\n" || len(s.repl.waiting) != 0 {
+		t.Errorf("TestWait failed: after enough acknowledgements blocked=%v, output %q", c.blocked, c.out)
+	}
+	if len(s.repl.unblocked) != 1 || s.repl.unblocked[0] != c {
+		t.Errorf("TestWait failed: unblocked client not queued to resume")
+	}
+
+	// Acknowledged offsets never go back
+	s.ack(replicas[0], 10)
+	if replicas[0].ackOffset != 60 {
+		t.Errorf("TestWait failed: ack offset went back to %d", replicas[0].ackOffset)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	s, _ := newWaitServer(60)
+	c := &client{woff: 50, processing: true}
+
+	s.wait(c, []string{"WAIT", "3", "100"})
+	s.checkWaiting(time.Now())
+	if !c.blocked {
+		t.Fatalf("TestWaitTimeout failed: WAIT unblocked before its timeout")
+	}
+	s.checkWaiting(time.Now().Add(time.Second))
+	if c.blocked || string(c.out) != ":1\r\n" {
+		t.Errorf("TestWaitTimeout failed: after the timeout blocked=%v, output %q", c.blocked, c.out)
+	}
+
+	// A closed client's WAIT is dropped
+	other := &client{woff: 100, processing: true}
+	s.wait(other, []string{"WAIT", "1", "0"})
+	s.forgetWaiter(other)
+	if len(s.repl.waiting) != 0 {
+		t.Errorf("TestWaitTimeout failed: %d waiters left after forgetWaiter", len(s.repl.waiting))
+	}
+}
+
+func TestWaitErrors(t *testing.T) {
+	s, _ := newWaitServer()
+	c := &client{processing: true}
+	for _, args := range [][]string{
+		{"WAIT", "1"},
+		{"WAIT", "x", "0"},
+		{"WAIT", "1", "x"},
+		{"WAIT", "1", "-1"},
+	} {
+		if _, err := s.wait(c, args); err == nil {
+			t.Errorf("TestWaitErrors failed: %v was accepted", args)
+		}
+	}
+
+	s.repl.masterHost = "127.0.0.1"
+	if _, err := s.wait(c, []string{"WAIT", "0", "0"}); err == nil {
+		t.Errorf("TestWaitErrors failed: WAIT was accepted on a replica")
+	}
+}
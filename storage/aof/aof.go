@@ -0,0 +1,433 @@
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/protocol"
+	"github.com/bhaski-1234/redis-db/storage/encryption"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// Fsync policies, matching the appendfsync setting of Redis.
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+// ErrRewriteInProgress is returned when a rewrite is requested while one is
+// already running.
+var ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// AOF is an append-only log of write commands in RESP form. Commands are
+// buffered by Append and written by Flush, which the server calls before it
+// replies to clients, so an acknowledged write is at least in the kernel.
+type AOF struct {
+	path   string
+	file   *os.File
+	enc    *encryption.Writer // nil unless the file is encrypted
+	policy string
+	buf    []byte
+	mu     sync.Mutex
+	done   chan struct{}
+
+	// size is the current file size and baseSize its size right after the
+	// last rewrite, used for the automatic rewrite trigger.
+	size     int64
+	baseSize int64
+
+	// While a rewrite runs, everything flushed to the old file is also kept
+	// in rewriteBuf so it can be appended to the new one before the swap.
+	rewriting  bool
+	rewriteBuf []byte
+	closed     bool
+}
+
+var active *AOF
+
+// Active returns the log opened by Open, or nil when AOF is disabled.
+func Active() *AOF {
+	return active
+}
+
+func encodeCommand(args []string) []byte {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	return protocol.EncodeArray(values)
+}
+
+// ParsePolicy validates an appendfsync value.
+func ParsePolicy(policy string) error {
+	switch policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return nil
+	}
+	return fmt.Errorf("invalid appendfsync policy %q", policy)
+}
+
+// Open opens the log at path for appending. A missing log is created from
+// the current dataset, so enabling AOF on top of a loaded snapshot keeps the
+// data. A log whose encryption does not match the configuration, e.g. one
+// sealed with a key that has since been rotated out of first place, is
+// rewritten the same way. Open must run after Load and before clients are
+// served.
+func Open(path, policy string) (*AOF, error) {
+	if err := ParsePolicy(policy); err != nil {
+		return nil, err
+	}
+	current, err := isCurrent(path)
+	if err != nil {
+		return nil, err
+	}
+	if !current {
+		file, _, err := writeRewrite(path, inMemory.GetInMemoryStore().Snapshot())
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+		if err := os.Rename(file.Name(), path); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var enc *encryption.Writer
+	if encryption.Enabled() {
+		if enc, err = encryption.Resume(file, file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("encrypted AOF %s: %w", path, err)
+		}
+	}
+	a := &AOF{
+		path:     path,
+		file:     file,
+		enc:      enc,
+		policy:   policy,
+		done:     make(chan struct{}),
+		size:     info.Size(),
+		baseSize: info.Size(),
+	}
+	if policy == FsyncEverySec {
+		go a.syncEverySecond()
+	}
+	active = a
+	return a, nil
+}
+
+// Append buffers a command for the next Flush.
+func (a *AOF) Append(args []string) {
+	a.mu.Lock()
+	a.buf = append(a.buf, encodeCommand(args)...)
+	a.mu.Unlock()
+}
+
+// Flush writes the buffered commands and syncs them when the policy is
+// "always".
+func (a *AOF) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.flushLocked()
+}
+
+func (a *AOF) flushLocked() error {
+	if len(a.buf) == 0 {
+		return nil
+	}
+	if err := a.write(a.buf); err != nil {
+		return err
+	}
+	if a.rewriting {
+		a.rewriteBuf = append(a.rewriteBuf, a.buf...)
+	}
+	a.buf = a.buf[:0]
+	if a.policy == FsyncAlways {
+		return a.file.Sync()
+	}
+	return nil
+}
+
+// write appends data to the file, sealing it first when the file is
+// encrypted, and keeps the size up to date.
+func (a *AOF) write(data []byte) error {
+	if a.enc == nil {
+		n, err := a.file.Write(data)
+		a.size += int64(n)
+		return err
+	}
+	a.enc.Write(data)
+	err := a.enc.Flush()
+	a.size = a.enc.Size()
+	return err
+}
+
+// isCurrent reports whether the log at path can be appended to as it is,
+// i.e. it exists and is encrypted exactly as new files would be.
+func isCurrent(path string) (bool, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	head := make([]byte, 64)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if !encryption.IsCurrent(head[:n]) {
+		fmt.Printf("AOF %s does not match the encryption settings, rewriting it\n", path)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (a *AOF) syncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			if err := a.file.Sync(); err != nil {
+				fmt.Printf("AOF fsync failed: %v\n", err)
+			}
+			a.mu.Unlock()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// NeedsRewrite reports whether the log has grown by at least percentage
+// percent since the last rewrite and is at least minSize bytes. A zero
+// percentage disables automatic rewrites.
+func (a *AOF) NeedsRewrite(percentage int, minSize int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if percentage <= 0 || a.rewriting || a.size < minSize {
+		return false
+	}
+	base := a.baseSize
+	if base == 0 {
+		base = 1
+	}
+	return (a.size-base)*100/base >= int64(percentage)
+}
+
+// Sizes returns the current size of the log and its size after the last
+// rewrite.
+func (a *AOF) Sizes() (current, base int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size, a.baseSize
+}
+
+// Rewriting reports whether a background rewrite is running.
+func (a *AOF) Rewriting() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rewriting
+}
+
+// StartRewrite begins a background rewrite from a snapshot of the current
+// dataset. It must be called from the goroutine that executes commands, so
+// that the snapshot and the start of the rewrite buffer line up exactly.
+func (a *AOF) StartRewrite() error {
+	a.mu.Lock()
+	if a.rewriting {
+		a.mu.Unlock()
+		return ErrRewriteInProgress
+	}
+	// Commands still buffered are part of the snapshot, so they must reach
+	// the old file now instead of also landing in the rewrite buffer
+	if err := a.flushLocked(); err != nil {
+		a.mu.Unlock()
+		return err
+	}
+	a.rewriting = true
+	a.rewriteBuf = nil
+	a.mu.Unlock()
+
+	snap := inMemory.GetInMemoryStore().Snapshot()
+	go func() {
+		if err := a.rewrite(snap); err != nil {
+			fmt.Printf("Background AOF rewrite failed: %v\n", err)
+			return
+		}
+		fmt.Println("Background AOF rewrite finished successfully")
+	}()
+	return nil
+}
+
+// rewrite writes snap to a temporary file, appends the commands flushed in
+// the meantime and atomically replaces the log with it.
+func (a *AOF) rewrite(snap *inMemory.Snapshot) error {
+	file, enc, err := writeRewrite(a.path, snap)
+	if err != nil {
+		a.abortRewrite()
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriting = false
+	if a.closed {
+		file.Close()
+		os.Remove(file.Name())
+		return errors.New("AOF closed during rewrite")
+	}
+	fail := func(err error) error {
+		a.rewriteBuf = nil
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if enc != nil {
+		enc.Write(a.rewriteBuf)
+		err = enc.Flush()
+	} else {
+		_, err = file.Write(a.rewriteBuf)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(file.Name(), a.path); err != nil {
+		return fail(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	a.file.Close()
+	a.file = file
+	a.enc = enc
+	a.size = info.Size()
+	a.baseSize = info.Size()
+	a.rewriteBuf = nil
+	return nil
+}
+
+func (a *AOF) abortRewrite() {
+	a.mu.Lock()
+	a.rewriting = false
+	a.rewriteBuf = nil
+	a.mu.Unlock()
+}
+
+// writeRewrite writes snap to a temporary file next to path and returns it
+// synced and open for appending, together with the writer that seals further
+// appends when encryption is enabled.
+func writeRewrite(path string, snap *inMemory.Snapshot) (*os.File, *encryption.Writer, error) {
+	defer snap.Release()
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	fail := func(err error) (*os.File, *encryption.Writer, error) {
+		file.Close()
+		os.Remove(tmp)
+		return nil, nil, err
+	}
+	var enc *encryption.Writer
+	if encryption.Enabled() {
+		if enc, err = encryption.NewWriter(file); err != nil {
+			return fail(err)
+		}
+		err = writeDataset(enc, snap)
+		if err == nil {
+			err = enc.Flush()
+		}
+	} else {
+		err = writeDataset(file, snap)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	return file, enc, nil
+}
+
+// Close flushes pending commands, syncs the file and closes it.
+func (a *AOF) Close() error {
+	close(a.done)
+	if err := a.Flush(); err != nil {
+		a.file.Close()
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// Load replays the log at path by passing every command frame to apply. A
+// final record cut short by a crash is truncated away with a warning; any
+// other malformed data is reported as an error. Encrypted logs are
+// decrypted first, and a chunk failing authentication is an error too.
+func Load(path string, apply func(frame interface{}) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	encrypted := encryption.IsEncrypted(data)
+	if encrypted {
+		plain, valid, err := encryption.DecryptLog(data)
+		if err != nil {
+			return fmt.Errorf("encrypted AOF %s: %w", path, err)
+		}
+		if valid < len(data) {
+			fmt.Printf("WARNING: AOF %s ends with a truncated chunk at offset %d, discarding the last %d bytes\n",
+				path, valid, len(data)-valid)
+			if err := os.Truncate(path, int64(valid)); err != nil {
+				return err
+			}
+		}
+		data = plain
+	}
+
+	pos := 0
+	for pos < len(data) {
+		frame, n, err := protocol.DecodeRESP(data[pos:])
+		if errors.Is(err, protocol.ErrIncomplete) && !encrypted {
+			fmt.Printf("WARNING: AOF %s ends with a truncated command at offset %d, discarding the last %d bytes\n",
+				path, pos, len(data)-pos)
+			return os.Truncate(path, int64(pos))
+		}
+		if err != nil {
+			return fmt.Errorf("bad AOF format at offset %d: %w", pos, err)
+		}
+		if err := apply(frame); err != nil {
+			return fmt.Errorf("AOF replay failed at offset %d: %w", pos, err)
+		}
+		pos += n
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package aof
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadCommands replays the log at path and returns its commands.
+func loadCommands(path string) ([][]string, error) {
+	var commands [][]string
+	err := Load(path, func(frame interface{}) error {
+		var args []string
+		for _, arg := range frame.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+		commands = append(commands, args)
+		return nil
+	})
+	return commands, err
+}
+
+func TestAppendFlushLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	a, err := Open(path, FsyncAlways)
+	if err != nil {
+		t.Fatalf("TestAppendFlushLoad failed: Open returned %v", err)
+	}
+	want := [][]string{
+		{"SET", "k", "v"},
+		{"RPUSH", "l", "x", "with space", ""},
+	}
+	for _, args := range want {
+		a.Append(args)
+	}
+
+	// Appended commands wait in the buffer until Flush
+	if current, _ := a.Sizes(); current != 0 {
+		t.Errorf("TestAppendFlushLoad failed: %d bytes written before Flush", current)
+	}
+	if err := a.Flush(); err != nil {
+		t.Fatalf("TestAppendFlushLoad failed: Flush returned %v", err)
+	}
+	info, err := os.Stat(path)
+	if current, _ := a.Sizes(); err != nil || info.Size() != current || current == 0 {
+		t.Errorf("TestAppendFlushLoad failed: file holds %d bytes, size is %d", info.Size(), current)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("TestAppendFlushLoad failed: Close returned %v", err)
+	}
+
+	got, err := loadCommands(path)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("TestAppendFlushLoad failed: loaded %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestLoadTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	complete := encodeCommand([]string{"SET", "k", "v"})
+	torn := encodeCommand([]string{"SET", "k2", "v2"})
+	data := append(append([]byte(nil), complete...), torn[:len(torn)-3]...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("TestLoadTruncated failed: %v", err)
+	}
+
+	// The torn final command is dropped and cut off the file
+	got, err := loadCommands(path)
+	if err != nil || len(got) != 1 || got[0][1] != "k" {
+		t.Errorf("TestLoadTruncated failed: loaded %q, %v", got, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(len(complete)) {
+		t.Errorf("TestLoadTruncated failed: file not truncated to %d bytes", len(complete))
+	}
+	if got, err := loadCommands(path); err != nil || len(got) != 1 {
+		t.Errorf("TestLoadTruncated failed: reloading gave %q, %v", got, err)
+	}
+
+	// Malformed data anywhere else is an error, and so is a failed command
+	if err := os.WriteFile(path, append([]byte("?bad\r\n"), complete...), 0644); err != nil {
+		t.Fatalf("TestLoadTruncated failed: %v", err)
+	}
+	if _, err := loadCommands(path); err == nil || !strings.Contains(err.Error(), "bad AOF format at offset 0") {
+		t.Errorf("TestLoadTruncated failed: malformed log gave %v", err)
+	}
+	if err := os.WriteFile(path, complete, 0644); err != nil {
+		t.Fatalf("TestLoadTruncated failed: %v", err)
+	}
+	errApply := errors.New("apply failed")
+	if err := Load(path, func(interface{}) error { return errApply }); !errors.Is(err, errApply) {
+		t.Errorf("TestLoadTruncated failed: failed replay gave %v", err)
+	}
+}
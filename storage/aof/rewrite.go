@@ -0,0 +1,153 @@
+package aof
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// itemsPerCommand caps how many elements a single rewritten command carries,
+// like AOF_REWRITE_ITEMS_PER_CMD in Redis.
+const itemsPerCommand = 64
+
+// commandWriter encodes commands to w and remembers the first write error.
+// Without w the commands are collected instead.
+type commandWriter struct {
+	w         *bufio.Writer
+	err       error
+	collected [][]string
+}
+
+func (cw *commandWriter) emit(args ...string) {
+	if cw.w == nil {
+		cw.collected = append(cw.collected, args)
+		return
+	}
+	if cw.err != nil {
+		return
+	}
+	_, cw.err = cw.w.Write(encodeCommand(args))
+}
+
+// emitBatched emits prefix followed by items, split into several commands
+// when there are more than itemsPerCommand items. Each item is a group of
+// arguments such as a field and its value.
+func (cw *commandWriter) emitBatched(prefix []string, items [][]string) {
+	for start := 0; start < len(items); start += itemsPerCommand {
+		end := start + itemsPerCommand
+		if end > len(items) {
+			end = len(items)
+		}
+		args := append([]string(nil), prefix...)
+		for _, item := range items[start:end] {
+			args = append(args, item...)
+		}
+		cw.emit(args...)
+	}
+}
+
+func unixMilli(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// writeDataset writes the shortest command log that recreates snap.
+func writeDataset(w io.Writer, snap *inMemory.Snapshot) error {
+	cw := &commandWriter{w: bufio.NewWriter(w)}
+	writeKeys(cw, snap)
+	if cw.err != nil {
+		return cw.err
+	}
+	return cw.w.Flush()
+}
+
+// Commands returns the commands that recreate snap, the same ones a rewrite
+// writes to the log.
+func Commands(snap *inMemory.Snapshot) [][]string {
+	cw := &commandWriter{}
+	writeKeys(cw, snap)
+	return cw.collected
+}
+
+func writeKeys(cw *commandWriter, snap *inMemory.Snapshot) {
+	snap.Range(func(key string, value interface{}, expTime time.Time) bool {
+		switch v := value.(type) {
+		case string:
+			cw.emit("SET", key, v)
+		case int:
+			cw.emit("SET", key, strconv.Itoa(v))
+		case *inMemory.List:
+			cw.emitBatched([]string{"RPUSH", key}, single(v.Values()))
+		case *inMemory.Hash:
+			writeHash(cw, key, v)
+		case *inMemory.Set:
+			cw.emitBatched([]string{"SADD", key}, single(v.Members()))
+		case *inMemory.SortedSet:
+			var items [][]string
+			for _, m := range v.Members() {
+				items = append(items, []string{strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member})
+			}
+			cw.emitBatched([]string{"ZADD", key}, items)
+		case *inMemory.Stream:
+			writeStream(cw, key, v)
+		}
+		if !expTime.IsZero() {
+			cw.emit("PEXPIREAT", key, unixMilli(expTime))
+		}
+		return cw.err == nil
+	})
+}
+
+func single(values []string) [][]string {
+	items := make([][]string, len(values))
+	for i, value := range values {
+		items[i] = []string{value}
+	}
+	return items
+}
+
+func writeHash(cw *commandWriter, key string, hash *inMemory.Hash) {
+	var items [][]string
+	for _, field := range hash.Fields() {
+		value, _ := hash.Get(field)
+		items = append(items, []string{field, value})
+	}
+	cw.emitBatched([]string{"HSET", key}, items)
+	for _, field := range hash.Fields() {
+		if expTime, ok := hash.GetExpiration(field); ok {
+			cw.emit("HPEXPIREAT", key, unixMilli(expTime), "FIELDS", "1", field)
+		}
+	}
+}
+
+// writeStream recreates the entries, the ID bookkeeping and the consumer
+// groups with their pending entries.
+func writeStream(cw *commandWriter, key string, stream *inMemory.Stream) {
+	entries := stream.Range(inMemory.StreamID{}, inMemory.MaxStreamID, -1, false)
+	if len(entries) == 0 {
+		// Create the empty stream with a placeholder entry trimmed right away
+		cw.emit("XADD", key, "MAXLEN", "0", "0-1", "x", "y")
+	}
+	for _, entry := range entries {
+		cw.emit(append([]string{"XADD", key, entry.ID.String()}, entry.Fields...)...)
+	}
+	cw.emit("XSETID", key, stream.LastID.String(),
+		"ENTRIESADDED", strconv.FormatUint(stream.EntriesAdded, 10),
+		"MAXDELETEDID", stream.MaxDeletedID.String())
+
+	for name, g := range stream.Groups {
+		cw.emit("XGROUP", "CREATE", key, name, g.LastDeliveredID.String(),
+			"ENTRIESREAD", strconv.FormatInt(g.EntriesRead, 10))
+		for consumer := range g.Consumers {
+			cw.emit("XGROUP", "CREATECONSUMER", key, name, consumer)
+		}
+		for id, p := range g.Pending {
+			cw.emit("XCLAIM", key, name, p.Consumer, "0", id.String(),
+				"TIME", unixMilli(p.DeliveryTime),
+				"RETRYCOUNT", strconv.Itoa(p.DeliveryCount),
+				"JUSTID", "FORCE")
+		}
+	}
+}
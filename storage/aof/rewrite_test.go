@@ -0,0 +1,63 @@
+package aof
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func TestRewrite(t *testing.T) {
+	store := inMemory.GetInMemoryStore()
+	store.Clear()
+	defer store.Clear()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "appendonly.aof")
+	a, err := Open(path, FsyncNo)
+	if err != nil {
+		t.Fatalf("TestRewrite failed: Open returned %v", err)
+	}
+	defer a.Close()
+
+	// The log grows with commands the dataset no longer needs
+	for i := 0; i < 10; i++ {
+		a.Append([]string{"SET", "k", "old"})
+	}
+	a.Flush()
+	store.Set("k", "v")
+	a.Append([]string{"SET", "k", "v"})
+	a.Flush()
+	if a.NeedsRewrite(0, 0) || a.NeedsRewrite(100, 1<<20) || !a.NeedsRewrite(100, 0) {
+		t.Errorf("TestRewrite failed: NeedsRewrite ignored its percentage or minimum size")
+	}
+
+	if err := a.StartRewrite(); err != nil {
+		t.Fatalf("TestRewrite failed: StartRewrite returned %v", err)
+	}
+	// Writes made while the rewrite runs follow the snapshot in the new log
+	a.Append([]string{"SET", "during", "1"})
+	a.Flush()
+	for deadline := time.Now().Add(5 * time.Second); a.Rewriting() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if a.Rewriting() {
+		t.Fatalf("TestRewrite failed: rewrite did not finish")
+	}
+	if a.NeedsRewrite(100, 0) {
+		t.Errorf("TestRewrite failed: rewrite needed right after one")
+	}
+
+	// The new log is in place and takes further writes
+	a.Append([]string{"SET", "after", "2"})
+	a.Flush()
+	want := [][]string{{"SET", "k", "v"}, {"SET", "during", "1"}, {"SET", "after", "2"}}
+	if got, err := loadCommands(path); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("TestRewrite failed: loaded %q, %v, want %q", got, err, want)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("TestRewrite failed: %d files left in the directory", len(entries))
+	}
+}
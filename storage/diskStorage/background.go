@@ -0,0 +1,98 @@
+package diskstorage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSaveInProgress is returned when a save is requested while a background
+// save is still running.
+var ErrSaveInProgress = errors.New("ERR Background save already in progress")
+
+// SaveStatus describes the state of snapshot persistence, as reported by
+// INFO persistence.
+type SaveStatus struct {
+	InProgress       bool
+	LastSaveTime     time.Time
+	LastBgsaveOK     bool
+	LastBgsaveTime   time.Duration // -1 until the first background save
+	CurrentStartTime time.Time
+	KeysProcessed    int64
+	KeysTotal        int64
+}
+
+var (
+	statusMu sync.Mutex
+	status   = SaveStatus{LastSaveTime: time.Now(), LastBgsaveOK: true, LastBgsaveTime: -1}
+
+	keysProcessed atomic.Int64
+)
+
+// Status returns a copy of the current persistence status.
+func Status() SaveStatus {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	current := status
+	if current.InProgress {
+		current.KeysProcessed = keysProcessed.Load()
+	}
+	return current
+}
+
+// SaveInProgress reports whether a background save is running.
+func SaveInProgress() bool {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	return status.InProgress
+}
+
+// RecordSave marks a successful foreground save.
+func RecordSave() {
+	statusMu.Lock()
+	status.LastSaveTime = time.Now()
+	statusMu.Unlock()
+}
+
+// BackgroundSave writes a point-in-time snapshot of the dataset to fileName
+// without blocking command processing. The snapshot is taken before it
+// returns, so it must be called from the goroutine that executes commands.
+func (ds *DiskStorage) BackgroundSave(fileName string) error {
+	statusMu.Lock()
+	if status.InProgress {
+		statusMu.Unlock()
+		return ErrSaveInProgress
+	}
+	snap := ds.inMemoryStore.Snapshot()
+	dirty := ds.inMemoryStore.Dirty()
+	start := time.Now()
+	status.InProgress = true
+	status.CurrentStartTime = start
+	status.KeysTotal = int64(snap.Len())
+	keysProcessed.Store(0)
+	statusMu.Unlock()
+
+	go func() {
+		err := ds.SaveSnapshot(fileName, snap, func() { keysProcessed.Add(1) })
+
+		statusMu.Lock()
+		status.InProgress = false
+		status.KeysProcessed = keysProcessed.Load()
+		status.LastBgsaveOK = err == nil
+		status.LastBgsaveTime = time.Since(start)
+		if err == nil {
+			status.LastSaveTime = time.Now()
+			ds.inMemoryStore.ResetDirty(dirty)
+		}
+		statusMu.Unlock()
+
+		if err != nil {
+			fmt.Printf("Background saving error: %v\n", err)
+		} else {
+			fmt.Println("Background saving terminated with success")
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,69 @@
+package diskstorage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc64"
+	"io"
+)
+
+// checksumLength is the size of the CRC64 trailer after the EOF marker.
+const checksumLength = 8
+
+// crcTable uses the Jones polynomial, the same CRC64 variant Redis uses for
+// its RDB files.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+var (
+	errChecksumMismatch = errors.New("checksum mismatch")
+	errMissingChecksum  = errors.New("missing checksum trailer, the file is truncated")
+)
+
+// checksumWriter buffers snapshot output and keeps a running CRC64 of it.
+// The first write error is remembered and returned by Close, so the record
+// writers do not have to check every call.
+type checksumWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash64
+	err error
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{
+		w:   bufio.NewWriter(w),
+		crc: crc64.New(crcTable),
+	}
+}
+
+func (cw *checksumWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	cw.crc.Write(p)
+	var n int
+	n, cw.err = cw.w.Write(p)
+	return n, cw.err
+}
+
+// Close appends the checksum trailer and flushes the buffer. It does not
+// close the underlying writer.
+func (cw *checksumWriter) Close() error {
+	if cw.err != nil {
+		return cw.err
+	}
+	var trailer [checksumLength]byte
+	binary.LittleEndian.PutUint64(trailer[:], cw.crc.Sum64())
+	if _, err := cw.w.Write(trailer[:]); err != nil {
+		return err
+	}
+	return cw.w.Flush()
+}
+
+func verifyChecksum(data, trailer []byte) error {
+	if crc64.Checksum(data, crcTable) != binary.LittleEndian.Uint64(trailer) {
+		return errChecksumMismatch
+	}
+	return nil
+}
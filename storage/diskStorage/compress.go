@@ -0,0 +1,51 @@
+package diskstorage
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+
+	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/utils"
+)
+
+var errCorruptCompressed = errors.New("corrupt compressed value")
+
+// compressValue deflates values of at least config.SnapshotCompressThreshold
+// bytes. The result is [RawLen][deflate data]; it is only used when it is
+// actually smaller than the raw value.
+func compressValue(value []byte) ([]byte, bool) {
+	threshold := config.SnapshotCompressThreshold
+	if threshold <= 0 || len(value) < threshold {
+		return nil, false
+	}
+	buf := bytes.NewBuffer(utils.EncodeVarIntBigEndian(len(value)))
+	fw, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := fw.Write(value); err != nil {
+		return nil, false
+	}
+	if err := fw.Close(); err != nil || buf.Len() >= len(value) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressValue is the inverse of compressValue. The recorded raw length
+// bounds the output, so a corrupt file can not inflate without limit.
+func decompressValue(data []byte) ([]byte, error) {
+	rawLen, pos, err := readVarIntAt(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	fr := flate.NewReader(bytes.NewReader(data[pos:]))
+	defer fr.Close()
+	value, err := io.ReadAll(io.LimitReader(fr, int64(rawLen)+1))
+	if err != nil || len(value) != rawLen {
+		return nil, errCorruptCompressed
+	}
+	return value, nil
+}
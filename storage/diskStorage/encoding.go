@@ -0,0 +1,397 @@
+package diskstorage
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/constant"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+	"github.com/bhaski-1234/redis-db/utils"
+)
+
+var errCorruptValue = errors.New("corrupt value in data file")
+
+// writeRecord writes a single [Type][KeyLen][Key][ValLen][Val] record,
+// compressing large values.
+func writeRecord(fs *checksumWriter, recordType byte, key string, value []byte) error {
+	if compressed, ok := compressValue(value); ok {
+		recordType |= constant.TypeCompressed
+		value = compressed
+	}
+	fs.Write([]byte{recordType})
+	fs.Write(utils.EncodeVarIntBigEndian(len(key)))
+	fs.Write([]byte(key))
+	fs.Write(utils.EncodeVarIntBigEndian(len(value)))
+	fs.Write(value)
+	return nil
+}
+
+// appendString appends a varint length prefixed string to buf.
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, utils.EncodeVarIntBigEndian(len(s))...)
+	return append(buf, s...)
+}
+
+// encodeStrings serialises a slice of strings as
+// [Count][Len][Bytes][Len][Bytes]...
+func encodeStrings(values []string) []byte {
+	buf := utils.EncodeVarIntBigEndian(len(values))
+	for _, v := range values {
+		buf = appendString(buf, v)
+	}
+	return buf
+}
+
+// maxVarIntLength is the longest varint that fits an int: 9 groups of 7
+// bits.
+const maxVarIntLength = 9
+
+// readVarIntAt decodes a varint starting at pos and returns it together with
+// the position just past it.
+func readVarIntAt(data []byte, pos int) (int, int, error) {
+	start := pos
+	for pos < len(data) && pos-start < maxVarIntLength {
+		if data[pos]&0x80 == 0 {
+			return int(utils.DecodeVarIntBigEndian(data[start : pos+1])), pos + 1, nil
+		}
+		pos++
+	}
+	return 0, 0, errCorruptValue
+}
+
+// readLengthAt decodes a varint length or count starting at pos, which can
+// not exceed the bytes left after it since every byte or element takes at
+// least one.
+func readLengthAt(data []byte, pos int) (int, int, error) {
+	n, pos, err := readVarIntAt(data, pos)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n > len(data)-pos {
+		return 0, 0, errCorruptValue
+	}
+	return n, pos, nil
+}
+
+// readStringAt decodes a length prefixed string starting at pos.
+func readStringAt(data []byte, pos int) (string, int, error) {
+	length, pos, err := readLengthAt(data, pos)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(data[pos : pos+length]), pos + length, nil
+}
+
+// decodeStrings is the inverse of encodeStrings.
+func decodeStrings(data []byte) ([]string, error) {
+	count, pos, err := readLengthAt(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		var v string
+		v, pos, err = readStringAt(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func writeList(fs *checksumWriter, key string, list []string) error {
+	return writeRecord(fs, constant.TypeList, key, encodeStrings(list))
+}
+
+// encodeHash serialises a hash as [Count] followed by
+// [Field][Value][ExpireMs] triples, where ExpireMs is 0 for persistent fields.
+func encodeHash(hash *inMemory.Hash) []byte {
+	fields := hash.Fields()
+	buf := utils.EncodeVarIntBigEndian(len(fields))
+	for _, field := range fields {
+		value, _ := hash.Get(field)
+		buf = appendString(buf, field)
+		buf = appendString(buf, value)
+		var expMs int64
+		if expTime, ok := hash.GetExpiration(field); ok {
+			expMs = expTime.UnixMilli()
+		}
+		buf = append(buf, utils.EncodeVarIntBigEndian(int(expMs))...)
+	}
+	return buf
+}
+
+// decodeHash is the inverse of encodeHash. Fields that expired while the
+// server was down are skipped.
+func decodeHash(data []byte) (*inMemory.Hash, error) {
+	count, pos, err := readLengthAt(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	hash := inMemory.NewHash()
+	now := time.Now()
+	for i := 0; i < count; i++ {
+		var field, value string
+		var expMs int
+		if field, pos, err = readStringAt(data, pos); err != nil {
+			return nil, err
+		}
+		if value, pos, err = readStringAt(data, pos); err != nil {
+			return nil, err
+		}
+		if expMs, pos, err = readVarIntAt(data, pos); err != nil {
+			return nil, err
+		}
+		if expMs == 0 {
+			hash.Set(field, value)
+			continue
+		}
+		expTime := time.UnixMilli(int64(expMs))
+		if expTime.After(now) {
+			hash.Set(field, value)
+			hash.SetExpiration(field, expTime)
+		}
+	}
+	return hash, nil
+}
+
+func writeHash(fs *checksumWriter, key string, hash *inMemory.Hash) error {
+	return writeRecord(fs, constant.TypeHash, key, encodeHash(hash))
+}
+
+func writeSet(fs *checksumWriter, key string, set *inMemory.Set) error {
+	return writeRecord(fs, constant.TypeSet, key, encodeStrings(set.Members()))
+}
+
+// encodeSortedSet serialises a sorted set as [Count] followed by
+// [Member][Score] pairs, with scores in their shortest exact decimal form.
+func encodeSortedSet(zset *inMemory.SortedSet) []byte {
+	members := zset.Members()
+	buf := utils.EncodeVarIntBigEndian(len(members))
+	for _, m := range members {
+		buf = appendString(buf, m.Member)
+		buf = appendString(buf, strconv.FormatFloat(m.Score, 'g', -1, 64))
+	}
+	return buf
+}
+
+func decodeSortedSet(data []byte) (*inMemory.SortedSet, error) {
+	count, pos, err := readLengthAt(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	zset := inMemory.NewSortedSet()
+	for i := 0; i < count; i++ {
+		var member, scoreStr string
+		if member, pos, err = readStringAt(data, pos); err != nil {
+			return nil, err
+		}
+		if scoreStr, pos, err = readStringAt(data, pos); err != nil {
+			return nil, err
+		}
+		score, err := strconv.ParseFloat(scoreStr, 64)
+		if err != nil {
+			return nil, errCorruptValue
+		}
+		zset.Add(member, score)
+	}
+	return zset, nil
+}
+
+func writeSortedSet(fs *checksumWriter, key string, zset *inMemory.SortedSet) error {
+	return writeRecord(fs, constant.TypeSortedSet, key, encodeSortedSet(zset))
+}
+
+// encodeStream serialises a stream as its metadata, the live entries and the
+// consumer groups:
+//
+//	[LastID][MaxDeletedID][EntriesAdded]
+//	[EntryCount] ([ID][Fields]...)
+//	[GroupCount] ([Name][LastDeliveredID][EntriesRead]
+//	             [PendingCount] ([ID][Consumer][DeliveryMs][DeliveryCount]...)
+//	             [ConsumerCount] ([Name][SeenMs][ActiveMs]...)...)
+//
+// IDs and 64-bit counters are written as decimal strings so that values
+// beyond the varint range survive the round trip.
+func encodeStream(stream *inMemory.Stream) []byte {
+	var buf []byte
+	buf = appendString(buf, stream.LastID.String())
+	buf = appendString(buf, stream.MaxDeletedID.String())
+	buf = appendString(buf, strconv.FormatUint(stream.EntriesAdded, 10))
+
+	entries := stream.Range(inMemory.StreamID{}, inMemory.MaxStreamID, -1, false)
+	buf = append(buf, utils.EncodeVarIntBigEndian(len(entries))...)
+	for _, entry := range entries {
+		buf = appendString(buf, entry.ID.String())
+		buf = append(buf, encodeStrings(entry.Fields)...)
+	}
+
+	buf = append(buf, utils.EncodeVarIntBigEndian(len(stream.Groups))...)
+	for _, g := range stream.Groups {
+		buf = appendString(buf, g.Name)
+		buf = appendString(buf, g.LastDeliveredID.String())
+		buf = appendString(buf, strconv.FormatInt(g.EntriesRead, 10))
+
+		pending := g.PendingRange(inMemory.StreamID{}, inMemory.MaxStreamID, "")
+		buf = append(buf, utils.EncodeVarIntBigEndian(len(pending))...)
+		for _, p := range pending {
+			buf = appendString(buf, p.ID.String())
+			buf = appendString(buf, p.Consumer)
+			buf = appendString(buf, strconv.FormatInt(p.DeliveryTime.UnixMilli(), 10))
+			buf = append(buf, utils.EncodeVarIntBigEndian(p.DeliveryCount)...)
+		}
+
+		buf = append(buf, utils.EncodeVarIntBigEndian(len(g.Consumers))...)
+		for _, c := range g.Consumers {
+			buf = appendString(buf, c.Name)
+			buf = appendString(buf, strconv.FormatInt(c.SeenTime.UnixMilli(), 10))
+			buf = appendString(buf, strconv.FormatInt(c.ActiveTime.UnixMilli(), 10))
+		}
+	}
+	return buf
+}
+
+// streamDecoder reads the fields of an encoded stream, remembering the
+// first error so the decoding code can stay linear.
+type streamDecoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *streamDecoder) varint() int {
+	if d.err != nil {
+		return 0
+	}
+	var n int
+	n, d.pos, d.err = readVarIntAt(d.data, d.pos)
+	return n
+}
+
+// count reads a number of elements, bounded by the bytes left.
+func (d *streamDecoder) count() int {
+	if d.err != nil {
+		return 0
+	}
+	var n int
+	n, d.pos, d.err = readLengthAt(d.data, d.pos)
+	return n
+}
+
+func (d *streamDecoder) str() string {
+	if d.err != nil {
+		return ""
+	}
+	var s string
+	s, d.pos, d.err = readStringAt(d.data, d.pos)
+	return s
+}
+
+func (d *streamDecoder) id() inMemory.StreamID {
+	s := d.str()
+	if d.err != nil {
+		return inMemory.StreamID{}
+	}
+	id, err := inMemory.ParseStreamID(s, 0)
+	if err != nil {
+		d.err = errCorruptValue
+	}
+	return id
+}
+
+func (d *streamDecoder) int64() int64 {
+	s := d.str()
+	if d.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		d.err = errCorruptValue
+	}
+	return n
+}
+
+func (d *streamDecoder) strings() []string {
+	if d.err != nil {
+		return nil
+	}
+	count := d.count()
+	values := make([]string, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		values = append(values, d.str())
+	}
+	return values
+}
+
+func decodeStream(data []byte) (*inMemory.Stream, error) {
+	d := &streamDecoder{data: data}
+	stream := inMemory.NewStream()
+
+	lastID := d.id()
+	maxDeletedID := d.id()
+	entriesAdded, err := strconv.ParseUint(d.str(), 10, 64)
+	if d.err == nil && err != nil {
+		d.err = errCorruptValue
+	}
+
+	entryCount := d.count()
+	for i := 0; i < entryCount && d.err == nil; i++ {
+		id := d.id()
+		fields := d.strings()
+		if d.err == nil {
+			stream.Append(id, fields)
+		}
+	}
+	stream.LastID = lastID
+	stream.MaxDeletedID = maxDeletedID
+	stream.EntriesAdded = entriesAdded
+
+	groupCount := d.count()
+	for i := 0; i < groupCount && d.err == nil; i++ {
+		name := d.str()
+		lastDelivered := d.id()
+		entriesRead := d.int64()
+		if d.err != nil {
+			break
+		}
+		stream.CreateGroup(name, lastDelivered, entriesRead)
+		g := stream.Groups[name]
+
+		pendingCount := d.count()
+		for j := 0; j < pendingCount && d.err == nil; j++ {
+			id := d.id()
+			consumerName := d.str()
+			deliveryTime := time.UnixMilli(d.int64())
+			deliveryCount := d.varint()
+			if d.err != nil {
+				break
+			}
+			consumer, _ := g.Consumer(consumerName, true)
+			g.Deliver(consumer, id, deliveryTime)
+			g.Pending[id].DeliveryCount = deliveryCount
+		}
+
+		consumerCount := d.count()
+		for j := 0; j < consumerCount && d.err == nil; j++ {
+			consumerName := d.str()
+			seen := time.UnixMilli(d.int64())
+			active := time.UnixMilli(d.int64())
+			if d.err != nil {
+				break
+			}
+			consumer, _ := g.Consumer(consumerName, true)
+			consumer.SeenTime = seen
+			consumer.ActiveTime = active
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return stream, nil
+}
+
+func writeStream(fs *checksumWriter, key string, stream *inMemory.Stream) error {
+	return writeRecord(fs, constant.TypeStream, key, encodeStream(stream))
+}
@@ -0,0 +1,185 @@
+package diskstorage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/constant"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// Checksum states reported by Inspect.
+const (
+	ChecksumOK         = "ok"
+	ChecksumMismatch   = "mismatch"
+	ChecksumAbsent     = "absent"  // legacy file written before checksums
+	ChecksumMissing    = "missing" // versioned file cut short
+	ChecksumUnverified = "unverified"
+)
+
+// Problem is a part of a snapshot file that could not be read.
+type Problem struct {
+	Offset int
+	Err    error
+}
+
+// RecordInfo describes one data record of a snapshot file. TTL records are
+// included with Type constant.TypeTTL and the expiration time as Value.
+type RecordInfo struct {
+	Offset int
+	Size   int // bytes the record takes in the file
+	Type   byte
+	Key    string
+	Value  interface{}
+	// Salvaged is set for records found by skipping past a problem, which
+	// are worth checking by hand.
+	Salvaged bool
+}
+
+// Inspection is the result of walking a native snapshot file.
+type Inspection struct {
+	Version  int
+	Aux      map[string]string
+	Records  []RecordInfo
+	Problems []Problem
+	Checksum string
+}
+
+// Inspect walks a native snapshot file record by record and reports what it
+// finds instead of failing on the first problem like Load. Without salvage
+// the walk stops at the first unreadable record; with salvage it skips ahead
+// to the next offset where two records in a row decode and carries on, so
+// the result holds every record that can still be recovered. The records
+// read after skipping are marked Salvaged.
+func Inspect(data []byte, salvage bool) *Inspection {
+	in := &Inspection{Aux: map[string]string{}, Checksum: ChecksumUnverified}
+	salvaged := false
+	snapshot, pos, err := readPreamble(data)
+	if err != nil {
+		in.Problems = append(in.Problems, Problem{Offset: 0, Err: err})
+		if !salvage || errors.Is(err, ErrNewerFormat) {
+			return in
+		}
+		snapshot = &snapshotFile{version: 1, aux: in.Aux}
+		pos = resync(snapshot, data, constant.HeaderLength)
+		salvaged = true
+	}
+	in.Version = snapshot.version
+	in.Aux = snapshot.aux
+
+	for {
+		if pos >= len(data) {
+			in.Problems = append(in.Problems, Problem{Offset: pos, Err: errors.New("unexpected end of file, missing EOF marker")})
+			return in
+		}
+		if data[pos] == constant.EOF {
+			pos++
+			break
+		}
+		r, next, err := readRecord(snapshot, data, pos)
+		if err != nil {
+			in.Problems = append(in.Problems, Problem{Offset: pos, Err: err})
+			if !salvage {
+				return in
+			}
+			pos = resync(snapshot, data, pos+1)
+			salvaged = true
+			continue
+		}
+		if r != nil {
+			in.Records = append(in.Records, RecordInfo{Offset: r.offset, Size: r.size, Type: r.recordType, Key: r.key, Value: r.value, Salvaged: salvaged})
+		}
+		pos = next
+	}
+
+	switch trailer := data[pos:]; len(trailer) {
+	case 0:
+		in.Checksum = ChecksumAbsent
+		if snapshot.version > 1 {
+			in.Checksum = ChecksumMissing
+			in.Problems = append(in.Problems, Problem{Offset: pos, Err: errMissingChecksum})
+		}
+	case checksumLength:
+		in.Checksum = ChecksumOK
+		if err := verifyChecksum(data[:pos], trailer); err != nil {
+			in.Checksum = ChecksumMismatch
+			in.Problems = append(in.Problems, Problem{Offset: pos, Err: err})
+		}
+	default:
+		in.Problems = append(in.Problems, Problem{Offset: pos, Err: fmt.Errorf("%d unexpected bytes after EOF marker", len(trailer))})
+	}
+	return in
+}
+
+// resync returns the first offset from pos on where the rest of the file
+// looks sound again, or len(data) if there is none: either the EOF marker
+// ends the file, or a record decodes completely and is followed by another
+// record that does or by the EOF marker. A single record decoding is
+// not enough, since garbage often happens to.
+func resync(snapshot *snapshotFile, data []byte, pos int) int {
+	for ; pos < len(data); pos++ {
+		if endsFile(data, pos) {
+			return pos
+		}
+		if data[pos] >= constant.OpFirstOptional {
+			continue
+		}
+		// Probe with a copy, so that optional records read by mistake do
+		// not end up in the aux fields
+		probe := &snapshotFile{version: snapshot.version, aux: map[string]string{}}
+		_, next, err := readRecord(probe, data, pos)
+		if err != nil {
+			continue
+		}
+		if endsFile(data, next) {
+			return pos
+		}
+		if next < len(data) {
+			if _, _, err := readRecord(probe, data, next); err == nil {
+				return pos
+			}
+		}
+	}
+	return len(data)
+}
+
+// endsFile reports whether the EOF marker at pos ends the file, followed by
+// nothing or a checksum.
+func endsFile(data []byte, pos int) bool {
+	if pos >= len(data) || data[pos] != constant.EOF {
+		return false
+	}
+	rest := len(data) - pos - 1
+	return rest == 0 || rest == checksumLength
+}
+
+// Snapshot assembles the records into a dataset that SaveSnapshot can
+// write, dropping keys that have already expired.
+func (in *Inspection) Snapshot() *inMemory.Snapshot {
+	snap := &inMemory.Snapshot{Values: map[string]interface{}{}, Expirations: map[string]time.Time{}}
+	for _, r := range in.Records {
+		if r.Type == constant.TypeTTL {
+			continue
+		}
+		if hash, ok := r.Value.(*inMemory.Hash); ok && hash.Len() == 0 {
+			continue
+		}
+		snap.Values[r.Key] = r.Value
+	}
+	now := time.Now()
+	for _, r := range in.Records {
+		if r.Type != constant.TypeTTL {
+			continue
+		}
+		if _, ok := snap.Values[r.Key]; !ok {
+			continue
+		}
+		if expTime := r.Value.(time.Time); expTime.After(now) {
+			snap.Expirations[r.Key] = expTime
+		} else {
+			delete(snap.Values, r.Key)
+		}
+	}
+	return snap
+}
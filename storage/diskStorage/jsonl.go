@@ -0,0 +1,451 @@
+package diskstorage
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+	"github.com/bhaski-1234/redis-db/utils"
+)
+
+// jsonRecord is one line of a JSON Lines export. ExpireAt and the hash
+// field expirations are absolute Unix times in milliseconds, omitted for
+// persistent keys and fields.
+type jsonRecord struct {
+	Key           string           `json:"key"`
+	Type          string           `json:"type"`
+	Encoding      string           `json:"encoding,omitempty"`
+	Value         json.RawMessage  `json:"value"`
+	ExpireAt      int64            `json:"expire_at_ms,omitempty"`
+	FieldExpireAt map[string]int64 `json:"field_expire_at_ms,omitempty"`
+}
+
+// jsonBase64 marks a record whose key and data strings are base64 encoded.
+// JSON strings can not carry bytes that are not valid UTF-8, so records
+// holding such bytes are written this way instead of being mangled.
+const jsonBase64 = "base64"
+
+// stringCodec converts the key and data strings of one record. Stream IDs,
+// scores and other bookkeeping values are never encoded.
+type stringCodec struct {
+	base64  bool
+	invalid bool  // a plain string was not valid UTF-8
+	err     error // first base64 decoding error
+}
+
+func (c *stringCodec) enc(s string) string {
+	if c.base64 {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	if !utf8.ValidString(s) {
+		c.invalid = true
+	}
+	return s
+}
+
+func (c *stringCodec) dec(s string) string {
+	if !c.base64 {
+		return s
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil && c.err == nil {
+		c.err = fmt.Errorf("invalid base64 string %q", s)
+	}
+	return string(b)
+}
+
+func (c *stringCodec) encAll(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = c.enc(v)
+	}
+	return out
+}
+
+func (c *stringCodec) decAll(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = c.dec(v)
+	}
+	return out
+}
+
+// Scores are strings so that inf and -inf survive, which JSON numbers can
+// not express.
+type jsonZMember struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+type jsonStream struct {
+	LastID       string            `json:"last_id"`
+	MaxDeletedID string            `json:"max_deleted_id"`
+	EntriesAdded uint64            `json:"entries_added"`
+	Entries      []jsonStreamEntry `json:"entries"`
+	Groups       []jsonGroup       `json:"groups,omitempty"`
+}
+
+type jsonStreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+type jsonGroup struct {
+	Name            string         `json:"name"`
+	LastDeliveredID string         `json:"last_delivered_id"`
+	EntriesRead     int64          `json:"entries_read"`
+	Pending         []jsonPending  `json:"pending"`
+	Consumers       []jsonConsumer `json:"consumers"`
+}
+
+type jsonPending struct {
+	ID            string `json:"id"`
+	Consumer      string `json:"consumer"`
+	DeliveredAt   int64  `json:"delivered_at_ms"`
+	DeliveryCount int    `json:"delivery_count"`
+}
+
+type jsonConsumer struct {
+	Name     string `json:"name"`
+	SeenAt   int64  `json:"seen_at_ms"`
+	ActiveAt int64  `json:"active_at_ms"`
+}
+
+// ExportJSON writes the keys of snap matching pattern as JSON Lines, one
+// key per line in key order, and returns how many were written. An empty
+// pattern matches every key.
+func ExportJSON(w io.Writer, snap *inMemory.Snapshot, pattern string) (int, error) {
+	// Records are built as the snapshot is read, since its values are only
+	// valid meanwhile, and sorted afterwards
+	var records []*jsonRecord
+	var keys []string
+	var err error
+	snap.Range(func(key string, value interface{}, expTime time.Time) bool {
+		if pattern != "" && !utils.MatchPattern(pattern, key) {
+			return true
+		}
+		var r *jsonRecord
+		r, err = encodeJSON(key, value, &stringCodec{})
+		if err == nil && r.Encoding == jsonBase64 {
+			r, err = encodeJSON(key, value, &stringCodec{base64: true})
+		}
+		if err != nil {
+			return false
+		}
+		if !expTime.IsZero() {
+			r.ExpireAt = expTime.UnixMilli()
+		}
+		records = append(records, r)
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Sort(byKey{keys, records})
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return 0, err
+		}
+	}
+	return len(records), bw.Flush()
+}
+
+// byKey sorts records by the keys they were built from.
+type byKey struct {
+	keys    []string
+	records []*jsonRecord
+}
+
+func (b byKey) Len() int           { return len(b.keys) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.records[i], b.records[j] = b.records[j], b.records[i]
+}
+
+// encodeJSON builds the record for one key. With a plain codec, a record
+// that turns out to need base64 comes back with Encoding set so the caller
+// can encode it again.
+func encodeJSON(key string, value interface{}, c *stringCodec) (*jsonRecord, error) {
+	r := &jsonRecord{Key: c.enc(key)}
+	var v interface{}
+	switch val := value.(type) {
+	case string:
+		r.Type, v = "string", c.enc(val)
+	case int:
+		r.Type, v = "string", strconv.Itoa(val)
+	case *inMemory.List:
+		r.Type, v = "list", c.encAll(val.Values())
+	case *inMemory.Set:
+		r.Type, v = "set", c.encAll(val.Members())
+	case *inMemory.Hash:
+		fields := map[string]string{}
+		for _, field := range val.Fields() {
+			value, _ := val.Get(field)
+			fields[c.enc(field)] = c.enc(value)
+			if expTime, ok := val.GetExpiration(field); ok {
+				if r.FieldExpireAt == nil {
+					r.FieldExpireAt = map[string]int64{}
+				}
+				r.FieldExpireAt[c.enc(field)] = expTime.UnixMilli()
+			}
+		}
+		r.Type, v = "hash", fields
+	case *inMemory.SortedSet:
+		members := []jsonZMember{}
+		for _, m := range val.Members() {
+			members = append(members, jsonZMember{Member: c.enc(m.Member), Score: strconv.FormatFloat(m.Score, 'g', -1, 64)})
+		}
+		r.Type, v = "zset", members
+	case *inMemory.Stream:
+		r.Type, v = "stream", encodeJSONStream(val, c)
+	default:
+		return nil, fmt.Errorf("key %q: unsupported type %T", key, value)
+	}
+	if c.base64 || c.invalid {
+		r.Encoding = jsonBase64
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	r.Value = raw
+	return r, nil
+}
+
+func encodeJSONStream(stream *inMemory.Stream, c *stringCodec) *jsonStream {
+	s := &jsonStream{
+		LastID:       stream.LastID.String(),
+		MaxDeletedID: stream.MaxDeletedID.String(),
+		EntriesAdded: stream.EntriesAdded,
+		Entries:      []jsonStreamEntry{},
+	}
+	for _, entry := range stream.Range(inMemory.StreamID{}, inMemory.MaxStreamID, -1, false) {
+		s.Entries = append(s.Entries, jsonStreamEntry{ID: entry.ID.String(), Fields: c.encAll(entry.Fields)})
+	}
+	names := make([]string, 0, len(stream.Groups))
+	for name := range stream.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g := stream.Groups[name]
+		group := jsonGroup{
+			Name:            c.enc(name),
+			LastDeliveredID: g.LastDeliveredID.String(),
+			EntriesRead:     g.EntriesRead,
+			Pending:         []jsonPending{},
+			Consumers:       []jsonConsumer{},
+		}
+		for _, p := range g.PendingRange(inMemory.StreamID{}, inMemory.MaxStreamID, "") {
+			group.Pending = append(group.Pending, jsonPending{
+				ID:            p.ID.String(),
+				Consumer:      c.enc(p.Consumer),
+				DeliveredAt:   p.DeliveryTime.UnixMilli(),
+				DeliveryCount: p.DeliveryCount,
+			})
+		}
+		for _, consumer := range g.Consumers {
+			group.Consumers = append(group.Consumers, jsonConsumer{
+				Name:     c.enc(consumer.Name),
+				SeenAt:   consumer.SeenTime.UnixMilli(),
+				ActiveAt: consumer.ActiveTime.UnixMilli(),
+			})
+		}
+		sort.Slice(group.Consumers, func(i, j int) bool { return group.Consumers[i].Name < group.Consumers[j].Name })
+		s.Groups = append(s.Groups, group)
+	}
+	return s
+}
+
+// ParseJSON reads a JSON Lines export and returns the keys matching
+// pattern as a dataset. Keys that have already expired are left out. Blank
+// lines are ignored; any malformed line is an error naming its number.
+func ParseJSON(r io.Reader, pattern string) (*inMemory.Snapshot, error) {
+	snap := &inMemory.Snapshot{Values: map[string]interface{}{}, Expirations: map[string]time.Time{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 512<<20)
+	now := time.Now()
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec jsonRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Encoding != "" && rec.Encoding != jsonBase64 {
+			return nil, fmt.Errorf("line %d: unknown encoding %q", line, rec.Encoding)
+		}
+		c := &stringCodec{base64: rec.Encoding == jsonBase64}
+		key := c.dec(rec.Key)
+		if pattern != "" && !utils.MatchPattern(pattern, key) {
+			continue
+		}
+		value, err := decodeJSON(&rec, c, now)
+		if err == nil {
+			err = c.err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: key %q: %w", line, rec.Key, err)
+		}
+		if hash, ok := value.(*inMemory.Hash); ok && hash.Len() == 0 {
+			continue
+		}
+		if rec.ExpireAt != 0 {
+			expTime := time.UnixMilli(rec.ExpireAt)
+			if !expTime.After(now) {
+				continue
+			}
+			snap.Expirations[key] = expTime
+		}
+		snap.Values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func decodeJSON(rec *jsonRecord, c *stringCodec, now time.Time) (interface{}, error) {
+	switch rec.Type {
+	case "string":
+		var s string
+		err := json.Unmarshal(rec.Value, &s)
+		return c.dec(s), err
+	case "list":
+		var items []string
+		if err := json.Unmarshal(rec.Value, &items); err != nil {
+			return nil, err
+		}
+		list := inMemory.NewList()
+		for _, item := range c.decAll(items) {
+			list.PushBack(item)
+		}
+		return list, nil
+	case "set":
+		var members []string
+		if err := json.Unmarshal(rec.Value, &members); err != nil {
+			return nil, err
+		}
+		set := inMemory.NewSet()
+		for _, member := range c.decAll(members) {
+			set.Add(member)
+		}
+		return set, nil
+	case "hash":
+		var fields map[string]string
+		if err := json.Unmarshal(rec.Value, &fields); err != nil {
+			return nil, err
+		}
+		hash := inMemory.NewHash()
+		for field, value := range fields {
+			expMs, ok := rec.FieldExpireAt[field]
+			if ok && !time.UnixMilli(expMs).After(now) {
+				continue
+			}
+			hash.Set(c.dec(field), c.dec(value))
+			if ok {
+				hash.SetExpiration(c.dec(field), time.UnixMilli(expMs))
+			}
+		}
+		return hash, nil
+	case "zset":
+		var members []jsonZMember
+		if err := json.Unmarshal(rec.Value, &members); err != nil {
+			return nil, err
+		}
+		zset := inMemory.NewSortedSet()
+		for _, m := range members {
+			score, err := strconv.ParseFloat(m.Score, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid score %q", m.Score)
+			}
+			zset.Add(c.dec(m.Member), score)
+		}
+		return zset, nil
+	case "stream":
+		var s jsonStream
+		if err := json.Unmarshal(rec.Value, &s); err != nil {
+			return nil, err
+		}
+		return decodeJSONStream(&s, c)
+	}
+	return nil, fmt.Errorf("unknown type %q", rec.Type)
+}
+
+func decodeJSONStream(s *jsonStream, c *stringCodec) (*inMemory.Stream, error) {
+	parseID := func(id string) (inMemory.StreamID, error) {
+		parsed, err := inMemory.ParseStreamID(id, 0)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid stream ID %q", id)
+		}
+		return parsed, nil
+	}
+
+	stream := inMemory.NewStream()
+	for _, entry := range s.Entries {
+		id, err := parseID(entry.ID)
+		if err != nil {
+			return nil, err
+		}
+		stream.Append(id, c.decAll(entry.Fields))
+	}
+	var err error
+	if stream.LastID, err = parseID(s.LastID); err != nil {
+		return nil, err
+	}
+	if stream.MaxDeletedID, err = parseID(s.MaxDeletedID); err != nil {
+		return nil, err
+	}
+	stream.EntriesAdded = s.EntriesAdded
+
+	for _, group := range s.Groups {
+		lastDelivered, err := parseID(group.LastDeliveredID)
+		if err != nil {
+			return nil, err
+		}
+		name := c.dec(group.Name)
+		stream.CreateGroup(name, lastDelivered, group.EntriesRead)
+		g := stream.Groups[name]
+		for _, p := range group.Pending {
+			id, err := parseID(p.ID)
+			if err != nil {
+				return nil, err
+			}
+			consumer, _ := g.Consumer(c.dec(p.Consumer), true)
+			g.Deliver(consumer, id, time.UnixMilli(p.DeliveredAt))
+			g.Pending[id].DeliveryCount = p.DeliveryCount
+		}
+		for _, jc := range group.Consumers {
+			consumer, _ := g.Consumer(c.dec(jc.Name), true)
+			consumer.SeenTime = time.UnixMilli(jc.SeenAt)
+			consumer.ActiveTime = time.UnixMilli(jc.ActiveAt)
+		}
+	}
+	return stream, nil
+}
+
+// Restore stores every key of snap, replacing keys of the same name
+// together with their expirations. Other keys are left alone.
+func (ds *DiskStorage) Restore(snap *inMemory.Snapshot) {
+	for key, value := range snap.Values {
+		ds.inMemoryStore.Delete(key)
+		ds.inMemoryStore.SetValue(key, value)
+		if expTime, ok := snap.Expirations[key]; ok {
+			ds.inMemoryStore.SetExpiration(key, expTime)
+		}
+	}
+}
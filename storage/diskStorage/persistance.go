@@ -2,12 +2,18 @@ package diskstorage
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
+	"github.com/bhaski-1234/redis-db/config"
 	"github.com/bhaski-1234/redis-db/constant"
+	"github.com/bhaski-1234/redis-db/storage/encryption"
 	"github.com/bhaski-1234/redis-db/storage/inMemory"
+	"github.com/bhaski-1234/redis-db/storage/rdb"
 	"github.com/bhaski-1234/redis-db/utils"
 )
 
@@ -21,144 +27,436 @@ func NewDiskStorage() *DiskStorage {
 	}
 }
 
+// Save writes a snapshot of the current dataset to fileName.
 func (ds *DiskStorage) Save(fileName string) error {
-	//format
-	// [Header] [Type][KeyLen][Key][ValLen][Val] [Type][KeyLen][Key][ValLen][Val] [EOF]
-	// Implement the logic to save data to a file
-	fs, err := os.OpenFile(fileName+constant.DataFileExtension, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	dirty := ds.inMemoryStore.Dirty()
+	if err := ds.SaveSnapshot(fileName, ds.inMemoryStore.Snapshot(), nil); err != nil {
+		return err
+	}
+	ds.inMemoryStore.ResetDirty(dirty)
+	return nil
+}
+
+// SaveSnapshot writes snap to fileName. progress, if set, is called after
+// every key written.
+//
+// The snapshot is written to a temporary file that is synced and renamed
+// over the old one, so a crash mid-save never destroys the previous copy.
+func (ds *DiskStorage) SaveSnapshot(fileName string, snap *inMemory.Snapshot, progress func()) error {
+	defer snap.Release()
+	path := fileName + constant.DataFileExtension
+	dir := filepath.Dir(path)
+	tmp := filepath.Join(dir, fmt.Sprintf("temp-%d%s", os.Getpid(), constant.DataFileExtension))
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = writeFormatted(file, snap, progress)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Make the rename itself durable
+	return syncDir(dir)
+}
+
+// writeFormatted writes snap in the configured format, sealed when
+// encryption is enabled.
+func writeFormatted(file io.Writer, snap *inMemory.Snapshot, progress func()) error {
+	if !encryption.Enabled() {
+		return writeFormat(file, snap, progress)
+	}
+	enc, err := encryption.NewWriter(file)
 	if err != nil {
 		return err
 	}
-	defer fs.Close()
-	// Write header
+	if err := writeFormat(enc, snap, progress); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func writeFormat(file io.Writer, snap *inMemory.Snapshot, progress func()) error {
+	if config.SnapshotFormat == config.SnapshotFormatRedis {
+		return rdb.Write(file, snap, progress)
+	}
+	return writeSnapshot(file, snap, progress)
+}
+
+// writeSnapshot writes snap in the native format.
+func writeSnapshot(file io.Writer, snap *inMemory.Snapshot, progress func()) error {
+	//format
+	// [Header] [OpVersion][Version] [OpAux][Len][Key][Value]...
+	// [Type][KeyLen][Key][ValLen][Val] [Type][KeyLen][Key][ValLen][Val] [EOF] [CRC64]
+	fs := newChecksumWriter(file)
+	// Write header and format version
 	fs.Write([]byte(constant.Header))
+	fs.Write([]byte{constant.OpVersion})
+	fs.Write(utils.EncodeVarIntBigEndian(constant.FormatVersion))
+
+	// Write metadata
+	writeAux(fs, "server-version", constant.ServerVersion)
+	writeAux(fs, "ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	writeAux(fs, "keys", strconv.Itoa(snap.Len()))
 
-	// Save all key-values
-	ds.inMemoryStore.Store.Range(func(key, value interface{}) bool {
-		keyStr, _ := key.(string)
+	// Save all key-values, each followed by its TTL
+	snap.Range(func(keyStr string, value interface{}, expTime time.Time) bool {
 		switch value.(type) {
 		case string:
 			writeString(fs, keyStr, value.(string))
 		case int:
 			writeInt(fs, keyStr, value.(int))
+		case *inMemory.List:
+			writeList(fs, keyStr, value.(*inMemory.List).Values())
+		case *inMemory.Hash:
+			writeHash(fs, keyStr, value.(*inMemory.Hash))
+		case *inMemory.Set:
+			writeSet(fs, keyStr, value.(*inMemory.Set))
+		case *inMemory.SortedSet:
+			writeSortedSet(fs, keyStr, value.(*inMemory.SortedSet))
+		case *inMemory.Stream:
+			writeStream(fs, keyStr, value.(*inMemory.Stream))
 		case []byte:
 			//TODO
 		}
-		return true
-	})
-
-	// Save all TTL information
-	ds.inMemoryStore.GetExpirations(func(key string, expTime time.Time) bool {
-		writeTTL(fs, key, expTime)
+		if !expTime.IsZero() {
+			writeTTL(fs, keyStr, expTime)
+		}
+		if progress != nil {
+			progress()
+		}
 		return true
 	})
 
 	fs.Write([]byte{constant.EOF})
-	return nil
+	return fs.Close()
 }
 
-func (ds *DiskStorage) Load(fileName string) error {
-	ds.inMemoryStore.Clear()
-	fs, err := os.OpenFile(fileName+constant.DataFileExtension, os.O_RDONLY, 0644)
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	defer fs.Close()
+	defer d.Close()
+	return d.Sync()
+}
+
+// ErrNewerFormat is returned for snapshots written by a newer version that
+// uses a format this binary does not understand.
+var ErrNewerFormat = errors.New("snapshot format is newer than this server supports")
+
+// record is a decoded snapshot entry waiting to be applied to the store.
+type record struct {
+	recordType byte
+	key        string
+	value      interface{}
+
+	// offset and size locate the record in the file.
+	offset int
+	size   int
+}
 
-	// Read and verify header
-	header := make([]byte, len(constant.Header))
-	if _, err := fs.Read(header); err != nil {
+// snapshotFile is the decoded content of a snapshot file. Files without a
+// version record predate versioning and are reported as version 1.
+type snapshotFile struct {
+	version int
+	aux     map[string]string
+	records []record
+}
+
+// Load replaces the dataset with the snapshot in fileName. The whole file is
+// decoded and its checksum verified first, so a corrupt snapshot is
+// reported without touching the data in memory.
+func (ds *DiskStorage) Load(fileName string) error {
+	path := fileName + constant.DataFileExtension
+	data, err := os.ReadFile(path)
+	if err != nil {
 		return err
 	}
-	if string(header) != constant.Header {
-		return errors.New("invalid file header")
+	if !encryption.IsEncrypted(data) && encryption.Enabled() {
+		fmt.Printf("WARNING: snapshot %s is not encrypted, it will be encrypted on the next save\n", path)
 	}
+	return ds.LoadData(path, data)
+}
 
-	for {
-		// Read type
-		typeBuf := make([]byte, 1)
-		if _, err := fs.Read(typeBuf); err != nil {
-			return err
-		}
-		if typeBuf[0] == constant.EOF {
-			break
-		}
+// WriteSnapshot writes snap to w in the native format, unencrypted, e.g. to
+// send it to a replica.
+func WriteSnapshot(w io.Writer, snap *inMemory.Snapshot) error {
+	defer snap.Release()
+	return writeSnapshot(w, snap, nil)
+}
 
-		// Read key length (varint)
-		keyLen, err := readVarInt(fs)
-		if err != nil {
-			return err
-		}
-		// Read key
-		keyBuf := make([]byte, keyLen)
-		if _, err := fs.Read(keyBuf); err != nil {
-			return err
+// LoadData replaces the dataset with the snapshot in data, like Load. path
+// names its source in errors.
+func (ds *DiskStorage) LoadData(path string, data []byte) error {
+	var err error
+	if encryption.IsEncrypted(data) {
+		if data, err = encryption.Decrypt(data); err != nil {
+			return fmt.Errorf("encrypted snapshot %s: %w", path, err)
 		}
-
-		// Read value length (varint)
-		valLen, err := readVarInt(fs)
+	}
+	if rdb.IsRDB(data) {
+		// Snapshots of stock Redis are imported as they are
+		snap, err := rdb.Read(data)
 		if err != nil {
-			return err
+			return fmt.Errorf("Redis RDB file %s: %w", path, err)
 		}
-		// Read value
-		valBuf := make([]byte, valLen)
-		if _, err := fs.Read(valBuf); err != nil {
-			return err
+		ds.inMemoryStore.Clear()
+		for key, value := range snap.Values {
+			ds.inMemoryStore.SetValue(key, value)
 		}
+		for key, expTime := range snap.Expirations {
+			ds.inMemoryStore.SetExpiration(key, expTime)
+		}
+		return nil
+	}
 
-		keyStr := string(keyBuf)
-		switch typeBuf[0] {
+	snapshot, err := parseSnapshot(data)
+	if errors.Is(err, ErrNewerFormat) {
+		return fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	if err != nil {
+		return fmt.Errorf("snapshot %s is corrupt: %w", path, err)
+	}
+
+	ds.inMemoryStore.Clear()
+	now := time.Now()
+	for _, r := range snapshot.records {
+		switch r.recordType {
 		case constant.TypeString:
-			ds.inMemoryStore.Set(keyStr, string(valBuf))
+			ds.inMemoryStore.Set(r.key, r.value.(string))
 		case constant.TypeInteger:
-			intVal, _ := strconv.Atoi(string(valBuf))
-			ds.inMemoryStore.Store.Store(keyStr, intVal)
+			ds.inMemoryStore.SetValue(r.key, r.value)
+		case constant.TypeHash:
+			if r.value.(*inMemory.Hash).Len() > 0 {
+				ds.inMemoryStore.SetValue(r.key, r.value)
+			}
 		case constant.TypeTTL:
-			// TTL is stored as timestamp in milliseconds
-			ttlMs, _ := strconv.ParseInt(string(valBuf), 10, 64)
-			expTime := time.Unix(0, ttlMs*int64(time.Millisecond))
-
 			// Skip expired keys
-			if time.Now().Before(expTime) {
-				ds.inMemoryStore.SetExpiration(keyStr, expTime)
+			expTime := r.value.(time.Time)
+			if now.Before(expTime) {
+				ds.inMemoryStore.SetExpiration(r.key, expTime)
 			} else {
 				// If expired, delete the key
-				ds.inMemoryStore.Delete(keyStr)
+				ds.inMemoryStore.Delete(r.key)
 			}
+		default:
+			ds.inMemoryStore.SetValue(r.key, r.value)
 		}
 	}
 	return nil
 }
 
-// Helper to read a varint from file
-func readVarInt(fs *os.File) (int, error) {
-	var buf []byte
+// parseSnapshot decodes every record of a snapshot file and verifies the
+// CRC64 trailer. Only unversioned files may lack the trailer, as those
+// written before it was introduced end right after the EOF marker; every
+// versioned file has one, so a missing trailer there means truncation.
+func parseSnapshot(data []byte) (*snapshotFile, error) {
+	snapshot, pos, err := readPreamble(data)
+	if err != nil {
+		return nil, err
+	}
+	// A versioned file that ends in its trailer is checked before any
+	// record is decoded, so corruption is reported as such
+	if end := len(data) - checksumLength; snapshot.version > 1 && end > pos && data[end-1] == constant.EOF {
+		if err := verifyChecksum(data[:end], data[end:]); err != nil {
+			return nil, err
+		}
+	}
+
 	for {
-		b := make([]byte, 1)
-		if _, err := fs.Read(b); err != nil {
-			return 0, err
+		if pos >= len(data) {
+			return nil, errors.New("unexpected end of file, missing EOF marker")
 		}
-		buf = append(buf, b[0])
-		if b[0]&0x80 == 0 {
+		if data[pos] == constant.EOF {
+			pos++
 			break
 		}
+		r, next, err := readRecord(snapshot, data, pos)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			snapshot.records = append(snapshot.records, *r)
+		}
+		pos = next
 	}
-	return int(utils.DecodeVarIntBigEndian(buf)), nil
+
+	switch trailer := data[pos:]; len(trailer) {
+	case 0:
+		if snapshot.version > 1 {
+			return nil, errMissingChecksum
+		}
+	case checksumLength:
+		if err := verifyChecksum(data[:pos], trailer); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%d unexpected bytes after EOF marker", len(trailer))
+	}
+	return snapshot, nil
 }
 
-func writeString(fs *os.File, key string, value string) error {
-	fs.Write([]byte{constant.TypeString})
-	// Get the varint length of the key and value
-	keyBytes := utils.EncodeVarIntBigEndian(len(key))
-	valueBytes := utils.EncodeVarIntBigEndian(len(value))
-	fs.Write(keyBytes)
-	fs.Write([]byte(key))
-	fs.Write(valueBytes)
-	fs.Write([]byte(value))
-	return nil
+// readPreamble checks the header and reads the version record, returning
+// the position of the first record.
+func readPreamble(data []byte) (*snapshotFile, int, error) {
+	if len(data) < constant.HeaderLength || string(data[:constant.HeaderLength]) != constant.Header {
+		return nil, 0, errors.New("invalid file header")
+	}
+
+	snapshot := &snapshotFile{version: 1, aux: map[string]string{}}
+	pos := constant.HeaderLength
+	if pos < len(data) && data[pos] == constant.OpVersion {
+		version, next, err := readVarIntAt(data, pos+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		if version > constant.FormatVersion {
+			return nil, 0, fmt.Errorf("%w (file version %d, supported up to %d)", ErrNewerFormat, version, constant.FormatVersion)
+		}
+		snapshot.version = version
+		pos = next
+	}
+	return snapshot, pos, nil
+}
+
+// readRecord reads the record starting at pos and returns the position
+// after it. Optional records are applied to snapshot and yield no record.
+func readRecord(snapshot *snapshotFile, data []byte, pos int) (*record, int, error) {
+	recordType := data[pos]
+	if recordType >= constant.OpFirstOptional {
+		next, err := readOptional(snapshot, recordType, data, pos+1)
+		if err != nil {
+			return nil, 0, fmt.Errorf("record at offset %d: %w", pos, err)
+		}
+		return nil, next, nil
+	}
+
+	keyStr, next, err := readStringAt(data, pos+1)
+	if err != nil {
+		return nil, 0, fmt.Errorf("record at offset %d: %w", pos, err)
+	}
+	valLen, next, err := readLengthAt(data, next)
+	if err != nil {
+		return nil, 0, fmt.Errorf("record at offset %d: %w", pos, errCorruptValue)
+	}
+	valBuf := data[next : next+valLen]
+	if recordType&constant.TypeCompressed != 0 {
+		recordType &^= constant.TypeCompressed
+		if valBuf, err = decompressValue(valBuf); err != nil {
+			return nil, 0, fmt.Errorf("record %q at offset %d: %w", keyStr, pos, err)
+		}
+	}
+
+	value, err := decodeValue(recordType, valBuf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("record %q at offset %d: %w", keyStr, pos, err)
+	}
+	r := &record{recordType: recordType, key: keyStr, value: value, offset: pos, size: next + valLen - pos}
+	return r, next + valLen, nil
+}
+
+// readOptional reads a length-prefixed optional record starting after its
+// opcode and returns the position after it. Unknown optional records are
+// skipped.
+func readOptional(snapshot *snapshotFile, opcode byte, data []byte, pos int) (int, error) {
+	if opcode == constant.OpVersion {
+		return 0, errors.New("unexpected version record")
+	}
+	length, pos, err := readLengthAt(data, pos)
+	if err != nil {
+		return 0, errCorruptValue
+	}
+	payload := data[pos : pos+length]
+	if opcode == constant.OpAux {
+		key, next, err := readStringAt(payload, 0)
+		if err != nil {
+			return 0, err
+		}
+		value, _, err := readStringAt(payload, next)
+		if err != nil {
+			return 0, err
+		}
+		snapshot.aux[key] = value
+	}
+	return pos + length, nil
+}
+
+// writeAux writes a metadata record.
+func writeAux(fs *checksumWriter, key, value string) {
+	payload := appendString(appendString(nil, key), value)
+	fs.Write([]byte{constant.OpAux})
+	fs.Write(utils.EncodeVarIntBigEndian(len(payload)))
+	fs.Write(payload)
+}
+
+// decodeValue decodes the value of a data record. Unknown data types are an
+// error, since skipping them would silently lose keys.
+func decodeValue(recordType byte, valBuf []byte) (interface{}, error) {
+	switch recordType {
+	case constant.TypeString:
+		return string(valBuf), nil
+	case constant.TypeInteger:
+		intVal, err := strconv.Atoi(string(valBuf))
+		if err != nil {
+			return nil, errCorruptValue
+		}
+		return intVal, nil
+	case constant.TypeList:
+		values, err := decodeStrings(valBuf)
+		if err != nil {
+			return nil, err
+		}
+		list := inMemory.NewList()
+		for _, v := range values {
+			list.PushBack(v)
+		}
+		return list, nil
+	case constant.TypeHash:
+		return decodeHash(valBuf)
+	case constant.TypeSet:
+		members, err := decodeStrings(valBuf)
+		if err != nil {
+			return nil, err
+		}
+		set := inMemory.NewSet()
+		for _, member := range members {
+			set.Add(member)
+		}
+		return set, nil
+	case constant.TypeSortedSet:
+		return decodeSortedSet(valBuf)
+	case constant.TypeStream:
+		return decodeStream(valBuf)
+	case constant.TypeTTL:
+		// TTL is stored as timestamp in milliseconds
+		ttlMs, err := strconv.ParseInt(string(valBuf), 10, 64)
+		if err != nil {
+			return nil, errCorruptValue
+		}
+		return time.Unix(0, ttlMs*int64(time.Millisecond)), nil
+	}
+	return nil, fmt.Errorf("unknown record type 0x%02x", recordType)
+}
+
+func writeString(fs *checksumWriter, key string, value string) error {
+	return writeRecord(fs, constant.TypeString, key, []byte(value))
 }
 
-func writeInt(fs *os.File, key string, value int) error {
+func writeInt(fs *checksumWriter, key string, value int) error {
 	valueStr := strconv.Itoa(value)
 	fs.Write([]byte{constant.TypeInteger})
 	// Get the varint length of the key
@@ -171,7 +469,7 @@ func writeInt(fs *os.File, key string, value int) error {
 	return nil
 }
 
-func writeTTL(fs *os.File, key string, expTime time.Time) error {
+func writeTTL(fs *checksumWriter, key string, expTime time.Time) error {
 	fs.Write([]byte{constant.TypeTTL})
 	// Write key
 	keyBytes := utils.EncodeVarIntBigEndian(len(key))
@@ -0,0 +1,276 @@
+package diskstorage
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/config"
+	"github.com/bhaski-1234/redis-db/constant"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+	"github.com/bhaski-1234/redis-db/utils"
+)
+
+// buildSnapshot assembles a snapshot file from raw record bytes and appends
+// the checksum trailer.
+func buildSnapshot(version int, body ...[]byte) []byte {
+	var buf bytes.Buffer
+	fs := newChecksumWriter(&buf)
+	fs.Write([]byte(constant.Header))
+	if version > 0 {
+		fs.Write([]byte{constant.OpVersion})
+		fs.Write(utils.EncodeVarIntBigEndian(version))
+	}
+	for _, b := range body {
+		fs.Write(b)
+	}
+	fs.Write([]byte{constant.EOF})
+	fs.Close()
+	return buf.Bytes()
+}
+
+func stringRecord(key, value string) []byte {
+	b := []byte{constant.TypeString}
+	b = appendString(b, key)
+	return appendString(b, value)
+}
+
+func TestParseSnapshotVersions(t *testing.T) {
+	// Legacy files have neither a version record nor a checksum
+	legacy := append([]byte(constant.Header), stringRecord("k", "v")...)
+	legacy = append(legacy, constant.EOF)
+	snapshot, err := parseSnapshot(legacy)
+	if err != nil || snapshot.version != 1 || len(snapshot.records) != 1 {
+		t.Fatalf("TestParseSnapshotVersions legacy file: got %+v, %v", snapshot, err)
+	}
+
+	// Unknown optional records are skipped, aux metadata is collected
+	aux := appendString(appendString(nil, "ctime"), "123")
+	optional := append([]byte{constant.OpAux}, utils.EncodeVarIntBigEndian(len(aux))...)
+	optional = append(optional, aux...)
+	unknown := []byte{0x90, 0x02, 'x', 'y'}
+	snapshot, err = parseSnapshot(buildSnapshot(constant.FormatVersion, optional, unknown, stringRecord("k", "v")))
+	if err != nil {
+		t.Fatalf("TestParseSnapshotVersions optional records: unexpected error %v", err)
+	}
+	if snapshot.aux["ctime"] != "123" || len(snapshot.records) != 1 {
+		t.Errorf("TestParseSnapshotVersions optional records: got %+v", snapshot)
+	}
+
+	// Unknown data types can not be skipped safely
+	_, err = parseSnapshot(buildSnapshot(constant.FormatVersion, []byte{0x3F, 0x01, 'k', 0x01, 'v'}))
+	if err == nil {
+		t.Errorf("TestParseSnapshotVersions expected an error for an unknown data type")
+	}
+
+	// Newer files are rejected with a dedicated error
+	_, err = parseSnapshot(buildSnapshot(constant.FormatVersion + 1))
+	if !errors.Is(err, ErrNewerFormat) {
+		t.Errorf("TestParseSnapshotVersions expected ErrNewerFormat, got %v", err)
+	}
+
+	// A flipped bit is caught by the checksum
+	corrupt := buildSnapshot(constant.FormatVersion, stringRecord("k", "v"))
+	corrupt[len(corrupt)-checksumLength-2] ^= 1
+	if _, err := parseSnapshot(corrupt); !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("TestParseSnapshotVersions expected a checksum mismatch, got %v", err)
+	}
+
+	// Versioned files must keep their trailer, so truncation is caught
+	stripped := buildSnapshot(constant.FormatVersion, stringRecord("k", "v"))
+	stripped = stripped[:len(stripped)-checksumLength]
+	if _, err := parseSnapshot(stripped); !errors.Is(err, errMissingChecksum) {
+		t.Errorf("TestParseSnapshotVersions expected a missing checksum error, got %v", err)
+	}
+	if in := Inspect(stripped, false); in.Checksum != ChecksumMissing || len(in.Problems) != 1 {
+		t.Errorf("TestParseSnapshotVersions: inspecting a stripped file got %+v", in)
+	}
+}
+
+func TestCompressedRecords(t *testing.T) {
+	config.SnapshotCompressThreshold = 64
+	defer func() { config.SnapshotCompressThreshold = 0 }()
+
+	large := strings.Repeat(`{"name":"value"},`, 100)
+	var buf bytes.Buffer
+	fs := newChecksumWriter(&buf)
+	fs.Write([]byte(constant.Header))
+	fs.Write([]byte{constant.OpVersion})
+	fs.Write(utils.EncodeVarIntBigEndian(constant.FormatVersion))
+	writeString(fs, "large", large)
+	writeString(fs, "small", "v")
+	fs.Write([]byte{constant.EOF})
+	fs.Close()
+
+	data := buf.Bytes()
+	if len(data) >= len(large) {
+		t.Errorf("TestCompressedRecords: file of %d bytes is not compressed", len(data))
+	}
+	snapshot, err := parseSnapshot(data)
+	if err != nil {
+		t.Fatalf("TestCompressedRecords: unexpected error %v", err)
+	}
+	for _, r := range snapshot.records {
+		want := map[string]string{"large": large, "small": "v"}[r.key]
+		if r.recordType != constant.TypeString || r.value != want {
+			t.Errorf("TestCompressedRecords: record %q = %v %.20q", r.key, r.recordType, r.value)
+		}
+	}
+
+	// A compressed value that does not inflate to its recorded length is corrupt
+	compressed, _ := compressValue([]byte(large))
+	compressed[0]++
+	record := append([]byte{constant.TypeString | constant.TypeCompressed}, appendString(nil, "k")...)
+	record = append(record, appendString(nil, string(compressed))...)
+	if _, err := parseSnapshot(buildSnapshot(constant.FormatVersion, record)); !errors.Is(err, errCorruptCompressed) {
+		t.Errorf("TestCompressedRecords expected a corrupt value error, got %v", err)
+	}
+}
+
+func TestInspectSalvage(t *testing.T) {
+	good := stringRecord("a", "1")
+	broken := []byte{constant.TypeList, 0x01, 'b', 0x05, 0x09, 'x'}
+	data := buildSnapshot(constant.FormatVersion, good, broken, stringRecord("c", "3"))
+
+	in := Inspect(data, false)
+	if len(in.Records) != 1 || len(in.Problems) != 1 || in.Problems[0].Offset != 10+len(good) {
+		t.Fatalf("TestInspectSalvage: got %+v", in)
+	}
+
+	in = Inspect(data, true)
+	snap := in.Snapshot()
+	if len(snap.Values) != 2 || snap.Values["a"] != "1" || snap.Values["c"] != "3" {
+		t.Errorf("TestInspectSalvage: salvaged %v", snap.Values)
+	}
+	if in.Checksum != ChecksumOK {
+		t.Errorf("TestInspectSalvage: checksum %q", in.Checksum)
+	}
+	if in.Records[0].Salvaged || !in.Records[1].Salvaged {
+		t.Errorf("TestInspectSalvage: salvaged flags %+v", in.Records)
+	}
+
+	// A lone record that happens to decode amid garbage is not trusted
+	fake := stringRecord("z", "9")
+	junk := []byte{constant.TypeString, 0x7f}
+	data = buildSnapshot(constant.FormatVersion, good, broken, fake, junk, stringRecord("c", "3"))
+	in = Inspect(data, true)
+	if snap := in.Snapshot(); len(snap.Values) != 2 || snap.Values["c"] != "3" {
+		t.Errorf("TestInspectSalvage: salvaged %v past garbage", snap.Values)
+	}
+	if len(in.Problems) != 1 {
+		t.Errorf("TestInspectSalvage: got problems %v past garbage", in.Problems)
+	}
+}
+
+func TestCorruptLengths(t *testing.T) {
+	// A key length varint that overflows an int once decoded
+	data := []byte(constant.Header)
+	data = append(data, constant.TypeString, 0x81)
+	data = append(data, bytes.Repeat([]byte{0x80}, 8)...)
+	data = append(data, 0, 0, 0, 0, 0, 0)
+	if len(data) != 24 {
+		t.Fatalf("TestCorruptLengths failed: test file is %d bytes, want 24", len(data))
+	}
+	if _, err := parseSnapshot(data); err == nil {
+		t.Errorf("TestCorruptLengths failed: overflowing length was accepted")
+	}
+	for _, salvage := range []bool{false, true} {
+		if in := Inspect(data, salvage); len(in.Problems) == 0 {
+			t.Errorf("TestCorruptLengths failed: Inspect with salvage %v found no problem", salvage)
+		}
+	}
+
+	// Counts larger than the bytes left are rejected before allocating
+	huge := utils.EncodeVarIntBigEndian(1 << 60)
+	if _, err := decodeStrings(huge); err == nil {
+		t.Errorf("TestCorruptLengths failed: decodeStrings accepted a count of 2^60")
+	}
+	if _, err := decodeHash(huge); err == nil {
+		t.Errorf("TestCorruptLengths failed: decodeHash accepted a count of 2^60")
+	}
+	if _, err := decodeSortedSet(huge); err == nil {
+		t.Errorf("TestCorruptLengths failed: decodeSortedSet accepted a count of 2^60")
+	}
+	if _, _, err := readVarIntAt(bytes.Repeat([]byte{0x81}, 10), 0); err == nil {
+		t.Errorf("TestCorruptLengths failed: a 10 byte varint was accepted")
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	ds := NewDiskStorage()
+	data := []byte(constant.Header)
+	data = append(data, constant.TypeString, 0x81)
+	data = append(data, bytes.Repeat([]byte{0x80}, 8)...)
+	data = append(data, 0, 0, 0, 0, 0, 0)
+	if err := ds.LoadData("bad", data); err == nil || !strings.Contains(err.Error(), "is corrupt") {
+		t.Errorf("TestLoadCorrupt failed: got %v, want a corrupt file error", err)
+	}
+
+	// A damaged record of a versioned file fails its checksum before it
+	// is decoded
+	data = buildSnapshot(constant.FormatVersion, stringRecord("a", "1"), stringRecord("b", "2"))
+	data[len(data)-12] = 0x7f
+	if err := ds.LoadData("damaged", data); !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("TestLoadCorrupt failed: got %v, want a checksum mismatch", err)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	expiry := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	list := inMemory.NewList()
+	list.PushBack("a")
+	list.PushBack("b")
+	set := inMemory.NewSet()
+	set.Add("1")
+	set.Add("2")
+	hash := inMemory.NewHash()
+	hash.Set("f", "v")
+	hash.Set("g", "w")
+	hash.SetExpiration("g", expiry)
+	zset := inMemory.NewSortedSet()
+	zset.Add("m", math.Inf(1))
+	zset.Add("n", 0.1)
+	stream := inMemory.NewStream()
+	stream.Append(inMemory.StreamID{Ms: 1, Seq: 1}, []string{"k", "v"})
+	stream.LastID = inMemory.StreamID{Ms: 1, Seq: 1}
+	stream.EntriesAdded = 1
+	stream.CreateGroup("g1", inMemory.StreamID{}, 0)
+	consumer, _ := stream.Groups["g1"].Consumer("alice", true)
+	stream.Groups["g1"].Deliver(consumer, inMemory.StreamID{Ms: 1, Seq: 1}, time.UnixMilli(1000))
+
+	snap := &inMemory.Snapshot{
+		Values: map[string]interface{}{
+			"str": "text", "bin": "\xff\x00", "list": list, "set": set,
+			"hash": hash, "zset": zset, "stream": stream,
+		},
+		Expirations: map[string]time.Time{"str": expiry},
+	}
+	var first bytes.Buffer
+	if n, err := ExportJSON(&first, snap, ""); err != nil || n != 7 {
+		t.Fatalf("TestJSONRoundTrip: exported %d keys, %v", n, err)
+	}
+	if !strings.Contains(first.String(), `"encoding":"base64"`) {
+		t.Errorf("TestJSONRoundTrip: binary value not base64 encoded")
+	}
+
+	parsed, err := ParseJSON(bytes.NewReader(first.Bytes()), "")
+	if err != nil {
+		t.Fatalf("TestJSONRoundTrip: %v", err)
+	}
+	if parsed.Values["bin"] != "\xff\x00" || !parsed.Expirations["str"].Equal(expiry) {
+		t.Errorf("TestJSONRoundTrip: got %q expiring %v", parsed.Values["bin"], parsed.Expirations["str"])
+	}
+	var second bytes.Buffer
+	ExportJSON(&second, parsed, "")
+	if first.String() != second.String() {
+		t.Errorf("TestJSONRoundTrip: export differs after import:\n%s\n%s", first.String(), second.String())
+	}
+
+	filtered, err := ParseJSON(bytes.NewReader(first.Bytes()), "s*")
+	if err != nil || len(filtered.Values) != 3 {
+		t.Errorf("TestJSONRoundTrip: pattern kept %d keys, %v", len(filtered.Values), err)
+	}
+}
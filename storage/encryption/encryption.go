@@ -0,0 +1,357 @@
+// Package encryption seals snapshot and AOF files with AES-256-GCM.
+//
+// A sealed file starts with a header naming the key it was written with and
+// a random salt, followed by authenticated chunks:
+//
+//	[Magic][Version][KeyID][Salt] ([Flags][Len][Ciphertext+Tag])...
+//
+// Every file derives its own AES key from the master key and its salt, and
+// numbers its chunks through the GCM nonce, so chunks can not be reordered
+// or moved between files. The header and the chunk flags are authenticated
+// as well. A snapshot ends with a chunk flagged as final, which makes a cut
+// short file detectable; an append log has no final chunk.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Magic starts every sealed file.
+const Magic = "REDISENC"
+
+const (
+	version     = 1
+	keyIDLength = 8
+	saltLength  = 16
+	headerLen   = len(Magic) + 1 + keyIDLength + saltLength
+
+	// ChunkSize is the amount of plaintext sealed per chunk by Writer.
+	ChunkSize = 64 << 10
+
+	chunkHeaderLen = 5
+	flagFinal      = 0x01
+)
+
+var (
+	ErrUnknownKey = errors.New("file is encrypted with a key that is not in the key file")
+	ErrNoKey      = errors.New("file is encrypted but no encryption key is configured")
+	ErrTampered   = errors.New("encrypted data failed authentication")
+	ErrTruncated  = errors.New("encrypted file is truncated")
+)
+
+type masterKey struct {
+	id  [keyIDLength]byte
+	key []byte
+}
+
+// keys holds the configured keys, the current one first.
+var keys []masterKey
+
+// LoadKeyFile reads the master keys from path: one key of 64 hex digits
+// (32 bytes) per line, blank lines and lines starting with # ignored. The
+// first key encrypts everything written from now on; the others are only
+// used to read files written before a key rotation.
+func LoadKeyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var loaded []masterKey
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := hex.DecodeString(line)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("key file %s line %d: expected 64 hex digits", path, i+1)
+		}
+		loaded = append(loaded, masterKey{id: keyID(key), key: key})
+	}
+	if len(loaded) == 0 {
+		return fmt.Errorf("key file %s contains no key", path)
+	}
+	keys = loaded
+	return nil
+}
+
+// Enabled reports whether new files are encrypted.
+func Enabled() bool {
+	return len(keys) > 0
+}
+
+// IsEncrypted reports whether data starts like a sealed file.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+// IsCurrent reports whether data is sealed with the current key, which is
+// what a file written now would look like.
+func IsCurrent(data []byte) bool {
+	if !Enabled() {
+		return !IsEncrypted(data)
+	}
+	if len(data) < headerLen || !IsEncrypted(data) {
+		return false
+	}
+	return bytes.Equal(data[len(Magic)+1:len(Magic)+1+keyIDLength], keys[0].id[:])
+}
+
+func keyID(key []byte) [keyIDLength]byte {
+	sum := sha256.Sum256(append([]byte("redis-db key id\x00"), key...))
+	var id [keyIDLength]byte
+	copy(id[:], sum[:])
+	return id
+}
+
+// sealer seals or opens the chunks of one file.
+type sealer struct {
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+}
+
+func newSealer(header []byte) (*sealer, error) {
+	if len(header) < headerLen || !IsEncrypted(header) {
+		return nil, errors.New("invalid encryption header")
+	}
+	header = header[:headerLen]
+	if header[len(Magic)] != version {
+		return nil, fmt.Errorf("unsupported encryption version %d", header[len(Magic)])
+	}
+	if !Enabled() {
+		return nil, ErrNoKey
+	}
+	id := header[len(Magic)+1 : len(Magic)+1+keyIDLength]
+	salt := header[len(Magic)+1+keyIDLength:]
+	for _, k := range keys {
+		if !bytes.Equal(k.id[:], id) {
+			continue
+		}
+		mac := hmac.New(sha256.New, k.key)
+		mac.Write([]byte("redis-db file key\x00"))
+		mac.Write(salt)
+		block, err := aes.NewCipher(mac.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		return &sealer{aead: aead, header: append([]byte(nil), header...)}, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (s *sealer) nonce() []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], s.counter)
+	s.counter++
+	return nonce
+}
+
+func (s *sealer) additionalData(flags byte) []byte {
+	return append(append([]byte(nil), s.header...), flags)
+}
+
+// seal returns plain as a framed chunk.
+func (s *sealer) seal(plain []byte, flags byte) []byte {
+	chunk := make([]byte, chunkHeaderLen, chunkHeaderLen+len(plain)+s.aead.Overhead())
+	chunk[0] = flags
+	binary.BigEndian.PutUint32(chunk[1:], uint32(len(plain)+s.aead.Overhead()))
+	return s.aead.Seal(chunk, s.nonce(), plain, s.additionalData(flags))
+}
+
+// open decrypts the chunk at the start of data. It returns the plaintext,
+// the chunk flags and the chunk length, or a zero length when data ends
+// before the chunk does.
+func (s *sealer) open(data []byte) ([]byte, byte, int, error) {
+	if len(data) < chunkHeaderLen {
+		return nil, 0, 0, nil
+	}
+	flags := data[0]
+	length := int(binary.BigEndian.Uint32(data[1:]))
+	if length < s.aead.Overhead() || length > ChunkSize+s.aead.Overhead() {
+		return nil, 0, 0, ErrTampered
+	}
+	if chunkHeaderLen+length > len(data) {
+		return nil, 0, 0, nil
+	}
+	plain, err := s.aead.Open(nil, s.nonce(), data[chunkHeaderLen:chunkHeaderLen+length], s.additionalData(flags))
+	if err != nil {
+		return nil, 0, 0, ErrTampered
+	}
+	return plain, flags, chunkHeaderLen + length, nil
+}
+
+// Decrypt returns the plaintext of a sealed snapshot. Every chunk must
+// authenticate and the last one must be flagged final.
+func Decrypt(data []byte) ([]byte, error) {
+	s, err := newSealer(data)
+	if err != nil {
+		return nil, err
+	}
+	var plain []byte
+	pos := headerLen
+	for {
+		chunk, flags, n, err := s.open(data[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("%w (chunk at offset %d)", err, pos)
+		}
+		if n == 0 {
+			return nil, ErrTruncated
+		}
+		plain = append(plain, chunk...)
+		pos += n
+		if flags&flagFinal != 0 {
+			break
+		}
+	}
+	if pos != len(data) {
+		return nil, fmt.Errorf("%d unexpected bytes after the final chunk", len(data)-pos)
+	}
+	return plain, nil
+}
+
+// DecryptLog returns the plaintext of a sealed append log together with the
+// length of the complete chunks it came from. A chunk cut short by a crash
+// ends the log; a chunk that fails authentication is an error.
+func DecryptLog(data []byte) ([]byte, int, error) {
+	s, err := newSealer(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	var plain []byte
+	pos := headerLen
+	for pos < len(data) {
+		chunk, _, n, err := s.open(data[pos:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w (chunk at offset %d)", err, pos)
+		}
+		if n == 0 {
+			break
+		}
+		plain = append(plain, chunk...)
+		pos += n
+	}
+	return plain, pos, nil
+}
+
+// Writer encrypts everything written to it as a sealed file on out.
+type Writer struct {
+	out  io.Writer
+	s    *sealer
+	buf  []byte
+	size int64
+}
+
+// NewWriter writes a header for the current key to out and returns a
+// Writer for the file body.
+func NewWriter(out io.Writer) (*Writer, error) {
+	if !Enabled() {
+		return nil, errors.New("no encryption key configured")
+	}
+	header := make([]byte, 0, headerLen)
+	header = append(header, Magic...)
+	header = append(header, version)
+	header = append(header, keys[0].id[:]...)
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+	s, err := newSealer(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{out: out, s: s, size: int64(headerLen)}, nil
+}
+
+// Resume returns a Writer that appends chunks to the sealed log read from
+// in, which must be positioned at its start, and writes them to out. Only
+// the chunk headers are read.
+func Resume(in io.Reader, out io.Writer) (*Writer, error) {
+	r := bufio.NewReader(in)
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	s, err := newSealer(header)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(headerLen)
+	chunkHeader := make([]byte, chunkHeaderLen)
+	for {
+		if _, err := io.ReadFull(r, chunkHeader); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint32(chunkHeader[1:]))
+		if _, err := r.Discard(length); err != nil {
+			return nil, err
+		}
+		s.counter++
+		size += int64(chunkHeaderLen + length)
+	}
+	return &Writer{out: out, s: s, size: size}, nil
+}
+
+func (w *Writer) writeChunk(plain []byte, flags byte) error {
+	n, err := w.out.Write(w.s.seal(plain, flags))
+	w.size += int64(n)
+	return err
+}
+
+// Write buffers p and seals every full chunk.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= ChunkSize {
+		if err := w.writeChunk(w.buf[:ChunkSize], 0); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[ChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Flush seals the buffered data as a non-final chunk, as an append log does
+// after every batch of commands.
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeChunk(w.buf, 0)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// Close seals the buffered data as the final chunk of the file.
+func (w *Writer) Close() error {
+	err := w.writeChunk(w.buf, flagFinal)
+	w.buf = nil
+	return err
+}
+
+// Size returns the number of bytes of the sealed file written so far.
+func (w *Writer) Size() int64 {
+	return w.size
+}
@@ -0,0 +1,117 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	keyA = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	keyB = "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff000102030405060708090a0b0c0d0e0f"
+)
+
+// useKeys loads a key file with the given keys, the first one current.
+func useKeys(t *testing.T, hexKeys ...string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# test keys\n" + strings.Join(hexKeys, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { keys = nil })
+}
+
+func seal(t *testing.T, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	useKeys(t, keyA)
+	plain := bytes.Repeat([]byte("secret customer data "), ChunkSize/8)
+	sealed := seal(t, plain)
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("TestSnapshotRoundTrip: plaintext visible in sealed file")
+	}
+	got, err := Decrypt(sealed)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("TestSnapshotRoundTrip: got %d bytes, %v", len(got), err)
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[headerLen+100] ^= 1
+	if _, err := Decrypt(tampered); !errors.Is(err, ErrTampered) {
+		t.Errorf("TestSnapshotRoundTrip: expected ErrTampered for a flipped bit, got %v", err)
+	}
+
+	// Dropping the final chunk leaves a file that is only valid as a log
+	firstChunk := headerLen + chunkHeaderLen + ChunkSize + 16
+	if _, err := Decrypt(sealed[:firstChunk]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("TestSnapshotRoundTrip: expected ErrTruncated, got %v", err)
+	}
+}
+
+func TestLogResume(t *testing.T) {
+	useKeys(t, keyA)
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf)
+	w.Write([]byte("first;"))
+	w.Flush()
+
+	// Reopen the log and keep appending after the existing chunks
+	w, err := Resume(bytes.NewReader(buf.Bytes()), &buf)
+	if err != nil {
+		t.Fatalf("TestLogResume: %v", err)
+	}
+	w.Write([]byte("second;"))
+	w.Flush()
+	if w.Size() != int64(buf.Len()) {
+		t.Errorf("TestLogResume: size %d, file has %d bytes", w.Size(), buf.Len())
+	}
+
+	// A torn final chunk ends the log
+	data := append(buf.Bytes(), 0, 0, 0, 1, 0, 'x')
+	plain, valid, err := DecryptLog(data)
+	if err != nil || string(plain) != "first;second;" || valid != buf.Len() {
+		t.Errorf("TestLogResume: got %q, %d, %v", plain, valid, err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	useKeys(t, keyA)
+	old := seal(t, []byte("old data"))
+
+	// The new key goes first; the old one still reads existing files
+	useKeys(t, keyB, keyA)
+	if IsCurrent(old) {
+		t.Errorf("TestKeyRotation: file sealed with the old key reported as current")
+	}
+	if got, err := Decrypt(old); err != nil || string(got) != "old data" {
+		t.Errorf("TestKeyRotation: got %q, %v", got, err)
+	}
+	if !IsCurrent(seal(t, []byte("new data"))) {
+		t.Errorf("TestKeyRotation: re-saved file is not current")
+	}
+
+	// Once the old key is removed its files can no longer be read
+	useKeys(t, keyB)
+	if _, err := Decrypt(old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("TestKeyRotation: expected ErrUnknownKey, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package inMemory
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Hash maps fields to string values. Individual fields may carry their own
+// expiration, which is enforced lazily: a field is dropped when it is
+// accessed after it expired, and the fields that expired so far are
+// dropped whenever the whole hash is read.
+type Hash struct {
+	fields      map[string]string
+	expirations map[string]time.Time
+	// byExpiration orders the fields with an expiration by their
+	// expiration in milliseconds, so that the expired ones are found
+	// without scanning the hash.
+	byExpiration *SortedSet
+}
+
+func NewHash() *Hash {
+	return &Hash{
+		fields:       make(map[string]string),
+		expirations:  make(map[string]time.Time),
+		byExpiration: NewSortedSet(),
+	}
+}
+
+// hasExpired reports whether some field has expired at now.
+func (h *Hash) hasExpired(now time.Time) bool {
+	if h.byExpiration.Len() == 0 {
+		return false
+	}
+	first := h.byExpiration.RangeByRank(0, 0, false)[0]
+	return float64(now.UnixMilli()) >= first.Score && now.After(h.expirations[first.Member])
+}
+
+// purgeExpired drops every field whose expiration has passed, in time
+// proportional to their number.
+func (h *Hash) purgeExpired() {
+	if h.byExpiration.Len() == 0 {
+		return
+	}
+	now := time.Now()
+	upTo := ScoreBound{Value: float64(now.UnixMilli())}
+	for _, m := range h.byExpiration.RangeByScore(ScoreBound{Value: math.Inf(-1)}, upTo, false, 0, -1) {
+		h.expireField(m.Member, now)
+	}
+}
+
+// expireField drops field if it has expired at now.
+func (h *Hash) expireField(field string, now time.Time) {
+	if expTime, ok := h.expirations[field]; ok && now.After(expTime) {
+		delete(h.fields, field)
+		h.clearExpiration(field)
+	}
+}
+
+func (h *Hash) clearExpiration(field string) bool {
+	if _, ok := h.expirations[field]; !ok {
+		return false
+	}
+	delete(h.expirations, field)
+	h.byExpiration.Remove(field)
+	return true
+}
+
+func (h *Hash) Len() int {
+	h.purgeExpired()
+	return len(h.fields)
+}
+
+func (h *Hash) Get(field string) (string, bool) {
+	h.expireField(field, time.Now())
+	value, ok := h.fields[field]
+	return value, ok
+}
+
+// Set stores a field and reports whether it is new. Overwriting a field
+// clears its expiration, as in Redis.
+func (h *Hash) Set(field, value string) bool {
+	h.expireField(field, time.Now())
+	_, exists := h.fields[field]
+	h.fields[field] = value
+	h.clearExpiration(field)
+	return !exists
+}
+
+// Delete removes a field and reports whether it existed.
+func (h *Hash) Delete(field string) bool {
+	h.expireField(field, time.Now())
+	_, exists := h.fields[field]
+	delete(h.fields, field)
+	h.clearExpiration(field)
+	return exists
+}
+
+// Fields returns the field names in sorted order so iteration is stable
+// across calls.
+func (h *Hash) Fields() []string {
+	h.purgeExpired()
+	fields := make([]string, 0, len(h.fields))
+	for field := range h.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// GetExpiration returns the expiration time of a field, if any.
+func (h *Hash) GetExpiration(field string) (time.Time, bool) {
+	h.expireField(field, time.Now())
+	expTime, ok := h.expirations[field]
+	return expTime, ok
+}
+
+// SetExpiration sets the expiration time of an existing field.
+func (h *Hash) SetExpiration(field string, expTime time.Time) {
+	if _, ok := h.fields[field]; ok {
+		h.expirations[field] = expTime
+		h.byExpiration.Add(field, float64(expTime.UnixMilli()))
+	}
+}
+
+// Persist removes the expiration of a field and reports whether it had one.
+func (h *Hash) Persist(field string) bool {
+	h.expireField(field, time.Now())
+	return h.clearExpiration(field)
+}
+
+// Clone returns an independent copy of the hash and its field expirations.
+func (h *Hash) Clone() *Hash {
+	clone := NewHash()
+	for field, value := range h.fields {
+		clone.fields[field] = value
+	}
+	for field, expTime := range h.expirations {
+		clone.SetExpiration(field, expTime)
+	}
+	return clone
+}
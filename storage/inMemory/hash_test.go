@@ -0,0 +1,66 @@
+package inMemory
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHashFieldExpiration(t *testing.T) {
+	hash := NewHash()
+	for i := 0; i < 100; i++ {
+		hash.Set("f"+strconv.Itoa(i), "v")
+	}
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Hour)
+	for i := 0; i < 100; i += 2 {
+		hash.SetExpiration("f"+strconv.Itoa(i), past)
+	}
+	hash.SetExpiration("f1", future)
+
+	if _, ok := hash.Get("f0"); ok {
+		t.Errorf("TestHashFieldExpiration failed: expired field f0 still readable")
+	}
+	if hash.Len() != 50 {
+		t.Errorf("TestHashFieldExpiration failed: got %d fields, want 50", hash.Len())
+	}
+	if len(hash.expirations) != 1 || hash.byExpiration.Len() != 1 {
+		t.Errorf("TestHashFieldExpiration failed: %d expirations left, want 1", len(hash.expirations))
+	}
+	if expTime, ok := hash.GetExpiration("f1"); !ok || !expTime.Equal(future) {
+		t.Errorf("TestHashFieldExpiration failed: f1 expires at %v, want %v", expTime, future)
+	}
+
+	// Overwriting or persisting a field clears its expiration
+	hash.Set("f1", "w")
+	if _, ok := hash.GetExpiration("f1"); ok || hash.byExpiration.Len() != 0 {
+		t.Errorf("TestHashFieldExpiration failed: overwritten f1 kept its expiration")
+	}
+	hash.SetExpiration("f3", future)
+	if !hash.Persist("f3") || hash.Persist("f3") {
+		t.Errorf("TestHashFieldExpiration failed: Persist did not report the expiration once")
+	}
+}
+
+func TestHashExpiredKey(t *testing.T) {
+	m := &InMemoryStore{expirations: make(map[string]time.Time)}
+	hash := NewHash()
+	hash.Set("a", "1")
+	hash.Set("b", "2")
+	m.SetValue("h", hash)
+	hash.SetExpiration("a", time.Now().Add(-time.Second))
+
+	if got, _ := m.GetHash("h"); got == nil || !reflect.DeepEqual(got.Fields(), []string{"b"}) {
+		t.Errorf("TestHashExpiredKey failed: expected only field b to remain")
+	}
+
+	// The key goes away with its last field, whatever command looks at it
+	hash.SetExpiration("b", time.Now().Add(-time.Second))
+	if m.Type("h") != "none" || m.Exists("h") {
+		t.Errorf("TestHashExpiredKey failed: hash with every field expired still exists")
+	}
+	if _, ok := m.Store.Load("h"); ok {
+		t.Errorf("TestHashExpiredKey failed: hash with every field expired was not deleted")
+	}
+}
@@ -1,15 +1,38 @@
 package inMemory
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/bhaski-1234/redis-db/constant"
 )
 
+// ErrWrongType is returned when a key holds a value of a different type than
+// the one the caller asked for.
+var ErrWrongType = errors.New(constant.ErrWrongType)
+
 // InMemoryStore provides a thread-safe in-memory key-value store using sync.Map.
 type InMemoryStore struct {
 	Store       sync.Map
 	expirations map[string]time.Time
 	mutex       sync.RWMutex // Mutex to protect the expirations map
+	dirty       atomic.Int64 // Writes since the last successful save
+
+	// expired collects the keys deleted on expiry, while recordExpired is
+	// set, until TakeExpired hands them out. Guarded by mutex.
+	expired       []string
+	recordExpired bool
+
+	// keys counts the stored keys, including expired ones not deleted yet.
+	keys atomic.Int64
+	// slots groups the keys by hash slot once IndexSlots is called.
+	slots atomic.Pointer[slotIndex]
+
+	// snapshots are the copy-on-write snapshots still being read.
+	snapshotsMu sync.Mutex
+	snapshots   []*cow
 }
 
 var storage *InMemoryStore
@@ -30,7 +53,8 @@ func GetInMemoryStore() *InMemoryStore {
 
 // Set stores a value for a given key.
 func (m *InMemoryStore) Set(key, value string) {
-	m.Store.Store(key, value)
+	m.preserve(key, false)
+	m.store(key, value)
 
 	// Remove any expiration for this key
 	m.mutex.Lock()
@@ -40,7 +64,8 @@ func (m *InMemoryStore) Set(key, value string) {
 
 // SetWithExpiration stores a value for a given key with an expiration time.
 func (m *InMemoryStore) SetWithExpiration(key, value string, expiration time.Duration) {
-	m.Store.Store(key, value)
+	m.preserve(key, false)
+	m.store(key, value)
 
 	if expiration > 0 {
 		m.mutex.Lock()
@@ -49,29 +74,217 @@ func (m *InMemoryStore) SetWithExpiration(key, value string, expiration time.Dur
 	}
 }
 
-// Returns the value and a boolean indicating if the key exists.
+// Returns the value and a boolean indicating if the key exists. Keys holding
+// a non-string value are reported as missing; use GetValue to inspect them.
 func (m *InMemoryStore) Get(key string) (string, bool) {
+	val, ok := m.GetValue(key)
+	if !ok {
+		return "", false
+	}
+	str, ok := val.(string)
+	return str, ok
+}
+
+// GetValue returns the raw value stored at key regardless of its type,
+// deleting the key first if it has expired. The expired fields of a hash
+// are dropped, and so is the hash once its last field has expired.
+func (m *InMemoryStore) GetValue(key string) (interface{}, bool) {
 	// Check if key has expired
 	m.mutex.RLock()
 	expTime, hasExpiration := m.expirations[key]
 	m.mutex.RUnlock()
 
-	if hasExpiration && time.Now().After(expTime) {
+	now := time.Now()
+	if hasExpiration && now.After(expTime) {
 		// Key has expired, delete it
-		m.Delete(key)
-		return "", false
+		m.expire(key)
+		return nil, false
 	}
 
 	val, ok := m.Store.Load(key)
-	if ok {
-		return val.(string), true
+	if hash, isHash := val.(*Hash); isHash && hash.hasExpired(now) {
+		m.preserve(key, true)
+		if hash.Len() == 0 {
+			m.Delete(key)
+			return nil, false
+		}
+	}
+	return val, ok
+}
+
+// SetValue stores a value of any type without touching the key's expiration.
+func (m *InMemoryStore) SetValue(key string, value interface{}) {
+	m.preserve(key, false)
+	m.store(key, value)
+}
+
+// store stores a value, counting and indexing the key if it is new.
+func (m *InMemoryStore) store(key string, value interface{}) {
+	if _, loaded := m.Store.Swap(key, value); !loaded {
+		m.keys.Add(1)
+		if idx := m.slots.Load(); idx != nil {
+			idx.add(key)
+		}
+	}
+}
+
+// remove deletes a value, uncounting the key if it existed.
+func (m *InMemoryStore) remove(key string) {
+	if _, loaded := m.Store.LoadAndDelete(key); loaded {
+		m.keys.Add(-1)
+		if idx := m.slots.Load(); idx != nil {
+			idx.remove(key)
+		}
+	}
+}
+
+// GetList returns the list stored at key, or nil if the key does not exist.
+func (m *InMemoryStore) GetList(key string) (*List, error) {
+	val, ok := m.GetValue(key)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := val.(*List)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return list, nil
+}
+
+// GetOrCreateList returns the list stored at key, creating an empty one if
+// the key does not exist.
+func (m *InMemoryStore) GetOrCreateList(key string) (*List, error) {
+	list, err := m.GetList(key)
+	if err != nil || list != nil {
+		return list, err
+	}
+	list = NewList()
+	m.SetValue(key, list)
+	return list, nil
+}
+
+// GetHash returns the hash stored at key, or nil if the key does not exist.
+func (m *InMemoryStore) GetHash(key string) (*Hash, error) {
+	val, ok := m.GetValue(key)
+	if !ok {
+		return nil, nil
+	}
+	hash, ok := val.(*Hash)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	if len(hash.expirations) > 0 {
+		// Accessing a field drops it if it has expired
+		m.preserve(key, true)
+	}
+	return hash, nil
+}
+
+// GetOrCreateHash returns the hash stored at key, creating an empty one if
+// the key does not exist.
+func (m *InMemoryStore) GetOrCreateHash(key string) (*Hash, error) {
+	hash, err := m.GetHash(key)
+	if err != nil || hash != nil {
+		return hash, err
+	}
+	hash = NewHash()
+	m.SetValue(key, hash)
+	return hash, nil
+}
+
+// GetSet returns the set stored at key, or nil if the key does not exist.
+func (m *InMemoryStore) GetSet(key string) (*Set, error) {
+	val, ok := m.GetValue(key)
+	if !ok {
+		return nil, nil
+	}
+	set, ok := val.(*Set)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return set, nil
+}
+
+// GetOrCreateSet returns the set stored at key, creating an empty one if the
+// key does not exist.
+func (m *InMemoryStore) GetOrCreateSet(key string) (*Set, error) {
+	set, err := m.GetSet(key)
+	if err != nil || set != nil {
+		return set, err
+	}
+	set = NewSet()
+	m.SetValue(key, set)
+	return set, nil
+}
+
+// GetSortedSet returns the sorted set stored at key, or nil if the key does
+// not exist.
+func (m *InMemoryStore) GetSortedSet(key string) (*SortedSet, error) {
+	val, ok := m.GetValue(key)
+	if !ok {
+		return nil, nil
+	}
+	zset, ok := val.(*SortedSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return zset, nil
+}
+
+// GetOrCreateSortedSet returns the sorted set stored at key, creating an
+// empty one if the key does not exist.
+func (m *InMemoryStore) GetOrCreateSortedSet(key string) (*SortedSet, error) {
+	zset, err := m.GetSortedSet(key)
+	if err != nil || zset != nil {
+		return zset, err
+	}
+	zset = NewSortedSet()
+	m.SetValue(key, zset)
+	return zset, nil
+}
+
+// GetStream returns the stream stored at key, or nil if the key does not
+// exist.
+func (m *InMemoryStore) GetStream(key string) (*Stream, error) {
+	val, ok := m.GetValue(key)
+	if !ok {
+		return nil, nil
+	}
+	stream, ok := val.(*Stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return stream, nil
+}
+
+// Type returns the Redis type name of the value stored at key, or "none".
+func (m *InMemoryStore) Type(key string) string {
+	val, ok := m.GetValue(key)
+	if !ok {
+		return "none"
+	}
+	switch val.(type) {
+	case string, int:
+		return "string"
+	case *List:
+		return "list"
+	case *Hash:
+		return "hash"
+	case *Set:
+		return "set"
+	case *SortedSet:
+		return "zset"
+	case *Stream:
+		return "stream"
+	default:
+		return "none"
 	}
-	return "", false
 }
 
 // Delete removes a key from the store.
 func (m *InMemoryStore) Delete(key string) {
-	m.Store.Delete(key)
+	m.preserve(key, false)
+	m.remove(key)
 
 	m.mutex.Lock()
 	delete(m.expirations, key)
@@ -80,7 +293,11 @@ func (m *InMemoryStore) Delete(key string) {
 
 // Clear clears the entire store and expirations.
 func (m *InMemoryStore) Clear() {
-	m.Store = sync.Map{}
+	m.Store.Range(func(key, _ interface{}) bool {
+		m.preserve(key.(string), false)
+		m.remove(key.(string))
+		return true
+	})
 
 	m.mutex.Lock()
 	m.expirations = make(map[string]time.Time)
@@ -107,11 +324,45 @@ func (m *InMemoryStore) cleanupExpiredKeys() {
 
 		// Delete expired keys
 		for _, key := range keysToDelete {
-			m.Delete(key)
+			m.expire(key)
 		}
 	}
 }
 
+// expire deletes key if it is still expired; it may have been given a new
+// expiration since it was found expired.
+func (m *InMemoryStore) expire(key string) {
+	m.preserve(key, true)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expTime, ok := m.expirations[key]
+	if !ok || !time.Now().After(expTime) {
+		return
+	}
+	m.remove(key)
+	delete(m.expirations, key)
+	if m.recordExpired {
+		m.expired = append(m.expired, key)
+	}
+}
+
+// RecordExpired makes the store collect the keys it deletes on expiry for
+// TakeExpired.
+func (m *InMemoryStore) RecordExpired() {
+	m.mutex.Lock()
+	m.recordExpired = true
+	m.mutex.Unlock()
+}
+
+// TakeExpired returns the keys deleted on expiry since the last call.
+func (m *InMemoryStore) TakeExpired() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expired := m.expired
+	m.expired = nil
+	return expired
+}
+
 // GetExpirations iterates through all expiration entries and calls the provided function
 func (m *InMemoryStore) GetExpirations(fn func(key string, expTime time.Time) bool) {
 	m.mutex.RLock()
@@ -124,19 +375,44 @@ func (m *InMemoryStore) GetExpirations(fn func(key string, expTime time.Time) bo
 	}
 }
 
+// RangeKeys calls fn for every key that has not expired until fn returns
+// false. fn must not call back into the store.
+func (m *InMemoryStore) RangeKeys(fn func(key string) bool) {
+	now := time.Now()
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	m.Store.Range(func(key, _ interface{}) bool {
+		keyStr := key.(string)
+		if expTime, ok := m.expirations[keyStr]; ok && now.After(expTime) {
+			return true
+		}
+		return fn(keyStr)
+	})
+}
+
 // SetExpiration directly sets an expiration time for a key
 func (m *InMemoryStore) SetExpiration(key string, expTime time.Time) {
+	m.preserve(key, true)
 	m.mutex.Lock()
 	m.expirations[key] = expTime
 	m.mutex.Unlock()
 }
 
+// GetExpiration returns the expiration time of a key, if it has one.
+func (m *InMemoryStore) GetExpiration(key string) (time.Time, bool) {
+	m.mutex.RLock()
+	expTime, ok := m.expirations[key]
+	m.mutex.RUnlock()
+	return expTime, ok
+}
+
 func (m *InMemoryStore) Exists(key string) bool {
-	_, exists := m.Get(key)
+	_, exists := m.GetValue(key)
 	return exists
 }
 
 func (m *InMemoryStore) DeleteExpiration(key string) {
+	m.preserve(key, true)
 	m.mutex.Lock()
 	delete(m.expirations, key)
 	m.mutex.Unlock()
@@ -157,3 +433,20 @@ func (m *InMemoryStore) GetTTL(key string) int64 {
 	}
 	return int64(ttl.Seconds())
 }
+
+// IncrDirty records a change to the dataset.
+func (m *InMemoryStore) IncrDirty() {
+	m.dirty.Add(1)
+}
+
+// Dirty returns the number of changes since the last successful save.
+func (m *InMemoryStore) Dirty() int64 {
+	return m.dirty.Load()
+}
+
+// ResetDirty discards the changes that a save has persisted. Passing the
+// value of Dirty from when the snapshot was taken keeps the writes made
+// during a background save counted.
+func (m *InMemoryStore) ResetDirty(saved int64) {
+	m.dirty.Add(-saved)
+}
@@ -0,0 +1,219 @@
+package inMemory
+
+// List is a double-ended queue of strings backed by a ring buffer, so pushes
+// and pops at either end are O(1) and indexing is O(1).
+type List struct {
+	items []string
+	head  int
+	size  int
+}
+
+func NewList() *List {
+	return &List{}
+}
+
+func (l *List) Len() int {
+	return l.size
+}
+
+func (l *List) grow() {
+	capacity := len(l.items) * 2
+	if capacity == 0 {
+		capacity = 8
+	}
+	items := make([]string, capacity)
+	for i := 0; i < l.size; i++ {
+		items[i] = l.items[(l.head+i)%len(l.items)]
+	}
+	l.items = items
+	l.head = 0
+}
+
+func (l *List) at(i int) int {
+	return (l.head + i) % len(l.items)
+}
+
+// PushFront inserts a value at the head of the list.
+func (l *List) PushFront(value string) {
+	if l.size == len(l.items) {
+		l.grow()
+	}
+	l.head = (l.head - 1 + len(l.items)) % len(l.items)
+	l.items[l.head] = value
+	l.size++
+}
+
+// PushBack appends a value at the tail of the list.
+func (l *List) PushBack(value string) {
+	if l.size == len(l.items) {
+		l.grow()
+	}
+	l.items[l.at(l.size)] = value
+	l.size++
+}
+
+// PopFront removes and returns the head of the list.
+func (l *List) PopFront() (string, bool) {
+	if l.size == 0 {
+		return "", false
+	}
+	value := l.items[l.head]
+	l.items[l.head] = ""
+	l.head = (l.head + 1) % len(l.items)
+	l.size--
+	return value, true
+}
+
+// PopBack removes and returns the tail of the list.
+func (l *List) PopBack() (string, bool) {
+	if l.size == 0 {
+		return "", false
+	}
+	idx := l.at(l.size - 1)
+	value := l.items[idx]
+	l.items[idx] = ""
+	l.size--
+	return value, true
+}
+
+// normalizeIndex converts a possibly negative index into an offset from the
+// head. The second return value reports whether the index is in range.
+func (l *List) normalizeIndex(index int) (int, bool) {
+	if index < 0 {
+		index += l.size
+	}
+	return index, index >= 0 && index < l.size
+}
+
+// Index returns the element at index; negative indexes count from the tail.
+func (l *List) Index(index int) (string, bool) {
+	i, ok := l.normalizeIndex(index)
+	if !ok {
+		return "", false
+	}
+	return l.items[l.at(i)], true
+}
+
+// Set replaces the element at index and reports whether it was in range.
+func (l *List) Set(index int, value string) bool {
+	i, ok := l.normalizeIndex(index)
+	if !ok {
+		return false
+	}
+	l.items[l.at(i)] = value
+	return true
+}
+
+// clampRange converts inclusive start/stop indexes with Redis semantics into
+// a half-open range of offsets from the head.
+func (l *List) clampRange(start, stop int) (int, int) {
+	if start < 0 {
+		start += l.size
+	}
+	if stop < 0 {
+		stop += l.size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= l.size {
+		stop = l.size - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// Range returns the elements between start and stop inclusive.
+func (l *List) Range(start, stop int) []string {
+	from, to := l.clampRange(start, stop)
+	result := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		result = append(result, l.items[l.at(i)])
+	}
+	return result
+}
+
+// Values returns a copy of every element from head to tail.
+func (l *List) Values() []string {
+	return l.Range(0, -1)
+}
+
+// Trim keeps only the elements between start and stop inclusive.
+func (l *List) Trim(start, stop int) {
+	l.replace(l.Range(start, stop))
+}
+
+// Remove deletes up to count occurrences of value. A positive count scans
+// from the head, a negative count from the tail and zero removes them all.
+func (l *List) Remove(count int, value string) int {
+	values := l.Values()
+	removed := 0
+	keep := make([]bool, len(values))
+	for i := range keep {
+		keep[i] = true
+	}
+
+	if count >= 0 {
+		for i := 0; i < len(values); i++ {
+			if values[i] == value && (count == 0 || removed < count) {
+				keep[i] = false
+				removed++
+			}
+		}
+	} else {
+		for i := len(values) - 1; i >= 0; i-- {
+			if values[i] == value && removed < -count {
+				keep[i] = false
+				removed++
+			}
+		}
+	}
+
+	if removed == 0 {
+		return 0
+	}
+	result := make([]string, 0, len(values)-removed)
+	for i, v := range values {
+		if keep[i] {
+			result = append(result, v)
+		}
+	}
+	l.replace(result)
+	return removed
+}
+
+// Insert places value before or after the first occurrence of pivot. It
+// returns the new length, or -1 when the pivot was not found.
+func (l *List) Insert(before bool, pivot, value string) int {
+	values := l.Values()
+	for i, v := range values {
+		if v != pivot {
+			continue
+		}
+		pos := i
+		if !before {
+			pos = i + 1
+		}
+		result := make([]string, 0, len(values)+1)
+		result = append(result, values[:pos]...)
+		result = append(result, value)
+		result = append(result, values[pos:]...)
+		l.replace(result)
+		return l.size
+	}
+	return -1
+}
+
+func (l *List) replace(values []string) {
+	l.items = values
+	l.head = 0
+	l.size = len(values)
+}
+
+// Clone returns an independent copy of the list.
+func (l *List) Clone() *List {
+	items := l.Values()
+	return &List{items: items, size: len(items)}
+}
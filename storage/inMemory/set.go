@@ -0,0 +1,219 @@
+package inMemory
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// maxIntsetEntries is the size above which an integer-only set is converted
+// to the hashtable encoding, mirroring Redis' set-max-intset-entries.
+const maxIntsetEntries = 512
+
+// Set is an unordered collection of unique strings. Small sets made only of
+// integers are kept as a sorted slice of int64 (the intset encoding) and are
+// upgraded to a hashtable as soon as a non-integer member is added or the
+// set grows past maxIntsetEntries. The hashtable maps each member to its
+// position in list, so that random members are picked in constant time.
+type Set struct {
+	intset  []int64
+	members map[string]int
+	list    []string
+}
+
+func NewSet() *Set {
+	return &Set{}
+}
+
+// parseIntsetMember reports whether a member can be stored in an intset. Only
+// canonical decimal forms qualify so that "007" and "7" stay distinct.
+func parseIntsetMember(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *Set) isIntset() bool {
+	return s.members == nil
+}
+
+// Encoding returns "intset" or "hashtable".
+func (s *Set) Encoding() string {
+	if s.isIntset() {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+func (s *Set) convertToHashtable() {
+	s.members = make(map[string]int, len(s.intset))
+	s.list = make([]string, 0, len(s.intset))
+	for _, n := range s.intset {
+		s.members[strconv.FormatInt(n, 10)] = len(s.list)
+		s.list = append(s.list, strconv.FormatInt(n, 10))
+	}
+	s.intset = nil
+}
+
+func (s *Set) searchInt(n int64) (int, bool) {
+	i := sort.Search(len(s.intset), func(i int) bool { return s.intset[i] >= n })
+	return i, i < len(s.intset) && s.intset[i] == n
+}
+
+func (s *Set) Len() int {
+	if s.isIntset() {
+		return len(s.intset)
+	}
+	return len(s.members)
+}
+
+// Add inserts a member and reports whether it was not already present.
+func (s *Set) Add(member string) bool {
+	if s.isIntset() {
+		n, ok := parseIntsetMember(member)
+		if ok {
+			i, found := s.searchInt(n)
+			if found {
+				return false
+			}
+			if len(s.intset) < maxIntsetEntries {
+				s.intset = append(s.intset, 0)
+				copy(s.intset[i+1:], s.intset[i:])
+				s.intset[i] = n
+				return true
+			}
+		}
+		s.convertToHashtable()
+	}
+	if _, exists := s.members[member]; exists {
+		return false
+	}
+	s.members[member] = len(s.list)
+	s.list = append(s.list, member)
+	return true
+}
+
+// Remove deletes a member and reports whether it was present.
+func (s *Set) Remove(member string) bool {
+	if s.isIntset() {
+		n, ok := parseIntsetMember(member)
+		if !ok {
+			return false
+		}
+		i, found := s.searchInt(n)
+		if !found {
+			return false
+		}
+		s.intset = append(s.intset[:i], s.intset[i+1:]...)
+		return true
+	}
+	i, exists := s.members[member]
+	if !exists {
+		return false
+	}
+	// The last member takes the place of the removed one
+	last := s.list[len(s.list)-1]
+	s.list[i] = last
+	s.members[last] = i
+	s.list[len(s.list)-1] = ""
+	s.list = s.list[:len(s.list)-1]
+	delete(s.members, member)
+	return true
+}
+
+func (s *Set) Contains(member string) bool {
+	if s.isIntset() {
+		n, ok := parseIntsetMember(member)
+		if !ok {
+			return false
+		}
+		_, found := s.searchInt(n)
+		return found
+	}
+	_, exists := s.members[member]
+	return exists
+}
+
+// Members returns every member. Intsets are returned in ascending order,
+// hashtables in sorted order so replies are deterministic.
+func (s *Set) Members() []string {
+	if s.isIntset() {
+		result := make([]string, len(s.intset))
+		for i, n := range s.intset {
+			result[i] = strconv.FormatInt(n, 10)
+		}
+		return result
+	}
+	result := append([]string(nil), s.list...)
+	sort.Strings(result)
+	return result
+}
+
+// at returns the member at position i of the encoding.
+func (s *Set) at(i int) string {
+	if s.isIntset() {
+		return strconv.FormatInt(s.intset[i], 10)
+	}
+	return s.list[i]
+}
+
+// RandomMembers returns count random members. With distinct set, members
+// are unique and at most Len() are returned; otherwise they may repeat.
+// Either way it takes time in proportion to the members returned.
+func (s *Set) RandomMembers(count int, distinct bool) []string {
+	n := s.Len()
+	if n == 0 || count <= 0 {
+		return nil
+	}
+	if !distinct {
+		result := make([]string, count)
+		for i := range result {
+			result[i] = s.at(rand.Intn(n))
+		}
+		return result
+	}
+	// A Fisher-Yates shuffle of the first count positions, with the swaps
+	// kept aside instead of applied to the set
+	count = min(count, n)
+	swapped := make(map[int]int, count)
+	position := func(i int) int {
+		if j, ok := swapped[i]; ok {
+			return j
+		}
+		return i
+	}
+	result := make([]string, count)
+	for i := range result {
+		j := i + rand.Intn(n-i)
+		pi, pj := position(i), position(j)
+		swapped[j] = pi
+		result[i] = s.at(pj)
+	}
+	return result
+}
+
+// Pop removes and returns up to count random members.
+func (s *Set) Pop(count int) []string {
+	members := s.RandomMembers(count, true)
+	for _, member := range members {
+		s.Remove(member)
+	}
+	return members
+}
+
+// Clone returns an independent copy of the set with the same encoding.
+func (s *Set) Clone() *Set {
+	clone := &Set{}
+	if s.isIntset() {
+		clone.intset = append([]int64(nil), s.intset...)
+		return clone
+	}
+	clone.members = make(map[string]int, len(s.members))
+	for member, i := range s.members {
+		clone.members[member] = i
+	}
+	clone.list = append([]string(nil), s.list...)
+	return clone
+}
@@ -0,0 +1,79 @@
+package inMemory
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSetRandomMembers(t *testing.T) {
+	set := NewSet()
+	expected := make(map[string]bool)
+
+	// Random adds and removals, with non-integer members forcing a hashtable
+	for i := 0; i < 2000; i++ {
+		member := "m" + strconv.Itoa(rand.Intn(300))
+		if rand.Intn(3) < 2 {
+			set.Add(member)
+			expected[member] = true
+		} else {
+			set.Remove(member)
+			delete(expected, member)
+		}
+	}
+
+	members := set.Members()
+	if len(members) != len(expected) || !sort.StringsAreSorted(members) {
+		t.Fatalf("TestSetRandomMembers failed: got %d members, want %d sorted", len(members), len(expected))
+	}
+	for _, member := range members {
+		if !expected[member] || !set.Contains(member) {
+			t.Errorf("TestSetRandomMembers failed: unexpected member %s", member)
+		}
+	}
+
+	for _, count := range []int{1, 10, len(expected), len(expected) + 10} {
+		picked := set.RandomMembers(count, true)
+		if len(picked) != min(count, len(expected)) {
+			t.Errorf("TestSetRandomMembers failed: got %d distinct members for count %d", len(picked), count)
+		}
+		seen := make(map[string]bool)
+		for _, member := range picked {
+			if seen[member] || !expected[member] {
+				t.Errorf("TestSetRandomMembers failed: picked %s twice or outside the set", member)
+			}
+			seen[member] = true
+		}
+	}
+
+	if picked := set.RandomMembers(5000, false); len(picked) != 5000 {
+		t.Errorf("TestSetRandomMembers failed: got %d members with repetitions, want 5000", len(picked))
+	}
+
+	popped := set.Pop(len(expected))
+	if len(popped) != len(expected) || set.Len() != 0 {
+		t.Errorf("TestSetRandomMembers failed: popped %d of %d, %d left", len(popped), len(expected), set.Len())
+	}
+}
+
+func TestSetIntsetRandomMembers(t *testing.T) {
+	set := NewSet()
+	for i := 0; i < 50; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	if set.Encoding() != "intset" {
+		t.Fatalf("TestSetIntsetRandomMembers failed: encoding is %s", set.Encoding())
+	}
+	picked := set.RandomMembers(50, true)
+	sort.Slice(picked, func(i, j int) bool {
+		a, _ := strconv.Atoi(picked[i])
+		b, _ := strconv.Atoi(picked[j])
+		return a < b
+	})
+	for i, member := range picked {
+		if member != strconv.Itoa(i) {
+			t.Fatalf("TestSetIntsetRandomMembers failed: got %v", picked)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package inMemory
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// slotIndex groups the keys by the hash slot they map to, so that cluster
+// mode can list the keys of a slot without scanning the keyspace.
+type slotIndex struct {
+	mu     sync.Mutex
+	slotOf func(key string) int
+	slots  []map[string]struct{}
+}
+
+func (idx *slotIndex) add(key string) {
+	idx.mu.Lock()
+	slot := idx.slotOf(key)
+	if idx.slots[slot] == nil {
+		idx.slots[slot] = make(map[string]struct{})
+	}
+	idx.slots[slot][key] = struct{}{}
+	idx.mu.Unlock()
+}
+
+func (idx *slotIndex) remove(key string) {
+	idx.mu.Lock()
+	slot := idx.slotOf(key)
+	delete(idx.slots[slot], key)
+	if len(idx.slots[slot]) == 0 {
+		idx.slots[slot] = nil
+	}
+	idx.mu.Unlock()
+}
+
+// IndexSlots makes the store keep its keys grouped by slot, as returned by
+// slotOf for slots between 0 and count, from now on. The keys already
+// stored are indexed before it returns.
+func (m *InMemoryStore) IndexSlots(slotOf func(key string) int, count int) {
+	idx := &slotIndex{slotOf: slotOf, slots: make([]map[string]struct{}, count)}
+	// Keys stored or removed meanwhile wait for the index to be built
+	idx.mu.Lock()
+	m.slots.Store(idx)
+	m.Store.Range(func(key, _ interface{}) bool {
+		slot := slotOf(key.(string))
+		if idx.slots[slot] == nil {
+			idx.slots[slot] = make(map[string]struct{})
+		}
+		idx.slots[slot][key.(string)] = struct{}{}
+		return true
+	})
+	idx.mu.Unlock()
+}
+
+// KeysInSlot returns up to limit keys of slot in sorted order, or all of
+// them if limit is negative. Keys that have expired are left out. It
+// returns nothing unless IndexSlots was called.
+func (m *InMemoryStore) KeysInSlot(slot, limit int) []string {
+	idx := m.slots.Load()
+	if idx == nil {
+		return nil
+	}
+	now := time.Now()
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	keys := make([]string, 0, len(idx.slots[slot]))
+	for key := range idx.slots[slot] {
+		if expTime, ok := m.expirations[key]; ok && now.After(expTime) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if limit >= 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
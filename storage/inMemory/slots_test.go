@@ -0,0 +1,37 @@
+package inMemory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeysInSlot(t *testing.T) {
+	m := &InMemoryStore{expirations: make(map[string]time.Time)}
+	m.Set("a", "1")
+	m.Set("bb", "2")
+	// Slots are the key lengths, indexed after the first keys exist
+	m.IndexSlots(func(key string) int { return len(key) }, 4)
+
+	m.Set("c", "3")
+	m.Set("dd", "4")
+	m.SetValue("e", NewList())
+	m.Delete("bb")
+	m.Set("f", "5")
+	m.SetExpiration("f", time.Now().Add(-time.Second))
+
+	if got := m.KeysInSlot(1, -1); !reflect.DeepEqual(got, []string{"a", "c", "e"}) {
+		t.Errorf("TestKeysInSlot failed: slot 1 has %v, want [a c e]", got)
+	}
+	if got := m.KeysInSlot(1, 2); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("TestKeysInSlot failed: got %v with a limit of 2, want [a c]", got)
+	}
+	if got := m.KeysInSlot(2, -1); !reflect.DeepEqual(got, []string{"dd"}) {
+		t.Errorf("TestKeysInSlot failed: slot 2 has %v, want [dd]", got)
+	}
+
+	m.Clear()
+	if got := m.KeysInSlot(1, -1); len(got) != 0 {
+		t.Errorf("TestKeysInSlot failed: slot 1 has %v after Clear", got)
+	}
+}
@@ -0,0 +1,250 @@
+package inMemory
+
+import (
+	"sync"
+	"time"
+)
+
+// Snapshot is a point-in-time view of the dataset that can be serialized in
+// the background while the live store keeps changing.
+//
+// Snapshots taken from the store are copy-on-write: taking one copies
+// nothing, and a value is only copied when it is about to change before
+// the snapshot has been read past it. Snapshots read from a file carry
+// their data in Values and Expirations instead.
+type Snapshot struct {
+	Values      map[string]interface{}
+	Expirations map[string]time.Time
+
+	store *InMemoryStore
+	cow   *cow
+	keys  int
+	ttls  int
+}
+
+// cow holds the state of a copy-on-write snapshot while it is read: the
+// values at the time of the snapshot of the keys changed since, and the
+// keys already read.
+type cow struct {
+	at time.Time
+
+	mu    sync.Mutex
+	saved map[string]snapshotEntry
+	seen  map[string]struct{}
+	// busy is the live key being read, which must not change until done is
+	// signalled.
+	busy     string
+	reading  bool
+	done     *sync.Cond
+	released bool
+}
+
+// snapshotEntry is a key as it was when the snapshot was taken. A key
+// created since is saved as not present.
+type snapshotEntry struct {
+	value   interface{}
+	expTime time.Time
+	present bool
+}
+
+func (e snapshotEntry) expiredAt(at time.Time) bool {
+	return !e.expTime.IsZero() && at.After(e.expTime)
+}
+
+// Snapshot returns a copy-on-write snapshot of every live key and its
+// expiration. It must be called from the goroutine that executes commands,
+// and the snapshot must be read with Range or given up with Release, since
+// the store keeps copying the values it changes until then.
+func (m *InMemoryStore) Snapshot() *Snapshot {
+	c := &cow{
+		at:    time.Now(),
+		saved: make(map[string]snapshotEntry),
+		seen:  make(map[string]struct{}),
+	}
+	c.done = sync.NewCond(&c.mu)
+
+	m.mutex.RLock()
+	ttls := len(m.expirations)
+	m.mutex.RUnlock()
+
+	m.snapshotsMu.Lock()
+	m.snapshots = append(m.snapshots, c)
+	m.snapshotsMu.Unlock()
+	return &Snapshot{store: m, cow: c, keys: int(m.keys.Load()), ttls: ttls}
+}
+
+// Len returns the number of keys in the snapshot. For a snapshot of the
+// store it counts keys that had expired without being deleted yet, so it
+// is only a size hint.
+func (s *Snapshot) Len() int {
+	if s.cow == nil {
+		return len(s.Values)
+	}
+	return s.keys
+}
+
+// Expiring returns the number of keys with an expiration, counted like
+// Len.
+func (s *Snapshot) Expiring() int {
+	if s.cow == nil {
+		return len(s.Expirations)
+	}
+	return s.ttls
+}
+
+// Range calls fn with every key of the snapshot, its value and its
+// expiration, zero if it has none, until fn returns false. A snapshot of
+// the store can be read only once and is released when Range returns. fn
+// must not keep the value or change it.
+func (s *Snapshot) Range(fn func(key string, value interface{}, expTime time.Time) bool) {
+	if s.cow == nil {
+		for key, value := range s.Values {
+			if !fn(key, value, s.Expirations[key]) {
+				return
+			}
+		}
+		return
+	}
+	defer s.Release()
+
+	c, m := s.cow, s.store
+	stopped := false
+	m.Store.Range(func(k, _ interface{}) bool {
+		key := k.(string)
+		c.mu.Lock()
+		if _, ok := c.seen[key]; ok {
+			c.mu.Unlock()
+			return true
+		}
+		c.seen[key] = struct{}{}
+		if e, ok := c.saved[key]; ok {
+			c.mu.Unlock()
+			if e.present && !e.expiredAt(c.at) {
+				stopped = !fn(key, e.value, e.expTime)
+			}
+			return !stopped
+		}
+		c.busy, c.reading = key, true
+		c.mu.Unlock()
+
+		// Until the key is done, changes to it wait in preserve
+		if value, ok := m.Store.Load(key); ok {
+			e := snapshotEntry{value: value, present: true}
+			e.expTime, _ = m.GetExpiration(key)
+			if hash, ok := value.(*Hash); ok && len(hash.expirations) > 0 {
+				// Reading a hash drops its expired fields
+				e.value = hash.Clone()
+			}
+			if !e.expiredAt(c.at) {
+				stopped = !fn(key, e.value, e.expTime)
+			}
+		}
+
+		c.mu.Lock()
+		c.reading = false
+		c.done.Broadcast()
+		c.mu.Unlock()
+		return !stopped
+	})
+	if stopped {
+		return
+	}
+
+	// Keys deleted before they were read
+	c.mu.Lock()
+	deleted := make(map[string]snapshotEntry)
+	for key, e := range c.saved {
+		if _, ok := c.seen[key]; !ok && e.present && !e.expiredAt(c.at) {
+			deleted[key] = e
+		}
+	}
+	c.mu.Unlock()
+	for key, e := range deleted {
+		if !fn(key, e.value, e.expTime) {
+			return
+		}
+	}
+}
+
+// Release stops the store from copying values for the snapshot. It is
+// safe to call more than once.
+func (s *Snapshot) Release() {
+	if s.cow == nil {
+		return
+	}
+	m := s.store
+	m.snapshotsMu.Lock()
+	for i, c := range m.snapshots {
+		if c == s.cow {
+			m.snapshots = append(m.snapshots[:i:i], m.snapshots[i+1:]...)
+			break
+		}
+	}
+	m.snapshotsMu.Unlock()
+
+	c := s.cow
+	c.mu.Lock()
+	c.released = true
+	c.saved, c.seen = nil, nil
+	c.mu.Unlock()
+}
+
+// WillModify must be called before the value of key is changed in place,
+// so that snapshots still being read keep the value they were taken with.
+func (m *InMemoryStore) WillModify(key string) {
+	m.preserve(key, true)
+}
+
+// preserve saves the current state of key in every snapshot that has not
+// read it yet, waiting if one is reading it right now. It must be called
+// before every change to a key, without holding mutex. Without clone the
+// value is saved as it is, for keys whose value is dropped.
+func (m *InMemoryStore) preserve(key string, clone bool) {
+	m.snapshotsMu.Lock()
+	snapshots := m.snapshots
+	m.snapshotsMu.Unlock()
+	for _, c := range snapshots {
+		c.mu.Lock()
+		for c.reading && c.busy == key {
+			c.done.Wait()
+		}
+		if !c.released {
+			if _, ok := c.seen[key]; !ok {
+				if _, ok := c.saved[key]; !ok {
+					c.saved[key] = m.entry(key, clone)
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// entry returns the current state of key.
+func (m *InMemoryStore) entry(key string, clone bool) snapshotEntry {
+	value, ok := m.Store.Load(key)
+	if !ok {
+		return snapshotEntry{}
+	}
+	if clone {
+		value = cloneValue(value)
+	}
+	e := snapshotEntry{value: value, present: true}
+	e.expTime, _ = m.GetExpiration(key)
+	return e
+}
+
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *List:
+		return v.Clone()
+	case *Hash:
+		return v.Clone()
+	case *Set:
+		return v.Clone()
+	case *SortedSet:
+		return v.Clone()
+	case *Stream:
+		return v.Clone()
+	}
+	return value
+}
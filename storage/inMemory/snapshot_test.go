@@ -0,0 +1,105 @@
+package inMemory
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	m := &InMemoryStore{expirations: make(map[string]time.Time)}
+	list := NewList()
+	list.PushBack("a")
+	m.SetValue("list", list)
+	m.Set("deleted", "x")
+	m.Set("replaced", "old")
+	m.Set("ttl", "v")
+	expTime := time.Now().Add(time.Hour)
+	m.SetExpiration("ttl", expTime)
+
+	snap := m.Snapshot()
+	if snap.Len() != 4 || snap.Expiring() != 1 {
+		t.Errorf("TestSnapshotCopyOnWrite failed: got %d keys and %d TTLs, want 4 and 1", snap.Len(), snap.Expiring())
+	}
+
+	// Changes made after the snapshot was taken must not show in it
+	m.WillModify("list")
+	list.PushBack("b")
+	m.Delete("deleted")
+	m.Set("replaced", "new")
+	m.DeleteExpiration("ttl")
+	m.Set("created", "y")
+
+	got := make(map[string]interface{})
+	ttls := make(map[string]time.Time)
+	snap.Range(func(key string, value interface{}, exp time.Time) bool {
+		if l, ok := value.(*List); ok {
+			value = l.Values()
+		}
+		got[key] = value
+		if !exp.IsZero() {
+			ttls[key] = exp
+		}
+		return true
+	})
+	want := map[string]interface{}{
+		"list":     []string{"a"},
+		"deleted":  "x",
+		"replaced": "old",
+		"ttl":      "v",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TestSnapshotCopyOnWrite failed: got %v, want %v", got, want)
+	}
+	if len(ttls) != 1 || !ttls["ttl"].Equal(expTime) {
+		t.Errorf("TestSnapshotCopyOnWrite failed: got TTLs %v", ttls)
+	}
+
+	// Once read, the snapshot no longer costs copies
+	if len(m.snapshots) != 0 {
+		t.Errorf("TestSnapshotCopyOnWrite failed: %d snapshots still registered", len(m.snapshots))
+	}
+	if values := list.Values(); !reflect.DeepEqual(values, []string{"a", "b"}) {
+		t.Errorf("TestSnapshotCopyOnWrite failed: live list is %v", values)
+	}
+}
+
+func TestSnapshotConcurrentWrites(t *testing.T) {
+	m := &InMemoryStore{expirations: make(map[string]time.Time)}
+	for i := 0; i < 1000; i++ {
+		m.Set("key:"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	snap := m.Snapshot()
+
+	done := make(chan map[string]interface{})
+	go func() {
+		got := make(map[string]interface{})
+		snap.Range(func(key string, value interface{}, _ time.Time) bool {
+			got[key] = value
+			return true
+		})
+		done <- got
+	}()
+	for i := 0; i < 1000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		switch i % 3 {
+		case 0:
+			m.Delete(key)
+		case 1:
+			m.Set(key, "changed")
+		}
+		m.Set("new:"+strconv.Itoa(i), "v")
+	}
+
+	got := <-done
+	if len(got) != 1000 {
+		t.Fatalf("TestSnapshotConcurrentWrites failed: got %d keys, want 1000", len(got))
+	}
+	for i := 0; i < 1000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if got[key] != strconv.Itoa(i) {
+			t.Errorf("TestSnapshotConcurrentWrites failed: %s is %v, want %d", key, got[key], i)
+		}
+	}
+}
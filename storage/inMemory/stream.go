@@ -0,0 +1,490 @@
+package inMemory
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamChunkSize is the number of entries per chunk of the stream log.
+// Approximate trimming (MAXLEN ~) only ever drops whole chunks.
+const streamChunkSize = 100
+
+var ErrInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// StreamID identifies a stream entry as <milliseconds>-<sequence>.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare returns -1, 0 or 1 depending on whether id sorts before, equal to
+// or after other.
+func (id StreamID) Compare(other StreamID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	}
+	return 0
+}
+
+func (id StreamID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// Next returns the smallest ID greater than id.
+func (id StreamID) Next() (StreamID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev returns the largest ID smaller than id.
+func (id StreamID) Prev() (StreamID, bool) {
+	if id.Seq > 0 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseStreamID parses "ms-seq" or "ms". A missing sequence defaults to
+// defaultSeq, which lets callers choose 0 for range starts and the maximum
+// for range ends.
+func ParseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// StreamEntry is a single entry: an ID and a flat list of field/value pairs.
+type StreamEntry struct {
+	ID      StreamID
+	Fields  []string
+	deleted bool
+}
+
+// streamChunk is a run of consecutive entries. Deleted entries are kept as
+// tombstones until the whole chunk is empty, so IDs inside a chunk stay
+// sorted and binary search keeps working.
+type streamChunk struct {
+	entries []StreamEntry
+	live    int
+}
+
+// Stream is an append-only log of entries stored as a list of chunks, with
+// the consumer groups reading from it.
+type Stream struct {
+	chunks       []*streamChunk
+	length       int
+	LastID       StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	Groups       map[string]*ConsumerGroup
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		Groups: make(map[string]*ConsumerGroup),
+	}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+// Append adds an entry at the end of the log. The caller must ensure that
+// id is greater than LastID.
+func (s *Stream) Append(id StreamID, fields []string) {
+	if len(s.chunks) == 0 || len(s.chunks[len(s.chunks)-1].entries) >= streamChunkSize {
+		s.chunks = append(s.chunks, &streamChunk{entries: make([]StreamEntry, 0, streamChunkSize)})
+	}
+	chunk := s.chunks[len(s.chunks)-1]
+	chunk.entries = append(chunk.entries, StreamEntry{ID: id, Fields: fields})
+	chunk.live++
+	s.length++
+	s.LastID = id
+	s.EntriesAdded++
+}
+
+// locate returns the chunk and offset of the first entry with an ID >= id.
+func (s *Stream) locate(id StreamID) (int, int) {
+	c := sort.Search(len(s.chunks), func(i int) bool {
+		entries := s.chunks[i].entries
+		return entries[len(entries)-1].ID.Compare(id) >= 0
+	})
+	if c == len(s.chunks) {
+		return c, 0
+	}
+	entries := s.chunks[c].entries
+	e := sort.Search(len(entries), func(i int) bool { return entries[i].ID.Compare(id) >= 0 })
+	return c, e
+}
+
+// Get returns the entry with the given ID.
+func (s *Stream) Get(id StreamID) (*StreamEntry, bool) {
+	c, e := s.locate(id)
+	if c == len(s.chunks) {
+		return nil, false
+	}
+	entry := &s.chunks[c].entries[e]
+	if entry.deleted || entry.ID != id {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Range returns up to count live entries with start <= ID <= end, walking
+// backwards from end when reverse is set. A negative count means no limit.
+func (s *Stream) Range(start, end StreamID, count int, reverse bool) []StreamEntry {
+	result := []StreamEntry{}
+	if start.Compare(end) > 0 || count == 0 {
+		return result
+	}
+
+	if !reverse {
+		c, e := s.locate(start)
+		for ; c < len(s.chunks); c, e = c+1, 0 {
+			for entries := s.chunks[c].entries; e < len(entries); e++ {
+				if entries[e].ID.Compare(end) > 0 {
+					return result
+				}
+				if entries[e].deleted {
+					continue
+				}
+				result = append(result, entries[e])
+				if len(result) == count {
+					return result
+				}
+			}
+		}
+		return result
+	}
+
+	after, ok := end.Next()
+	c, e := len(s.chunks), 0
+	if ok {
+		c, e = s.locate(after)
+	}
+	// Step back to the last entry <= end
+	for {
+		if e > 0 {
+			e--
+		} else if c > 0 {
+			c--
+			e = len(s.chunks[c].entries) - 1
+		} else {
+			return result
+		}
+		entry := s.chunks[c].entries[e]
+		if entry.ID.Compare(start) < 0 {
+			return result
+		}
+		if entry.deleted {
+			continue
+		}
+		result = append(result, entry)
+		if len(result) == count {
+			return result
+		}
+	}
+}
+
+// Delete removes an entry and reports whether it existed.
+func (s *Stream) Delete(id StreamID) bool {
+	c, e := s.locate(id)
+	if c == len(s.chunks) {
+		return false
+	}
+	chunk := s.chunks[c]
+	if chunk.entries[e].ID != id || chunk.entries[e].deleted {
+		return false
+	}
+	chunk.entries[e].deleted = true
+	chunk.entries[e].Fields = nil
+	chunk.live--
+	s.length--
+	if id.Compare(s.MaxDeletedID) > 0 {
+		s.MaxDeletedID = id
+	}
+	if chunk.live == 0 {
+		s.chunks = append(s.chunks[:c], s.chunks[c+1:]...)
+	}
+	return true
+}
+
+// First returns the first live entry.
+func (s *Stream) First() (StreamEntry, bool) {
+	entries := s.Range(StreamID{}, MaxStreamID, 1, false)
+	if len(entries) == 0 {
+		return StreamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// Last returns the last live entry.
+func (s *Stream) Last() (StreamEntry, bool) {
+	entries := s.Range(StreamID{}, MaxStreamID, 1, true)
+	if len(entries) == 0 {
+		return StreamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// Trim evicts entries from the head of the stream while shouldEvict
+// returns true. shouldEvict receives the candidate entry and the length the
+// stream would have after evicting it. With approx set only whole chunks are
+// dropped, judged by their last entry, which is much cheaper. limit caps the
+// number of evicted entries (0 = unlimited).
+func (s *Stream) Trim(shouldEvict func(entry StreamEntry, remaining int) bool, approx bool, limit int) int {
+	evicted := 0
+	for len(s.chunks) > 0 {
+		chunk := s.chunks[0]
+		if approx {
+			last := chunk.entries[len(chunk.entries)-1]
+			if !shouldEvict(last, s.length-chunk.live) || (limit > 0 && evicted+chunk.live > limit) {
+				return evicted
+			}
+			evicted += chunk.live
+			s.length -= chunk.live
+			s.chunks = s.chunks[1:]
+			continue
+		}
+
+		for len(chunk.entries) > 0 {
+			entry := chunk.entries[0]
+			if !entry.deleted {
+				if !shouldEvict(entry, s.length-1) || (limit > 0 && evicted == limit) {
+					return evicted
+				}
+				chunk.live--
+				s.length--
+				evicted++
+			}
+			chunk.entries = chunk.entries[1:]
+		}
+		s.chunks = s.chunks[1:]
+	}
+	return evicted
+}
+
+// TrimMaxLen evicts the oldest entries until at most maxLen remain.
+func (s *Stream) TrimMaxLen(maxLen int, approx bool, limit int) int {
+	return s.Trim(func(entry StreamEntry, remaining int) bool {
+		return remaining >= maxLen
+	}, approx, limit)
+}
+
+// TrimMinID evicts entries with an ID lower than minID.
+func (s *Stream) TrimMinID(minID StreamID, approx bool, limit int) int {
+	return s.Trim(func(entry StreamEntry, remaining int) bool {
+		return entry.ID.Compare(minID) < 0
+	}, approx, limit)
+}
+
+// PendingEntry tracks a message delivered to a consumer but not yet
+// acknowledged.
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  time.Time
+	DeliveryCount int
+}
+
+// Consumer is a named reader inside a consumer group.
+type Consumer struct {
+	Name       string
+	SeenTime   time.Time
+	ActiveTime time.Time
+	Pending    map[StreamID]*PendingEntry
+}
+
+// ConsumerGroup tracks the last delivered ID and the pending entries list
+// (PEL) shared by its consumers.
+type ConsumerGroup struct {
+	Name            string
+	LastDeliveredID StreamID
+	EntriesRead     int64
+	Pending         map[StreamID]*PendingEntry
+	Consumers       map[string]*Consumer
+}
+
+// CreateGroup adds a consumer group and reports whether it was created.
+func (s *Stream) CreateGroup(name string, lastID StreamID, entriesRead int64) bool {
+	if _, exists := s.Groups[name]; exists {
+		return false
+	}
+	s.Groups[name] = &ConsumerGroup{
+		Name:            name,
+		LastDeliveredID: lastID,
+		EntriesRead:     entriesRead,
+		Pending:         make(map[StreamID]*PendingEntry),
+		Consumers:       make(map[string]*Consumer),
+	}
+	return true
+}
+
+// Consumer returns the named consumer, creating it when create is set. The
+// second return value reports whether a new consumer was created.
+func (g *ConsumerGroup) Consumer(name string, create bool) (*Consumer, bool) {
+	if consumer, ok := g.Consumers[name]; ok {
+		return consumer, false
+	}
+	if !create {
+		return nil, false
+	}
+	consumer := &Consumer{
+		Name:     name,
+		SeenTime: time.Now(),
+		Pending:  make(map[StreamID]*PendingEntry),
+	}
+	g.Consumers[name] = consumer
+	return consumer, true
+}
+
+// DeleteConsumer removes a consumer and its pending entries, returning the
+// number of pending entries it had.
+func (g *ConsumerGroup) DeleteConsumer(name string) int {
+	consumer, ok := g.Consumers[name]
+	if !ok {
+		return 0
+	}
+	for id := range consumer.Pending {
+		delete(g.Pending, id)
+	}
+	delete(g.Consumers, name)
+	return len(consumer.Pending)
+}
+
+// Deliver records that an entry was handed to consumer.
+func (g *ConsumerGroup) Deliver(consumer *Consumer, id StreamID, now time.Time) {
+	if pending, ok := g.Pending[id]; ok {
+		// Re-delivery to a possibly different consumer
+		if owner, ok := g.Consumers[pending.Consumer]; ok {
+			delete(owner.Pending, id)
+		}
+		pending.Consumer = consumer.Name
+		pending.DeliveryTime = now
+		pending.DeliveryCount++
+		consumer.Pending[id] = pending
+		return
+	}
+	pending := &PendingEntry{ID: id, Consumer: consumer.Name, DeliveryTime: now, DeliveryCount: 1}
+	g.Pending[id] = pending
+	consumer.Pending[id] = pending
+}
+
+// Ack removes an entry from the PEL and reports whether it was pending.
+func (g *ConsumerGroup) Ack(id StreamID) bool {
+	pending, ok := g.Pending[id]
+	if !ok {
+		return false
+	}
+	if consumer, ok := g.Consumers[pending.Consumer]; ok {
+		delete(consumer.Pending, id)
+	}
+	delete(g.Pending, id)
+	return true
+}
+
+// PendingRange returns pending entries with start <= ID <= end in ID order,
+// optionally restricted to one consumer.
+func (g *ConsumerGroup) PendingRange(start, end StreamID, consumer string) []*PendingEntry {
+	source := g.Pending
+	if consumer != "" {
+		c, ok := g.Consumers[consumer]
+		if !ok {
+			return nil
+		}
+		source = c.Pending
+	}
+	result := make([]*PendingEntry, 0, len(source))
+	for id, pending := range source {
+		if id.Compare(start) >= 0 && id.Compare(end) <= 0 {
+			result = append(result, pending)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID.Compare(result[j].ID) < 0 })
+	return result
+}
+
+// Clone returns an independent copy of the stream and its consumer groups.
+// Entry field slices are shared since entries are never modified in place.
+func (s *Stream) Clone() *Stream {
+	clone := &Stream{
+		chunks:       make([]*streamChunk, len(s.chunks)),
+		length:       s.length,
+		LastID:       s.LastID,
+		MaxDeletedID: s.MaxDeletedID,
+		EntriesAdded: s.EntriesAdded,
+		Groups:       make(map[string]*ConsumerGroup, len(s.Groups)),
+	}
+	for i, chunk := range s.chunks {
+		clone.chunks[i] = &streamChunk{
+			entries: append([]StreamEntry(nil), chunk.entries...),
+			live:    chunk.live,
+		}
+	}
+	for name, g := range s.Groups {
+		clone.Groups[name] = g.clone()
+	}
+	return clone
+}
+
+func (g *ConsumerGroup) clone() *ConsumerGroup {
+	clone := &ConsumerGroup{
+		Name:            g.Name,
+		LastDeliveredID: g.LastDeliveredID,
+		EntriesRead:     g.EntriesRead,
+		Pending:         make(map[StreamID]*PendingEntry, len(g.Pending)),
+		Consumers:       make(map[string]*Consumer, len(g.Consumers)),
+	}
+	for name, c := range g.Consumers {
+		clone.Consumers[name] = &Consumer{
+			Name:       c.Name,
+			SeenTime:   c.SeenTime,
+			ActiveTime: c.ActiveTime,
+			Pending:    make(map[StreamID]*PendingEntry, len(c.Pending)),
+		}
+	}
+	// The group and consumer PELs share entries, so keep them shared
+	for id, p := range g.Pending {
+		copied := *p
+		clone.Pending[id] = &copied
+		if c, ok := clone.Consumers[p.Consumer]; ok {
+			c.Pending[id] = &copied
+		}
+	}
+	return clone
+}
@@ -0,0 +1,161 @@
+package inMemory
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStreamIDs(t *testing.T) {
+	tests := []struct {
+		in         string
+		defaultSeq uint64
+		want       StreamID
+		wantErr    bool
+	}{
+		{"5-3", 0, StreamID{5, 3}, false},
+		{"5", 0, StreamID{5, 0}, false},
+		{"5", math.MaxUint64, StreamID{5, math.MaxUint64}, false},
+		{"5-", 0, StreamID{}, true},
+		{"-3", 0, StreamID{}, true},
+		{"x-1", 0, StreamID{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStreamID(tt.in, tt.defaultSeq)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("TestStreamIDs failed: ParseStreamID(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+
+	if next, ok := (StreamID{5, math.MaxUint64}).Next(); !ok || next != (StreamID{6, 0}) {
+		t.Errorf("TestStreamIDs failed: Next of 5-max is %v", next)
+	}
+	if _, ok := MaxStreamID.Next(); ok {
+		t.Errorf("TestStreamIDs failed: the maximum ID has a next")
+	}
+	if prev, ok := (StreamID{6, 0}).Prev(); !ok || prev != (StreamID{5, math.MaxUint64}) {
+		t.Errorf("TestStreamIDs failed: Prev of 6-0 is %v", prev)
+	}
+	if _, ok := (StreamID{}).Prev(); ok {
+		t.Errorf("TestStreamIDs failed: 0-0 has a previous")
+	}
+	if (StreamID{1, 9}).Compare(StreamID{2, 0}) != -1 || (StreamID{2, 1}).Compare(StreamID{2, 0}) != 1 {
+		t.Errorf("TestStreamIDs failed: Compare orders by ms, then sequence")
+	}
+}
+
+// newTestStream returns a stream of n entries with IDs 1-0 to n-0.
+func newTestStream(n int) *Stream {
+	s := NewStream()
+	for i := 1; i <= n; i++ {
+		s.Append(StreamID{Ms: uint64(i)}, []string{"f", "v"})
+	}
+	return s
+}
+
+func TestStreamTrim(t *testing.T) {
+	s := newTestStream(250)
+	if n := s.TrimMaxLen(240, false, 0); n != 10 || s.Len() != 240 {
+		t.Errorf("TestStreamTrim failed: exact MAXLEN evicted %d, %d left", n, s.Len())
+	}
+	if first, _ := s.First(); first.ID != (StreamID{Ms: 11}) {
+		t.Errorf("TestStreamTrim failed: first entry is %v after MAXLEN", first.ID)
+	}
+
+	// Approximate trimming only drops whole chunks
+	if n := s.TrimMaxLen(100, true, 0); n != 90 || s.Len() != 150 {
+		t.Errorf("TestStreamTrim failed: approximate MAXLEN evicted %d, %d left", n, s.Len())
+	}
+	if n := s.TrimMaxLen(0, true, 10); n != 0 {
+		t.Errorf("TestStreamTrim failed: a limit below the chunk size evicted %d", n)
+	}
+
+	if n := s.TrimMinID(StreamID{Ms: 205}, false, 0); n != 104 || s.Len() != 46 {
+		t.Errorf("TestStreamTrim failed: MINID evicted %d, %d left", n, s.Len())
+	}
+
+	// Deleted entries do not count towards the length
+	s.Delete(StreamID{Ms: 206})
+	s.Delete(StreamID{Ms: 207})
+	if n := s.TrimMaxLen(40, false, 0); n != 4 || s.Len() != 40 {
+		t.Errorf("TestStreamTrim failed: MAXLEN past deleted entries evicted %d, %d left", n, s.Len())
+	}
+	if first, _ := s.First(); first.ID != (StreamID{Ms: 211}) {
+		t.Errorf("TestStreamTrim failed: first entry is %v past deleted entries", first.ID)
+	}
+}
+
+func TestStreamDeleteAndRange(t *testing.T) {
+	s := newTestStream(10)
+	if !s.Delete(StreamID{Ms: 5}) || s.Delete(StreamID{Ms: 5}) || s.Delete(StreamID{Ms: 50}) {
+		t.Errorf("TestStreamDeleteAndRange failed: Delete did not report the entry once")
+	}
+	if _, ok := s.Get(StreamID{Ms: 5}); ok {
+		t.Errorf("TestStreamDeleteAndRange failed: deleted entry still found")
+	}
+
+	entries := s.Range(StreamID{Ms: 3}, StreamID{Ms: 8}, -1, false)
+	want := []uint64{3, 4, 6, 7, 8}
+	if len(entries) != len(want) {
+		t.Fatalf("TestStreamDeleteAndRange failed: got %d entries, want %d", len(entries), len(want))
+	}
+	for i, e := range entries {
+		if e.ID.Ms != want[i] {
+			t.Errorf("TestStreamDeleteAndRange failed: entry %d is %v, want %d-0", i, e.ID, want[i])
+		}
+	}
+	reverse := s.Range(StreamID{}, MaxStreamID, 2, true)
+	if len(reverse) != 2 || reverse[0].ID.Ms != 10 || reverse[1].ID.Ms != 9 {
+		t.Errorf("TestStreamDeleteAndRange failed: reverse range is %v", reverse)
+	}
+}
+
+func TestConsumerGroups(t *testing.T) {
+	s := newTestStream(3)
+	if !s.CreateGroup("g", StreamID{}, 0) || s.CreateGroup("g", StreamID{}, 0) {
+		t.Fatalf("TestConsumerGroups failed: CreateGroup did not create the group once")
+	}
+	g := s.Groups["g"]
+	alice, created := g.Consumer("alice", true)
+	if !created {
+		t.Fatalf("TestConsumerGroups failed: consumer not created")
+	}
+	if _, ok := g.Consumer("bob", false); ok {
+		t.Errorf("TestConsumerGroups failed: missing consumer created without create")
+	}
+	bob, _ := g.Consumer("bob", true)
+
+	now := time.Now()
+	for ms := uint64(1); ms <= 3; ms++ {
+		g.Deliver(alice, StreamID{Ms: ms}, now)
+	}
+	// A re-delivery moves the entry to its new consumer
+	g.Deliver(bob, StreamID{Ms: 2}, now)
+	if p := g.Pending[StreamID{Ms: 2}]; p.Consumer != "bob" || p.DeliveryCount != 2 {
+		t.Errorf("TestConsumerGroups failed: re-delivered entry is %+v", p)
+	}
+	if len(alice.Pending) != 2 || len(bob.Pending) != 1 {
+		t.Errorf("TestConsumerGroups failed: alice has %d pending, bob %d", len(alice.Pending), len(bob.Pending))
+	}
+
+	if !g.Ack(StreamID{Ms: 1}) || g.Ack(StreamID{Ms: 1}) {
+		t.Errorf("TestConsumerGroups failed: Ack did not report the entry once")
+	}
+	if pending := g.PendingRange(StreamID{}, MaxStreamID, ""); len(pending) != 2 || pending[0].ID.Ms != 2 {
+		t.Errorf("TestConsumerGroups failed: pending range is %v", pending)
+	}
+	if pending := g.PendingRange(StreamID{}, MaxStreamID, "alice"); len(pending) != 1 || pending[0].ID.Ms != 3 {
+		t.Errorf("TestConsumerGroups failed: pending range of alice is %v", pending)
+	}
+
+	if n := g.DeleteConsumer("alice"); n != 1 || len(g.Pending) != 1 {
+		t.Errorf("TestConsumerGroups failed: deleting alice dropped %d, %d pending left", n, len(g.Pending))
+	}
+
+	clone := s.Clone()
+	clone.Groups["g"].Ack(StreamID{Ms: 2})
+	clone.Append(StreamID{Ms: 4}, nil)
+	if len(g.Pending) != 1 || s.Len() != 3 {
+		t.Errorf("TestConsumerGroups failed: changing a clone changed the stream")
+	}
+}
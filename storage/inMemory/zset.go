@@ -0,0 +1,248 @@
+package inMemory
+
+// ScoredMember is a sorted set member together with its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreBound is one end of a score range such as "(1.5" or "+inf".
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// LexBound is one end of a lexicographical range such as "[a", "(b", "-"
+// or "+". Inf is -1 for "-", 1 for "+" and 0 for a concrete value.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+func (b ScoreBound) belowOrAt(score float64) bool {
+	if b.Exclusive {
+		return b.Value < score
+	}
+	return b.Value <= score
+}
+
+func (b ScoreBound) aboveOrAt(score float64) bool {
+	if b.Exclusive {
+		return score < b.Value
+	}
+	return score <= b.Value
+}
+
+func (b LexBound) belowOrAt(member string) bool {
+	switch b.Inf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	if b.Exclusive {
+		return b.Value < member
+	}
+	return b.Value <= member
+}
+
+func (b LexBound) aboveOrAt(member string) bool {
+	switch b.Inf {
+	case -1:
+		return false
+	case 1:
+		return true
+	}
+	if b.Exclusive {
+		return member < b.Value
+	}
+	return member <= b.Value
+}
+
+// SortedSet keeps members ordered by score using a skiplist, with a map from
+// member to score for O(1) lookups.
+type SortedSet struct {
+	dict map[string]float64
+	zsl  *skiplist
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		dict: make(map[string]float64),
+		zsl:  newSkiplist(),
+	}
+}
+
+func (z *SortedSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Add sets the score of member and reports whether the member is new.
+func (z *SortedSet) Add(member string, score float64) bool {
+	current, exists := z.dict[member]
+	if exists {
+		if current == score {
+			return false
+		}
+		z.zsl.delete(current, member)
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return !exists
+}
+
+// Remove deletes member and reports whether it was present.
+func (z *SortedSet) Remove(member string) bool {
+	score, exists := z.dict[member]
+	if !exists {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+// Rank returns the 0-based position of member, counting from the highest
+// score when reverse is set.
+func (z *SortedSet) Rank(member string, reverse bool) (int, bool) {
+	score, exists := z.dict[member]
+	if !exists {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.Len() - rank, true
+	}
+	return rank - 1, true
+}
+
+// collect walks count nodes starting at x, forwards or backwards.
+func collect(x *skiplistNode, reverse bool, count int, accept func(*skiplistNode) bool) []ScoredMember {
+	result := []ScoredMember{}
+	for x != nil && count != 0 && accept(x) {
+		result = append(result, ScoredMember{Member: x.member, Score: x.score})
+		count--
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return result
+}
+
+// RangeByRank returns members between the inclusive start and stop ranks.
+// Negative ranks count from the end.
+func (z *SortedSet) RangeByRank(start, stop int, reverse bool) []ScoredMember {
+	length := z.Len()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return []ScoredMember{}
+	}
+
+	var x *skiplistNode
+	if reverse {
+		x = z.zsl.byRank(length - start)
+	} else {
+		x = z.zsl.byRank(start + 1)
+	}
+	return collect(x, reverse, stop-start+1, func(*skiplistNode) bool { return true })
+}
+
+// skip advances offset nodes from x in the given direction.
+func skip(x *skiplistNode, reverse bool, offset int) *skiplistNode {
+	for ; x != nil && offset > 0; offset-- {
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return x
+}
+
+// RangeByScore returns members with a score between min and max. A negative
+// count returns every match after offset.
+func (z *SortedSet) RangeByScore(min, max ScoreBound, reverse bool, offset, count int) []ScoredMember {
+	var x *skiplistNode
+	var accept func(*skiplistNode) bool
+	if reverse {
+		x = z.zsl.lastMatching(func(n *skiplistNode) bool { return max.aboveOrAt(n.score) })
+		accept = func(n *skiplistNode) bool { return min.belowOrAt(n.score) }
+	} else {
+		x = z.zsl.firstMatching(func(n *skiplistNode) bool { return min.belowOrAt(n.score) })
+		accept = func(n *skiplistNode) bool { return max.aboveOrAt(n.score) }
+	}
+	return collect(skip(x, reverse, offset), reverse, count, accept)
+}
+
+// RangeByLex returns members between min and max in lexicographical order.
+// It is only meaningful when all members share the same score.
+func (z *SortedSet) RangeByLex(min, max LexBound, reverse bool, offset, count int) []ScoredMember {
+	var x *skiplistNode
+	var accept func(*skiplistNode) bool
+	if reverse {
+		x = z.zsl.lastMatching(func(n *skiplistNode) bool { return max.aboveOrAt(n.member) })
+		accept = func(n *skiplistNode) bool { return min.belowOrAt(n.member) }
+	} else {
+		x = z.zsl.firstMatching(func(n *skiplistNode) bool { return min.belowOrAt(n.member) })
+		accept = func(n *skiplistNode) bool { return max.aboveOrAt(n.member) }
+	}
+	return collect(skip(x, reverse, offset), reverse, count, accept)
+}
+
+// CountByScore returns the number of members with a score in [min, max].
+func (z *SortedSet) CountByScore(min, max ScoreBound) int {
+	first := z.zsl.firstMatching(func(n *skiplistNode) bool { return min.belowOrAt(n.score) })
+	if first == nil || !max.aboveOrAt(first.score) {
+		return 0
+	}
+	last := z.zsl.lastMatching(func(n *skiplistNode) bool { return max.aboveOrAt(n.score) })
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// CountByLex returns the number of members between min and max.
+func (z *SortedSet) CountByLex(min, max LexBound) int {
+	return len(z.RangeByLex(min, max, false, 0, -1))
+}
+
+// Pop removes and returns up to count members with the lowest scores, or
+// the highest when max is set.
+func (z *SortedSet) Pop(count int, max bool) []ScoredMember {
+	popped := z.RangeByRank(0, count-1, max)
+	for _, m := range popped {
+		z.Remove(m.Member)
+	}
+	return popped
+}
+
+// Members returns every member in ascending score order.
+func (z *SortedSet) Members() []ScoredMember {
+	return z.RangeByRank(0, -1, false)
+}
+
+// Clone returns an independent copy of the sorted set.
+func (z *SortedSet) Clone() *SortedSet {
+	clone := NewSortedSet()
+	for _, m := range z.Members() {
+		clone.Add(m.Member, m.Score)
+	}
+	return clone
+}
@@ -0,0 +1,78 @@
+package inMemory
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSortedSetRankAndRange(t *testing.T) {
+	zset := NewSortedSet()
+	expected := make(map[string]float64)
+
+	// Random inserts, score updates and removals
+	for i := 0; i < 2000; i++ {
+		member := "m" + strconv.Itoa(rand.Intn(300))
+		switch rand.Intn(3) {
+		case 0, 1:
+			score := float64(rand.Intn(50))
+			zset.Add(member, score)
+			expected[member] = score
+		case 2:
+			zset.Remove(member)
+			delete(expected, member)
+		}
+	}
+
+	ordered := make([]ScoredMember, 0, len(expected))
+	for member, score := range expected {
+		ordered = append(ordered, ScoredMember{Member: member, Score: score})
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].Score != ordered[j].Score {
+			return ordered[i].Score < ordered[j].Score
+		}
+		return ordered[i].Member < ordered[j].Member
+	})
+
+	if zset.Len() != len(ordered) {
+		t.Fatalf("TestSortedSetRankAndRange expected length %d, got %d", len(ordered), zset.Len())
+	}
+	for i, m := range ordered {
+		rank, ok := zset.Rank(m.Member, false)
+		if !ok || rank != i {
+			t.Errorf("TestSortedSetRankAndRange rank of %s: expected %d, got %d", m.Member, i, rank)
+		}
+		revRank, _ := zset.Rank(m.Member, true)
+		if revRank != len(ordered)-1-i {
+			t.Errorf("TestSortedSetRankAndRange reverse rank of %s: expected %d, got %d", m.Member, len(ordered)-1-i, revRank)
+		}
+	}
+
+	members := zset.Members()
+	for i := range members {
+		if members[i] != ordered[i] {
+			t.Fatalf("TestSortedSetRankAndRange member %d: expected %v, got %v", i, ordered[i], members[i])
+		}
+	}
+
+	// Score range (10, 20] against a linear scan
+	count := 0
+	for _, m := range ordered {
+		if m.Score > 10 && m.Score <= 20 {
+			count++
+		}
+	}
+	min := ScoreBound{Value: 10, Exclusive: true}
+	max := ScoreBound{Value: 20}
+	if got := len(zset.RangeByScore(min, max, false, 0, -1)); got != count {
+		t.Errorf("TestSortedSetRankAndRange RangeByScore expected %d members, got %d", count, got)
+	}
+	if got := len(zset.RangeByScore(min, max, true, 0, -1)); got != count {
+		t.Errorf("TestSortedSetRankAndRange reverse RangeByScore expected %d members, got %d", count, got)
+	}
+	if got := zset.CountByScore(min, max); got != count {
+		t.Errorf("TestSortedSetRankAndRange CountByScore expected %d, got %d", count, got)
+	}
+}
@@ -0,0 +1,230 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var errPacked = errors.New("invalid packed encoding")
+
+// Redis stores small collections as a single string holding a compact
+// serialisation: intsets for integer-only sets, ziplists in RDB versions
+// before 10 and listpacks after. These helpers flatten them to strings.
+
+// decodeIntset decodes [encoding][length][int...] with little-endian
+// integers of 2, 4 or 8 bytes.
+func decodeIntset(data []byte) ([]string, error) {
+	if len(data) < 8 {
+		return nil, errPacked
+	}
+	width := int(binary.LittleEndian.Uint32(data[0:4]))
+	count := int(binary.LittleEndian.Uint32(data[4:8]))
+	if (width != 2 && width != 4 && width != 8) || len(data) != 8+width*count {
+		return nil, errPacked
+	}
+	values := make([]string, count)
+	for i := 0; i < count; i++ {
+		b := data[8+i*width:]
+		var n int64
+		switch width {
+		case 2:
+			n = int64(int16(binary.LittleEndian.Uint16(b)))
+		case 4:
+			n = int64(int32(binary.LittleEndian.Uint32(b)))
+		case 8:
+			n = int64(binary.LittleEndian.Uint64(b))
+		}
+		values[i] = strconv.FormatInt(n, 10)
+	}
+	return values, nil
+}
+
+// decodeZiplist decodes the entries of a ziplist:
+// [zlbytes][zltail][zllen] ([prevlen][encoding][data])... [0xFF]
+func decodeZiplist(data []byte) ([]string, error) {
+	if len(data) < 11 {
+		return nil, errPacked
+	}
+	var values []string
+	pos := 10
+	for {
+		if pos >= len(data) {
+			return nil, errPacked
+		}
+		if data[pos] == 0xFF {
+			return values, nil
+		}
+		// Skip the length of the previous entry
+		if data[pos] == 0xFE {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, errPacked
+		}
+
+		enc := data[pos]
+		var value string
+		switch {
+		case enc>>6 == 0:
+			value, pos = sliceString(data, pos+1, int(enc&0x3f))
+		case enc>>6 == 1:
+			if pos+1 >= len(data) {
+				return nil, errPacked
+			}
+			value, pos = sliceString(data, pos+2, int(enc&0x3f)<<8|int(data[pos+1]))
+		case enc == 0x80:
+			if pos+5 > len(data) {
+				return nil, errPacked
+			}
+			value, pos = sliceString(data, pos+5, int(binary.BigEndian.Uint32(data[pos+1:])))
+		default:
+			var n int64
+			var ok bool
+			n, pos, ok = ziplistInt(data, pos)
+			if !ok {
+				return nil, errPacked
+			}
+			value = strconv.FormatInt(n, 10)
+		}
+		if pos < 0 {
+			return nil, errPacked
+		}
+		values = append(values, value)
+	}
+}
+
+// ziplistInt decodes an integer entry whose encoding byte is at pos.
+func ziplistInt(data []byte, pos int) (int64, int, bool) {
+	enc := data[pos]
+	pos++
+	width := 0
+	switch enc {
+	case 0xC0:
+		width = 2
+	case 0xD0:
+		width = 4
+	case 0xE0:
+		width = 8
+	case 0xF0:
+		width = 3
+	case 0xFE:
+		width = 1
+	default:
+		// 1111xxxx holds the value xxxx-1 directly
+		if enc >= 0xF1 && enc <= 0xFD {
+			return int64(enc&0x0f) - 1, pos, true
+		}
+		return 0, 0, false
+	}
+	if pos+width > len(data) {
+		return 0, 0, false
+	}
+	b := data[pos : pos+width]
+	var n int64
+	switch width {
+	case 1:
+		n = int64(int8(b[0]))
+	case 2:
+		n = int64(int16(binary.LittleEndian.Uint16(b)))
+	case 3:
+		n = int64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+	case 4:
+		n = int64(int32(binary.LittleEndian.Uint32(b)))
+	case 8:
+		n = int64(binary.LittleEndian.Uint64(b))
+	}
+	return n, pos + width, true
+}
+
+// sliceString returns length bytes at pos and the position after them, or
+// a negative position when data is too short.
+func sliceString(data []byte, pos, length int) (string, int) {
+	if pos+length > len(data) {
+		return "", -1
+	}
+	return string(data[pos : pos+length]), pos + length
+}
+
+// decodeListpack decodes the entries of a listpack:
+// [total bytes][count] ([encoding][data][backlen])... [0xFF]
+func decodeListpack(data []byte) ([]string, error) {
+	if len(data) < 7 {
+		return nil, errPacked
+	}
+	var values []string
+	pos := 6
+	for {
+		if pos >= len(data) {
+			return nil, errPacked
+		}
+		start := pos
+		enc := data[pos]
+		var value string
+		switch {
+		case enc == 0xFF:
+			return values, nil
+		case enc&0x80 == 0:
+			value = strconv.Itoa(int(enc))
+			pos++
+		case enc&0xC0 == 0x80:
+			value, pos = sliceString(data, pos+1, int(enc&0x3f))
+		case enc&0xE0 == 0xC0:
+			if pos+1 >= len(data) {
+				return nil, errPacked
+			}
+			n := int64(enc&0x1f)<<8 | int64(data[pos+1])
+			if n >= 1<<12 {
+				n -= 1 << 13
+			}
+			value = strconv.FormatInt(n, 10)
+			pos += 2
+		case enc&0xF0 == 0xE0:
+			if pos+1 >= len(data) {
+				return nil, errPacked
+			}
+			value, pos = sliceString(data, pos+2, int(enc&0x0f)<<8|int(data[pos+1]))
+		case enc == 0xF0:
+			if pos+5 > len(data) {
+				return nil, errPacked
+			}
+			value, pos = sliceString(data, pos+5, int(binary.LittleEndian.Uint32(data[pos+1:])))
+		default:
+			width := map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}[enc]
+			if width == 0 || pos+1+width > len(data) {
+				return nil, errPacked
+			}
+			var u uint64
+			for i := width - 1; i >= 0; i-- {
+				u = u<<8 | uint64(data[pos+1+i])
+			}
+			// Sign-extend from the encoded width
+			shift := 64 - 8*width
+			value = strconv.FormatInt(int64(u<<shift)>>shift, 10)
+			pos += 1 + width
+		}
+		if pos < 0 {
+			return nil, errPacked
+		}
+		values = append(values, value)
+		pos += backlenSize(pos - start)
+	}
+}
+
+// backlenSize returns how many bytes the backlen of an entry of the given
+// size takes.
+func backlenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
@@ -0,0 +1,123 @@
+package rdb
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	zset := inMemory.NewSortedSet()
+	zset.Add("a", 1.5)
+	zset.Add("b", -2)
+	hash := inMemory.NewHash()
+	hash.Set("f", "v")
+	expiry := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	snap := &inMemory.Snapshot{
+		Values: map[string]interface{}{
+			"str":  "hello",
+			"num":  "12345",
+			"list": newList([]string{"x", "y", "x"}),
+			"set":  newSet([]string{"m", "n"}),
+			"zset": zset,
+			"hash": hash,
+		},
+		Expirations: map[string]time.Time{"str": expiry},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, snap, nil); err != nil {
+		t.Fatalf("TestWriteReadRoundTrip failed: Write returned %v", err)
+	}
+	if !IsRDB(buf.Bytes()) {
+		t.Fatalf("TestWriteReadRoundTrip failed: written file is not recognised as RDB")
+	}
+	got, err := Read(buf.Bytes())
+	if err != nil {
+		t.Fatalf("TestWriteReadRoundTrip failed: Read returned %v", err)
+	}
+
+	if got.Values["str"] != "hello" || got.Values["num"] != "12345" {
+		t.Errorf("TestWriteReadRoundTrip failed: got strings %v and %v", got.Values["str"], got.Values["num"])
+	}
+	if !got.Expirations["str"].Equal(expiry) {
+		t.Errorf("TestWriteReadRoundTrip failed: got expiration %v, want %v", got.Expirations["str"], expiry)
+	}
+	if list := got.Values["list"].(*inMemory.List).Values(); !reflect.DeepEqual(list, []string{"x", "y", "x"}) {
+		t.Errorf("TestWriteReadRoundTrip failed: got list %v", list)
+	}
+	if set := got.Values["set"].(*inMemory.Set); set.Len() != 2 || !set.Contains("m") {
+		t.Errorf("TestWriteReadRoundTrip failed: got set members %v", set.Members())
+	}
+	members := got.Values["zset"].(*inMemory.SortedSet).Members()
+	if len(members) != 2 || members[0].Member != "b" || members[0].Score != -2 || members[1].Score != 1.5 {
+		t.Errorf("TestWriteReadRoundTrip failed: got zset %v", members)
+	}
+	if v, _ := got.Values["hash"].(*inMemory.Hash).Get("f"); v != "v" {
+		t.Errorf("TestWriteReadRoundTrip failed: got hash field %q", v)
+	}
+
+	// A flipped byte must be caught by the checksum
+	data := buf.Bytes()
+	data[len(data)-12] ^= 0xFF
+	if _, err := Read(data); err == nil {
+		t.Errorf("TestWriteReadRoundTrip failed: corrupted file was accepted")
+	}
+}
+
+func TestDecodePacked(t *testing.T) {
+	intset := []byte{2, 0, 0, 0, 3, 0, 0, 0, 0xFF, 0xFF, 1, 0, 0, 1}
+	if got, err := decodeIntset(intset); err != nil || !reflect.DeepEqual(got, []string{"-1", "1", "256"}) {
+		t.Errorf("TestDecodePacked failed: got intset %v, %v", got, err)
+	}
+
+	// "ab", 5 and 12 as a 6-bit string, a 7-bit uint and a 13-bit int
+	listpack := []byte{0, 0, 0, 0, 3, 0,
+		0x82, 'a', 'b', 3,
+		0x05, 1,
+		0xC0, 12, 2,
+		0xFF}
+	if got, err := decodeListpack(listpack); err != nil || !reflect.DeepEqual(got, []string{"ab", "5", "12"}) {
+		t.Errorf("TestDecodePacked failed: got listpack %v, %v", got, err)
+	}
+
+	// "ab" followed by the immediate integer 7
+	ziplist := []byte{0, 0, 0, 0, 0, 0, 0, 0, 2, 0,
+		0, 0x02, 'a', 'b',
+		4, 0xF8,
+		0xFF}
+	if got, err := decodeZiplist(ziplist); err != nil || !reflect.DeepEqual(got, []string{"ab", "7"}) {
+		t.Errorf("TestDecodePacked failed: got ziplist %v, %v", got, err)
+	}
+}
+
+func TestReadLZF(t *testing.T) {
+	// "a" followed by back references of the maximum length, 264 bytes,
+	// copying the byte before them: 5000 bytes in 59
+	compressed := []byte{0, 'a'}
+	for left := 4999; left > 0; left -= 264 {
+		n := left
+		if n > 264 {
+			n = 264
+		}
+		compressed = append(compressed, 7<<5, byte(n-2-7), 0)
+	}
+
+	data := []byte("REDIS0009")
+	data = append(data, typeString, 3, 'b', 'i', 'g')
+	data = append(data, 0xC0|encLZF, byte(len(compressed)), 0x40|5000>>8, 5000&0xFF)
+	data = append(data, compressed...)
+	data = append(data, opEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	got, err := Read(data)
+	if err != nil {
+		t.Fatalf("TestReadLZF failed: Read returned %v", err)
+	}
+	if v := got.Values["big"]; v != strings.Repeat("a", 5000) {
+		t.Errorf("TestReadLZF failed: got a value of %d bytes", len(v.(string)))
+	}
+}
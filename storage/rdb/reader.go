@@ -0,0 +1,466 @@
+// Package rdb reads and writes the RDB snapshot format of stock Redis, so
+// that data can move between this server and Redis tooling.
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// Magic is the prefix of every Redis RDB file, followed by a four digit
+// version number.
+const Magic = "REDIS"
+
+// Supported RDB versions. Version 9 is written for compatibility with Redis
+// 5.0 and later; versions up to 12 (Redis 7.2) can be read.
+const (
+	writeVersion   = 9
+	maxReadVersion = 12
+)
+
+// Opcodes
+const (
+	opSlotInfo     = 0xF4
+	opFunction2    = 0xF5
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMs = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+)
+
+// Value types
+const (
+	typeString          = 0
+	typeList            = 1
+	typeSet             = 2
+	typeZset            = 3
+	typeHash            = 4
+	typeZset2           = 5
+	typeHashZipmap      = 9
+	typeListZiplist     = 10
+	typeSetIntset       = 11
+	typeZsetZiplist     = 12
+	typeHashZiplist     = 13
+	typeListQuicklist   = 14
+	typeHashListpack    = 16
+	typeZsetListpack    = 17
+	typeListQuicklist2  = 18
+	typeSetListpack     = 20
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// String encodings of the special length format
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// maxStringLength bounds the uncompressed length of an LZF string, which,
+// unlike other lengths, may exceed the size of the file holding it.
+const maxStringLength = 512 << 20
+
+// crcTable is the CRC64 Jones variant Redis uses for the RDB trailer.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+var (
+	errTruncated = errors.New("unexpected end of RDB file")
+	// ErrNotRDB is returned for data that does not start with the RDB magic.
+	ErrNotRDB = errors.New("not a Redis RDB file")
+)
+
+// IsRDB reports whether data starts like a Redis RDB file.
+func IsRDB(data []byte) bool {
+	if len(data) < 9 || string(data[:5]) != Magic {
+		return false
+	}
+	_, err := strconv.Atoi(string(data[5:9]))
+	return err == nil
+}
+
+// reader decodes RDB primitives, remembering the first error.
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.fail(errTruncated)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+// length reads a length and reports whether it is a special string
+// encoding rather than a plain length.
+func (r *reader) length() (uint64, bool) {
+	first := r.byte()
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3f), false
+	case 1:
+		return uint64(first&0x3f)<<8 | uint64(r.byte()), false
+	case 2:
+		switch first {
+		case 0x80:
+			b := r.bytes(4)
+			if b == nil {
+				return 0, false
+			}
+			return uint64(binary.BigEndian.Uint32(b)), false
+		case 0x81:
+			b := r.bytes(8)
+			if b == nil {
+				return 0, false
+			}
+			return binary.BigEndian.Uint64(b), false
+		}
+		r.fail(fmt.Errorf("unknown length encoding 0x%02x", first))
+		return 0, false
+	}
+	return uint64(first & 0x3f), true
+}
+
+func (r *reader) count() int {
+	n, special := r.length()
+	if special || n > uint64(len(r.data)) {
+		r.fail(errors.New("invalid element count"))
+		return 0
+	}
+	return int(n)
+}
+
+func (r *reader) string() string {
+	n, special := r.length()
+	if r.err != nil {
+		return ""
+	}
+	if !special {
+		if n > uint64(len(r.data)) {
+			r.fail(errTruncated)
+			return ""
+		}
+		return string(r.bytes(int(n)))
+	}
+	switch n {
+	case encInt8:
+		return strconv.Itoa(int(int8(r.byte())))
+	case encInt16:
+		b := r.bytes(2)
+		if b == nil {
+			return ""
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b))))
+	case encInt32:
+		b := r.bytes(4)
+		if b == nil {
+			return ""
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b))))
+	case encLZF:
+		clen := r.count()
+		ulen, special := r.length()
+		if r.err == nil && (special || ulen > maxStringLength) {
+			r.fail(errors.New("invalid LZF string length"))
+		}
+		compressed := r.bytes(clen)
+		if r.err != nil {
+			return ""
+		}
+		out, err := lzfDecompress(compressed, int(ulen))
+		if err != nil {
+			r.fail(err)
+			return ""
+		}
+		return string(out)
+	}
+	r.fail(fmt.Errorf("unknown string encoding %d", n))
+	return ""
+}
+
+func (r *reader) strings(n int) []string {
+	values := make([]string, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		values = append(values, r.string())
+	}
+	return values
+}
+
+// packed reads a string holding a packed encoding and decodes it.
+func (r *reader) packed(decode func([]byte) ([]string, error)) []string {
+	s := r.string()
+	if r.err != nil {
+		return nil
+	}
+	values, err := decode([]byte(s))
+	if err != nil {
+		r.fail(err)
+	}
+	return values
+}
+
+// oldScore reads a score of the RDB_TYPE_ZSET format, stored as a length
+// prefixed decimal string with special lengths for NaN and infinities.
+func (r *reader) oldScore() float64 {
+	n := r.byte()
+	switch n {
+	case 253:
+		return math.NaN()
+	case 254:
+		return math.Inf(1)
+	case 255:
+		return math.Inf(-1)
+	}
+	score, err := strconv.ParseFloat(string(r.bytes(int(n))), 64)
+	if err != nil {
+		r.fail(err)
+	}
+	return score
+}
+
+func (r *reader) binaryScore() float64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// Read decodes an RDB file into a snapshot. Only database 0 is imported;
+// keys of other databases are rejected since this server has a single
+// keyspace.
+func Read(data []byte) (*inMemory.Snapshot, error) {
+	if !IsRDB(data) {
+		return nil, ErrNotRDB
+	}
+	version, _ := strconv.Atoi(string(data[5:9]))
+	if version < 1 || version > maxReadVersion {
+		return nil, fmt.Errorf("unsupported RDB version %d", version)
+	}
+
+	snap := &inMemory.Snapshot{
+		Values:      make(map[string]interface{}),
+		Expirations: make(map[string]time.Time),
+	}
+	r := &reader{data: data, pos: 9}
+	var expTime time.Time
+	for r.err == nil {
+		opcode := r.byte()
+		switch opcode {
+		case opEOF:
+			if err := verifyTrailer(data, r.pos, version); err != nil {
+				return nil, err
+			}
+			return snap, nil
+		case opSelectDB:
+			if db := r.count(); db != 0 {
+				return nil, fmt.Errorf("database %d is not supported, only database 0 can be imported", db)
+			}
+		case opResizeDB:
+			r.length()
+			r.length()
+		case opAux:
+			r.string()
+			r.string()
+		case opExpireTimeMs:
+			if b := r.bytes(8); b != nil {
+				expTime = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+			}
+		case opExpireTime:
+			if b := r.bytes(4); b != nil {
+				expTime = time.Unix(int64(binary.LittleEndian.Uint32(b)), 0)
+			}
+		case opFreq:
+			r.byte()
+		case opIdle:
+			r.length()
+		case opSlotInfo:
+			r.length()
+			r.length()
+			r.length()
+		case opFunction2:
+			r.string()
+		case opModuleAux:
+			return nil, errors.New("module data is not supported")
+		default:
+			key := r.string()
+			value := r.value(opcode)
+			if r.err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, r.err)
+			}
+			snap.Values[key] = value
+			if !expTime.IsZero() {
+				snap.Expirations[key] = expTime
+			}
+			expTime = time.Time{}
+		}
+	}
+	return nil, r.err
+}
+
+// verifyTrailer checks the CRC64 that follows the EOF opcode since RDB
+// version 5. A zero checksum means checksums were disabled.
+func verifyTrailer(data []byte, pos, version int) error {
+	if version < 5 {
+		return nil
+	}
+	if pos+8 > len(data) {
+		return errTruncated
+	}
+	expected := binary.LittleEndian.Uint64(data[pos:])
+	if expected != 0 && crc64.Checksum(data[:pos], crcTable) != expected {
+		return errors.New("RDB checksum mismatch")
+	}
+	return nil
+}
+
+// value decodes a value of the given type into the in-memory types.
+func (r *reader) value(valueType byte) interface{} {
+	switch valueType {
+	case typeString:
+		return r.string()
+	case typeList:
+		return newList(r.strings(r.count()))
+	case typeListZiplist:
+		return newList(r.packed(decodeZiplist))
+	case typeListQuicklist, typeListQuicklist2:
+		var items []string
+		nodes := r.count()
+		for i := 0; i < nodes && r.err == nil; i++ {
+			container := uint64(quicklistNodePacked)
+			if valueType == typeListQuicklist2 {
+				container, _ = r.length()
+			}
+			switch {
+			case container == quicklistNodePlain:
+				items = append(items, r.string())
+			case valueType == typeListQuicklist:
+				items = append(items, r.packed(decodeZiplist)...)
+			default:
+				items = append(items, r.packed(decodeListpack)...)
+			}
+		}
+		return newList(items)
+	case typeSet:
+		return newSet(r.strings(r.count()))
+	case typeSetIntset:
+		return newSet(r.packed(decodeIntset))
+	case typeSetListpack:
+		return newSet(r.packed(decodeListpack))
+	case typeZset, typeZset2:
+		zset := inMemory.NewSortedSet()
+		n := r.count()
+		for i := 0; i < n && r.err == nil; i++ {
+			member := r.string()
+			if valueType == typeZset {
+				zset.Add(member, r.oldScore())
+			} else {
+				zset.Add(member, r.binaryScore())
+			}
+		}
+		return zset
+	case typeZsetZiplist:
+		return r.packedSortedSet(decodeZiplist)
+	case typeZsetListpack:
+		return r.packedSortedSet(decodeListpack)
+	case typeHash:
+		hash := inMemory.NewHash()
+		n := r.count()
+		for i := 0; i < n && r.err == nil; i++ {
+			field := r.string()
+			hash.Set(field, r.string())
+		}
+		return hash
+	case typeHashZiplist:
+		return r.packedHash(decodeZiplist)
+	case typeHashListpack:
+		return r.packedHash(decodeListpack)
+	case typeHashZipmap:
+		r.fail(errors.New("zipmap encoded hashes (RDB < 4) are not supported"))
+		return nil
+	}
+	r.fail(fmt.Errorf("unsupported value type %d", valueType))
+	return nil
+}
+
+func (r *reader) packedSortedSet(decode func([]byte) ([]string, error)) *inMemory.SortedSet {
+	items := r.packed(decode)
+	if len(items)%2 != 0 {
+		r.fail(errPacked)
+		return nil
+	}
+	zset := inMemory.NewSortedSet()
+	for i := 0; i < len(items); i += 2 {
+		score, err := strconv.ParseFloat(items[i+1], 64)
+		if err != nil {
+			r.fail(err)
+			return nil
+		}
+		zset.Add(items[i], score)
+	}
+	return zset
+}
+
+func (r *reader) packedHash(decode func([]byte) ([]string, error)) *inMemory.Hash {
+	items := r.packed(decode)
+	if len(items)%2 != 0 {
+		r.fail(errPacked)
+		return nil
+	}
+	hash := inMemory.NewHash()
+	for i := 0; i < len(items); i += 2 {
+		hash.Set(items[i], items[i+1])
+	}
+	return hash
+}
+
+func newList(items []string) *inMemory.List {
+	list := inMemory.NewList()
+	for _, item := range items {
+		list.PushBack(item)
+	}
+	return list
+}
+
+func newSet(members []string) *inMemory.Set {
+	set := inMemory.NewSet()
+	for _, member := range members {
+		set.Add(member)
+	}
+	return set
+}
@@ -0,0 +1,196 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bhaski-1234/redis-db/constant"
+	"github.com/bhaski-1234/redis-db/storage/inMemory"
+)
+
+// writer encodes RDB primitives, checksumming everything it writes and
+// remembering the first error.
+type writer struct {
+	w   *bufio.Writer
+	crc hash.Hash64
+	err error
+}
+
+func (w *writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc.Write(p)
+	_, w.err = w.w.Write(p)
+}
+
+func (w *writer) byte(b byte) {
+	w.write([]byte{b})
+}
+
+func (w *writer) length(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.byte(byte(n))
+	case n < 1<<14:
+		w.write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n <= math.MaxUint32:
+		b := []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		w.write(b)
+	default:
+		b := []byte{0x81, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], n)
+		w.write(b)
+	}
+}
+
+// string writes s, using the compact integer encoding when s is the
+// canonical form of a 32-bit integer.
+func (w *writer) string(s string) {
+	if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+		switch {
+		case n >= math.MinInt8 && n <= math.MaxInt8:
+			w.write([]byte{0xC0 | encInt8, byte(n)})
+		case n >= math.MinInt16 && n <= math.MaxInt16:
+			b := []byte{0xC0 | encInt16, 0, 0}
+			binary.LittleEndian.PutUint16(b[1:], uint16(n))
+			w.write(b)
+		default:
+			b := []byte{0xC0 | encInt32, 0, 0, 0, 0}
+			binary.LittleEndian.PutUint32(b[1:], uint32(n))
+			w.write(b)
+		}
+		return
+	}
+	w.length(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *writer) aux(key, value string) {
+	w.byte(opAux)
+	w.string(key)
+	w.string(value)
+}
+
+// Write encodes snap as an RDB file readable by Redis 5.0 and later.
+// progress, if set, is called after every key written.
+//
+// Values use the plain list, set, hash and binary-score sorted set types,
+// which every Redis version converts to its own compact encodings on load.
+// Streams and hash field expirations have no equivalent in RDB version 9
+// and are reported as an error.
+func Write(out io.Writer, snap *inMemory.Snapshot, progress func()) error {
+	w := &writer{w: bufio.NewWriter(out), crc: crc64.New(crcTable)}
+	w.write([]byte(fmt.Sprintf("%s%04d", Magic, writeVersion)))
+	w.aux("redis-ver", constant.ServerVersion)
+	w.aux("redis-bits", "64")
+	w.aux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+
+	w.byte(opSelectDB)
+	w.length(0)
+	w.byte(opResizeDB)
+	w.length(uint64(snap.Len()))
+	w.length(uint64(snap.Expiring()))
+
+	var err error
+	snap.Range(func(key string, value interface{}, expTime time.Time) bool {
+		if !expTime.IsZero() {
+			b := make([]byte, 9)
+			b[0] = opExpireTimeMs
+			binary.LittleEndian.PutUint64(b[1:], uint64(expTime.UnixMilli()))
+			w.write(b)
+		}
+		if err = w.value(key, value); err != nil {
+			return false
+		}
+		if w.err != nil {
+			err = w.err
+			return false
+		}
+		if progress != nil {
+			progress()
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	w.byte(opEOF)
+	if w.err != nil {
+		return w.err
+	}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(trailer, w.crc.Sum64())
+	if _, err := w.w.Write(trailer); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *writer) value(key string, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		w.byte(typeString)
+		w.string(key)
+		w.string(v)
+	case int:
+		w.byte(typeString)
+		w.string(key)
+		w.string(strconv.Itoa(v))
+	case *inMemory.List:
+		w.byte(typeList)
+		w.string(key)
+		w.strings(v.Values())
+	case *inMemory.Set:
+		w.byte(typeSet)
+		w.string(key)
+		w.strings(v.Members())
+	case *inMemory.SortedSet:
+		members := v.Members()
+		w.byte(typeZset2)
+		w.string(key)
+		w.length(uint64(len(members)))
+		for _, m := range members {
+			w.string(m.Member)
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, math.Float64bits(m.Score))
+			w.write(b)
+		}
+	case *inMemory.Hash:
+		fields := v.Fields()
+		for _, field := range fields {
+			if _, ok := v.GetExpiration(field); ok {
+				return fmt.Errorf("key %q: hash field expiration can not be exported to RDB", key)
+			}
+		}
+		w.byte(typeHash)
+		w.string(key)
+		w.length(uint64(len(fields)))
+		for _, field := range fields {
+			value, _ := v.Get(field)
+			w.string(field)
+			w.string(value)
+		}
+	case *inMemory.Stream:
+		return fmt.Errorf("key %q: streams can not be exported to RDB", key)
+	default:
+		return fmt.Errorf("key %q: %T values can not be exported to RDB", key, value)
+	}
+	return nil
+}
+
+func (w *writer) strings(values []string) {
+	w.length(uint64(len(values)))
+	for _, v := range values {
+		w.string(v)
+	}
+}
@@ -43,3 +43,86 @@ func ParseDuration(durationStr string) (time.Duration, error) {
 	}
 	return time.Duration(t) * time.Second, nil
 }
+
+// MatchPattern reports whether s matches the Redis style glob pattern.
+// It supports '*', '?', character classes like [abc], [^a] and [a-z],
+// and backslash escapes.
+func MatchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if MatchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			negate := false
+			if end < len(pattern) && pattern[end] == '^' {
+				negate = true
+				end++
+			}
+			matched := false
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' && end+1 < len(pattern) {
+					end++
+					if pattern[end] == s[0] {
+						matched = true
+					}
+				} else if end+2 < len(pattern) && pattern[end+1] == '-' && pattern[end+2] != ']' {
+					lo, hi := pattern[end], pattern[end+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if s[0] >= lo && s[0] <= hi {
+						matched = true
+					}
+					end += 2
+				} else if pattern[end] == s[0] {
+					matched = true
+				}
+				end++
+			}
+			if negate {
+				matched = !matched
+			}
+			if !matched {
+				return false
+			}
+			if end < len(pattern) {
+				end++ // skip the closing bracket
+			}
+			pattern = pattern[end:]
+			s = s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}